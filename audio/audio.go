@@ -26,6 +26,14 @@ type Stream interface {
 	Read(interface{}) (int, error)
 }
 
+// BufferedStream represents a stream that buffers audio ahead of reads, such
+// as a realtime stream. Buffered returns the number of samples that can be
+// read without blocking.
+type BufferedStream interface {
+	Stream
+	Buffered() int
+}
+
 // PlaybackDevice represents a playback device that can play a stream.
 type PlaybackDevice interface {
 	PlayStream(Stream) error
@@ -89,6 +89,14 @@ func (r *realtimeStream) Read(dst interface{}) (int, error) {
 	}
 }
 
+// Buffered returns the number of samples that can be read without blocking.
+func (r *realtimeStream) Buffered() int {
+	r.usageLock.Lock()
+	defer r.usageLock.Unlock()
+
+	return len(r.buffer) - r.readPosition
+}
+
 func (r *realtimeStream) SampleRate() int {
 	return r.stream.SampleRate()
 }
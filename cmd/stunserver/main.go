@@ -0,0 +1,24 @@
+// Command stunserver runs a STUN server that responds to binding requests,
+// for discovering server-reflexive addresses during development and tests.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/1lann/dissonance/stun"
+)
+
+func main() {
+	addr := flag.String("addr", ":3478", "UDP address to listen on")
+	password := flag.String("password", "", "require short-term credentials with this password")
+	flag.Parse()
+
+	server := &stun.Server{
+		Software: "dissonance stunserver",
+		Password: *password,
+	}
+
+	log.Println("stunserver: listening on", *addr)
+	log.Fatal(server.ListenAndServe(*addr))
+}
@@ -0,0 +1,35 @@
+// Command turnserver runs a TURN relay server with a single set of long-term
+// credentials, for relaying calls between peers during development and tests.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/1lann/dissonance/turn"
+)
+
+func main() {
+	addr := flag.String("addr", ":3478", "UDP address to listen on")
+	realm := flag.String("realm", "dissonance", "realm for long-term credentials")
+	username := flag.String("username", "dissonance", "username clients authenticate with")
+	password := flag.String("password", "", "password clients authenticate with")
+	relayIP := flag.String("relay-ip", "", "IP address to allocate relayed addresses on")
+	flag.Parse()
+
+	if *password == "" {
+		log.Fatal("turnserver: -password is required")
+	}
+
+	server := turn.NewServer(*realm, func(name string) (string, bool) {
+		return *password, name == *username
+	})
+	server.Software = "dissonance turnserver"
+	if *relayIP != "" {
+		server.RelayIP = net.ParseIP(*relayIP)
+	}
+
+	log.Println("turnserver: listening on", *addr)
+	log.Fatal(server.ListenAndServe(*addr))
+}
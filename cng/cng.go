@@ -0,0 +1,162 @@
+// Package cng implements comfort noise (RFC 3389). Rather than sending
+// silence, a sender stops transmitting audio when the VAD detects no speech,
+// and periodically sends the level and spectral shape of its background
+// noise instead. The receiver synthesizes matching noise from those
+// parameters, so the call doesn't sound like it has dropped.
+package cng
+
+import (
+	"errors"
+	"math"
+)
+
+// PayloadType is the static RTP payload type of comfort noise at 8 kHz.
+// Other clock rates use a dynamic payload type.
+const PayloadType = 13
+
+// DefaultOrder is the number of reflection coefficients sent to describe the
+// spectrum of the noise.
+const DefaultOrder = 10
+
+// MaxLevel is the noise level of silence, in -dBov.
+const MaxLevel = 127
+
+// fullScale is the RMS of a full scale signal, or 0 dBov.
+const fullScale = 2147483647
+
+// ErrInvalidPayload is returned when a comfort noise payload is empty.
+var ErrInvalidPayload = errors.New("cng: invalid comfort noise payload")
+
+// Parameters represents the parameters of comfort noise, as carried by a
+// comfort noise payload.
+type Parameters struct {
+	// Level is the level of the noise in -dBov.
+	Level uint8
+
+	// Reflection are the reflection coefficients of the noise's spectral
+	// envelope, each between -1 and 1. There may be none, for white noise.
+	Reflection []float64
+}
+
+// Marshal encodes the parameters into a comfort noise payload.
+func (p *Parameters) Marshal() []byte {
+	b := make([]byte, 1+len(p.Reflection))
+	b[0] = p.Level
+	if b[0] > MaxLevel {
+		b[0] = MaxLevel
+	}
+
+	for i, k := range p.Reflection {
+		q := math.Round(k*128) + 127
+		if q < 0 {
+			q = 0
+		} else if q > 254 {
+			q = 254
+		}
+		b[1+i] = uint8(q)
+	}
+
+	return b
+}
+
+// Unmarshal decodes parameters from a comfort noise payload.
+func (p *Parameters) Unmarshal(b []byte) error {
+	if len(b) < 1 {
+		return ErrInvalidPayload
+	}
+
+	p.Level = b[0] & 0x7f
+	p.Reflection = make([]float64, len(b)-1)
+	for i, q := range b[1:] {
+		p.Reflection[i] = (float64(q) - 127) / 128
+	}
+
+	return nil
+}
+
+// levelOf returns the level in -dBov of a signal with the given mean square.
+func levelOf(meanSquare float64) uint8 {
+	if meanSquare <= 0 {
+		return MaxLevel
+	}
+
+	level := math.Round(-10 * math.Log10(meanSquare/(fullScale*fullScale)))
+	if level < 0 {
+		return 0
+	} else if level > MaxLevel {
+		return MaxLevel
+	}
+
+	return uint8(level)
+}
+
+// autocorrelation adds the autocorrelation of samples up to the given lag
+// to r, normalized by the number of samples.
+func autocorrelation(samples []int32, r []float64) {
+	if len(samples) == 0 {
+		return
+	}
+
+	for lag := range r {
+		var sum float64
+		for i := lag; i < len(samples); i++ {
+			sum += float64(samples[i]) * float64(samples[i-lag])
+		}
+		r[lag] = sum / float64(len(samples))
+	}
+}
+
+// reflection returns the reflection coefficients of the autocorrelation r
+// using the Levinson-Durbin recursion.
+func reflection(r []float64) []float64 {
+	order := len(r) - 1
+	k := make([]float64, order)
+	if r[0] <= 0 {
+		return k
+	}
+
+	a := make([]float64, order+1)
+	prev := make([]float64, order+1)
+	err := r[0]
+
+	for i := 1; i <= order; i++ {
+		acc := r[i]
+		for j := 1; j < i; j++ {
+			acc += a[j] * r[i-j]
+		}
+
+		ki := -acc / err
+		if ki >= 1 || ki <= -1 || math.IsNaN(ki) {
+			break
+		}
+
+		copy(prev, a)
+		for j := 1; j < i; j++ {
+			a[j] = prev[j] + ki*prev[i-j]
+		}
+		a[i] = ki
+		k[i-1] = ki
+
+		err *= 1 - ki*ki
+	}
+
+	return k
+}
+
+// predictor returns the direct form coefficients of the all-pole filter with
+// the given reflection coefficients, where a[0] is 1.
+func predictor(k []float64) []float64 {
+	a := make([]float64, len(k)+1)
+	prev := make([]float64, len(k)+1)
+	a[0] = 1
+
+	for i := 1; i <= len(k); i++ {
+		copy(prev, a)
+		for j := 1; j < i; j++ {
+			a[j] = prev[j] + k[i-1]*prev[i-j]
+		}
+		a[i] = k[i-1]
+	}
+
+	return a
+}
@@ -0,0 +1,183 @@
+package cng
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+const testSampleRate = 8000
+
+func TestParametersRoundTrip(t *testing.T) {
+	tests := []struct {
+		params   Parameters
+		expected Parameters
+	}{
+		{Parameters{Level: 40, Reflection: []float64{}}, Parameters{Level: 40, Reflection: []float64{}}},
+		{
+			Parameters{Level: 60, Reflection: []float64{-0.5, 0.25, 0, 127.0 / 128}},
+			Parameters{Level: 60, Reflection: []float64{-0.5, 0.25, 0, 127.0 / 128}},
+		},
+		// Levels and coefficients out of range are clamped.
+		{
+			Parameters{Level: 200, Reflection: []float64{1, -1}},
+			Parameters{Level: MaxLevel, Reflection: []float64{127.0 / 128, -127.0 / 128}},
+		},
+	}
+
+	for _, test := range tests {
+		var decoded Parameters
+		if err := decoded.Unmarshal(test.params.Marshal()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, test.expected) {
+			t.Errorf("got %+v, expected %+v", decoded, test.expected)
+		}
+	}
+
+	// Coefficients are quantized to within half a step.
+	r := rand.New(rand.NewSource(1))
+	params := Parameters{Reflection: make([]float64, DefaultOrder)}
+	for i := range params.Reflection {
+		params.Reflection[i] = 1.9*r.Float64() - 0.95
+	}
+
+	var decoded Parameters
+	if err := decoded.Unmarshal(params.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range decoded.Reflection {
+		if math.Abs(k-params.Reflection[i]) > 0.5/128 {
+			t.Errorf("coefficient %d: got %.4f, expected %.4f", i, k, params.Reflection[i])
+		}
+	}
+
+	if err := decoded.Unmarshal(nil); err != ErrInvalidPayload {
+		t.Errorf("empty payload: got %v, expected ErrInvalidPayload", err)
+	}
+}
+
+// colouredNoise returns white noise at the given RMS relative to full scale
+// through a one pole filter with the given pole, which tilts its spectrum
+// down towards high frequencies if the pole is positive and up if
+// negative.
+func colouredNoise(r *rand.Rand, n int, rms, pole float64) []int32 {
+	// The filter's gain on white noise is 1/sqrt(1 - pole^2).
+	gain := rms * fullScale * math.Sqrt(1-pole*pole)
+
+	x := make([]int32, n)
+	var y float64
+	for i := range x {
+		y = gain*r.NormFloat64() + pole*y
+		x[i] = clip(y)
+	}
+
+	return x
+}
+
+// analyse returns the level in dBov and the normalized lag one
+// autocorrelation of x, which is positive for spectra tilted towards low
+// frequencies and negative for high frequencies.
+func analyse(x []int32) (float64, float64) {
+	r := make([]float64, 2)
+	autocorrelation(x, r)
+
+	return 10 * math.Log10(r[0]/(fullScale*fullScale)), r[1] / r[0]
+}
+
+func TestEncodeGenerate(t *testing.T) {
+	tests := []struct {
+		level float64 // in dBov
+		pole  float64
+	}{
+		{-40, 0},
+		{-30, 0.9},
+		{-50, -0.7},
+		{-60, 0.5},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		noise := colouredNoise(r, 5*testSampleRate, math.Pow(10, test.level/20), test.pole)
+		level, tilt := analyse(noise)
+
+		// The threshold of 1 treats all of the noise as silence.
+		encoder := NewEncoder(testSampleRate, 1)
+		var payload []byte
+		for i := 0; i+160 <= len(noise); i += 160 {
+			transmit, p := encoder.Encode(noise[i : i+160])
+			if transmit {
+				t.Fatalf("level %.0f: noise was transmitted", test.level)
+			}
+			if p != nil {
+				payload = p
+			}
+		}
+
+		if payload == nil {
+			t.Fatalf("level %.0f: no comfort noise payload sent", test.level)
+		}
+
+		// The first reflection coefficient is the negated lag one
+		// autocorrelation.
+		var params Parameters
+		if err := params.Unmarshal(payload); err != nil {
+			t.Fatal(err)
+		}
+		if len(params.Reflection) != DefaultOrder {
+			t.Errorf("level %.0f: got %d coefficients, expected %d", test.level,
+				len(params.Reflection), DefaultOrder)
+		}
+		if k := params.Reflection[0]; math.Abs(k+tilt) > 0.05 {
+			t.Errorf("level %.0f: got first coefficient %.3f, expected %.3f", test.level,
+				k, -tilt)
+		}
+
+		generator := NewGenerator(testSampleRate)
+		generator.random = rand.New(rand.NewSource(2))
+		if err := generator.Update(payload); err != nil {
+			t.Fatal(err)
+		}
+
+		generated := make([]int32, 5*testSampleRate)
+		if _, err := generator.Read(generated); err != nil {
+			t.Fatal(err)
+		}
+
+		generatedLevel, generatedTilt := analyse(generated)
+		if math.Abs(generatedLevel-level) > 1 {
+			t.Errorf("level %.0f: generated noise at %.1f dBov, expected %.1f dBov",
+				test.level, generatedLevel, level)
+		}
+		if math.Abs(generatedTilt-tilt) > 0.05 {
+			t.Errorf("level %.0f: generated noise with tilt %.3f, expected %.3f", test.level,
+				generatedTilt, tilt)
+		}
+	}
+}
+
+func TestGeneratorSilent(t *testing.T) {
+	generator := NewGenerator(testSampleRate)
+
+	buf := make([]int32, 160)
+	if _, err := generator.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range buf {
+		if sample != 0 {
+			t.Fatal("generated noise before receiving parameters")
+		}
+	}
+
+	// The level of silence generates silence.
+	generator.SetParameters(Parameters{Level: MaxLevel, Reflection: []float64{0.5}})
+	if _, err := generator.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range buf {
+		if sample != 0 {
+			t.Fatal("generated noise at the level of silence")
+		}
+	}
+}
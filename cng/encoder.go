@@ -0,0 +1,148 @@
+package cng
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/filters/vad"
+)
+
+// Defaults for deciding when to send comfort noise.
+const (
+	DefaultHangover    = 200 * time.Millisecond
+	DefaultSIDInterval = time.Second
+)
+
+// levelChange is the change in noise level, in dB, that causes new
+// parameters to be sent before the SID interval has elapsed.
+const levelChange = 3
+
+// noiseSmoothing is the weight of each silent frame in the noise estimate.
+const noiseSmoothing = 0.1
+
+// Encoder decides which frames of audio a sender transmits, and produces the
+// comfort noise payloads to send in place of the frames it suppresses.
+type Encoder struct {
+	sampleRate int
+	threshold  float64
+
+	// Order is the number of reflection coefficients sent.
+	Order int
+
+	// Hangover is how long frames continue to be transmitted after speech
+	// ends, so the tails of words aren't cut off.
+	Hangover time.Duration
+
+	// SIDInterval is the longest time between comfort noise payloads while
+	// the noise stays the same.
+	SIDInterval time.Duration
+
+	speaking    bool
+	sinceSpeech int
+	sinceSID    int
+	lastLevel   uint8
+	estimate    []float64
+	usageLock   *sync.Mutex
+}
+
+// NewEncoder returns a new encoder for audio at the given sample rate, which
+// detects speech using the VAD's energy measure with a threshold between 0
+// and 1.
+func NewEncoder(sampleRate int, threshold float64) *Encoder {
+	if threshold < 0 || threshold > 1 {
+		panic("cng: threshold must be between 0 and 1")
+	}
+
+	return &Encoder{
+		sampleRate:  sampleRate,
+		threshold:   threshold,
+		Order:       DefaultOrder,
+		Hangover:    DefaultHangover,
+		SIDInterval: DefaultSIDInterval,
+		usageLock:   new(sync.Mutex),
+	}
+}
+
+// Encode processes a frame of audio. It returns whether the frame should be
+// transmitted. If it shouldn't, and new noise parameters are due, it also
+// returns the comfort noise payload to send instead, otherwise nothing is
+// sent for the frame.
+func (e *Encoder) Encode(frame []int32) (transmit bool, payload []byte) {
+	e.usageLock.Lock()
+	defer e.usageLock.Unlock()
+
+	if vad.Active(frame, e.threshold) {
+		e.speaking = true
+		e.sinceSpeech = 0
+		return true, nil
+	}
+
+	e.updateEstimate(frame)
+
+	if e.speaking {
+		e.sinceSpeech += len(frame)
+		if e.sinceSpeech < e.samples(e.Hangover) {
+			return true, nil
+		}
+
+		// Speech has ended, so send the noise parameters straight away.
+		e.speaking = false
+		return false, e.sid()
+	}
+
+	e.sinceSID += len(frame)
+	level := levelOf(e.estimate[0])
+	if e.sinceSID >= e.samples(e.SIDInterval) ||
+		math.Abs(float64(level)-float64(e.lastLevel)) >= levelChange {
+		return false, e.sid()
+	}
+
+	return false, nil
+}
+
+// Parameters returns the current estimate of the background noise.
+func (e *Encoder) Parameters() Parameters {
+	e.usageLock.Lock()
+	defer e.usageLock.Unlock()
+
+	return e.parameters()
+}
+
+func (e *Encoder) parameters() Parameters {
+	if len(e.estimate) == 0 {
+		return Parameters{Level: MaxLevel}
+	}
+
+	return Parameters{
+		Level:      levelOf(e.estimate[0]),
+		Reflection: reflection(e.estimate),
+	}
+}
+
+func (e *Encoder) sid() []byte {
+	params := e.parameters()
+	e.lastLevel = params.Level
+	e.sinceSID = 0
+	return params.Marshal()
+}
+
+// updateEstimate updates the spectral noise estimate, a smoothed
+// autocorrelation of silent frames.
+func (e *Encoder) updateEstimate(frame []int32) {
+	r := make([]float64, e.Order+1)
+	autocorrelation(frame, r)
+
+	if len(e.estimate) != len(r) {
+		e.estimate = r
+		return
+	}
+
+	for i := range r {
+		e.estimate[i] += noiseSmoothing * (r[i] - e.estimate[i])
+	}
+}
+
+func (e *Encoder) samples(d time.Duration) int {
+	return int(int64(d) * int64(e.sampleRate) / int64(time.Second))
+}
@@ -0,0 +1,73 @@
+package cng
+
+import "github.com/1lann/dissonance/audio"
+
+// Filter represents a comfort noise filter, which replaces the audio of a
+// stream with comfort noise when it contains no speech. It is an alternative
+// to the VAD filter that doesn't leave silent gaps.
+type Filter struct {
+	threshold float64
+}
+
+// streamFilter represents a stream filter.
+type streamFilter struct {
+	stream    audio.Stream
+	encoder   *Encoder
+	generator *Generator
+	buffer    []int32
+}
+
+// NewFilter creates a new comfort noise filter with a VAD threshold between 0
+// and 1.
+func NewFilter(threshold float64) audio.Filter {
+	if threshold < 0 || threshold > 1 {
+		panic("cng: threshold must be between 0 and 1")
+	}
+
+	return &Filter{threshold}
+}
+
+// Filter implements the Filter method for filters.
+func (f *Filter) Filter(stream audio.Stream) audio.Stream {
+	return &streamFilter{
+		stream:    stream,
+		encoder:   NewEncoder(stream.SampleRate(), f.threshold),
+		generator: NewGenerator(stream.SampleRate()),
+	}
+}
+
+func (f *streamFilter) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	if len(f.buffer) < dstLen {
+		f.buffer = make([]int32, dstLen)
+	}
+
+	n, err := f.stream.Read(f.buffer[:dstLen])
+	if n == 0 {
+		return 0, err
+	}
+
+	transmit, payload := f.encoder.Encode(f.buffer[:n])
+	if transmit {
+		if convErr := audio.ReadFromInt32(dst, f.buffer[:n], n); convErr != nil {
+			return 0, convErr
+		}
+
+		return n, err
+	}
+
+	if payload != nil {
+		f.generator.Update(payload)
+	}
+
+	f.generator.Read(f.buffer[:n])
+	if convErr := audio.ReadFromInt32(dst, f.buffer[:n], n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+func (f *streamFilter) SampleRate() int {
+	return f.stream.SampleRate()
+}
@@ -0,0 +1,107 @@
+package cng
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+)
+
+// Generator represents a stream of comfort noise synthesized from the most
+// recently received parameters. It never ends, and is silent until it
+// receives parameters.
+type Generator struct {
+	sampleRate int
+	gain       float64
+	predictor  []float64
+	history    []float64
+	random     *rand.Rand
+	usageLock  *sync.Mutex
+}
+
+// NewGenerator returns a new comfort noise generator at the given sample
+// rate.
+func NewGenerator(sampleRate int) *Generator {
+	return &Generator{
+		sampleRate: sampleRate,
+		predictor:  []float64{1},
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		usageLock:  new(sync.Mutex),
+	}
+}
+
+// Update decodes a comfort noise payload and updates the parameters of the
+// generated noise.
+func (g *Generator) Update(payload []byte) error {
+	var params Parameters
+	if err := params.Unmarshal(payload); err != nil {
+		return err
+	}
+
+	g.SetParameters(params)
+	return nil
+}
+
+// SetParameters updates the parameters of the generated noise.
+func (g *Generator) SetParameters(params Parameters) {
+	g.usageLock.Lock()
+	defer g.usageLock.Unlock()
+
+	// The excitation is scaled so that the filtered noise has the RMS of
+	// the given level.
+	rms := 0.0
+	if params.Level < MaxLevel {
+		rms = fullScale * math.Pow(10, -float64(params.Level)/20)
+	}
+
+	predictionError := 1.0
+	for _, k := range params.Reflection {
+		predictionError *= 1 - k*k
+	}
+
+	g.gain = rms * math.Sqrt(predictionError)
+	g.predictor = predictor(params.Reflection)
+	if len(g.history) != len(g.predictor)-1 {
+		g.history = make([]float64, len(g.predictor)-1)
+	}
+}
+
+func (g *Generator) Read(dst interface{}) (int, error) {
+	length := audio.SliceLength(dst)
+	samples := make([]int32, length)
+
+	g.usageLock.Lock()
+	for i := range samples {
+		value := g.random.NormFloat64() * g.gain
+		for j, y := range g.history {
+			value -= g.predictor[j+1] * y
+		}
+
+		if len(g.history) > 0 {
+			copy(g.history[1:], g.history)
+			g.history[0] = value
+		}
+
+		samples[i] = clip(value)
+	}
+	g.usageLock.Unlock()
+
+	return length, audio.ReadFromInt32(dst, samples, length)
+}
+
+// SampleRate returns the sample rate of the generated noise.
+func (g *Generator) SampleRate() int {
+	return g.sampleRate
+}
+
+func clip(value float64) int32 {
+	if value > math.MaxInt32 {
+		return math.MaxInt32
+	} else if value < math.MinInt32 {
+		return math.MinInt32
+	}
+
+	return int32(value)
+}
@@ -0,0 +1,430 @@
+// Package conference implements a conference bridge, or MCU, which mixes the
+// audio of every participant in a call and plays each participant a mix of
+// everyone else. Participants are a source stream and a sink, and may join
+// and leave while the bridge is running.
+//
+// Mixing runs once per frame at ffmpeg.SampleRate. The mix of all
+// participants is computed once per frame, and each participant's own
+// contribution is subtracted from it, so the cost of a frame grows linearly
+// with the number of participants rather than quadratically.
+//
+// For rooms too large to mix, a Router forwards the packets of only the
+// loudest few speakers to each participant instead.
+package conference
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/ffmpeg"
+)
+
+// DefaultFrameDuration is the duration of audio mixed at a time.
+const DefaultFrameDuration = 20 * time.Millisecond
+
+// DefaultMaxParticipants is the default limit on the number of participants,
+// which bounds the CPU time spent mixing each frame.
+const DefaultMaxParticipants = 32
+
+// maxBufferedFrames is the number of frames buffered from a source or to a
+// sink before the oldest audio is dropped, bounding latency when either end
+// falls behind.
+const maxBufferedFrames = 10
+
+// Errors returned by the bridge.
+var (
+	ErrClosed            = errors.New("conference: bridge closed")
+	ErrNameTaken         = errors.New("conference: participant name already taken")
+	ErrFull              = errors.New("conference: bridge is full")
+	ErrInvalidSampleRate = errors.New("conference: source sample rate must be ffmpeg.SampleRate")
+	ErrNotJoined         = errors.New("conference: participant has not joined")
+)
+
+// Bridge represents a conference bridge.
+type Bridge struct {
+	frameSize       int
+	frameDuration   time.Duration
+	maxParticipants int
+
+	participants []*Participant
+	closed       chan struct{}
+	usageLock    *sync.Mutex
+}
+
+// NewBridge returns a new running bridge which mixes frames of the given
+// duration, and accepts up to maxParticipants participants.
+func NewBridge(frameDuration time.Duration, maxParticipants int) *Bridge {
+	frameSize := int(int64(ffmpeg.SampleRate) * int64(frameDuration) / int64(time.Second))
+	if frameSize <= 0 {
+		panic("conference: frame duration must be at least one sample")
+	}
+
+	if maxParticipants <= 0 {
+		panic("conference: maxParticipants must be positive")
+	}
+
+	b := &Bridge{
+		frameSize:       frameSize,
+		frameDuration:   frameDuration,
+		maxParticipants: maxParticipants,
+		closed:          make(chan struct{}),
+		usageLock:       new(sync.Mutex),
+	}
+
+	go b.run()
+
+	return b
+}
+
+// Join adds a participant to the bridge. Audio is read from source, and the
+// mix of every other participant is played on sink. The source's sample rate
+// must be ffmpeg.SampleRate.
+func (b *Bridge) Join(name string, source audio.Stream, sink audio.PlaybackDevice) (*Participant, error) {
+	if source.SampleRate() != ffmpeg.SampleRate {
+		return nil, ErrInvalidSampleRate
+	}
+
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+
+	select {
+	case <-b.closed:
+		return nil, ErrClosed
+	default:
+	}
+
+	if len(b.participants) >= b.maxParticipants {
+		return nil, ErrFull
+	}
+
+	for _, p := range b.participants {
+		if p.name == name {
+			return nil, ErrNameTaken
+		}
+	}
+
+	p := &Participant{
+		name:      name,
+		bridge:    b,
+		source:    source,
+		gain:      1,
+		input:     newBuffer(b.frameSize * maxBufferedFrames),
+		output:    newBuffer(b.frameSize * maxBufferedFrames),
+		usageLock: new(sync.Mutex),
+	}
+	b.participants = append(b.participants, p)
+
+	go p.readSource()
+	go sink.PlayStream(&outputStream{p.output})
+
+	return p, nil
+}
+
+// Participants returns the participants currently in the bridge, in the
+// order they joined.
+func (b *Bridge) Participants() []*Participant {
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+
+	return append([]*Participant(nil), b.participants...)
+}
+
+// remove removes a participant from the bridge.
+func (b *Bridge) remove(p *Participant) {
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+
+	for i, other := range b.participants {
+		if other == p {
+			b.participants = append(b.participants[:i], b.participants[i+1:]...)
+			break
+		}
+	}
+
+	p.input.close(nil)
+	p.output.close(io.EOF)
+}
+
+// Close removes every participant and stops the bridge.
+func (b *Bridge) Close() error {
+	b.usageLock.Lock()
+	select {
+	case <-b.closed:
+		b.usageLock.Unlock()
+		return nil
+	default:
+	}
+
+	close(b.closed)
+	// remove modifies the participants slice in place, so a copy is
+	// iterated.
+	participants := append([]*Participant(nil), b.participants...)
+	b.usageLock.Unlock()
+
+	for _, p := range participants {
+		b.remove(p)
+	}
+
+	return nil
+}
+
+// run mixes a frame every frame duration until the bridge is closed.
+func (b *Bridge) run() {
+	ticker := time.NewTicker(b.frameDuration)
+	defer ticker.Stop()
+
+	m := newMixer(b.frameSize)
+
+	for {
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C:
+		}
+
+		m.mixFrame(b.Participants())
+	}
+}
+
+// mixer holds the buffers used to mix frames.
+type mixer struct {
+	frames [][]int32
+	total  []int64
+	mix    []int32
+}
+
+func newMixer(frameSize int) *mixer {
+	return &mixer{
+		total: make([]int64, frameSize),
+		mix:   make([]int32, frameSize),
+	}
+}
+
+// mixFrame takes a frame from each participant's input, and gives each
+// participant the mix of every other participant's frame.
+func (m *mixer) mixFrame(participants []*Participant) {
+	for len(m.frames) < len(participants) {
+		m.frames = append(m.frames, make([]int32, len(m.mix)))
+	}
+
+	for i := range m.total {
+		m.total[i] = 0
+	}
+
+	// Take each participant's frame with their gain applied, and sum them
+	// all.
+	for i, p := range participants {
+		frame := m.frames[i]
+		n := p.input.take(frame)
+		for j := n; j < len(frame); j++ {
+			frame[j] = 0
+		}
+
+		gain, muted := p.levels()
+		if muted {
+			for j := range frame {
+				frame[j] = 0
+			}
+			continue
+		}
+
+		if gain != 1 {
+			for j, sample := range frame {
+				frame[j] = clip(int64(float64(sample) * gain))
+			}
+		}
+
+		for j, sample := range frame {
+			m.total[j] += int64(sample)
+		}
+	}
+
+	// Each participant hears everyone but themselves.
+	for i, p := range participants {
+		frame := m.frames[i]
+		for j := range m.mix {
+			m.mix[j] = clip(m.total[j] - int64(frame[j]))
+		}
+
+		p.output.put(m.mix)
+	}
+}
+
+func clip(sample int64) int32 {
+	if sample > math.MaxInt32 {
+		return math.MaxInt32
+	} else if sample < math.MinInt32 {
+		return math.MinInt32
+	}
+
+	return int32(sample)
+}
+
+// Participant represents a participant in a bridge.
+type Participant struct {
+	name   string
+	bridge *Bridge
+	source audio.Stream
+	input  *buffer
+	output *buffer
+
+	gain      float64
+	muted     bool
+	usageLock *sync.Mutex
+}
+
+// Name returns the name of the participant.
+func (p *Participant) Name() string {
+	return p.name
+}
+
+// SetGain sets the linear gain applied to the participant's audio in the
+// mixes of other participants.
+func (p *Participant) SetGain(gain float64) {
+	if gain < 0 {
+		panic("conference: gain must not be negative")
+	}
+
+	p.usageLock.Lock()
+	defer p.usageLock.Unlock()
+
+	p.gain = gain
+}
+
+// SetMuted sets whether the participant's audio is left out of the mixes of
+// other participants. Muted participants still hear everyone else.
+func (p *Participant) SetMuted(muted bool) {
+	p.usageLock.Lock()
+	defer p.usageLock.Unlock()
+
+	p.muted = muted
+}
+
+func (p *Participant) levels() (float64, bool) {
+	p.usageLock.Lock()
+	defer p.usageLock.Unlock()
+
+	return p.gain, p.muted
+}
+
+// Leave removes the participant from the bridge. The participant's sink
+// reaches the end of its stream, and the source is no longer read.
+func (p *Participant) Leave() {
+	p.bridge.remove(p)
+}
+
+// readSource reads from the participant's source until it returns an error
+// or the participant leaves. A source that ends leaves the bridge.
+func (p *Participant) readSource() {
+	buf := make([]int32, p.bridge.frameSize)
+	for {
+		n, err := p.source.Read(buf)
+		if n > 0 && !p.input.put(buf[:n]) {
+			return
+		}
+
+		if err != nil {
+			p.Leave()
+			return
+		}
+	}
+}
+
+// buffer is a bounded buffer of samples, which drops the oldest samples
+// when it is full.
+type buffer struct {
+	samples  []int32
+	capacity int
+	err      error
+	closed   bool
+
+	dataChannel chan bool
+	usageLock   *sync.Mutex
+}
+
+func newBuffer(capacity int) *buffer {
+	return &buffer{
+		capacity:    capacity,
+		dataChannel: make(chan bool, 1),
+		usageLock:   new(sync.Mutex),
+	}
+}
+
+// put appends samples to the buffer, returning false if it is closed.
+func (b *buffer) put(samples []int32) bool {
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+
+	if b.closed {
+		return false
+	}
+
+	b.samples = append(b.samples, samples...)
+	if len(b.samples) > b.capacity {
+		b.samples = append(b.samples[:0], b.samples[len(b.samples)-b.capacity:]...)
+	}
+
+	b.emitDataEvent()
+	return true
+}
+
+// take removes up to len(dst) samples from the buffer without blocking.
+func (b *buffer) take(dst []int32) int {
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+
+	n := copy(dst, b.samples)
+	b.samples = b.samples[:copy(b.samples, b.samples[n:])]
+	return n
+}
+
+func (b *buffer) close(err error) {
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+
+	b.closed = true
+	b.err = err
+	b.emitDataEvent()
+}
+
+func (b *buffer) emitDataEvent() {
+	select {
+	case b.dataChannel <- true:
+	default:
+	}
+}
+
+// outputStream is the stream of mixed audio played to a participant's sink.
+type outputStream struct {
+	buffer *buffer
+}
+
+func (o *outputStream) Read(dst interface{}) (int, error) {
+	length := audio.SliceLength(dst)
+	samples := make([]int32, length)
+
+	for {
+		n := o.buffer.take(samples)
+		if n > 0 {
+			return n, audio.ReadFromInt32(dst, samples, n)
+		}
+
+		o.buffer.usageLock.Lock()
+		closed, err := o.buffer.closed, o.buffer.err
+		o.buffer.usageLock.Unlock()
+
+		if closed {
+			return 0, err
+		}
+
+		<-o.buffer.dataChannel
+	}
+}
+
+func (o *outputStream) SampleRate() int {
+	return ffmpeg.SampleRate
+}
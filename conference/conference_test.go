@@ -0,0 +1,254 @@
+package conference
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/ffmpeg"
+)
+
+const testFrameSize = 4
+
+func newTestParticipant(name string) *Participant {
+	return &Participant{
+		name:      name,
+		gain:      1,
+		input:     newBuffer(testFrameSize * maxBufferedFrames),
+		output:    newBuffer(testFrameSize * maxBufferedFrames),
+		usageLock: new(sync.Mutex),
+	}
+}
+
+func TestMixFrame(t *testing.T) {
+	a := []int32{1, 2, 3, 4}
+	b := []int32{10, 20, 30, 40}
+	c := []int32{100, -200, 300, -400}
+
+	tests := []struct {
+		name     string
+		setup    func(a, b, c *Participant)
+		inputs   [][]int32
+		expected [][]int32
+	}{
+		{
+			name:   "everyone",
+			setup:  func(a, b, c *Participant) {},
+			inputs: [][]int32{a, b, c},
+			expected: [][]int32{
+				{110, -180, 330, -360},
+				{101, -198, 303, -396},
+				{11, 22, 33, 44},
+			},
+		},
+		{
+			// A muted participant still hears everyone else.
+			name:   "muted",
+			setup:  func(a, b, c *Participant) { b.SetMuted(true) },
+			inputs: [][]int32{a, b, c},
+			expected: [][]int32{
+				{100, -200, 300, -400},
+				{101, -198, 303, -396},
+				{1, 2, 3, 4},
+			},
+		},
+		{
+			name: "gain",
+			setup: func(a, b, c *Participant) {
+				a.SetGain(2)
+				c.SetGain(0.5)
+			},
+			inputs: [][]int32{a, b, c},
+			expected: [][]int32{
+				{60, -80, 180, -160},
+				{52, -96, 156, -192},
+				{12, 24, 36, 48},
+			},
+		},
+		{
+			// A participant with no audio buffered contributes silence.
+			name:   "missing input",
+			setup:  func(a, b, c *Participant) {},
+			inputs: [][]int32{a, b[:2], nil},
+			expected: [][]int32{
+				{10, 20, 0, 0},
+				{1, 2, 3, 4},
+				{11, 22, 3, 4},
+			},
+		},
+		{
+			name:  "clipping",
+			setup: func(a, b, c *Participant) {},
+			inputs: [][]int32{
+				{math.MaxInt32, math.MinInt32, 0, 0},
+				{math.MaxInt32, math.MinInt32, 0, 0},
+				{1, -1, 0, 0},
+			},
+			expected: [][]int32{
+				{math.MaxInt32, math.MinInt32, 0, 0},
+				{math.MaxInt32, math.MinInt32, 0, 0},
+				{math.MaxInt32, math.MinInt32, 0, 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		participants := []*Participant{
+			newTestParticipant("a"),
+			newTestParticipant("b"),
+			newTestParticipant("c"),
+		}
+		test.setup(participants[0], participants[1], participants[2])
+
+		for i, p := range participants {
+			p.input.put(test.inputs[i])
+		}
+
+		newMixer(testFrameSize).mixFrame(participants)
+
+		for i, p := range participants {
+			got := make([]int32, testFrameSize+1)
+			n := p.output.take(got)
+			if !reflect.DeepEqual(got[:n], test.expected[i]) {
+				t.Errorf("%s: participant %s heard %v, expected %v", test.name, p.name,
+					got[:n], test.expected[i])
+			}
+		}
+	}
+}
+
+// constantStream is a source of a constant sample value, read in real time.
+type constantStream struct {
+	value int32
+}
+
+func (s *constantStream) SampleRate() int {
+	return ffmpeg.SampleRate
+}
+
+func (s *constantStream) Read(dst interface{}) (int, error) {
+	buf := dst.([]int32)
+	for i := range buf {
+		buf[i] = s.value
+	}
+
+	time.Sleep(time.Duration(len(buf)) * time.Second / ffmpeg.SampleRate)
+	return len(buf), nil
+}
+
+// recordingSink is a playback device which records the samples played.
+type recordingSink struct {
+	samples chan []int32
+}
+
+func (r *recordingSink) PlayStream(stream audio.Stream) error {
+	defer close(r.samples)
+
+	for {
+		buf := make([]int32, 480)
+		n, err := stream.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		r.samples <- buf[:n]
+	}
+}
+
+func (r *recordingSink) Close() {}
+
+func TestBridge(t *testing.T) {
+	bridge := NewBridge(5*time.Millisecond, 3)
+	defer bridge.Close()
+
+	// Each participant's level is a different bit, so whether a mix
+	// includes a participant can be told from any sample of it.
+	values := []int32{1 << 20, 1 << 21, 1 << 22}
+	sinks := make([]*recordingSink, len(values))
+	for i, value := range values {
+		sinks[i] = &recordingSink{samples: make(chan []int32, 1000)}
+		name := string(rune('a' + i))
+		if _, err := bridge.Join(name, &constantStream{value}, sinks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := bridge.Join("d", &constantStream{}, &recordingSink{}); err != ErrFull {
+		t.Errorf("join when full: got %v, expected ErrFull", err)
+	}
+
+	// Every participant eventually hears both of the others, once a frame
+	// is mixed with audio from everyone.
+	check := func(i int, sample int32) bool {
+		t.Helper()
+
+		others := values[0] + values[1] + values[2] - values[i]
+		if sample&values[i] != 0 || sample&^others != 0 {
+			t.Fatalf("participant %d heard %#x, which isn't a mix of others %#x", i,
+				sample, others)
+		}
+
+		return sample == others
+	}
+
+	timeout := time.After(5 * time.Second)
+	for i, sink := range sinks {
+		heardAll := false
+		for !heardAll {
+			select {
+			case samples := <-sink.samples:
+				for _, sample := range samples {
+					heardAll = check(i, sample) || heardAll
+				}
+			case <-timeout:
+				t.Fatalf("participant %d never heard both others", i)
+			}
+		}
+	}
+
+	bridge.Close()
+	for i, sink := range sinks {
+		for samples := range sink.samples {
+			for _, sample := range samples {
+				check(i, sample)
+			}
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	bridge := NewBridge(time.Hour, 2)
+
+	if _, err := bridge.Join("a", &constantStream{}, &recordingSink{
+		samples: make(chan []int32, 1),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bridge.Join("a", &constantStream{}, &recordingSink{}); err != ErrNameTaken {
+		t.Errorf("got %v, expected ErrNameTaken", err)
+	}
+
+	if _, err := bridge.Join("b", &wrongRateStream{}, &recordingSink{}); err != ErrInvalidSampleRate {
+		t.Errorf("got %v, expected ErrInvalidSampleRate", err)
+	}
+
+	bridge.Close()
+	if _, err := bridge.Join("b", &constantStream{}, &recordingSink{}); err != ErrClosed {
+		t.Errorf("got %v, expected ErrClosed", err)
+	}
+	if len(bridge.Participants()) != 0 {
+		t.Errorf("got %d participants after closing, expected 0", len(bridge.Participants()))
+	}
+}
+
+type wrongRateStream struct {
+	constantStream
+}
+
+func (s *wrongRateStream) SampleRate() int {
+	return 16000
+}
@@ -0,0 +1,366 @@
+package conference
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/rtp"
+)
+
+// Defaults for the speaker selection of a Router.
+const (
+	DefaultHysteresis  = 6
+	DefaultHoldTime    = time.Second
+	DefaultSilenceTime = 300 * time.Millisecond
+)
+
+// SilentLevel is the audio level, in -dBov, of a packet with no audio. The
+// loudest possible level is 0.
+const SilentLevel = rtp.MaxAudioLevel
+
+// levelSmoothing is the weight of each packet's level in a sender's smoothed
+// level.
+const levelSmoothing = 0.2
+
+// Router represents a selective forwarding unit, which forwards the RTP
+// packets of the loudest speakers in a room to every participant without
+// decoding them. It is used in place of a Bridge for rooms too large to mix.
+//
+// The loudness of each sender is taken from the audio level the sender
+// measured for each packet, smoothed over recent packets.
+type Router struct {
+	speakers int
+
+	// Hysteresis is how many dB louder a sender must be than the quietest
+	// active speaker to take their place.
+	Hysteresis float64
+
+	// HoldTime is the minimum time a speaker stays active before being
+	// replaced by a louder sender.
+	HoldTime time.Duration
+
+	// SilenceTime is how long a sender may go without sending a packet
+	// before being treated as silent, such as when using discontinuous
+	// transmission.
+	SilenceTime time.Duration
+
+	// AudioLevelID is the ID of the audio level header extension read by
+	// ReceivePacket.
+	AudioLevelID uint8
+
+	// OnSendError, if not nil, is called with a participant's name and the
+	// error returned by their send function. The participant is removed
+	// from the router before it is called.
+	OnSendError func(name string, err error)
+
+	participants map[string]*routerParticipant
+	active       []*routerParticipant
+	usageLock    *sync.Mutex
+}
+
+// routerParticipant represents a participant of a router, who is both a
+// sender and a subscriber.
+type routerParticipant struct {
+	name string
+	send func(p *rtp.Packet) error
+
+	// loudness is the smoothed level of the sender, in dB above silence.
+	loudness   float64
+	lastPacket time.Time
+	activeAt   time.Time
+	ssrc       uint32
+
+	// forwarding holds the state of the streams forwarded to this
+	// subscriber, by sender name.
+	forwarding map[string]*forwardState
+}
+
+// forwardState represents the state of a sender's stream forwarded to a
+// subscriber. Sequence numbers are rewritten so that packets not forwarded
+// while the sender wasn't an active speaker don't appear lost.
+type forwardState struct {
+	ssrc       uint32
+	started    bool
+	forwarding bool
+	offset     uint16
+	lastOut    uint16
+}
+
+// NewRouter returns a new router which forwards up to the given number of
+// speakers to each participant.
+func NewRouter(speakers int) *Router {
+	if speakers <= 0 {
+		panic("conference: speakers must be positive")
+	}
+
+	return &Router{
+		speakers:     speakers,
+		Hysteresis:   DefaultHysteresis,
+		HoldTime:     DefaultHoldTime,
+		SilenceTime:  DefaultSilenceTime,
+		AudioLevelID: rtp.DefaultAudioLevelID,
+		participants: make(map[string]*routerParticipant),
+		usageLock:    new(sync.Mutex),
+	}
+}
+
+// Join adds a participant to the router. Packets forwarded to the
+// participant are passed to send, which must not block and must not retain
+// the packet.
+func (r *Router) Join(name string, send func(p *rtp.Packet) error) error {
+	r.usageLock.Lock()
+	defer r.usageLock.Unlock()
+
+	if _, found := r.participants[name]; found {
+		return ErrNameTaken
+	}
+
+	r.participants[name] = &routerParticipant{
+		name:       name,
+		send:       send,
+		forwarding: make(map[string]*forwardState),
+	}
+
+	return nil
+}
+
+// Leave removes a participant from the router.
+func (r *Router) Leave(name string) {
+	r.usageLock.Lock()
+	defer r.usageLock.Unlock()
+
+	if p, found := r.participants[name]; found {
+		r.remove(p)
+	}
+}
+
+// remove removes a participant from the router. The usageLock must be held.
+func (r *Router) remove(p *routerParticipant) {
+	delete(r.participants, p.name)
+	for _, other := range r.participants {
+		delete(other.forwarding, p.name)
+	}
+
+	r.setActive(removeParticipant(r.active, p))
+}
+
+// Speakers returns the names of the active speakers, loudest first.
+func (r *Router) Speakers() []string {
+	r.usageLock.Lock()
+	defer r.usageLock.Unlock()
+
+	active := append([]*routerParticipant(nil), r.active...)
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].loudness > active[j].loudness
+	})
+
+	names := make([]string, len(active))
+	for i, p := range active {
+		names[i] = p.name
+	}
+
+	return names
+}
+
+// Receive handles a packet sent by a participant, with the audio level of
+// the packet in -dBov as measured by the sender. If the sender is an active
+// speaker, the packet is forwarded to every other participant. Participants
+// whose send function returns an error are removed, and reported to
+// OnSendError.
+func (r *Router) Receive(name string, packet *rtp.Packet, level uint8) error {
+	return r.receive(name, packet, level, time.Now())
+}
+
+func (r *Router) receive(name string, packet *rtp.Packet, level uint8, now time.Time) error {
+	if level > SilentLevel {
+		level = SilentLevel
+	}
+
+	r.usageLock.Lock()
+
+	sender, found := r.participants[name]
+	if !found {
+		r.usageLock.Unlock()
+		return ErrNotJoined
+	}
+
+	if now.Sub(sender.lastPacket) > r.SilenceTime {
+		sender.loudness = 0
+	}
+	sender.loudness += levelSmoothing * (float64(SilentLevel-level) - sender.loudness)
+	sender.lastPacket = now
+	sender.ssrc = packet.SSRC
+
+	r.selectSpeakers(sender, now)
+
+	if !containsParticipant(r.active, sender) {
+		r.usageLock.Unlock()
+		return nil
+	}
+
+	type delivery struct {
+		participant *routerParticipant
+		packet      *rtp.Packet
+	}
+
+	var deliveries []delivery
+	for _, p := range r.participants {
+		if p == sender {
+			continue
+		}
+
+		out := *packet
+		p.forwardState(sender).rewrite(&out.Header)
+		deliveries = append(deliveries, delivery{participant: p, packet: &out})
+	}
+
+	r.usageLock.Unlock()
+
+	for _, d := range deliveries {
+		err := d.participant.send(d.packet)
+		if err == nil {
+			continue
+		}
+
+		r.usageLock.Lock()
+		// The participant may have already left, and another joined with
+		// the same name.
+		if r.participants[d.participant.name] == d.participant {
+			r.remove(d.participant)
+		}
+		onSendError := r.OnSendError
+		r.usageLock.Unlock()
+
+		if onSendError != nil {
+			onSendError(d.participant.name, err)
+		}
+	}
+
+	return nil
+}
+
+// ReceivePacket handles a packet sent by a participant, like Receive, with
+// the audio level read from the packet's audio level header extension.
+// Packets without the extension, or which the sender flagged as having no
+// voice activity, are treated as silent.
+func (r *Router) ReceivePacket(name string, packet *rtp.Packet) error {
+	level, voice, ok := packet.AudioLevel(r.AudioLevelID)
+	if !ok || !voice {
+		level = SilentLevel
+	}
+
+	return r.Receive(name, packet, level)
+}
+
+// selectSpeakers updates the active speakers after the sender's level has
+// been updated. Only the sender can have become loud enough to become
+// active, so only the sender is considered as a candidate. The usageLock
+// must be held.
+func (r *Router) selectSpeakers(sender *routerParticipant, now time.Time) {
+	for _, p := range r.active {
+		if now.Sub(p.lastPacket) > r.SilenceTime {
+			p.loudness = 0
+		}
+	}
+
+	if sender.loudness <= 0 || containsParticipant(r.active, sender) {
+		return
+	}
+
+	if len(r.active) < r.speakers {
+		sender.activeAt = now
+		r.setActive(append(r.active, sender))
+		return
+	}
+
+	// Replace the quietest speaker that has held their place long enough,
+	// if the sender is sufficiently louder.
+	var quietest *routerParticipant
+	for _, p := range r.active {
+		if now.Sub(p.activeAt) < r.HoldTime && p.loudness > 0 {
+			continue
+		}
+
+		if quietest == nil || p.loudness < quietest.loudness {
+			quietest = p
+		}
+	}
+
+	if quietest == nil || sender.loudness < quietest.loudness+r.Hysteresis {
+		return
+	}
+
+	sender.activeAt = now
+	r.setActive(append(removeParticipant(r.active, quietest), sender))
+}
+
+// setActive sets the active speakers, pausing the forwarding of speakers
+// that are no longer active. The usageLock must be held.
+func (r *Router) setActive(active []*routerParticipant) {
+	for _, p := range r.active {
+		if containsParticipant(active, p) {
+			continue
+		}
+
+		for _, subscriber := range r.participants {
+			if state, found := subscriber.forwarding[p.name]; found {
+				state.forwarding = false
+			}
+		}
+	}
+
+	r.active = active
+}
+
+func (p *routerParticipant) forwardState(sender *routerParticipant) *forwardState {
+	state, found := p.forwarding[sender.name]
+	if !found || state.ssrc != sender.ssrc {
+		state = &forwardState{ssrc: sender.ssrc}
+		p.forwarding[sender.name] = state
+	}
+
+	return state
+}
+
+// rewrite rewrites the sequence number of a packet forwarded to the
+// subscriber, continuing on from the last packet forwarded when forwarding
+// resumes. The first packet after resuming is marked as the start of a
+// talkspurt.
+func (s *forwardState) rewrite(h *rtp.Header) {
+	if !s.started {
+		s.started = true
+		s.lastOut = h.SequenceNumber - 1
+		h.Marker = true
+	} else if !s.forwarding {
+		s.offset = s.lastOut + 1 - h.SequenceNumber
+		h.Marker = true
+	}
+
+	s.forwarding = true
+	h.SequenceNumber += s.offset
+	if rtp.SequenceNewer(h.SequenceNumber, s.lastOut) {
+		s.lastOut = h.SequenceNumber
+	}
+}
+
+func containsParticipant(list []*routerParticipant, p *routerParticipant) bool {
+	for _, other := range list {
+		if other == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeParticipant(list []*routerParticipant, p *routerParticipant) []*routerParticipant {
+	result := make([]*routerParticipant, 0, len(list))
+	for _, other := range list {
+		if other != p {
+			result = append(result, other)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,201 @@
+package conference
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1lann/dissonance/rtp"
+)
+
+const packetInterval = 20 * time.Millisecond
+
+// testRouter is a router whose participants record the packets forwarded to
+// them, and whose clock is advanced by the test.
+type testRouter struct {
+	t        *testing.T
+	router   *Router
+	now      time.Time
+	sequence map[string]uint16
+	received map[string][]rtp.Packet
+}
+
+func newTestRouter(t *testing.T, speakers int, names ...string) *testRouter {
+	r := &testRouter{
+		t:        t,
+		router:   NewRouter(speakers),
+		now:      time.Unix(1000, 0),
+		sequence: make(map[string]uint16),
+		received: make(map[string][]rtp.Packet),
+	}
+
+	for _, name := range names {
+		name := name
+		err := r.router.Join(name, func(p *rtp.Packet) error {
+			r.received[name] = append(r.received[name], *p)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return r
+}
+
+// talk sends a packet from each of the given participants at their level
+// every packet interval for a duration. Packets are identified by the
+// first letter of their sender's name in their SSRC.
+func (r *testRouter) talk(duration time.Duration, levels map[string]uint8) {
+	r.t.Helper()
+
+	for end := r.now.Add(duration); r.now.Before(end); r.now = r.now.Add(packetInterval) {
+		for _, name := range []string{"a", "b", "c"} {
+			level, found := levels[name]
+			if !found {
+				continue
+			}
+
+			p := &rtp.Packet{Header: rtp.Header{
+				SequenceNumber: r.sequence[name],
+				SSRC:           uint32(name[0]),
+			}}
+			r.sequence[name]++
+
+			if err := r.router.receive(name, p, level, r.now); err != nil {
+				r.t.Fatal(err)
+			}
+		}
+	}
+}
+
+func (r *testRouter) expectSpeakers(when string, expected ...string) {
+	r.t.Helper()
+
+	if speakers := r.router.Speakers(); !reflect.DeepEqual(speakers, expected) {
+		r.t.Errorf("%s: got speakers %v, expected %v", when, speakers, expected)
+	}
+}
+
+func TestActiveSpeakerSwitching(t *testing.T) {
+	r := newTestRouter(t, 1, "a", "b", "c")
+	r.router.HoldTime = 2 * time.Second
+
+	r.talk(time.Second, map[string]uint8{"a": 30})
+	r.expectSpeakers("a talking", "a")
+
+	// A louder speaker doesn't take over until the active speaker has held
+	// their place for the hold time.
+	r.talk(500*time.Millisecond, map[string]uint8{"a": 30, "b": 10})
+	r.expectSpeakers("b talking over a", "a")
+
+	r.talk(time.Second, map[string]uint8{"a": 30, "b": 10})
+	r.expectSpeakers("after the hold time", "b")
+
+	// A silent speaker is replaced without waiting for the hold time.
+	r.talk(500*time.Millisecond, map[string]uint8{"a": 30})
+	r.expectSpeakers("b silent", "a")
+
+	// Only the active speaker's packets are forwarded, and never back to
+	// the sender.
+	counts := make(map[string]map[uint32]int)
+	for _, name := range []string{"a", "b", "c"} {
+		counts[name] = make(map[uint32]int)
+		for _, p := range r.received[name] {
+			if p.SSRC == uint32(name[0]) {
+				t.Errorf("%s was sent their own packet", name)
+			}
+			counts[name][p.SSRC]++
+		}
+	}
+
+	// a is forwarded up to and including the packet at the end of the hold
+	// time, and again from the first packet more than the silence time
+	// after b's last.
+	fromA := 2*time.Second/packetInterval + 1 +
+		(500*time.Millisecond-DefaultSilenceTime)/packetInterval
+	fromB := 500 * time.Millisecond / packetInterval
+	expected := map[string]map[uint32]int{
+		"a": {'b': int(fromB)},
+		"b": {'a': int(fromA)},
+		"c": {'a': int(fromA), 'b': int(fromB)},
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("got packets forwarded %v, expected %v", counts, expected)
+	}
+}
+
+func TestHysteresis(t *testing.T) {
+	r := newTestRouter(t, 2, "a", "b", "c")
+	r.router.HoldTime = 0
+
+	r.talk(time.Second, map[string]uint8{"a": 40, "b": 30})
+	r.expectSpeakers("a and b talking", "b", "a")
+
+	// c is louder than the quietest speaker, but by less than the
+	// hysteresis.
+	r.talk(time.Second, map[string]uint8{"a": 40, "b": 30, "c": 40 - DefaultHysteresis + 1})
+	r.expectSpeakers("c slightly louder", "b", "a")
+
+	r.talk(time.Second, map[string]uint8{"a": 40, "b": 30, "c": 40 - DefaultHysteresis - 1})
+	r.expectSpeakers("c louder", "b", "c")
+
+	// a's forwarded stream continues on from where it left off, with the
+	// first packet after resuming marked.
+	r.talk(time.Second, map[string]uint8{"a": 10, "b": 30, "c": 40 - DefaultHysteresis - 1})
+	r.expectSpeakers("a louder again", "a", "b")
+
+	var last *rtp.Packet
+	resumed := 0
+	for i, p := range r.received["b"] {
+		if p.SSRC != 'a' {
+			continue
+		}
+
+		if last != nil && p.SequenceNumber != last.SequenceNumber+1 {
+			t.Errorf("b got a's packet %d after %d", p.SequenceNumber, last.SequenceNumber)
+		}
+		if p.Marker {
+			resumed++
+		}
+		last = &r.received["b"][i]
+	}
+
+	if resumed != 2 {
+		t.Errorf("got %d marked packets from a, expected 2", resumed)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	r := newTestRouter(t, 1, "a", "b")
+
+	errFailed := errors.New("send failed")
+	if err := r.router.Join("c", func(p *rtp.Packet) error {
+		return errFailed
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var failedName string
+	var failedErr error
+	r.router.OnSendError = func(name string, err error) {
+		failedName, failedErr = name, err
+	}
+
+	r.talk(packetInterval, map[string]uint8{"a": 30})
+	if failedName != "c" || failedErr != errFailed {
+		t.Errorf("got send error %q, %v, expected c, %v", failedName, failedErr, errFailed)
+	}
+
+	err := r.router.receive("c", &rtp.Packet{}, 30, r.now)
+	if err != ErrNotJoined {
+		t.Errorf("c: got %v, expected ErrNotJoined", err)
+	}
+
+	// The other participants are unaffected.
+	r.talk(packetInterval, map[string]uint8{"a": 30})
+	if len(r.received["b"]) != 2 {
+		t.Errorf("b received %d packets, expected 2", len(r.received["b"]))
+	}
+}
@@ -0,0 +1,231 @@
+// Package congestion implements congestion control for sending audio over a
+// network. A Controller consumes the loss, jitter and round trip time
+// reported by the receiver, and decides the bitrate, packet duration and
+// level of redundancy the sender should use so that the call follows the
+// capacity of the network rather than overloading it.
+//
+// The package also includes a simulation of a bottleneck link, for
+// replaying bandwidth traces against a controller.
+package congestion
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Defaults for the bitrates of a Controller.
+const (
+	DefaultMinBitrate   = 8000
+	DefaultMaxBitrate   = 128000
+	DefaultStartBitrate = 32000
+)
+
+// Loss fractions at which the controller changes behaviour, from the
+// loss-based controller of Google Congestion Control.
+const (
+	lowLoss  = 0.02
+	highLoss = 0.1
+)
+
+// Rates of bitrate changes.
+const (
+	// increasePerSecond is the multiplicative increase of the bitrate each
+	// second while the network is uncongested.
+	increasePerSecond = 0.08
+
+	// delayBackoff is the multiplicative decrease of the bitrate when
+	// queueing delay builds up.
+	delayBackoff = 0.85
+)
+
+// queueingThreshold is the round trip time above the lowest seen that
+// indicates a queue building at the bottleneck.
+const queueingThreshold = 60 * time.Millisecond
+
+// minRTTWindow is how long the lowest round trip time is remembered, so that
+// route changes don't leave the controller permanently congested.
+const minRTTWindow = 30 * time.Second
+
+// Feedback represents a report from the receiver about the packets it
+// received since its last report, such as from an RTCP receiver report.
+type Feedback struct {
+	// Loss is the fraction of packets lost, between 0 and 1.
+	Loss float64
+
+	// Jitter is the interarrival jitter of packets.
+	Jitter time.Duration
+
+	// RTT is the round trip time between the sender and receiver.
+	RTT time.Duration
+}
+
+// Settings represents the settings the sender should use.
+type Settings struct {
+	// Bitrate is the total bitrate the sender may use, in bits per second.
+	Bitrate int
+
+	// EncoderBitrate is the bitrate the audio encoder should target, which
+	// is the share of Bitrate left after packet headers and redundancy.
+	// Redundancy is dropped and packets are lengthened to keep it above half
+	// of the controller's MinBitrate where possible, but Bitrate is only
+	// exceeded if it can't carry the headers of the longest packets.
+	EncoderBitrate int
+
+	// PacketDuration is the duration of audio carried by each packet.
+	PacketDuration time.Duration
+
+	// Redundancy is the number of previous frames that should be carried
+	// by each packet as forward error correction, such as the distance of
+	// an rtp.REDEncoder.
+	Redundancy int
+}
+
+// Controller represents a congestion controller for a media sender.
+type Controller struct {
+	// MinBitrate and MaxBitrate bound the bitrate, in bits per second.
+	MinBitrate int
+	MaxBitrate int
+
+	bitrate    float64
+	lastUpdate time.Time
+	minRTT     time.Duration
+	minRTTAt   time.Time
+	lastJitter time.Duration
+	loss       float64
+	usageLock  *sync.Mutex
+}
+
+// NewController returns a new congestion controller which starts at the
+// given bitrate in bits per second.
+func NewController(startBitrate int) *Controller {
+	if startBitrate <= 0 {
+		panic("congestion: start bitrate must be positive")
+	}
+
+	return &Controller{
+		MinBitrate: DefaultMinBitrate,
+		MaxBitrate: DefaultMaxBitrate,
+		bitrate:    float64(startBitrate),
+		usageLock:  new(sync.Mutex),
+	}
+}
+
+// Update updates the controller with feedback received at the given time,
+// and returns the settings the sender should now use.
+func (c *Controller) Update(fb Feedback, now time.Time) Settings {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	elapsed := 0.0
+	if !c.lastUpdate.IsZero() {
+		elapsed = now.Sub(c.lastUpdate).Seconds()
+	}
+	c.lastUpdate = now
+
+	if fb.RTT > 0 && (c.minRTT == 0 || fb.RTT < c.minRTT ||
+		now.Sub(c.minRTTAt) > minRTTWindow) {
+		c.minRTT = fb.RTT
+		c.minRTTAt = now
+	}
+
+	// Rising round trip times and jitter mean a queue is building at the
+	// bottleneck, before it overflows into loss.
+	queueing := fb.RTT > c.minRTT+queueingThreshold ||
+		(c.lastJitter > 0 && fb.Jitter > 2*c.lastJitter && fb.Jitter > queueingThreshold/2)
+	c.lastJitter = fb.Jitter
+	c.loss = fb.Loss
+
+	switch {
+	case fb.Loss > highLoss:
+		c.bitrate *= 1 - fb.Loss/2
+	case queueing:
+		c.bitrate *= delayBackoff
+	case fb.Loss < lowLoss:
+		c.bitrate *= math.Pow(1+increasePerSecond, elapsed)
+	}
+
+	c.bitrate = math.Max(float64(c.MinBitrate), math.Min(float64(c.MaxBitrate), c.bitrate))
+
+	return c.settings()
+}
+
+// Settings returns the settings the sender should currently use.
+func (c *Controller) Settings() Settings {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	return c.settings()
+}
+
+// packetOverhead is the size of the IP, UDP and RTP headers of each packet,
+// in bytes.
+const packetOverhead = 40
+
+// redHeaderLength is the size of each redundant block's RED header.
+const redHeaderLength = 4
+
+// packetDurations are the packet durations the controller chooses from, from
+// shortest to longest.
+var packetDurations = []time.Duration{
+	20 * time.Millisecond,
+	40 * time.Millisecond,
+	60 * time.Millisecond,
+	120 * time.Millisecond,
+}
+
+func (c *Controller) settings() Settings {
+	s := Settings{Bitrate: int(c.bitrate)}
+
+	// Redundancy helps with random loss, but only while there is capacity
+	// to spare for it.
+	switch {
+	case c.loss >= highLoss && s.Bitrate >= 3*c.MinBitrate:
+		s.Redundancy = 2
+	case c.loss >= lowLoss && s.Bitrate >= 2*c.MinBitrate:
+		s.Redundancy = 1
+	}
+
+	// At low bitrates, headers are a large share of each packet, so fewer
+	// and longer packets are sent.
+	duration := 0
+	switch {
+	case s.Bitrate < 16000:
+		duration = 2
+	case s.Bitrate < 32000:
+		duration = 1
+	}
+
+	// If headers and redundancy leave the encoder too little, redundancy is
+	// dropped and then packets are lengthened, rather than exceeding the
+	// bitrate.
+	for {
+		s.PacketDuration = packetDurations[duration]
+		s.EncoderBitrate = encoderBitrate(s)
+		if s.EncoderBitrate >= c.MinBitrate/2 {
+			break
+		}
+
+		if s.Redundancy > 0 {
+			s.Redundancy--
+		} else if duration < len(packetDurations)-1 {
+			duration++
+		} else {
+			break
+		}
+	}
+
+	if s.EncoderBitrate < 0 {
+		s.EncoderBitrate = 0
+	}
+
+	return s
+}
+
+// encoderBitrate returns the share of the bitrate left for the encoder after
+// the headers of each packet, shared with redundant copies of its frames.
+func encoderBitrate(s Settings) int {
+	headers := float64((packetOverhead+s.Redundancy*redHeaderLength)*8) /
+		s.PacketDuration.Seconds()
+	return int((float64(s.Bitrate) - headers) / float64(1+s.Redundancy))
+}
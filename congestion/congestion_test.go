@@ -0,0 +1,177 @@
+package congestion
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+const feedbackInterval = time.Second
+
+// testController is a controller which has received an uncongested report,
+// so that later reports are a feedback interval apart.
+type testController struct {
+	t          *testing.T
+	controller *Controller
+	now        time.Time
+}
+
+func newTestController(t *testing.T, bitrate int) *testController {
+	c := &testController{
+		t:          t,
+		controller: NewController(bitrate),
+		now:        time.Unix(1000, 0),
+	}
+	c.controller.Update(Feedback{Loss: 0.05, RTT: 50 * time.Millisecond}, c.now)
+
+	return c
+}
+
+// update reports feedback a feedback interval after the last report, and
+// returns the change in bitrate.
+func (c *testController) update(fb Feedback) float64 {
+	c.t.Helper()
+
+	before := c.controller.Settings().Bitrate
+	c.now = c.now.Add(feedbackInterval)
+	after := c.controller.Update(fb, c.now).Bitrate
+
+	return float64(after) / float64(before)
+}
+
+func (c *testController) expectChange(when string, fb Feedback, expected float64) {
+	c.t.Helper()
+
+	if change := c.update(fb); math.Abs(change-expected) > 0.001 {
+		c.t.Errorf("%s: got a bitrate change of %.3f, expected %.3f", when, change, expected)
+	}
+}
+
+func TestLoss(t *testing.T) {
+	tests := []struct {
+		loss     float64
+		expected float64
+	}{
+		// High loss backs off in proportion to the loss.
+		{0.5, 0.75},
+		{0.2, 0.9},
+		{0.11, 0.945},
+		// Moderate loss holds the bitrate.
+		{0.1, 1},
+		{0.05, 1},
+		{0.02, 1},
+		// Low loss increases the bitrate.
+		{0.01, 1 + increasePerSecond},
+		{0, 1 + increasePerSecond},
+	}
+
+	for _, test := range tests {
+		c := newTestController(t, 64000)
+		c.expectChange(fmt.Sprintf("%.2f loss", test.loss),
+			Feedback{Loss: test.loss, RTT: 50 * time.Millisecond}, test.expected)
+	}
+}
+
+func TestIncreaseOverTime(t *testing.T) {
+	c := newTestController(t, 16000)
+
+	// The increase compounds with the time between reports.
+	before := c.controller.Settings().Bitrate
+	c.now = c.now.Add(10 * time.Second)
+	after := c.controller.Update(Feedback{RTT: 50 * time.Millisecond}, c.now).Bitrate
+
+	expected := float64(before) * math.Pow(1+increasePerSecond, 10)
+	if math.Abs(float64(after)-expected) > 1 {
+		t.Errorf("got bitrate %d, expected %.0f", after, expected)
+	}
+}
+
+func TestDelayOveruse(t *testing.T) {
+	c := newTestController(t, 64000)
+
+	// Round trip times within the threshold of the lowest seen are normal.
+	c.expectChange("small rtt increase", Feedback{RTT: 100 * time.Millisecond},
+		1+increasePerSecond)
+
+	// A growing queue at the bottleneck backs off before any loss.
+	c.expectChange("queueing", Feedback{RTT: 150 * time.Millisecond}, delayBackoff)
+	c.expectChange("still queueing", Feedback{RTT: 200 * time.Millisecond}, delayBackoff)
+	c.expectChange("drained", Feedback{RTT: 60 * time.Millisecond}, 1+increasePerSecond)
+
+	// A sudden rise in jitter also indicates queueing.
+	c.expectChange("low jitter", Feedback{Jitter: 10 * time.Millisecond,
+		RTT: 50 * time.Millisecond}, 1+increasePerSecond)
+	c.expectChange("rising jitter", Feedback{Jitter: 40 * time.Millisecond,
+		RTT: 50 * time.Millisecond}, delayBackoff)
+	c.expectChange("steady jitter", Feedback{Jitter: 40 * time.Millisecond,
+		RTT: 50 * time.Millisecond}, 1+increasePerSecond)
+
+	// High loss takes precedence over queueing.
+	c.expectChange("queueing with loss", Feedback{Loss: 0.4, RTT: 200 * time.Millisecond},
+		0.8)
+
+	// The lowest round trip time is forgotten after a while, so that a
+	// longer route isn't treated as congested forever.
+	c = newTestController(t, DefaultMaxBitrate)
+	c.controller.MinBitrate = 0
+	for elapsed := feedbackInterval; elapsed <= minRTTWindow; elapsed += feedbackInterval {
+		c.expectChange("longer route", Feedback{RTT: 200 * time.Millisecond}, delayBackoff)
+	}
+	c.expectChange("after the window", Feedback{RTT: 200 * time.Millisecond},
+		1+increasePerSecond)
+}
+
+func TestBitrateBounds(t *testing.T) {
+	c := newTestController(t, 64000)
+	c.controller.MinBitrate = 20000
+	c.controller.MaxBitrate = 80000
+
+	for i := 0; i < 20; i++ {
+		c.update(Feedback{RTT: 50 * time.Millisecond})
+	}
+	if bitrate := c.controller.Settings().Bitrate; bitrate != 80000 {
+		t.Errorf("got bitrate %d after low loss, expected 80000", bitrate)
+	}
+
+	for i := 0; i < 20; i++ {
+		c.update(Feedback{Loss: 0.5, RTT: 50 * time.Millisecond})
+	}
+	if bitrate := c.controller.Settings().Bitrate; bitrate != 20000 {
+		t.Errorf("got bitrate %d after high loss, expected 20000", bitrate)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	tests := []struct {
+		bitrate  int
+		loss     float64
+		expected Settings
+	}{
+		{64000, 0, Settings{64000, 48000, 20 * time.Millisecond, 0}},
+		{32000, 0, Settings{32000, 16000, 20 * time.Millisecond, 0}},
+		// Lower bitrates use longer packets to spend less on headers.
+		{31999, 0, Settings{31999, 23999, 40 * time.Millisecond, 0}},
+		{15999, 0, Settings{15999, 10665, 60 * time.Millisecond, 0}},
+		// Loss adds redundancy when there is capacity for it.
+		{64000, 0.02, Settings{64000, 23200, 20 * time.Millisecond, 1}},
+		{64000, 0.1, Settings{64000, 14933, 20 * time.Millisecond, 2}},
+		{30000, 0.1, Settings{30000, 6800, 40 * time.Millisecond, 2}},
+		{20000, 0.1, Settings{20000, 5600, 40 * time.Millisecond, 1}},
+		{64000, 0.019, Settings{64000, 48000, 20 * time.Millisecond, 0}},
+		// Redundancy is dropped when it would leave the encoder too little.
+		{16000, 0.05, Settings{16000, 8000, 40 * time.Millisecond, 0}},
+		// Then packets are lengthened.
+		{8000, 0.1, Settings{8000, 5333, 120 * time.Millisecond, 0}},
+	}
+
+	for _, test := range tests {
+		c := NewController(test.bitrate)
+		c.loss = test.loss
+
+		if settings := c.Settings(); settings != test.expected {
+			t.Errorf("%d bit/s, %.3f loss: got %+v, expected %+v", test.bitrate, test.loss,
+				settings, test.expected)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package congestion
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTrace is returned when a bandwidth trace cannot be parsed.
+var ErrInvalidTrace = errors.New("congestion: invalid trace")
+
+// TracePoint represents the conditions of a link for a period of time.
+type TracePoint struct {
+	Duration time.Duration
+
+	// Bandwidth is the capacity of the link in bits per second.
+	Bandwidth int
+
+	// Delay is the one way propagation delay of the link.
+	Delay time.Duration
+
+	// Loss is the fraction of packets randomly lost by the link, in
+	// addition to those dropped when its queue overflows.
+	Loss float64
+}
+
+// ParseTrace parses a bandwidth trace. Each line has the duration in
+// milliseconds and bandwidth in kbit/s of a period, optionally followed by
+// the one way delay in milliseconds and the random loss fraction. Blank
+// lines and lines starting with # are ignored.
+func ParseTrace(r io.Reader) ([]TracePoint, error) {
+	var trace []TracePoint
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, ErrInvalidTrace
+		}
+
+		values := make([]float64, 4)
+		for i, field := range fields {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil || value < 0 {
+				return nil, ErrInvalidTrace
+			}
+			values[i] = value
+		}
+
+		trace = append(trace, TracePoint{
+			Duration:  time.Duration(values[0] * float64(time.Millisecond)),
+			Bandwidth: int(values[1] * 1000),
+			Delay:     time.Duration(values[2] * float64(time.Millisecond)),
+			Loss:      values[3],
+		})
+	}
+
+	return trace, scanner.Err()
+}
+
+// Simulation represents the simulation of a sender using a controller to
+// send audio over a bottleneck link following a bandwidth trace. The link
+// has a drop-tail queue, and the receiver reports feedback at a regular
+// interval.
+type Simulation struct {
+	// QueueLength is the longest queueing delay the link's queue holds
+	// before dropping packets.
+	QueueLength time.Duration
+
+	// FeedbackInterval is the time between receiver reports.
+	FeedbackInterval time.Duration
+
+	// Seed seeds the random loss of the link.
+	Seed int64
+}
+
+// Sample represents the state of a simulation when feedback was delivered.
+type Sample struct {
+	Time      time.Duration
+	Bandwidth int
+	Settings  Settings
+
+	// SendRate is the rate the sender sent at since the last sample, in bits
+	// per second, including headers and redundancy.
+	SendRate int
+	Feedback Feedback
+}
+
+// NewSimulation returns a new simulation with a 200ms queue and feedback
+// every 500ms.
+func NewSimulation() *Simulation {
+	return &Simulation{
+		QueueLength:      200 * time.Millisecond,
+		FeedbackInterval: 500 * time.Millisecond,
+		Seed:             1,
+	}
+}
+
+// Run replays the trace against the controller, and returns a sample for
+// each feedback report.
+func (s *Simulation) Run(trace []TracePoint, c *Controller) []Sample {
+	random := rand.New(rand.NewSource(s.Seed))
+	start := time.Unix(0, 0)
+
+	var samples []Sample
+	var now time.Duration
+	var queueBits float64
+	var sent, lost, sentBits int
+	var lastDelay, jitter float64
+	var delay, lastFeedback time.Duration
+	nextFeedback := s.FeedbackInterval
+
+	settings := c.Settings()
+	for _, point := range trace {
+		end := now + point.Duration
+		for now < end {
+			tick := settings.PacketDuration
+			bandwidth := float64(point.Bandwidth)
+
+			// The link drains its queue while the packet is produced.
+			queueBits = math.Max(0, queueBits-bandwidth*tick.Seconds())
+
+			payload := float64(settings.EncoderBitrate) * tick.Seconds() / 8
+			size := packetOverhead + payload +
+				float64(settings.Redundancy)*(payload+redHeaderLength)
+			bits := size * 8
+
+			sent++
+			sentBits += int(bits)
+
+			queueing := 0.0
+			if bandwidth > 0 {
+				queueing = queueBits / bandwidth
+			}
+
+			if bandwidth == 0 || queueing > s.QueueLength.Seconds() ||
+				random.Float64() < point.Loss {
+				lost++
+			} else {
+				queueBits += bits
+				transit := point.Delay.Seconds() + queueing
+				if bandwidth > 0 {
+					transit += bits / bandwidth
+				}
+
+				// Interarrival jitter as computed by RTP receivers.
+				if lastDelay > 0 {
+					jitter += (math.Abs(transit-lastDelay) - jitter) / 16
+				}
+				lastDelay = transit
+				delay = time.Duration(transit * float64(time.Second))
+			}
+
+			now += tick
+			if now < nextFeedback {
+				continue
+			}
+
+			fb := Feedback{
+				Loss:   float64(lost) / float64(sent),
+				Jitter: time.Duration(jitter * float64(time.Second)),
+				RTT:    delay + point.Delay,
+			}
+
+			samples = append(samples, Sample{
+				Time:      now,
+				Bandwidth: point.Bandwidth,
+				Settings:  settings,
+				SendRate:  int(float64(sentBits) / (now - lastFeedback).Seconds()),
+				Feedback:  fb,
+			})
+
+			settings = c.Update(fb, start.Add(now))
+			sent, lost, sentBits = 0, 0, 0
+			lastFeedback = now
+			nextFeedback += s.FeedbackInterval
+		}
+	}
+
+	return samples
+}
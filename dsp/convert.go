@@ -0,0 +1,28 @@
+package dsp
+
+import "math"
+
+// fullScale is the magnitude of a full scale int32 sample.
+const fullScale = 2147483648
+
+// FromInt32 converts samples to floating point values between -1 and 1.
+func FromInt32(dst []float64, src []int32) {
+	for i, sample := range src {
+		dst[i] = float64(sample) / fullScale
+	}
+}
+
+// ToInt32 converts floating point values between -1 and 1 to samples,
+// clipping values out of range.
+func ToInt32(dst []int32, src []float64) {
+	for i, value := range src {
+		value *= fullScale
+		if value >= math.MaxInt32 {
+			dst[i] = math.MaxInt32
+		} else if value <= math.MinInt32 {
+			dst[i] = math.MinInt32
+		} else {
+			dst[i] = int32(value)
+		}
+	}
+}
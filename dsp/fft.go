@@ -0,0 +1,103 @@
+// Package dsp contains the signal processing building blocks shared by the
+// frequency domain filters, such as the FFT and analysis windows.
+package dsp
+
+import (
+	"math"
+	"math/bits"
+)
+
+// FFT represents a fast Fourier transform of a fixed size, with its twiddle
+// factors and bit reversal permutation precomputed.
+type FFT struct {
+	size    int
+	twiddle []complex128
+	reverse []int
+}
+
+// NewFFT returns a new FFT of the given size, which must be a power of two.
+func NewFFT(size int) *FFT {
+	if size <= 0 || size&(size-1) != 0 {
+		panic("dsp: FFT size must be a power of two")
+	}
+
+	f := &FFT{
+		size:    size,
+		twiddle: make([]complex128, size/2),
+		reverse: make([]int, size),
+	}
+
+	for i := range f.twiddle {
+		sin, cos := math.Sincos(-2 * math.Pi * float64(i) / float64(size))
+		f.twiddle[i] = complex(cos, sin)
+	}
+
+	shift := 64 - bits.Len(uint(size-1))
+	for i := range f.reverse {
+		if size > 1 {
+			f.reverse[i] = int(bits.Reverse64(uint64(i)) >> uint(shift))
+		}
+	}
+
+	return f
+}
+
+// Size returns the size of the transform.
+func (f *FFT) Size() int {
+	return f.size
+}
+
+// Forward computes the discrete Fourier transform of x in place.
+func (f *FFT) Forward(x []complex128) {
+	f.transform(x, false)
+}
+
+// Inverse computes the inverse discrete Fourier transform of x in place,
+// including the 1/N scaling.
+func (f *FFT) Inverse(x []complex128) {
+	f.transform(x, true)
+
+	scale := complex(1/float64(f.size), 0)
+	for i := range x {
+		x[i] *= scale
+	}
+}
+
+func (f *FFT) transform(x []complex128, inverse bool) {
+	if len(x) != f.size {
+		panic("dsp: FFT input has the wrong size")
+	}
+
+	for i, j := range f.reverse {
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for length := 2; length <= f.size; length <<= 1 {
+		half := length / 2
+		step := f.size / length
+		for start := 0; start < f.size; start += length {
+			for k := 0; k < half; k++ {
+				w := f.twiddle[k*step]
+				if inverse {
+					w = complex(real(w), -imag(w))
+				}
+
+				a := x[start+k]
+				b := x[start+k+half] * w
+				x[start+k] = a + b
+				x[start+k+half] = a - b
+			}
+		}
+	}
+}
+
+// NextPowerOfTwo returns the smallest power of two at least n.
+func NextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+
+	return 1 << uint(bits.Len(uint(n-1)))
+}
@@ -0,0 +1,12 @@
+package dsp
+
+// Interpolate returns the sample at a fractional position in buffer by
+// linear interpolation between the samples either side of it. The sample
+// after the position must be in buffer.
+func Interpolate(buffer []int32, position float64) int32 {
+	i := int(position)
+	between := position - float64(i)
+	// The difference is taken in float64, as it overflows an int32 between
+	// samples of opposite sign near full scale.
+	return int32(float64(buffer[i]) + (float64(buffer[i+1])-float64(buffer[i]))*between)
+}
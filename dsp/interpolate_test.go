@@ -0,0 +1,27 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInterpolateFullScale(t *testing.T) {
+	buffer := []int32{math.MaxInt32, math.MinInt32, math.MaxInt32}
+
+	tests := []struct {
+		position float64
+		expected float64
+	}{
+		{0, math.MaxInt32},
+		{0.25, math.MaxInt32 - 0.25*(math.MaxInt32-math.MinInt32)},
+		{0.5, -0.5},
+		{1.5, -0.5},
+		{1.75, math.MinInt32 + 0.75*(math.MaxInt32-math.MinInt32)},
+	}
+
+	for _, test := range tests {
+		if got := Interpolate(buffer, test.position); math.Abs(float64(got)-test.expected) > 1 {
+			t.Errorf("position %v: got %d, expected %.0f", test.position, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package dsp
+
+import "math"
+
+// HannWindow returns a periodic Hann window of the given size. Overlapping
+// the windows of frames by half their size sums to a constant, as needed for
+// STFT analysis and resynthesis.
+func HannWindow(size int) []float64 {
+	w := make([]float64, size)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+	}
+
+	return w
+}
+
+// SqrtHannWindow returns the square root of a periodic Hann window, for
+// applying the window both before analysis and after resynthesis.
+func SqrtHannWindow(size int) []float64 {
+	w := HannWindow(size)
+	for i := range w {
+		w[i] = math.Sqrt(w[i])
+	}
+
+	return w
+}
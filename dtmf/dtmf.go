@@ -0,0 +1,87 @@
+// Package dtmf implements dual-tone multi-frequency signalling, for sending
+// keypad digits to IVR systems. Digits are sent and received as telephone
+// events over RTP (RFC 4733), and can also be generated as in-band tones.
+package dtmf
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// MaxVolume is the quietest volume of a telephone event, in -dBm0. The
+// loudest volume is 0.
+const MaxVolume = 63
+
+const payloadLength = 4
+
+// Errors returned when encoding and decoding events.
+var (
+	ErrInvalidDigit   = errors.New("dtmf: invalid digit")
+	ErrInvalidPayload = errors.New("dtmf: invalid telephone event payload")
+)
+
+// eventDigits is the keypad digit of each DTMF event code.
+const eventDigits = "0123456789*#ABCD"
+
+// EventCode returns the telephone event code of a keypad digit, which is one
+// of 0-9, *, #, or A-D.
+func EventCode(digit rune) (uint8, error) {
+	if digit >= 'a' && digit <= 'd' {
+		digit -= 'a' - 'A'
+	}
+
+	for i, d := range eventDigits {
+		if d == digit {
+			return uint8(i), nil
+		}
+	}
+
+	return 0, ErrInvalidDigit
+}
+
+// Digit returns the keypad digit of a telephone event code.
+func Digit(code uint8) (rune, error) {
+	if int(code) >= len(eventDigits) {
+		return 0, ErrInvalidDigit
+	}
+
+	return rune(eventDigits[code]), nil
+}
+
+// Payload represents a telephone event payload.
+type Payload struct {
+	Event  uint8
+	End    bool
+	Volume uint8
+
+	// Duration is the duration of the event so far, in units of the RTP
+	// clock rate.
+	Duration uint16
+}
+
+// Marshal encodes the payload into its wire format.
+func (p *Payload) Marshal() []byte {
+	b := make([]byte, payloadLength)
+	b[0] = p.Event
+	b[1] = p.Volume & MaxVolume
+	if p.End {
+		b[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(b[2:], p.Duration)
+
+	return b
+}
+
+// Unmarshal decodes a payload from its wire format.
+func (p *Payload) Unmarshal(b []byte) error {
+	if len(b) < payloadLength {
+		return ErrInvalidPayload
+	}
+
+	p.Event = b[0]
+	p.End = b[1]&0x80 != 0
+	p.Volume = b[1] & MaxVolume
+	p.Duration = binary.BigEndian.Uint16(b[2:])
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package dtmf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1lann/dissonance/rtp"
+)
+
+const testPayloadType = 101
+
+func TestEventCode(t *testing.T) {
+	for i, digit := range eventDigits {
+		code, err := EventCode(digit)
+		if err != nil || code != uint8(i) {
+			t.Errorf("%c: got %d, %v, expected %d", digit, code, err, i)
+		}
+
+		if d, err := Digit(code); err != nil || d != digit {
+			t.Errorf("%d: got %c, %v, expected %c", code, d, err, digit)
+		}
+	}
+
+	if code, err := EventCode('b'); err != nil || code != 13 {
+		t.Errorf("b: got %d, %v, expected 13", code, err)
+	}
+	if _, err := EventCode('E'); err != ErrInvalidDigit {
+		t.Errorf("E: got %v, expected ErrInvalidDigit", err)
+	}
+	if _, err := Digit(16); err != ErrInvalidDigit {
+		t.Errorf("16: got %v, expected ErrInvalidDigit", err)
+	}
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	payloads := []Payload{
+		{Event: 5, Volume: 10, Duration: 800},
+		{Event: 11, End: true, Volume: MaxVolume, Duration: 0xffff},
+	}
+
+	for _, p := range payloads {
+		var decoded Payload
+		if err := decoded.Unmarshal(p.Marshal()); err != nil {
+			t.Fatal(err)
+		}
+		if decoded != p {
+			t.Errorf("got %+v, expected %+v", decoded, p)
+		}
+	}
+
+	if err := new(Payload).Unmarshal([]byte{1, 2, 3}); err != ErrInvalidPayload {
+		t.Errorf("short payload: got %v, expected ErrInvalidPayload", err)
+	}
+}
+
+// send sends a digit with a sender using the given clock rate, and returns
+// the packets it wrote.
+func send(t *testing.T, clockRate int, digit rune, duration time.Duration,
+	timestamp uint32) []*rtp.Packet {
+	t.Helper()
+
+	var packets []*rtp.Packet
+	s := NewSender(testPayloadType, func(p *rtp.Packet) error {
+		packets = append(packets, p)
+		return nil
+	})
+	s.ClockRate = clockRate
+	s.Interval = 10 * time.Millisecond
+
+	if err := s.SendDigit(digit, duration, timestamp); err != nil {
+		t.Fatal(err)
+	}
+
+	return packets
+}
+
+func decode(t *testing.T, p *rtp.Packet) Payload {
+	t.Helper()
+
+	var payload Payload
+	if err := payload.Unmarshal(p.Payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return payload
+}
+
+// receive passes packets to a receiver, and returns the events reported.
+func receive(t *testing.T, r *Receiver, packets []*rtp.Packet) []Event {
+	t.Helper()
+
+	var events []Event
+	for _, p := range packets {
+		e, err := r.Receive(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e...)
+	}
+
+	return events
+}
+
+func TestSendReceive(t *testing.T) {
+	packets := send(t, DefaultClockRate, '5', 100*time.Millisecond, 1000)
+
+	// A packet is sent every 10ms, followed by three end packets.
+	if len(packets) != 9+endRedundancy {
+		t.Fatalf("got %d packets, expected %d", len(packets), 9+endRedundancy)
+	}
+
+	for i, p := range packets {
+		payload := decode(t, p)
+		if p.PayloadType != testPayloadType || p.Timestamp != 1000 ||
+			payload.Event != 5 || payload.Volume != DefaultVolume {
+			t.Errorf("packet %d: got %+v with %+v", i, p.Header, payload)
+		}
+
+		if p.Marker != (i == 0) {
+			t.Errorf("packet %d: got marker %v", i, p.Marker)
+		}
+
+		end := i >= len(packets)-endRedundancy
+		expected := uint16(80 * (i + 1))
+		if end {
+			expected = 800
+		}
+		if payload.End != end || payload.Duration != expected {
+			t.Errorf("packet %d: got end %v, duration %d, expected %v, %d", i,
+				payload.End, payload.Duration, end, expected)
+		}
+	}
+
+	r := NewReceiver(testPayloadType)
+	events := receive(t, r, packets)
+	expected := []Event{{Digit: '5'}, {Digit: '5', End: true, Duration: 100 * time.Millisecond}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+
+	// Retransmissions and packets of other payload types are ignored.
+	if events := receive(t, r, packets); len(events) != 0 {
+		t.Errorf("got events %+v from retransmitted packets", events)
+	}
+	other := &rtp.Packet{Header: rtp.Header{PayloadType: 0, Timestamp: 5000}}
+	if events := receive(t, r, []*rtp.Packet{other}); len(events) != 0 {
+		t.Errorf("got events %+v from another payload type", events)
+	}
+}
+
+func TestEndRedundancy(t *testing.T) {
+	packets := send(t, DefaultClockRate, '#', 50*time.Millisecond, 0)
+
+	// The end is received as long as one of the end packets arrives.
+	for lost := 0; lost < endRedundancy; lost++ {
+		received := append([]*rtp.Packet(nil), packets[:len(packets)-endRedundancy]...)
+		received = append(received, packets[len(packets)-1-lost])
+
+		events := receive(t, NewReceiver(testPayloadType), received)
+		expected := []Event{{Digit: '#'}, {Digit: '#', End: true, Duration: 50 * time.Millisecond}}
+		if !reflect.DeepEqual(events, expected) {
+			t.Errorf("losing all but end packet %d: got %+v, expected %+v", lost, events,
+				expected)
+		}
+	}
+}
+
+func TestLostEnd(t *testing.T) {
+	first := send(t, DefaultClockRate, '1', 100*time.Millisecond, 1000)
+	second := send(t, DefaultClockRate, '2', 100*time.Millisecond, 3000)
+
+	// Every end packet of the first event is lost, and the last packet
+	// received carried a duration of 90ms.
+	received := append(first[:len(first)-endRedundancy], second...)
+
+	events := receive(t, NewReceiver(testPayloadType), received)
+	expected := []Event{
+		{Digit: '1'},
+		{Digit: '1', End: true, Duration: 90 * time.Millisecond},
+		{Digit: '2'},
+		{Digit: '2', End: true, Duration: 100 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+
+	// A late packet of the first event is ignored.
+	r := NewReceiver(testPayloadType)
+	receive(t, r, second)
+	if events := receive(t, r, first[:1]); len(events) != 0 {
+		t.Errorf("got events %+v from a late packet", events)
+	}
+}
+
+func TestLongEvent(t *testing.T) {
+	// At a clock rate of 1 MHz, a packet's duration field carries at most
+	// 65.535ms, so a 200ms event is split into four segments.
+	const clockRate = 1000000
+	const start = 0xfff00000
+	packets := send(t, clockRate, '9', 200*time.Millisecond, start)
+
+	// Group the packets into segments by their timestamps.
+	var timestamps []uint32
+	var segments [][]Payload
+	for _, p := range packets {
+		if len(timestamps) == 0 || p.Timestamp != timestamps[len(timestamps)-1] {
+			timestamps = append(timestamps, p.Timestamp)
+			segments = append(segments, nil)
+		}
+		segments[len(segments)-1] = append(segments[len(segments)-1], decode(t, p))
+	}
+
+	expected := []uint32{start, start + maxDuration, start + 2*maxDuration,
+		start + 3*maxDuration}
+	if !reflect.DeepEqual(timestamps, expected) {
+		t.Fatalf("got segments at %#x, expected %#x", timestamps, expected)
+	}
+
+	// Every segment but the last ends at the maximum duration without the
+	// end bit, and the last ends with the end packets.
+	var total int64
+	for i, segment := range segments {
+		last := segment[len(segment)-1]
+		total += int64(last.Duration)
+
+		if i < len(segments)-1 && (last.End || last.Duration != maxDuration) {
+			t.Errorf("segment %d: ended with %+v, expected the maximum duration", i, last)
+		}
+	}
+
+	final := segments[len(segments)-1]
+	for _, payload := range final[len(final)-endRedundancy:] {
+		if !payload.End {
+			t.Errorf("last segment: got %+v, expected end packets", payload)
+		}
+	}
+	if total != 200000 {
+		t.Errorf("got a total duration of %d, expected 200000", total)
+	}
+
+	r := NewReceiver(testPayloadType)
+	r.ClockRate = clockRate
+	events := receive(t, r, packets)
+	expectedEvents := []Event{{Digit: '9'}, {Digit: '9', End: true, Duration: 200 * time.Millisecond}}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %+v, expected %+v", events, expectedEvents)
+	}
+}
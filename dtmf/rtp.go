@@ -0,0 +1,258 @@
+package dtmf
+
+import (
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/rtp"
+)
+
+// Defaults for sending telephone events.
+const (
+	DefaultClockRate = 8000
+	DefaultInterval  = 50 * time.Millisecond
+	DefaultVolume    = 10
+	DefaultDuration  = 100 * time.Millisecond
+)
+
+// endRedundancy is the number of times the final packet of an event is
+// sent, so the end of the event survives packet loss.
+const endRedundancy = 3
+
+// maxDuration is the longest duration a single event packet can carry.
+// Longer events are split into segments.
+const maxDuration = 0xffff
+
+// Sender sends telephone events over RTP.
+type Sender struct {
+	payloadType uint8
+	write       func(p *rtp.Packet) error
+
+	// ClockRate is the RTP clock rate negotiated for telephone events.
+	ClockRate int
+
+	// Interval is the time between packets updating the duration of an
+	// event.
+	Interval time.Duration
+
+	// Volume is the volume of events, in -dBm0.
+	Volume uint8
+
+	usageLock *sync.Mutex
+}
+
+// NewSender returns a new sender which sends telephone events with the given
+// payload type. Packets are passed to write, which must set their SSRC and
+// sequence number as they share those of the audio stream.
+func NewSender(payloadType uint8, write func(p *rtp.Packet) error) *Sender {
+	return &Sender{
+		payloadType: payloadType,
+		write:       write,
+		ClockRate:   DefaultClockRate,
+		Interval:    DefaultInterval,
+		Volume:      DefaultVolume,
+		usageLock:   new(sync.Mutex),
+	}
+}
+
+// SendDigit sends a digit held for the given duration, starting at the given
+// RTP timestamp. It blocks until the event has been sent, which takes about
+// as long as the duration. Digits are sent one at a time.
+//
+// Events longer than a packet's duration field can carry are split into
+// segments, as described in RFC 4733 section 2.5.1.3.
+func (s *Sender) SendDigit(digit rune, duration time.Duration, timestamp uint32) error {
+	code, err := EventCode(digit)
+	if err != nil {
+		return err
+	}
+
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+
+	total := int64(duration) * int64(s.ClockRate) / int64(time.Second)
+	step := int64(s.Interval) * int64(s.ClockRate) / int64(time.Second)
+	if step <= 0 {
+		step = 1
+	}
+
+	payload := Payload{Event: code, Volume: s.Volume}
+
+	// start is the offset of the current segment from the event's
+	// timestamp. endSegments sends the last packet of each segment that ends
+	// before elapsed, which carries the maximum duration without the end
+	// bit.
+	var start int64
+	endSegments := func(elapsed int64) error {
+		for elapsed-start > maxDuration {
+			payload.Duration = maxDuration
+			if err := s.send(&payload, timestamp+uint32(start), false); err != nil {
+				return err
+			}
+			start += maxDuration
+		}
+
+		return nil
+	}
+
+	for elapsed := step; elapsed < total; elapsed += step {
+		if err := endSegments(elapsed); err != nil {
+			return err
+		}
+
+		payload.Duration = uint16(elapsed - start)
+		if err := s.send(&payload, timestamp+uint32(start), elapsed == step); err != nil {
+			return err
+		}
+
+		time.Sleep(s.Interval)
+	}
+
+	if err := endSegments(total); err != nil {
+		return err
+	}
+
+	payload.Duration = uint16(total - start)
+	payload.End = true
+	for i := 0; i < endRedundancy; i++ {
+		if err := s.send(&payload, timestamp+uint32(start), total <= step && i == 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SendDigits sends each digit of a string in turn, held for
+// DefaultDuration with a gap of DefaultDuration between digits. The
+// timestamp function returns the current RTP timestamp of the stream.
+func (s *Sender) SendDigits(digits string, timestamp func() uint32) error {
+	for _, digit := range digits {
+		if err := s.SendDigit(digit, DefaultDuration, timestamp()); err != nil {
+			return err
+		}
+
+		time.Sleep(DefaultDuration)
+	}
+
+	return nil
+}
+
+func (s *Sender) send(payload *Payload, timestamp uint32, marker bool) error {
+	return s.write(&rtp.Packet{
+		Header: rtp.Header{
+			Marker:      marker,
+			PayloadType: s.payloadType,
+			Timestamp:   timestamp,
+		},
+		Payload: payload.Marshal(),
+	})
+}
+
+// Event represents a digit pressed or released by the remote party.
+type Event struct {
+	Digit rune
+	End   bool
+
+	// Duration is how long the digit was held, and is only set when End
+	// is true.
+	Duration time.Duration
+}
+
+// Receiver receives telephone events over RTP, and reports when digits are
+// pressed and released.
+type Receiver struct {
+	payloadType uint8
+
+	// ClockRate is the RTP clock rate negotiated for telephone events.
+	ClockRate int
+
+	seen      bool
+	timestamp uint32
+	digit     rune
+	duration  uint16
+	ended     bool
+
+	// previous is the duration of the event's previous segments.
+	previous int64
+
+	usageLock *sync.Mutex
+}
+
+// NewReceiver returns a new receiver of telephone events with the given
+// payload type.
+func NewReceiver(payloadType uint8) *Receiver {
+	return &Receiver{
+		payloadType: payloadType,
+		ClockRate:   DefaultClockRate,
+		usageLock:   new(sync.Mutex),
+	}
+}
+
+// Receive handles a received packet, returning the events of digits it
+// shows were pressed or released. Retransmitted and reordered packets of an
+// event are ignored, and packets of other payload types and events other
+// than keypad digits are ignored. The segments of long events are joined
+// into one event.
+func (r *Receiver) Receive(p *rtp.Packet) ([]Event, error) {
+	if p.PayloadType != r.payloadType {
+		return nil, nil
+	}
+
+	var payload Payload
+	if err := payload.Unmarshal(p.Payload); err != nil {
+		return nil, err
+	}
+
+	digit, err := Digit(payload.Event)
+	if err != nil {
+		return nil, nil
+	}
+
+	r.usageLock.Lock()
+	defer r.usageLock.Unlock()
+
+	var events []Event
+	if r.seen && !r.ended && digit == r.digit && r.duration == maxDuration &&
+		p.Timestamp == r.timestamp+maxDuration {
+		// The packet starts a new segment of a long event.
+		r.previous += maxDuration
+		r.timestamp = p.Timestamp
+		r.duration = 0
+	} else if !r.seen || rtp.TimestampNewer(p.Timestamp, r.timestamp) {
+		// The end packets of the previous event were all lost.
+		if r.seen && !r.ended {
+			events = append(events, r.endEvent())
+		}
+
+		r.seen = true
+		r.timestamp = p.Timestamp
+		r.digit = digit
+		r.duration = 0
+		r.previous = 0
+		r.ended = false
+		events = append(events, Event{Digit: digit})
+	} else if p.Timestamp != r.timestamp {
+		return nil, nil
+	}
+
+	if payload.Duration > r.duration {
+		r.duration = payload.Duration
+	}
+
+	if payload.End && !r.ended {
+		r.ended = true
+		events = append(events, r.endEvent())
+	}
+
+	return events, nil
+}
+
+func (r *Receiver) endEvent() Event {
+	duration := r.previous + int64(r.duration)
+	return Event{
+		Digit:    r.digit,
+		End:      true,
+		Duration: time.Duration(duration) * time.Second / time.Duration(r.ClockRate),
+	}
+}
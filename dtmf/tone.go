@@ -0,0 +1,91 @@
+package dtmf
+
+import (
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+)
+
+// keypad is the layout of the DTMF keypad, where each row has a low
+// frequency and each column a high frequency.
+const keypad = "123A456B789C*0#D"
+
+var (
+	rowFrequencies    = [4]float64{697, 770, 852, 941}
+	columnFrequencies = [4]float64{1209, 1336, 1477, 1633}
+)
+
+// toneAmplitude is the amplitude of each of the two tones of a digit, as a
+// fraction of full scale.
+const toneAmplitude = 0.35
+
+// toneStream represents a stream of in-band DTMF tones.
+type toneStream struct {
+	sampleRate int
+	tones      [][2]float64
+	toneLength int
+	gapLength  int
+	position   int
+}
+
+// NewToneStream returns a stream of the in-band tones of a string of digits
+// at the given sample rate, with each digit held for duration followed by a
+// gap of silence. The stream ends with io.EOF after the last gap.
+func NewToneStream(digits string, duration, gap time.Duration, sampleRate int) (audio.Stream, error) {
+	var tones [][2]float64
+	for _, digit := range digits {
+		code, err := EventCode(digit)
+		if err != nil {
+			return nil, err
+		}
+
+		key := strings.IndexByte(keypad, eventDigits[code])
+		tones = append(tones, [2]float64{rowFrequencies[key/4], columnFrequencies[key%4]})
+	}
+
+	return &toneStream{
+		sampleRate: sampleRate,
+		tones:      tones,
+		toneLength: int(int64(duration) * int64(sampleRate) / int64(time.Second)),
+		gapLength:  int(int64(gap) * int64(sampleRate) / int64(time.Second)),
+	}, nil
+}
+
+func (t *toneStream) Read(dst interface{}) (int, error) {
+	length := audio.SliceLength(dst)
+	period := t.toneLength + t.gapLength
+	end := period * len(t.tones)
+
+	if t.position >= end {
+		return 0, io.EOF
+	}
+
+	if t.position+length > end {
+		length = end - t.position
+	}
+
+	samples := make([]int32, length)
+	for i := range samples {
+		digit := (t.position + i) / period
+		offset := (t.position + i) % period
+		if offset >= t.toneLength {
+			continue
+		}
+
+		tone := t.tones[digit]
+		seconds := float64(offset) / float64(t.sampleRate)
+		value := math.Sin(2*math.Pi*tone[0]*seconds) + math.Sin(2*math.Pi*tone[1]*seconds)
+		samples[i] = int32(value * toneAmplitude * math.MaxInt32)
+	}
+
+	t.position += length
+
+	return length, audio.ReadFromInt32(dst, samples, length)
+}
+
+func (t *toneStream) SampleRate() int {
+	return t.sampleRate
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/1lann/dissonance/congestion"
+)
+
+// defaultTrace steps the bandwidth down and back up, with a lossy period.
+const defaultTrace = `
+# duration_ms bandwidth_kbps delay_ms loss
+10000 128 40
+10000 48 40
+10000 24 40
+10000 64 40 0.05
+10000 128 40
+`
+
+func main() {
+	traceFile := flag.String("trace", "", "bandwidth trace to replay, instead of the default trace")
+	seed := flag.Int64("seed", 1, "seed for random loss")
+	flag.Parse()
+
+	trace, err := readTrace(*traceFile)
+	if err != nil {
+		log.Fatal("congestion_sim: ", err)
+	}
+
+	sim := congestion.NewSimulation()
+	sim.Seed = *seed
+	samples := sim.Run(trace, congestion.NewController(congestion.DefaultStartBitrate))
+
+	fmt.Println("time_s,bandwidth_bps,bitrate_bps,send_rate_bps,packet_ms,redundancy,loss,jitter_ms,rtt_ms")
+	for _, s := range samples {
+		fmt.Printf("%.1f,%d,%d,%d,%d,%d,%.3f,%.1f,%.1f\n",
+			s.Time.Seconds(), s.Bandwidth, s.Settings.Bitrate, s.SendRate,
+			s.Settings.PacketDuration.Milliseconds(), s.Settings.Redundancy,
+			s.Feedback.Loss, float64(s.Feedback.Jitter.Microseconds())/1000,
+			float64(s.Feedback.RTT.Microseconds())/1000)
+	}
+}
+
+// readTrace reads the trace from the given file, or the default trace if
+// the file name is empty.
+func readTrace(name string) ([]congestion.TracePoint, error) {
+	if name == "" {
+		return congestion.ParseTrace(strings.NewReader(defaultTrace))
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return congestion.ParseTrace(file)
+}
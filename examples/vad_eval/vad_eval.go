@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+
+	"github.com/1lann/dissonance/filters/vad"
+	"github.com/1lann/dissonance/filters/vad/vadtest"
+)
+
+func main() {
+	sampleRate := flag.Int("rate", 16000, "sample rate of the signals")
+	duration := flag.Int("duration", 30, "duration of each signal in seconds")
+	threshold := flag.Float64("threshold", 0.6, "threshold of the energy VAD to compare against")
+	seed := flag.Int64("seed", 1, "seed for generating signals")
+	flag.Parse()
+
+	r := rand.New(rand.NewSource(*seed))
+	n := *sampleRate * *duration
+	frameSize := vad.NewDetector(*sampleRate, vad.Quality).FrameSize()
+	modes := []string{"quality", "low_bitrate", "aggressive", "very_aggressive"}
+
+	fmt.Println("noise,snr_db,detector,speech_detected,false_alarms")
+	for _, noise := range vadtest.Noises {
+		for _, snr := range []float64{20, 10, 5, 0} {
+			s, active := vadtest.Speech(r, n, *sampleRate)
+			sig := vadtest.Mix(s, active, noise.Generate(r, n, *sampleRate), snr, frameSize)
+
+			for mode, name := range modes {
+				d := vad.NewDetector(*sampleRate, vad.Mode(mode))
+				hits, falseAlarms := vadtest.Score(sig, frameSize, d.Process)
+				fmt.Printf("%s,%.0f,%s,%.3f,%.3f\n", noise.Name, snr, name, hits, falseAlarms)
+			}
+
+			hits, falseAlarms := vadtest.Score(sig, frameSize, func(frame []int32) bool {
+				return vad.Active(frame, *threshold)
+			})
+			fmt.Printf("%s,%.0f,energy,%.3f,%.3f\n", noise.Name, snr, hits, falseAlarms)
+		}
+	}
+}
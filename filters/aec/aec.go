@@ -0,0 +1,295 @@
+// Package aec implements acoustic echo cancellation. When a speaker and a
+// microphone are used together, the far end's audio played by the speaker
+// is picked up by the microphone and sent back as echo. The AEC filter
+// estimates the echo from a reference of what was played, and subtracts it
+// from the microphone's stream.
+//
+// The echo path is modelled with a partitioned block frequency domain
+// adaptive filter (PBFDAF) updated by normalized LMS, and adaptation is
+// frozen while both ends talk at once, as detected by a Geigel detector.
+package aec
+
+import (
+	"math"
+	"math/cmplx"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+// DefaultTail is the default length of echo the filter models, which covers
+// the latency between playback and recording as well as the room's
+// reverberation.
+const DefaultTail = 200 * time.Millisecond
+
+const (
+	// stepSize is the NLMS step size, which trades convergence speed for
+	// misadjustment.
+	stepSize = 0.5
+
+	// powerSmoothing is the weight of each block in the smoothed power
+	// spectrum of the reference.
+	powerSmoothing = 0.1
+
+	// regularization keeps the normalized step bounded when the reference
+	// is silent, relative to a full scale signal.
+	regularization = 1e-6
+
+	// geigelThreshold is the fraction of the recent reference peak above
+	// which a microphone peak is taken to be near end speech, assuming the
+	// echo path attenuates by at least 6 dB.
+	geigelThreshold = 0.5
+
+	// doubleTalkHangover is how long adaptation stays frozen after double
+	// talk is detected.
+	doubleTalkHangover = 100 * time.Millisecond
+)
+
+// Filter represents an AEC filter.
+type Filter struct {
+	reference audio.Stream
+	tail      time.Duration
+}
+
+// streamFilter represents a stream filter.
+type streamFilter struct {
+	stream    audio.Stream
+	reference audio.Stream
+
+	blockSize int
+	fft       *dsp.FFT
+
+	// partitions holds the spectra of the most recent reference blocks,
+	// newest first, each transformed with the block before it.
+	partitions [][]complex128
+	weights    [][]complex128
+	power      []float64
+
+	lastReference []float64
+	history       []float64 // reference samples within the tail, for the Geigel detector
+	hangover      int
+	hangoverLen   int
+
+	micPending []float64
+	refPending []float64
+	output     []float64
+
+	micBuf, refBuf []int32
+	echo           []complex128
+	errSpectrum    []complex128
+	gradient       []complex128
+}
+
+// NewFilter returns a new AEC filter which cancels the echo of reference in
+// the streams it filters, modelling echo up to the given tail length. The
+// reference is read in step with the filtered stream, so it must be the
+// audio that was played at the same time the filtered stream was recorded,
+// such as the reference returned by Tee.
+func NewFilter(reference audio.Stream, tail time.Duration) audio.Filter {
+	return &Filter{reference: reference, tail: tail}
+}
+
+// Filter implements the Filter method for filters.
+func (f *Filter) Filter(stream audio.Stream) audio.Stream {
+	if f.reference.SampleRate() != stream.SampleRate() {
+		panic("aec: reference and stream sample rates must match")
+	}
+
+	sampleRate := stream.SampleRate()
+	blockSize := dsp.NextPowerOfTwo(sampleRate / 200)
+	tailSamples := int(int64(f.tail) * int64(sampleRate) / int64(time.Second))
+	numPartitions := (tailSamples + blockSize - 1) / blockSize
+	if numPartitions < 1 {
+		numPartitions = 1
+	}
+
+	s := &streamFilter{
+		stream:        stream,
+		reference:     f.reference,
+		blockSize:     blockSize,
+		fft:           dsp.NewFFT(2 * blockSize),
+		partitions:    make([][]complex128, numPartitions),
+		weights:       make([][]complex128, numPartitions),
+		power:         make([]float64, 2*blockSize),
+		lastReference: make([]float64, blockSize),
+		history:       make([]float64, numPartitions*blockSize),
+		hangoverLen:   int(int64(doubleTalkHangover) * int64(sampleRate) / int64(time.Second)),
+		output:        make([]float64, blockSize),
+		echo:          make([]complex128, 2*blockSize),
+		errSpectrum:   make([]complex128, 2*blockSize),
+		gradient:      make([]complex128, 2*blockSize),
+	}
+
+	for i := range s.partitions {
+		s.partitions[i] = make([]complex128, 2*blockSize)
+		s.weights[i] = make([]complex128, 2*blockSize)
+	}
+
+	return s
+}
+
+func (f *streamFilter) SampleRate() int {
+	return f.stream.SampleRate()
+}
+
+// Read reads echo cancelled audio. The output is delayed by one block, which
+// is about 5ms.
+func (f *streamFilter) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	if len(f.micBuf) < dstLen {
+		f.micBuf = make([]int32, dstLen)
+		f.refBuf = make([]int32, dstLen)
+	}
+
+	n, err := f.stream.Read(f.micBuf[:dstLen])
+	if n == 0 {
+		return 0, err
+	}
+
+	// The reference is read in step with the microphone. If it runs out,
+	// the far end is treated as silent.
+	refRead := 0
+	for refRead < n {
+		m, refErr := f.reference.Read(f.refBuf[refRead:n])
+		refRead += m
+		if refErr != nil {
+			break
+		}
+	}
+	for i := refRead; i < n; i++ {
+		f.refBuf[i] = 0
+	}
+
+	mic := make([]float64, n)
+	ref := make([]float64, n)
+	dsp.FromInt32(mic, f.micBuf[:n])
+	dsp.FromInt32(ref, f.refBuf[:n])
+	f.micPending = append(f.micPending, mic...)
+	f.refPending = append(f.refPending, ref...)
+
+	for len(f.micPending) >= f.blockSize {
+		f.output = append(f.output, f.process(f.micPending[:f.blockSize],
+			f.refPending[:f.blockSize])...)
+		f.micPending = f.micPending[f.blockSize:]
+		f.refPending = f.refPending[f.blockSize:]
+	}
+
+	result := make([]int32, n)
+	dsp.ToInt32(result, f.output[:n])
+	f.output = f.output[n:]
+
+	if convErr := audio.ReadFromInt32(dst, result, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+// process cancels the echo in a block of microphone samples given the block
+// of reference samples played at the same time.
+func (f *streamFilter) process(mic, ref []float64) []float64 {
+	b := f.blockSize
+
+	// Shift in the spectrum of the newest reference block, transformed
+	// together with the previous block.
+	newest := f.partitions[len(f.partitions)-1]
+	copy(f.partitions[1:], f.partitions[:len(f.partitions)-1])
+	f.partitions[0] = newest
+	for i := 0; i < b; i++ {
+		newest[i] = complex(f.lastReference[i], 0)
+		newest[b+i] = complex(ref[i], 0)
+	}
+	f.fft.Forward(newest)
+	copy(f.lastReference, ref)
+
+	for i, x := range newest {
+		magnitude := real(x)*real(x) + imag(x)*imag(x)
+		f.power[i] += powerSmoothing * (magnitude - f.power[i])
+	}
+
+	// Estimate the echo as the sum of each partition's contribution.
+	for i := range f.echo {
+		f.echo[i] = 0
+	}
+	for p, partition := range f.partitions {
+		for i, x := range partition {
+			f.echo[i] += x * f.weights[p][i]
+		}
+	}
+	f.fft.Inverse(f.echo)
+
+	out := make([]float64, b)
+	var micEnergy, outEnergy float64
+	for i := 0; i < b; i++ {
+		out[i] = mic[i] - real(f.echo[b+i])
+		micEnergy += mic[i] * mic[i]
+		outEnergy += out[i] * out[i]
+	}
+
+	if f.doubleTalk(mic, ref) {
+		return out
+	}
+
+	// If cancellation made the block louder, the filter has diverged, such
+	// as after the echo path changed, so it starts over.
+	if outEnergy > 4*micEnergy && micEnergy > 0 {
+		for _, w := range f.weights {
+			for i := range w {
+				w[i] = 0
+			}
+		}
+		return mic
+	}
+
+	for i := 0; i < b; i++ {
+		f.errSpectrum[i] = 0
+		f.errSpectrum[b+i] = complex(out[i], 0)
+	}
+	f.fft.Forward(f.errSpectrum)
+
+	norm := regularization * float64(2*b)
+	for p, partition := range f.partitions {
+		for i, x := range partition {
+			f.gradient[i] = cmplx.Conj(x) * f.errSpectrum[i] *
+				complex(stepSize/(f.power[i]*float64(len(f.partitions))+norm), 0)
+		}
+
+		// Constrain the update to a linear convolution by discarding the
+		// second half of its impulse response.
+		f.fft.Inverse(f.gradient)
+		for i := b; i < 2*b; i++ {
+			f.gradient[i] = 0
+		}
+		f.fft.Forward(f.gradient)
+
+		for i, g := range f.gradient {
+			f.weights[p][i] += g
+		}
+	}
+
+	return out
+}
+
+// doubleTalk updates the Geigel double talk detector with a block, and
+// returns whether adaptation should be frozen.
+func (f *streamFilter) doubleTalk(mic, ref []float64) bool {
+	copy(f.history, f.history[len(ref):])
+	copy(f.history[len(f.history)-len(ref):], ref)
+
+	var refPeak, micPeak float64
+	for _, x := range f.history {
+		refPeak = math.Max(refPeak, math.Abs(x))
+	}
+	for _, d := range mic {
+		micPeak = math.Max(micPeak, math.Abs(d))
+	}
+
+	if micPeak > geigelThreshold*refPeak {
+		f.hangover = f.hangoverLen
+	} else if f.hangover > 0 {
+		f.hangover -= len(mic)
+	}
+
+	return f.hangover > 0
+}
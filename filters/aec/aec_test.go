@@ -0,0 +1,176 @@
+package aec
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/1lann/dissonance/dsp"
+)
+
+const testSampleRate = 16000
+
+// sliceStream is a stream of precomputed samples, followed by silence.
+type sliceStream struct {
+	samples []int32
+}
+
+func (s *sliceStream) SampleRate() int {
+	return testSampleRate
+}
+
+func (s *sliceStream) Read(dst interface{}) (int, error) {
+	buf := dst.([]int32)
+	n := copy(buf, s.samples)
+	s.samples = s.samples[n:]
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+
+	return len(buf), nil
+}
+
+// roomResponse returns a synthetic room impulse response: a direct path
+// after 20ms followed by exponentially decaying reflections, which
+// attenuates enough that the Geigel detector doesn't mistake echo for near
+// end speech.
+func roomResponse(r *rand.Rand) []float64 {
+	const delay = 320
+	rir := make([]float64, 1600)
+	rir[delay] = 0.1
+	for n := delay + 1; n < len(rir); n++ {
+		rir[n] = 0.01 * math.Exp(-float64(n-delay)/200) * r.NormFloat64()
+	}
+
+	return rir
+}
+
+func convolve(x, h []float64) []float64 {
+	y := make([]float64, len(x))
+	for n := range y {
+		for k := 0; k < len(h) && k <= n; k++ {
+			y[n] += h[k] * x[n-k]
+		}
+	}
+
+	return y
+}
+
+func noise(r *rand.Rand, n int, amplitude float64) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = amplitude * (2*r.Float64() - 1)
+	}
+
+	return x
+}
+
+func toStream(x []float64) *sliceStream {
+	samples := make([]int32, len(x))
+	dsp.ToInt32(samples, x)
+	return &sliceStream{samples: samples}
+}
+
+// cancel runs mic through an AEC filter with ref as the reference, and
+// returns the output aligned with the input.
+func cancel(t *testing.T, mic, ref []float64) ([]float64, *streamFilter) {
+	t.Helper()
+
+	filtered := NewFilter(toStream(ref), DefaultTail).Filter(toStream(mic)).(*streamFilter)
+
+	const readSize = 160
+	out := make([]float64, 0, len(mic)+readSize)
+	buf := make([]int32, readSize)
+	f := make([]float64, readSize)
+	for len(out) < len(mic)+filtered.blockSize {
+		n, err := filtered.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dsp.FromInt32(f[:n], buf[:n])
+		out = append(out, f[:n]...)
+	}
+
+	return out[filtered.blockSize : filtered.blockSize+len(mic)], filtered
+}
+
+func power(x []float64) float64 {
+	var sum float64
+	for _, v := range x {
+		sum += v * v
+	}
+
+	return sum / float64(len(x))
+}
+
+// erle returns the echo return loss enhancement in dB over each second.
+func erle(echo, residual []float64) []float64 {
+	var result []float64
+	for i := 0; i+testSampleRate <= len(echo); i += testSampleRate {
+		result = append(result, 10*math.Log10(power(echo[i:i+testSampleRate])/
+			power(residual[i:i+testSampleRate])))
+	}
+
+	return result
+}
+
+func TestConvergence(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ref := noise(r, 10*testSampleRate, 0.1)
+	echo := convolve(ref, roomResponse(r))
+
+	out, _ := cancel(t, echo, ref)
+	enhancement := erle(echo, out)
+
+	// The filter improves by about 10 dB each second until it reaches the
+	// limit of the output's precision.
+	floors := []float64{0, 10, 20, 30, 40, 50, 60, 70, 75, 80}
+	for second, e := range enhancement {
+		if e < floors[second] {
+			t.Errorf("second %d: got ERLE %.1f dB, expected at least %.0f dB", second,
+				e, floors[second])
+		}
+	}
+}
+
+func TestDoubleTalkFreezesAdaptation(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ref := noise(r, 9*testSampleRate, 0.1)
+	echo := convolve(ref, roomResponse(r))
+
+	// The near end talks loudly over the far end from 6s to 7s, once the
+	// filter has converged.
+	near := make([]float64, len(ref))
+	copy(near[6*testSampleRate:], noise(r, testSampleRate, 0.3))
+
+	mic := make([]float64, len(echo))
+	for i := range mic {
+		mic[i] = echo[i] + near[i]
+	}
+
+	out, filtered := cancel(t, mic, ref)
+	if filtered.hangover > 0 {
+		t.Error("adaptation still frozen after double talk ended")
+	}
+
+	// Adapting to the near end would diverge the filter, so the echo left
+	// in the output must stay as low during and after double talk as it
+	// was before.
+	residual := make([]float64, len(out))
+	for i := range out {
+		residual[i] = out[i] - near[i]
+	}
+
+	enhancement := erle(echo, residual)
+	before := enhancement[5]
+	if before < 50 {
+		t.Fatalf("got ERLE %.1f dB before double talk, expected at least 50 dB", before)
+	}
+
+	for second := 6; second < len(enhancement); second++ {
+		if e := enhancement[second]; e < before-10 {
+			t.Errorf("second %d: got ERLE %.1f dB, expected about %.1f dB", second, e, before)
+		}
+	}
+}
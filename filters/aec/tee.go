@@ -0,0 +1,88 @@
+package aec
+
+import (
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+)
+
+// maxReferenceDelay is the most reference audio buffered for the AEC filter
+// before the oldest is discarded.
+const maxReferenceDelay = time.Second
+
+// teeStream represents the playback side of a tee.
+type teeStream struct {
+	stream    audio.Stream
+	reference *referenceStream
+}
+
+// referenceStream represents the reference side of a tee.
+type referenceStream struct {
+	sampleRate int
+	buffer     []int32
+	usageLock  *sync.Mutex
+}
+
+// Tee splits the far end's stream for live use with a playback device.
+// Playback should be played, and reference passed to NewFilter. Everything
+// read from playback is copied to reference, which never blocks and returns
+// silence while nothing has been played.
+func Tee(far audio.Stream) (playback, reference audio.Stream) {
+	r := &referenceStream{
+		sampleRate: far.SampleRate(),
+		usageLock:  new(sync.Mutex),
+	}
+
+	return &teeStream{stream: far, reference: r}, r
+}
+
+func (t *teeStream) SampleRate() int {
+	return t.stream.SampleRate()
+}
+
+func (t *teeStream) Read(dst interface{}) (int, error) {
+	buf := make([]int32, audio.SliceLength(dst))
+	n, err := t.stream.Read(buf)
+	if n == 0 {
+		return 0, err
+	}
+
+	t.reference.write(buf[:n])
+
+	if convErr := audio.ReadFromInt32(dst, buf, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+func (r *referenceStream) write(samples []int32) {
+	r.usageLock.Lock()
+	defer r.usageLock.Unlock()
+
+	r.buffer = append(r.buffer, samples...)
+	if limit := int(int64(maxReferenceDelay) * int64(r.sampleRate) / int64(time.Second)); len(r.buffer) > limit {
+		r.buffer = append(r.buffer[:0], r.buffer[len(r.buffer)-limit:]...)
+	}
+}
+
+func (r *referenceStream) SampleRate() int {
+	return r.sampleRate
+}
+
+func (r *referenceStream) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+
+	r.usageLock.Lock()
+	buf := make([]int32, dstLen)
+	n := copy(buf, r.buffer)
+	r.buffer = r.buffer[n:]
+	r.usageLock.Unlock()
+
+	if err := audio.ReadFromInt32(dst, buf, dstLen); err != nil {
+		return 0, err
+	}
+
+	return dstLen, nil
+}
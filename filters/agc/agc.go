@@ -0,0 +1,211 @@
+// Package agc implements automatic gain control, which brings participants
+// to a similar loudness whether they whisper into a laptop's microphone or
+// shout into a headset.
+//
+// The loudness of speech is tracked in short blocks, ignoring blocks that
+// are silent or close to the background noise, so that noise isn't turned
+// up when nobody is talking. The gain moves towards the difference between
+// the target level and the tracked loudness, reducing quickly when speech
+// gets louder and recovering slowly when it gets quieter.
+package agc
+
+import (
+	"math"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+// Defaults for the configuration of a filter.
+const (
+	DefaultTarget    = -18
+	DefaultAttack    = 100 * time.Millisecond
+	DefaultRelease   = 2 * time.Second
+	DefaultMaxGain   = 30
+	DefaultThreshold = -55
+)
+
+const (
+	// blockDuration is the duration of the blocks levels are measured over.
+	blockDuration = 10 * time.Millisecond
+
+	// speechMargin is how far above the background noise, in dB, a block
+	// must be to be treated as speech.
+	speechMargin = 10
+
+	// floorRise is how fast the background noise estimate rises, in dB per
+	// second. It falls immediately to quieter blocks.
+	floorRise = 2
+
+	// peakLimit is the highest peak, relative to full scale, the gain may
+	// raise a block to.
+	peakLimit = 0.99
+)
+
+// Config represents the configuration of an AGC filter. Zero values are
+// replaced with their defaults.
+type Config struct {
+	// Target is the loudness speech is brought to, as an RMS level in dBFS.
+	Target float64
+
+	// Attack is the time constant of reducing the gain when speech gets
+	// louder.
+	Attack time.Duration
+
+	// Release is the time constant of increasing the gain when speech gets
+	// quieter.
+	Release time.Duration
+
+	// MaxGain is the most the gain may amplify by, in dB.
+	MaxGain float64
+
+	// Threshold is the level in dBFS below which blocks are never treated
+	// as speech.
+	Threshold float64
+}
+
+// Filter represents an AGC filter.
+type Filter struct {
+	config Config
+}
+
+// streamFilter represents a stream filter.
+type streamFilter struct {
+	stream    audio.Stream
+	config    Config
+	blockSize int
+
+	loudness float64 // tracked level of speech in dBFS
+	floor    float64 // tracked level of background noise in dBFS
+	gain     float64 // linear gain applied at the end of the last block
+
+	buf     []int32
+	samples []float64
+}
+
+// NewFilter returns a new AGC filter with the given configuration.
+func NewFilter(config Config) audio.Filter {
+	if config.Target == 0 {
+		config.Target = DefaultTarget
+	}
+	if config.Attack <= 0 {
+		config.Attack = DefaultAttack
+	}
+	if config.Release <= 0 {
+		config.Release = DefaultRelease
+	}
+	if config.MaxGain == 0 {
+		config.MaxGain = DefaultMaxGain
+	}
+	if config.Threshold == 0 {
+		config.Threshold = DefaultThreshold
+	}
+
+	if config.Target > 0 {
+		panic("agc: target must not be above full scale")
+	}
+
+	return &Filter{config: config}
+}
+
+// Filter implements the Filter method for filters.
+func (f *Filter) Filter(stream audio.Stream) audio.Stream {
+	// At sample rates below 100 Hz, a block is less than a sample.
+	blockSize := int(int64(blockDuration) * int64(stream.SampleRate()) / int64(time.Second))
+	if blockSize < 1 {
+		blockSize = 1
+	}
+
+	return &streamFilter{
+		stream:    stream,
+		config:    f.config,
+		blockSize: blockSize,
+		loudness:  f.config.Target,
+		floor:     math.Inf(1),
+		gain:      1,
+	}
+}
+
+func (f *streamFilter) SampleRate() int {
+	return f.stream.SampleRate()
+}
+
+func (f *streamFilter) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	if len(f.buf) < dstLen {
+		f.buf = make([]int32, dstLen)
+		f.samples = make([]float64, dstLen)
+	}
+
+	n, err := f.stream.Read(f.buf[:dstLen])
+	if n == 0 {
+		return 0, err
+	}
+
+	dsp.FromInt32(f.samples[:n], f.buf[:n])
+	for start := 0; start < n; start += f.blockSize {
+		end := start + f.blockSize
+		if end > n {
+			end = n
+		}
+		f.process(f.samples[start:end])
+	}
+	dsp.ToInt32(f.buf[:n], f.samples[:n])
+
+	if convErr := audio.ReadFromInt32(dst, f.buf, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+// process applies the gain to a block in place, after updating the tracked
+// levels with it.
+func (f *streamFilter) process(block []float64) {
+	var sum, peak float64
+	for _, x := range block {
+		sum += x * x
+		peak = math.Max(peak, math.Abs(x))
+	}
+
+	level := math.Inf(-1)
+	if sum > 0 {
+		level = 10 * math.Log10(sum/float64(len(block)))
+	}
+
+	seconds := float64(len(block)) / float64(f.stream.SampleRate())
+	if level < f.floor {
+		f.floor = math.Max(level, f.config.Threshold)
+	} else {
+		f.floor += floorRise * seconds
+	}
+
+	if level > f.config.Threshold && level > f.floor+speechMargin {
+		timeConstant := f.config.Release
+		if level > f.loudness {
+			timeConstant = f.config.Attack
+		}
+
+		coefficient := 1 - math.Exp(-seconds/timeConstant.Seconds())
+		f.loudness += coefficient * (level - f.loudness)
+	}
+
+	gainDB := math.Min(f.config.Target-f.loudness, f.config.MaxGain)
+	gain := math.Pow(10, gainDB/20)
+
+	// Ramp from the previous gain to avoid audible steps. Neither end of
+	// the ramp may raise the block's peak past the limit, so that a
+	// transient after quiet speech isn't clipped.
+	start := f.gain
+	if peak > 0 {
+		limit := peakLimit / peak
+		gain = math.Min(gain, limit)
+		start = math.Min(start, limit)
+	}
+
+	for i := range block {
+		block[i] *= start + (gain-start)*float64(i+1)/float64(len(block))
+	}
+	f.gain = gain
+}
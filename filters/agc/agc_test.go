@@ -0,0 +1,177 @@
+package agc
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/1lann/dissonance/dsp"
+)
+
+const testSampleRate = 16000
+
+// The durations in samples of the bursts and pauses of test speech.
+const (
+	testBurst = 300 * testSampleRate / 1000
+	testPause = 200 * testSampleRate / 1000
+)
+
+// sliceStream is a stream of precomputed samples, followed by silence.
+type sliceStream struct {
+	sampleRate int
+	samples    []int32
+}
+
+func (s *sliceStream) SampleRate() int {
+	return s.sampleRate
+}
+
+func (s *sliceStream) Read(dst interface{}) (int, error) {
+	buf := dst.([]int32)
+	n := copy(buf, s.samples)
+	s.samples = s.samples[n:]
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+
+	return len(buf), nil
+}
+
+// speech returns a 300 Hz tone at the given RMS level in dBFS, broken up
+// into 300ms bursts with 200ms pauses like speech, so that it stands out
+// from the background noise.
+func speech(level float64, seconds int) []float64 {
+	amplitude := math.Sqrt2 * math.Pow(10, level/20)
+	x := make([]float64, seconds*testSampleRate)
+	for i := range x {
+		if i%(testBurst+testPause) < testBurst {
+			x[i] = amplitude * math.Sin(2*math.Pi*300*float64(i)/testSampleRate)
+		}
+	}
+
+	return x
+}
+
+// lastBurst returns the last burst of speech in x.
+func lastBurst(x []float64) []float64 {
+	return x[len(x)-testBurst-testPause : len(x)-testPause]
+}
+
+// process runs x through an AGC filter with the given configuration.
+func process(t *testing.T, config Config, x []float64) []float64 {
+	t.Helper()
+
+	samples := make([]int32, len(x))
+	dsp.ToInt32(samples, x)
+	filtered := NewFilter(config).Filter(&sliceStream{
+		sampleRate: testSampleRate,
+		samples:    samples,
+	})
+
+	buf := make([]int32, len(x))
+	for read := 0; read < len(buf); {
+		n, err := filtered.Read(buf[read:min(read+320, len(buf))])
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += n
+	}
+
+	out := make([]float64, len(x))
+	dsp.FromInt32(out, buf)
+
+	return out
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func rmsLevel(x []float64) float64 {
+	var sum float64
+	for _, v := range x {
+		sum += v * v
+	}
+
+	return 10 * math.Log10(sum/float64(len(x)))
+}
+
+func TestConvergesToTarget(t *testing.T) {
+	tests := []struct {
+		level    float64
+		maxGain  float64
+		expected float64
+	}{
+		{-35, 0, DefaultTarget},
+		{-18, 0, DefaultTarget},
+		{-6, 0, DefaultTarget},
+		// Quieter speech is amplified by at most the maximum gain.
+		{-35, 10, -25},
+	}
+
+	for _, test := range tests {
+		// The gain recovers slowly, so speech quieter than the target takes
+		// several release time constants to reach it.
+		out := process(t, Config{MaxGain: test.maxGain}, speech(test.level, 15))
+
+		if level := rmsLevel(lastBurst(out)); math.Abs(level-test.expected) > 1 {
+			t.Errorf("%.0f dBFS, max gain %.0f dB: got %.1f dBFS, expected %.0f dBFS",
+				test.level, test.maxGain, level, test.expected)
+		}
+	}
+}
+
+func TestNoiseNotAmplified(t *testing.T) {
+	// Speech at the target level, followed by quiet noise, which must not
+	// be turned up.
+	x := speech(DefaultTarget, 2)
+	noise := rand.New(rand.NewSource(1))
+	for i := 0; i < 5*testSampleRate; i++ {
+		x = append(x, 0.001*noise.NormFloat64())
+	}
+
+	out := process(t, Config{}, x)
+	if level := rmsLevel(out[len(out)-testSampleRate:]); level > -58 {
+		t.Errorf("got noise at %.1f dBFS, expected about -60 dBFS", level)
+	}
+}
+
+func TestTransientNotClipped(t *testing.T) {
+	// Quiet speech raises the gain, then a loud burst starts abruptly.
+	x := append(speech(-40, 10), speech(-3, 1)...)
+	out := process(t, Config{}, x)
+
+	var peak float64
+	for _, v := range out {
+		peak = math.Max(peak, math.Abs(v))
+	}
+
+	if peak > peakLimit+1e-6 {
+		t.Errorf("got peak %.4f, expected at most %.2f", peak, peakLimit)
+	}
+}
+
+func TestLowSampleRate(t *testing.T) {
+	// Blocks would be shorter than a sample at 50 Hz.
+	filtered := NewFilter(Config{}).Filter(&sliceStream{sampleRate: 50})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := filtered.Read(make([]int32, 10))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read did not return")
+	}
+}
@@ -0,0 +1,262 @@
+// Package denoise implements spectral noise suppression, for steady
+// background noise such as fans, hum and traffic that leaks through the VAD
+// filter while someone talks.
+//
+// The stream is analysed in overlapping frames with a short-time Fourier
+// transform. The spectrum of the noise is learnt from frames without speech,
+// and each frequency bin is attenuated by a Wiener gain from its estimated
+// signal to noise ratio. The ratio is estimated with the decision-directed
+// approach, which smooths the gains over time to avoid the tonal artifacts
+// known as musical noise.
+package denoise
+
+import (
+	"math"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+const (
+	// maxAttenuation is the attenuation of noise at full strength, in dB.
+	maxAttenuation = 30
+
+	// priorSmoothing is the weight of the previous frame's clean speech in
+	// the decision-directed a priori SNR estimate. Higher values give less
+	// musical noise at the cost of smearing speech onsets.
+	priorSmoothing = 0.98
+
+	// noiseSmoothing is the weight of the current estimate when updating
+	// the noise spectrum with a frame without speech.
+	noiseSmoothing = 0.9
+
+	// speechRatio is the mean ratio of a frame's power to the noise
+	// spectrum above which the frame is taken to contain speech.
+	speechRatio = 3
+
+	// minimumWindow is how long, in seconds, the lowest power of each bin
+	// is tracked for. The noise estimate is never allowed below it, so that
+	// it catches up when noise gets louder while someone talks.
+	minimumWindow = 3
+
+	// powerSmoothing is the weight of the current frame in the smoothed
+	// power spectrum whose minimum is tracked.
+	powerSmoothing = 0.3
+
+	// learningFrames is the number of frames at the start of the stream
+	// which are assumed to be noise.
+	learningFrames = 10
+
+	// noiseFloor is the lowest level of noise assumed, in dBFS. Without it,
+	// digital silence leaves an empty noise spectrum which any signal
+	// after it is learnt as.
+	noiseFloor = -100
+)
+
+// Filter represents a noise suppression filter.
+type Filter struct {
+	floor float64
+}
+
+// streamFilter represents a stream filter.
+type streamFilter struct {
+	stream audio.Stream
+	floor  float64
+
+	frameSize int
+	hopSize   int
+	fft       *dsp.FFT
+	window    []float64
+
+	frame    []float64
+	overlap  []float64
+	pending  []float64
+	output   []float64
+	spectrum []complex128
+
+	minNoise   float64
+	noise      []float64
+	prior      []float64 // clean speech power of the previous frame
+	smoothed   []float64
+	minimum    []float64
+	candidate  []float64
+	minFrames  int
+	minCounter int
+	frames     int
+
+	buf []int32
+}
+
+// NewFilter returns a new noise suppression filter with a strength between
+// 0 and 1. At 0 the audio is unchanged, and at 1 noise is attenuated by up
+// to 30 dB.
+func NewFilter(strength float64) audio.Filter {
+	if strength < 0 || strength > 1 {
+		panic("denoise: strength must be between 0 and 1")
+	}
+
+	return &Filter{floor: math.Pow(10, -strength*maxAttenuation/20)}
+}
+
+// Filter implements the Filter method for filters.
+func (f *Filter) Filter(stream audio.Stream) audio.Stream {
+	frameSize := dsp.NextPowerOfTwo(stream.SampleRate() / 100)
+	hopSize := frameSize / 2
+	bins := frameSize/2 + 1
+
+	s := &streamFilter{
+		stream:    stream,
+		floor:     f.floor,
+		frameSize: frameSize,
+		hopSize:   hopSize,
+		fft:       dsp.NewFFT(frameSize),
+		window:    dsp.SqrtHannWindow(frameSize),
+		frame:     make([]float64, frameSize),
+		overlap:   make([]float64, frameSize),
+		output:    make([]float64, hopSize),
+		spectrum:  make([]complex128, frameSize),
+		noise:     make([]float64, bins),
+		prior:     make([]float64, bins),
+		smoothed:  make([]float64, bins),
+		minimum:   make([]float64, bins),
+		candidate: make([]float64, bins),
+		minFrames: int(minimumWindow * float64(stream.SampleRate()) / float64(hopSize)),
+	}
+
+	// The power of each bin of white noise is its variance times the sum
+	// of the squared window, which is half of the frame size.
+	s.minNoise = math.Pow(10, noiseFloor/10) * float64(frameSize) / 2
+
+	for i := range s.minimum {
+		s.minimum[i] = math.Inf(1)
+		s.candidate[i] = math.Inf(1)
+	}
+
+	return s
+}
+
+func (f *streamFilter) SampleRate() int {
+	return f.stream.SampleRate()
+}
+
+// Read reads denoised audio. The output is delayed by one frame, which is
+// between 10 and 20ms.
+func (f *streamFilter) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	if len(f.buf) < dstLen {
+		f.buf = make([]int32, dstLen)
+	}
+
+	n, err := f.stream.Read(f.buf[:dstLen])
+	if n == 0 {
+		return 0, err
+	}
+
+	samples := make([]float64, n)
+	dsp.FromInt32(samples, f.buf[:n])
+	f.pending = append(f.pending, samples...)
+
+	for len(f.pending) >= f.hopSize {
+		f.output = append(f.output, f.process(f.pending[:f.hopSize])...)
+		f.pending = f.pending[f.hopSize:]
+	}
+
+	dsp.ToInt32(f.buf[:n], f.output[:n])
+	f.output = f.output[n:]
+
+	if convErr := audio.ReadFromInt32(dst, f.buf, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+// process shifts a hop of samples into the analysis frame, and returns the
+// next hop of denoised samples.
+func (f *streamFilter) process(hop []float64) []float64 {
+	copy(f.frame, f.frame[f.hopSize:])
+	copy(f.frame[f.frameSize-f.hopSize:], hop)
+
+	for i, sample := range f.frame {
+		f.spectrum[i] = complex(sample*f.window[i], 0)
+	}
+	f.fft.Forward(f.spectrum)
+
+	power := make([]float64, len(f.noise))
+	for i := range power {
+		x := f.spectrum[i]
+		power[i] = real(x)*real(x) + imag(x)*imag(x)
+	}
+
+	f.updateNoise(power)
+
+	for i, p := range power {
+		posterior := p / f.noise[i]
+		prior := priorSmoothing*f.prior[i]/f.noise[i] +
+			(1-priorSmoothing)*math.Max(posterior-1, 0)
+		gain := math.Max(prior/(1+prior), f.floor)
+
+		f.prior[i] = gain * gain * p
+		f.spectrum[i] *= complex(gain, 0)
+		if i > 0 && i < f.frameSize/2 {
+			f.spectrum[f.frameSize-i] *= complex(gain, 0)
+		}
+	}
+
+	f.fft.Inverse(f.spectrum)
+	for i := range f.overlap {
+		f.overlap[i] += real(f.spectrum[i]) * f.window[i]
+	}
+
+	out := make([]float64, f.hopSize)
+	copy(out, f.overlap[:f.hopSize])
+	copy(f.overlap, f.overlap[f.hopSize:])
+	for i := f.frameSize - f.hopSize; i < f.frameSize; i++ {
+		f.overlap[i] = 0
+	}
+
+	return out
+}
+
+// updateNoise updates the estimate of the noise spectrum with the power
+// spectrum of a frame.
+func (f *streamFilter) updateNoise(power []float64) {
+	// Track the lowest smoothed power of each bin over a sliding window,
+	// made of two halves so that old minimums expire.
+	f.minCounter++
+	for i, p := range power {
+		f.smoothed[i] += powerSmoothing * (p - f.smoothed[i])
+		f.candidate[i] = math.Min(f.candidate[i], f.smoothed[i])
+		f.minimum[i] = math.Min(f.minimum[i], f.smoothed[i])
+	}
+	if f.minCounter >= f.minFrames/2 {
+		f.minCounter = 0
+		copy(f.minimum, f.candidate)
+		for i := range f.candidate {
+			f.candidate[i] = math.Inf(1)
+		}
+	}
+
+	f.frames++
+	if f.frames <= learningFrames {
+		for i, p := range power {
+			f.noise[i] += (p - f.noise[i]) / float64(f.frames)
+			f.noise[i] = math.Max(f.noise[i], f.minNoise)
+		}
+		return
+	}
+
+	var ratio float64
+	for i, p := range power {
+		ratio += p / f.noise[i]
+	}
+	ratio /= float64(len(power))
+
+	for i, p := range power {
+		if ratio < speechRatio {
+			f.noise[i] = noiseSmoothing*f.noise[i] + (1-noiseSmoothing)*p
+		}
+
+		f.noise[i] = math.Max(f.noise[i], math.Max(f.minimum[i], f.minNoise))
+	}
+}
@@ -0,0 +1,173 @@
+package denoise
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/1lann/dissonance/dsp"
+)
+
+const testSampleRate = 16000
+
+// sliceStream is a stream of precomputed samples, followed by silence.
+type sliceStream struct {
+	samples []int32
+}
+
+func (s *sliceStream) SampleRate() int {
+	return testSampleRate
+}
+
+func (s *sliceStream) Read(dst interface{}) (int, error) {
+	buf := dst.([]int32)
+	n := copy(buf, s.samples)
+	s.samples = s.samples[n:]
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+
+	return len(buf), nil
+}
+
+// process runs x through a noise suppression filter with the given strength,
+// and returns the output aligned with x by removing the filter's delay of a
+// frame.
+func process(t *testing.T, strength float64, x []float64) []float64 {
+	t.Helper()
+
+	in := make([]int32, len(x))
+	dsp.ToInt32(in, x)
+	filtered := NewFilter(strength).Filter(&sliceStream{samples: in})
+
+	delay := dsp.NextPowerOfTwo(testSampleRate / 100)
+	buf := make([]int32, len(x)+delay)
+	for read := 0; read < len(buf); {
+		end := read + 160
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		n, err := filtered.Read(buf[read:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += n
+	}
+
+	out := make([]float64, len(x))
+	dsp.FromInt32(out, buf[delay:])
+
+	return out
+}
+
+// tone returns the least squares fit of a tone at the given frequency to x.
+func tone(x []float64, frequency float64) []float64 {
+	var ss, sc, cc, xs, xc float64
+	for i, v := range x {
+		s, c := math.Sincos(2 * math.Pi * frequency * float64(i) / testSampleRate)
+		ss += s * s
+		sc += s * c
+		cc += c * c
+		xs += v * s
+		xc += v * c
+	}
+
+	det := ss*cc - sc*sc
+	a := (xs*cc - xc*sc) / det
+	b := (xc*ss - xs*sc) / det
+
+	fit := make([]float64, len(x))
+	for i := range fit {
+		s, c := math.Sincos(2 * math.Pi * frequency * float64(i) / testSampleRate)
+		fit[i] = a*s + b*c
+	}
+
+	return fit
+}
+
+func power(x []float64) float64 {
+	var sum float64
+	for _, v := range x {
+		sum += v * v
+	}
+
+	return sum / float64(len(x))
+}
+
+// snr returns the ratio in dB of the power of the tone at the given
+// frequency in x to the power of everything else.
+func snr(x []float64, frequency float64) float64 {
+	fit := tone(x, frequency)
+	residual := make([]float64, len(x))
+	for i := range x {
+		residual[i] = x[i] - fit[i]
+	}
+
+	return 10 * math.Log10(power(fit)/power(residual))
+}
+
+func TestToneInNoise(t *testing.T) {
+	const frequency = 1000
+
+	// A second of white noise to learn, then a tone in the noise. The tone
+	// is measured before its first second, after which minimum tracking
+	// treats a tone as steady as this one as noise.
+	r := rand.New(rand.NewSource(1))
+	x := make([]float64, 5*testSampleRate/2)
+	for i := range x {
+		x[i] = 0.01 * r.NormFloat64()
+		if i >= testSampleRate {
+			x[i] += 0.1 * math.Sin(2*math.Pi*frequency*float64(i)/testSampleRate)
+		}
+	}
+
+	measured := x[3*testSampleRate/2:]
+	before := snr(measured, frequency)
+
+	tests := []struct {
+		strength    float64
+		improvement float64
+	}{
+		{0, 0},
+		{0.5, 10},
+		{1, 12},
+	}
+
+	for _, test := range tests {
+		out := process(t, test.strength, x)[3*testSampleRate/2:]
+
+		after := snr(out, frequency)
+		if after-before < test.improvement-0.5 {
+			t.Errorf("strength %.1f: got SNR %.1f dB from %.1f dB, expected an improvement of %.0f dB",
+				test.strength, after, before, test.improvement)
+		}
+
+		// The tone itself keeps its level.
+		level := 10 * math.Log10(power(tone(out, frequency))/power(tone(measured, frequency)))
+		if math.Abs(level) > 0.5 {
+			t.Errorf("strength %.1f: tone changed by %.2f dB", test.strength, level)
+		}
+	}
+}
+
+func TestCleanSignal(t *testing.T) {
+	// Digital silence, then bursts of a tone like speech.
+	x := make([]float64, 4*testSampleRate)
+	for i := testSampleRate / 2; i < len(x); i++ {
+		if (i/(testSampleRate/10))%5 < 3 {
+			x[i] = 0.3 * math.Sin(2*math.Pi*440*float64(i)/testSampleRate)
+		}
+	}
+
+	out := process(t, 1, x)
+
+	residual := make([]float64, len(x))
+	for i := range x {
+		residual[i] = out[i] - x[i]
+	}
+
+	if ratio := 10 * math.Log10(power(residual)/power(x)); ratio > -40 {
+		t.Errorf("got output differing from the input by %.1f dB, expected below -40 dB", ratio)
+	}
+}
@@ -0,0 +1,182 @@
+// Package drift compensates for clock drift between a sender and a
+// receiver. Two sound cards nominally running at the same sample rate never
+// run at exactly the same rate, so over a long call a receive buffer slowly
+// underruns or overruns. The drift filter resamples a stream by a ratio that
+// adapts to keep the amount of buffered audio stable.
+//
+// The drift is estimated from the trend of the buffer's fill level when the
+// stream is an audio.BufferedStream, such as one returned by
+// audio.NewRealtimeStream, or from the RTP timestamps of received packets
+// when an Estimator is given. The filtered stream is read by a playback
+// device, such as the paudio playback device, at its own clock rate.
+package drift
+
+import (
+	"math"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+// MaxAdjustment is the largest fraction by which the filter speeds up or
+// slows down a stream, small enough for the change in pitch to be
+// inaudible.
+const MaxAdjustment = 0.005
+
+// correctionTime is the time over which a difference between the buffer's
+// fill level and its target is corrected.
+const correctionTime = 10 * time.Second
+
+// fillSmoothing is the weight of each read in the smoothed fill level, which
+// averages out the jitter of packet arrivals.
+const fillSmoothing = 0.01
+
+// trendInterval is the time between updates of the drift estimated from the
+// buffer's fill level.
+const trendInterval = time.Second
+
+// trendSmoothing is the weight of each trend update in the drift estimate.
+const trendSmoothing = 0.1
+
+// Filter represents a clock drift compensation filter.
+type Filter struct {
+	target    time.Duration
+	estimator *Estimator
+}
+
+// streamFilter represents a stream filter.
+type streamFilter struct {
+	stream    audio.Stream
+	buffered  audio.BufferedStream
+	target    float64
+	sender    *Estimator
+	receiver  *Estimator
+	buffer    []int32
+	position  float64
+	ratio     float64
+	read      int64
+	fill      float64
+	drift     float64
+	lastFill  float64
+	lastTrend int64
+}
+
+// NewFilter returns a new drift compensation filter which keeps the given
+// duration of audio buffered in the stream it filters. If estimator is not
+// nil, it is used to estimate the sender's clock rate from RTP timestamps,
+// and must be fed the timestamps of received packets with
+// ObserveTimestamp. Otherwise, the drift is estimated from the buffer's fill
+// level, which requires the stream to be an audio.BufferedStream.
+func NewFilter(target time.Duration, estimator *Estimator) audio.Filter {
+	return &Filter{target: target, estimator: estimator}
+}
+
+// Filter implements the Filter method for filters.
+func (f *Filter) Filter(stream audio.Stream) audio.Stream {
+	buffered, _ := stream.(audio.BufferedStream)
+	target := float64(int64(f.target) * int64(stream.SampleRate()) / int64(time.Second))
+
+	return &streamFilter{
+		stream:   stream,
+		buffered: buffered,
+		target:   target,
+		sender:   f.estimator,
+		receiver: NewEstimator(stream.SampleRate()),
+		buffer:   []int32{0},
+		ratio:    1,
+		fill:     target,
+		lastFill: target,
+	}
+}
+
+func (f *streamFilter) SampleRate() int {
+	return f.stream.SampleRate()
+}
+
+func (f *streamFilter) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	f.receiver.Observe(f.read, time.Now())
+	f.updateRatio()
+
+	// Read enough samples to interpolate every output sample.
+	required := int(math.Ceil(f.position+float64(dstLen)*f.ratio)) + 1
+	var err error
+	for len(f.buffer) < required && err == nil {
+		buf := make([]int32, required-len(f.buffer))
+		var n int
+		n, err = f.stream.Read(buf)
+		f.buffer = append(f.buffer, buf[:n]...)
+	}
+
+	// If the stream failed, the samples which can be interpolated from what
+	// was read are output before its error is returned.
+	n := dstLen
+	if err != nil {
+		n = 0
+		for p := f.position; p+1 < float64(len(f.buffer)) && n < dstLen; p += f.ratio {
+			n++
+		}
+	}
+
+	result := make([]int32, n)
+	for i := range result {
+		result[i] = dsp.Interpolate(f.buffer, f.position)
+		f.position += f.ratio
+	}
+
+	// Keep the sample before the next position for interpolation.
+	consumed := int(f.position)
+	f.buffer = append(f.buffer[:0], f.buffer[consumed:]...)
+	f.position -= float64(consumed)
+	f.read += int64(n)
+
+	if convErr := audio.ReadFromInt32(dst, result, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+// updateRatio updates the resampling ratio from the estimated drift and the
+// buffer's fill level.
+func (f *streamFilter) updateRatio() {
+	sampleRate := float64(f.stream.SampleRate())
+	correction := 0.0
+
+	if f.buffered != nil {
+		f.fill += fillSmoothing * (float64(f.buffered.Buffered()) - f.fill)
+
+		// Consuming at the current ratio, the fill level changes by the
+		// difference between the input rate and the ratio, so its trend
+		// gives the drift.
+		elapsed := f.read - f.lastTrend
+		if elapsed >= int64(trendInterval.Seconds()*sampleRate) {
+			slope := (f.fill - f.lastFill) / float64(elapsed)
+			f.drift += trendSmoothing * (f.ratio - 1 + slope - f.drift)
+			f.drift = math.Max(-MaxAdjustment, math.Min(MaxAdjustment, f.drift))
+			f.lastFill = f.fill
+			f.lastTrend = f.read
+		}
+
+		correction = (f.fill - f.target) / (correctionTime.Seconds() * sampleRate)
+	}
+
+	drift := f.drift
+	if f.sender != nil {
+		senderRate, senderOk := f.sender.Rate()
+		receiverRate, receiverOk := f.receiver.Rate()
+		if senderOk && receiverOk {
+			drift = senderRate/receiverRate - 1
+		}
+	}
+
+	adjustment := drift + correction
+	if adjustment > MaxAdjustment {
+		adjustment = MaxAdjustment
+	} else if adjustment < -MaxAdjustment {
+		adjustment = -MaxAdjustment
+	}
+
+	f.ratio = 1 + adjustment
+}
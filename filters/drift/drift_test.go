@@ -0,0 +1,129 @@
+package drift
+
+import (
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testSampleRate = 48000
+
+// squareStream is a full-scale square wave which reports a constant amount
+// of buffered audio.
+type squareStream struct {
+	halfPeriod int
+	buffered   int
+	position   int
+}
+
+func (s *squareStream) SampleRate() int {
+	return testSampleRate
+}
+
+func (s *squareStream) Read(dst interface{}) (int, error) {
+	buf := dst.([]int32)
+	for i := range buf {
+		if (s.position/s.halfPeriod)%2 == 0 {
+			buf[i] = math.MaxInt32
+		} else {
+			buf[i] = math.MinInt32
+		}
+		s.position++
+	}
+
+	return len(buf), nil
+}
+
+func (s *squareStream) Buffered() int {
+	return s.buffered
+}
+
+// endingStream is a stream of the given samples, which then ends.
+type endingStream struct {
+	samples []int32
+}
+
+func (s *endingStream) SampleRate() int {
+	return testSampleRate
+}
+
+func (s *endingStream) Read(dst interface{}) (int, error) {
+	n := copy(dst.([]int32), s.samples)
+	s.samples = s.samples[n:]
+	if len(s.samples) == 0 {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func TestReadUntilEnd(t *testing.T) {
+	samples := make([]int32, 100)
+	for i := range samples {
+		samples[i] = int32(i+1) << 16
+	}
+
+	// Without a buffered stream or an estimator, the ratio stays at 1, and
+	// the output lags the input by the sample kept for interpolation.
+	filtered := NewFilter(100*time.Millisecond, nil).Filter(&endingStream{samples: samples})
+
+	buf := make([]int32, 1000)
+	n, err := filtered.Read(buf)
+	if err != io.EOF {
+		t.Errorf("got error %v, expected io.EOF", err)
+	}
+
+	expected := append([]int32{0}, samples[:len(samples)-1]...)
+	if !reflect.DeepEqual(buf[:n], expected) {
+		t.Errorf("got %d samples %v, expected %d samples %v", n, buf[:n], len(expected), expected)
+	}
+}
+
+func TestResampleSquareWave(t *testing.T) {
+	const halfPeriod = 20
+
+	// Reporting far more buffered audio than the target makes the filter
+	// speed up the stream, so that it interpolates between samples.
+	stream := &squareStream{halfPeriod: halfPeriod, buffered: testSampleRate}
+	filtered := NewFilter(100*time.Millisecond, nil).Filter(stream)
+
+	const reads = 200
+	buf := make([]int32, 480)
+	var intermediate, edges int
+	last := int32(math.MaxInt32)
+	for i := 0; i < reads; i++ {
+		n, err := filtered.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, x := range buf[:n] {
+			if x != math.MaxInt32 && x != math.MinInt32 {
+				intermediate++
+
+				// Samples on an edge lie between the last level and the next.
+				if (last == math.MaxInt32 && x > last) || (last == math.MinInt32 && x < last) {
+					t.Fatalf("sample %d after %d is outside the edge", x, last)
+				}
+				continue
+			}
+
+			if x != last {
+				edges++
+			}
+			last = x
+		}
+	}
+
+	if stream.position <= reads*len(buf) {
+		t.Errorf("read %d samples for %d output samples, expected the stream to be sped up",
+			stream.position, reads*len(buf))
+	}
+
+	// All but the edges aligned exactly with a sample are interpolated.
+	if intermediate < edges*9/10 {
+		t.Errorf("got %d interpolated samples over %d edges", intermediate, edges)
+	}
+}
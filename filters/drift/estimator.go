@@ -0,0 +1,124 @@
+package drift
+
+import (
+	"sync"
+	"time"
+)
+
+// estimatorMemory is roughly the number of observations an estimate is
+// based on, so that it follows slow changes in drift such as those caused by
+// temperature.
+const estimatorMemory = 3000
+
+// minEstimateDuration is the time observations must span before an estimate
+// is made, as network jitter dominates shorter spans.
+const minEstimateDuration = 2 * time.Second
+
+// Estimator estimates the actual sample rate of a clock, such as that of a
+// sender's sound card, from the positions in its stream observed at
+// different times. It fits a line to the observations by least squares,
+// weighting recent observations more heavily.
+type Estimator struct {
+	nominal int
+
+	started   bool
+	first     time.Time
+	last      time.Time
+	origin    int64
+	timestamp uint32
+	unwrapped int64
+
+	// Weighted sums of time in seconds (x), and position less the nominal
+	// position at that time (y).
+	weight, sx, sy, sxx, sxy float64
+
+	usageLock *sync.Mutex
+}
+
+// NewEstimator returns a new estimator of a clock with the given nominal
+// sample rate.
+func NewEstimator(nominalRate int) *Estimator {
+	return &Estimator{
+		nominal:   nominalRate,
+		usageLock: new(sync.Mutex),
+	}
+}
+
+// Observe records that the clock was at the given sample position at the
+// given time.
+func (e *Estimator) Observe(position int64, at time.Time) {
+	e.usageLock.Lock()
+	defer e.usageLock.Unlock()
+
+	e.observe(position, at)
+}
+
+// ObserveTimestamp records that a packet with the given RTP timestamp
+// arrived at the given time. Timestamps are unwrapped, and those of reordered
+// packets are ignored.
+func (e *Estimator) ObserveTimestamp(timestamp uint32, at time.Time) {
+	e.usageLock.Lock()
+	defer e.usageLock.Unlock()
+
+	if !e.started {
+		e.timestamp = timestamp
+		e.unwrapped = int64(timestamp)
+	} else {
+		delta := int32(timestamp - e.timestamp)
+		if delta < 0 {
+			return
+		}
+
+		e.timestamp = timestamp
+		e.unwrapped += int64(delta)
+	}
+
+	e.observe(e.unwrapped, at)
+}
+
+func (e *Estimator) observe(position int64, at time.Time) {
+	if !e.started {
+		e.started = true
+		e.first = at
+		e.origin = position
+	}
+
+	x := at.Sub(e.first).Seconds()
+	y := float64(position-e.origin) - x*float64(e.nominal)
+
+	const decay = 1 - 1.0/estimatorMemory
+	e.weight = e.weight*decay + 1
+	e.sx = e.sx*decay + x
+	e.sy = e.sy*decay + y
+	e.sxx = e.sxx*decay + x*x
+	e.sxy = e.sxy*decay + x*y
+	e.last = at
+}
+
+// Rate returns the estimated actual sample rate of the clock, and whether
+// enough observations have been made for an estimate.
+func (e *Estimator) Rate() (float64, bool) {
+	e.usageLock.Lock()
+	defer e.usageLock.Unlock()
+
+	if !e.started || e.last.Sub(e.first) < minEstimateDuration {
+		return float64(e.nominal), false
+	}
+
+	variance := e.weight*e.sxx - e.sx*e.sx
+	if variance <= 0 {
+		return float64(e.nominal), false
+	}
+
+	slope := (e.weight*e.sxy - e.sx*e.sy) / variance
+	return float64(e.nominal) + slope, true
+}
+
+// Reset discards all observations, such as when a sender restarts its
+// stream.
+func (e *Estimator) Reset() {
+	e.usageLock.Lock()
+	defer e.usageLock.Unlock()
+
+	*e = Estimator{nominal: e.nominal, usageLock: e.usageLock}
+}
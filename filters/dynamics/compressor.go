@@ -0,0 +1,154 @@
+package dynamics
+
+import (
+	"math"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+// Defaults for the configuration of a compressor.
+const (
+	DefaultThreshold = -20
+	DefaultRatio     = 4
+	DefaultAttack    = 5 * time.Millisecond
+	DefaultRelease   = 100 * time.Millisecond
+)
+
+// CompressorConfig represents the configuration of a compressor. Zero
+// values of Threshold, Ratio, Attack and Release are replaced with their
+// defaults.
+type CompressorConfig struct {
+	// Threshold is the level in dBFS above which the compressor reduces the
+	// gain.
+	Threshold float64
+
+	// Ratio is the ratio of the change in input level above the threshold
+	// to the change in output level, such as 4 for 4:1.
+	Ratio float64
+
+	// Knee is the width in dB of the region around the threshold where the
+	// ratio is gradually applied. Zero is a hard knee.
+	Knee float64
+
+	// Attack is the time constant of reducing the gain when the level
+	// rises.
+	Attack time.Duration
+
+	// Release is the time constant of restoring the gain when the level
+	// falls.
+	Release time.Duration
+
+	// Makeup is the gain in dB applied after compression, to make up for
+	// the reduced loudness.
+	Makeup float64
+}
+
+// Compressor represents a feed-forward compressor filter.
+type Compressor struct {
+	config CompressorConfig
+}
+
+// compressorStream represents a stream filtered by a compressor.
+type compressorStream struct {
+	stream    audio.Stream
+	config    CompressorConfig
+	attack    float64
+	release   float64
+	makeup    float64
+	reduction float64 // smoothed gain reduction in dB
+
+	buf     []int32
+	samples []float64
+}
+
+// NewCompressor returns a new compressor filter with the given
+// configuration.
+func NewCompressor(config CompressorConfig) audio.Filter {
+	if config.Threshold == 0 {
+		config.Threshold = DefaultThreshold
+	}
+	if config.Ratio == 0 {
+		config.Ratio = DefaultRatio
+	}
+	if config.Attack == 0 {
+		config.Attack = DefaultAttack
+	}
+	if config.Release == 0 {
+		config.Release = DefaultRelease
+	}
+
+	if config.Ratio < 1 {
+		panic("dynamics: ratio must be at least 1")
+	}
+	if config.Knee < 0 {
+		panic("dynamics: knee must not be negative")
+	}
+
+	return &Compressor{config: config}
+}
+
+// Filter implements the Filter method for filters.
+func (c *Compressor) Filter(stream audio.Stream) audio.Stream {
+	return &compressorStream{
+		stream:  stream,
+		config:  c.config,
+		attack:  smoothing(c.config.Attack, stream.SampleRate()),
+		release: smoothing(c.config.Release, stream.SampleRate()),
+		makeup:  fromDB(c.config.Makeup),
+	}
+}
+
+func (c *compressorStream) SampleRate() int {
+	return c.stream.SampleRate()
+}
+
+func (c *compressorStream) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	if len(c.buf) < dstLen {
+		c.buf = make([]int32, dstLen)
+		c.samples = make([]float64, dstLen)
+	}
+
+	n, err := c.stream.Read(c.buf[:dstLen])
+	if n == 0 {
+		return 0, err
+	}
+
+	dsp.FromInt32(c.samples[:n], c.buf[:n])
+	for i, x := range c.samples[:n] {
+		target := c.gainReduction(toDB(math.Abs(x)))
+		if target < c.reduction {
+			c.reduction += c.attack * (target - c.reduction)
+		} else {
+			c.reduction += c.release * (target - c.reduction)
+		}
+
+		c.samples[i] = x * fromDB(c.reduction) * c.makeup
+	}
+	dsp.ToInt32(c.buf[:n], c.samples[:n])
+
+	if convErr := audio.ReadFromInt32(dst, c.buf, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+// gainReduction returns the static gain change in dB, which is zero or
+// negative, for an input level in dBFS.
+func (c *compressorStream) gainReduction(level float64) float64 {
+	over := level - c.config.Threshold
+	slope := 1/c.config.Ratio - 1
+	knee := c.config.Knee
+
+	switch {
+	case 2*over < -knee:
+		return 0
+	case knee > 0 && 2*math.Abs(over) <= knee:
+		return slope * (over + knee/2) * (over + knee/2) / (2 * knee)
+	default:
+		return slope * over
+	}
+}
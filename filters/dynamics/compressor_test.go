@@ -0,0 +1,80 @@
+package dynamics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCompressorStaticCurve(t *testing.T) {
+	tests := []struct {
+		config   CompressorConfig
+		level    float64
+		expected float64
+	}{
+		// Below the threshold, the level is unchanged.
+		{CompressorConfig{}, -40, -40},
+		{CompressorConfig{}, -20.5, -20.5},
+		// Above it, the level rises by the inverse of the ratio.
+		{CompressorConfig{}, -20, -20},
+		{CompressorConfig{}, -12, -18},
+		{CompressorConfig{}, 0, -15},
+		{CompressorConfig{Threshold: -30, Ratio: 2}, -10, -20},
+		{CompressorConfig{Ratio: 1}, -6, -6},
+		// A soft knee starts reducing the gain below the threshold, and
+		// reaches the ratio at the top of the knee.
+		{CompressorConfig{Knee: 10}, -26, -26},
+		{CompressorConfig{Knee: 10}, -20, -20.9375},
+		{CompressorConfig{Knee: 10}, -15, -18.75},
+		{CompressorConfig{Knee: 10}, -8, -17},
+		// Makeup gain applies at every level.
+		{CompressorConfig{Makeup: 6}, -40, -34},
+		{CompressorConfig{Makeup: 6}, -12, -12},
+	}
+
+	for _, test := range tests {
+		// The gain settles on the static curve for a constant level.
+		x := make([]float64, testSampleRate)
+		for i := range x {
+			x[i] = fromDB(test.level)
+		}
+
+		out := process(t, NewCompressor(test.config), x)
+		if level := toDB(out[len(out)-1]); math.Abs(level-test.expected) > 0.01 {
+			t.Errorf("%+v at %.1f dBFS: got %.2f dBFS, expected %.2f dBFS", test.config,
+				test.level, level, test.expected)
+		}
+	}
+}
+
+func TestCompressorAttackRelease(t *testing.T) {
+	config := CompressorConfig{Attack: 10 * time.Millisecond, Release: 100 * time.Millisecond}
+
+	// A step from below the threshold to 12 dB above it, and back.
+	step := testSampleRate / 2
+	x := make([]float64, 2*step)
+	for i := range x {
+		x[i] = fromDB(-30)
+		if i >= step/2 && i < step {
+			x[i] = fromDB(-8)
+		}
+	}
+
+	out := process(t, NewCompressor(config), x)
+
+	// After one time constant, the gain has moved about 63% of the way.
+	tests := []struct {
+		name     string
+		index    int
+		expected float64
+	}{
+		{"attack", step/2 + testSampleRate/100, -8 - 9*(1-math.Exp(-1))},
+		{"release", step + testSampleRate/10, -30 - 9*math.Exp(-1)},
+	}
+
+	for _, test := range tests {
+		if level := toDB(out[test.index]); math.Abs(level-test.expected) > 0.1 {
+			t.Errorf("%s: got %.2f dBFS, expected %.2f dBFS", test.name, level, test.expected)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Package dynamics implements dynamic range processing: a compressor, which
+// evens out the loudness of audio above a threshold, and a brickwall
+// limiter, which keeps peaks below a ceiling so that mixing and gain stages
+// don't clip.
+//
+// Use the limiter last in a chain of filters, after anything that may add
+// gain.
+package dynamics
+
+import (
+	"math"
+	"time"
+)
+
+// minLevel is the level in dB that silence is measured at, to keep levels
+// finite.
+const minLevel = -120
+
+// toDB converts a linear amplitude to dB.
+func toDB(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return minLevel
+	}
+
+	return math.Max(20*math.Log10(amplitude), minLevel)
+}
+
+// fromDB converts dB to a linear amplitude.
+func fromDB(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// smoothing returns the coefficient of a one pole smoother with the given
+// time constant at a sample rate.
+func smoothing(timeConstant time.Duration, sampleRate int) float64 {
+	if timeConstant <= 0 {
+		return 1
+	}
+
+	return 1 - math.Exp(-1/(timeConstant.Seconds()*float64(sampleRate)))
+}
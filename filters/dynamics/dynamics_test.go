@@ -0,0 +1,58 @@
+package dynamics
+
+import (
+	"testing"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+const testSampleRate = 48000
+
+// sliceStream is a stream of precomputed samples, followed by silence.
+type sliceStream struct {
+	samples []int32
+}
+
+func (s *sliceStream) SampleRate() int {
+	return testSampleRate
+}
+
+func (s *sliceStream) Read(dst interface{}) (int, error) {
+	buf := dst.([]int32)
+	n := copy(buf, s.samples)
+	s.samples = s.samples[n:]
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+
+	return len(buf), nil
+}
+
+// process runs x through a filter, and returns as many samples of output.
+func process(t *testing.T, filter audio.Filter, x []float64) []float64 {
+	t.Helper()
+
+	in := make([]int32, len(x))
+	dsp.ToInt32(in, x)
+	filtered := filter.Filter(&sliceStream{samples: in})
+
+	buf := make([]int32, len(x))
+	for read := 0; read < len(buf); {
+		end := read + 480
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		n, err := filtered.Read(buf[read:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += n
+	}
+
+	out := make([]float64, len(x))
+	dsp.FromInt32(out, buf)
+
+	return out
+}
@@ -0,0 +1,235 @@
+package dynamics
+
+import (
+	"math"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+// Defaults for the configuration of a limiter.
+const (
+	DefaultCeiling      = -1
+	DefaultLookahead    = 5 * time.Millisecond
+	DefaultLimitRelease = 50 * time.Millisecond
+)
+
+// oversampling is the factor true peaks are estimated at, and
+// interpolationTaps is the length of each phase of the interpolation
+// filter.
+const (
+	oversampling      = 4
+	interpolationTaps = 8
+)
+
+// interpolator holds the phases of a windowed sinc filter for estimating
+// the signal between samples.
+var interpolator = newInterpolator()
+
+func newInterpolator() [oversampling - 1][interpolationTaps]float64 {
+	var phases [oversampling - 1][interpolationTaps]float64
+	for p := range phases {
+		offset := float64(p+1) / oversampling
+		for k := range phases[p] {
+			t := float64(k-interpolationTaps/2+1) - offset
+			window := 0.5 + 0.5*math.Cos(math.Pi*t/(interpolationTaps/2))
+			phases[p][k] = sinc(t) * window
+		}
+	}
+
+	return phases
+}
+
+func sinc(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+
+	return math.Sin(math.Pi*t) / (math.Pi * t)
+}
+
+// LimiterConfig represents the configuration of a limiter. Zero values are
+// replaced with their defaults.
+type LimiterConfig struct {
+	// Ceiling is the highest true peak level of the output, in dBFS.
+	Ceiling float64
+
+	// Lookahead is how far ahead the limiter sees peaks coming, which is
+	// also the latency it adds. The gain is reduced smoothly over the
+	// lookahead before each peak.
+	Lookahead time.Duration
+
+	// Release is the time constant of restoring the gain after a peak.
+	Release time.Duration
+}
+
+// Limiter represents a lookahead brickwall limiter filter.
+type Limiter struct {
+	config LimiterConfig
+}
+
+// limiterStream represents a stream filtered by a limiter.
+type limiterStream struct {
+	stream  audio.Stream
+	ceiling float64
+	release float64
+
+	// input holds the samples needed to interpolate around the sample whose
+	// true peak is being estimated.
+	input []float64
+
+	// delay holds the samples waiting for the gain to be computed over the
+	// lookahead.
+	delay    []float64
+	delayPos int
+
+	// minimum is a monotonic queue of the lowest gains required over the
+	// lookahead, as positions and gains.
+	minimum   []gainAt
+	position  int
+	lookahead int
+
+	// window holds the recent minimum gains, which are averaged to smooth
+	// the gain over the lookahead.
+	window    []float64
+	windowPos int
+	windowSum float64
+
+	gain float64
+
+	buf     []int32
+	samples []float64
+}
+
+type gainAt struct {
+	position int
+	gain     float64
+}
+
+// NewLimiter returns a new limiter filter with the given configuration.
+func NewLimiter(config LimiterConfig) audio.Filter {
+	if config.Ceiling == 0 {
+		config.Ceiling = DefaultCeiling
+	}
+	if config.Lookahead <= 0 {
+		config.Lookahead = DefaultLookahead
+	}
+	if config.Release <= 0 {
+		config.Release = DefaultLimitRelease
+	}
+
+	if config.Ceiling > 0 {
+		panic("dynamics: ceiling must not be above full scale")
+	}
+
+	return &Limiter{config: config}
+}
+
+// Filter implements the Filter method for filters.
+func (l *Limiter) Filter(stream audio.Stream) audio.Stream {
+	lookahead := int(int64(l.config.Lookahead) * int64(stream.SampleRate()) / int64(time.Second))
+	if lookahead < 1 {
+		lookahead = 1
+	}
+
+	s := &limiterStream{
+		stream:    stream,
+		ceiling:   fromDB(l.config.Ceiling),
+		release:   smoothing(l.config.Release, stream.SampleRate()),
+		input:     make([]float64, interpolationTaps),
+		delay:     make([]float64, lookahead+interpolationTaps/2-1),
+		lookahead: lookahead,
+		window:    make([]float64, lookahead),
+		windowSum: float64(lookahead),
+		gain:      1,
+	}
+
+	for i := range s.window {
+		s.window[i] = 1
+	}
+
+	return s
+}
+
+func (l *limiterStream) SampleRate() int {
+	return l.stream.SampleRate()
+}
+
+// Read reads limited audio. The output is delayed by the lookahead and a
+// few samples for estimating true peaks.
+func (l *limiterStream) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	if len(l.buf) < dstLen {
+		l.buf = make([]int32, dstLen)
+		l.samples = make([]float64, dstLen)
+	}
+
+	n, err := l.stream.Read(l.buf[:dstLen])
+	if n == 0 {
+		return 0, err
+	}
+
+	dsp.FromInt32(l.samples[:n], l.buf[:n])
+	for i, x := range l.samples[:n] {
+		l.samples[i] = l.process(x)
+	}
+	dsp.ToInt32(l.buf[:n], l.samples[:n])
+
+	if convErr := audio.ReadFromInt32(dst, l.buf, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+// process takes the next input sample, and returns the next output sample.
+func (l *limiterStream) process(x float64) float64 {
+	copy(l.input, l.input[1:])
+	l.input[len(l.input)-1] = x
+
+	// The sample in the middle of the input and the peaks between it and
+	// the next sample are estimated.
+	peak := math.Abs(l.input[interpolationTaps/2-1])
+	for _, phase := range interpolator {
+		var y float64
+		for k, tap := range phase {
+			y += tap * l.input[k]
+		}
+		peak = math.Max(peak, math.Abs(y))
+	}
+
+	required := 1.0
+	if peak > l.ceiling {
+		required = l.ceiling / peak
+	}
+
+	// Track the lowest gain required over the lookahead.
+	for len(l.minimum) > 0 && l.minimum[len(l.minimum)-1].gain >= required {
+		l.minimum = l.minimum[:len(l.minimum)-1]
+	}
+	l.minimum = append(l.minimum, gainAt{position: l.position, gain: required})
+	if l.minimum[0].position <= l.position-l.lookahead {
+		l.minimum = l.minimum[1:]
+	}
+	l.position++
+
+	// Averaging the minimums over the lookahead ramps the gain down ahead
+	// of each peak, and never lets it exceed what the peak requires.
+	l.windowSum += l.minimum[0].gain - l.window[l.windowPos]
+	l.window[l.windowPos] = l.minimum[0].gain
+	l.windowPos = (l.windowPos + 1) % len(l.window)
+	smoothed := l.windowSum / float64(len(l.window))
+
+	if smoothed < l.gain {
+		l.gain = smoothed
+	} else {
+		l.gain += l.release * (smoothed - l.gain)
+	}
+
+	out := l.delay[l.delayPos] * l.gain
+	l.delay[l.delayPos] = x
+	l.delayPos = (l.delayPos + 1) % len(l.delay)
+
+	return out
+}
@@ -0,0 +1,142 @@
+package dynamics
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// latency returns the delay of a limiter with the given lookahead.
+func latency(lookahead time.Duration) int {
+	return int(int64(lookahead)*testSampleRate/int64(time.Second)) + interpolationTaps/2 - 1
+}
+
+func peak(x []float64) float64 {
+	var p float64
+	for _, v := range x {
+		p = math.Max(p, math.Abs(v))
+	}
+
+	return p
+}
+
+// truePeak returns the peak of x oversampled by 8, with a long windowed
+// sinc filter.
+func truePeak(x []float64) float64 {
+	const factor = 8
+	const taps = 64
+
+	p := peak(x)
+	for i := taps / 2; i < len(x)-taps/2; i++ {
+		for phase := 1; phase < factor; phase++ {
+			var y float64
+			for k := -taps/2 + 1; k <= taps/2; k++ {
+				t := float64(k) - float64(phase)/factor
+				window := 0.5 + 0.5*math.Cos(math.Pi*t/(taps/2))
+				y += x[i+k] * sinc(t) * window
+			}
+			p = math.Max(p, math.Abs(y))
+		}
+	}
+
+	return p
+}
+
+func TestLimiterCeiling(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	// Loud noise with bursts and isolated spikes far over the ceiling.
+	noise := make([]float64, testSampleRate)
+	for i := range noise {
+		level := 0.3
+		if (i/2000)%3 == 0 {
+			level = 2
+		}
+		noise[i] = math.Max(-1, math.Min(1, level*r.NormFloat64()))
+		if r.Intn(500) == 0 {
+			noise[i] = math.Copysign(1, noise[i])
+		}
+	}
+
+	// Chords of tones up to a quarter of the sample rate, which start
+	// abruptly and have peaks between samples.
+	chords := make([]float64, testSampleRate)
+	for start := 0; start < len(chords); start += testSampleRate / 10 {
+		for tone := 0; tone < 5; tone++ {
+			frequency := r.Float64() * testSampleRate / 4
+			phase := r.Float64() * 2 * math.Pi
+			for i := start; i < start+testSampleRate/20; i++ {
+				chords[i] += 0.19 * math.Sin(2*math.Pi*frequency*float64(i)/testSampleRate+phase)
+			}
+		}
+	}
+
+	// A tone at a quarter of the sample rate whose samples fall either side
+	// of its peaks, 3 dB below them.
+	tone := make([]float64, testSampleRate)
+	for i := range tone {
+		tone[i] = math.Sin(math.Pi*float64(i)/2 + math.Pi/4)
+	}
+
+	tests := []struct {
+		name   string
+		config LimiterConfig
+		x      []float64
+
+		// Peaks between samples are only limited for signals without
+		// much content near the Nyquist frequency, as the limiter
+		// estimates them with a short filter.
+		bandLimited bool
+	}{
+		{"noise", LimiterConfig{}, noise, false},
+		{"noise at -6 dBFS", LimiterConfig{Ceiling: -6}, noise, false},
+		{"noise with a short lookahead", LimiterConfig{Lookahead: time.Millisecond,
+			Release: 5 * time.Millisecond}, noise, false},
+		{"chords", LimiterConfig{Ceiling: -6}, chords, true},
+		{"chords with a short lookahead", LimiterConfig{Ceiling: -6,
+			Lookahead: time.Millisecond, Release: 5 * time.Millisecond}, chords, true},
+		{"intersample peaks", LimiterConfig{Ceiling: -3}, tone, true},
+	}
+
+	for _, test := range tests {
+		out := process(t, NewLimiter(test.config), test.x)
+
+		ceiling := fromDB(test.config.Ceiling)
+		if test.config.Ceiling == 0 {
+			ceiling = fromDB(DefaultCeiling)
+		}
+
+		if p := peak(out); p > ceiling+1e-9 {
+			t.Errorf("%s: got a peak of %.2f dBFS, expected at most %.2f dBFS", test.name,
+				toDB(p), toDB(ceiling))
+		}
+
+		// The limiter's estimate of true peaks is less accurate than the
+		// one used to measure them, so they may overshoot slightly.
+		if !test.bandLimited {
+			continue
+		}
+		if p := truePeak(out); p > ceiling*fromDB(0.1) {
+			t.Errorf("%s: got a true peak of %.2f dBFS, expected at most %.2f dBFS",
+				test.name, toDB(p), toDB(ceiling))
+		}
+	}
+}
+
+func TestLimiterTransparent(t *testing.T) {
+	// Audio below the ceiling is only delayed.
+	x := make([]float64, testSampleRate/10)
+	for i := range x {
+		x[i] = 0.5 * math.Sin(2*math.Pi*1000*float64(i)/testSampleRate)
+	}
+
+	out := process(t, NewLimiter(LimiterConfig{}), x)
+
+	delay := latency(DefaultLookahead)
+	for i := delay; i < len(out); i++ {
+		if math.Abs(out[i]-x[i-delay]) > 1e-6 {
+			t.Fatalf("sample %d: got %.6f, expected %.6f", i, out[i], x[i-delay])
+		}
+	}
+}
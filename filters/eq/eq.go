@@ -0,0 +1,244 @@
+// Package eq implements a parametric equalizer, for tuning the timbre of
+// voice per device, such as cutting the boominess of a laptop's microphone
+// or adding presence.
+//
+// Each band is a biquad filter from Robert Bristow-Johnson's Audio EQ
+// Cookbook, and bands are applied in series. For example, to cut 250 Hz and
+// lift the presence region:
+//
+//	eq.NewFilter(
+//		eq.Band{Type: eq.HighPass, Frequency: 80},
+//		eq.Band{Type: eq.Peaking, Frequency: 250, Q: 1, Gain: -4},
+//		eq.Band{Type: eq.HighShelf, Frequency: 4000, Gain: 3},
+//	)
+package eq
+
+import (
+	"math"
+	"math/cmplx"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+// Type represents the shape of a band's filter.
+type Type int
+
+// Types of bands.
+const (
+	Peaking Type = iota
+	LowShelf
+	HighShelf
+	LowPass
+	HighPass
+	Notch
+	BandPass
+)
+
+// DefaultQ is the Q of bands that don't set one, which gives a Butterworth
+// response for low and high pass bands, and the steepest shelf without
+// overshoot.
+const DefaultQ = math.Sqrt2 / 2
+
+// maxFrequency is the highest frequency of a band, relative to the sample
+// rate. Bands above it are clamped to it.
+const maxFrequency = 0.49
+
+// Band represents a band of an equalizer.
+type Band struct {
+	Type Type
+
+	// Frequency is the center frequency of peaking, notch and band pass
+	// bands, the corner frequency of shelves, and the cutoff frequency of
+	// low and high pass bands, in Hz.
+	Frequency float64
+
+	// Q is the quality factor, which is higher for narrower bands. It
+	// defaults to DefaultQ.
+	Q float64
+
+	// Gain is the gain in dB of peaking bands and shelves, and is ignored by
+	// the other types.
+	Gain float64
+}
+
+// coefficients represents the normalized coefficients of a biquad.
+type coefficients struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+// coefficients returns the coefficients of a band at a sample rate.
+func (b Band) coefficients(sampleRate int) coefficients {
+	frequency := math.Min(b.Frequency, maxFrequency*float64(sampleRate))
+	q := b.Q
+	if q <= 0 {
+		q = DefaultQ
+	}
+
+	w := 2 * math.Pi * frequency / float64(sampleRate)
+	cos, sin := math.Cos(w), math.Sin(w)
+	alpha := sin / (2 * q)
+	a := math.Pow(10, b.Gain/40)
+
+	var b0, b1, b2, a0, a1, a2 float64
+	switch b.Type {
+	case Peaking:
+		b0, b1, b2 = 1+alpha*a, -2*cos, 1-alpha*a
+		a0, a1, a2 = 1+alpha/a, -2*cos, 1-alpha/a
+	case LowShelf:
+		s := 2 * math.Sqrt(a) * alpha
+		b0 = a * ((a + 1) - (a-1)*cos + s)
+		b1 = 2 * a * ((a - 1) - (a+1)*cos)
+		b2 = a * ((a + 1) - (a-1)*cos - s)
+		a0 = (a + 1) + (a-1)*cos + s
+		a1 = -2 * ((a - 1) + (a+1)*cos)
+		a2 = (a + 1) + (a-1)*cos - s
+	case HighShelf:
+		s := 2 * math.Sqrt(a) * alpha
+		b0 = a * ((a + 1) + (a-1)*cos + s)
+		b1 = -2 * a * ((a - 1) + (a+1)*cos)
+		b2 = a * ((a + 1) + (a-1)*cos - s)
+		a0 = (a + 1) - (a-1)*cos + s
+		a1 = 2 * ((a - 1) - (a+1)*cos)
+		a2 = (a + 1) - (a-1)*cos - s
+	case LowPass:
+		b0, b1, b2 = (1-cos)/2, 1-cos, (1-cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case HighPass:
+		b0, b1, b2 = (1+cos)/2, -(1 + cos), (1+cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case Notch:
+		b0, b1, b2 = 1, -2*cos, 1
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case BandPass:
+		b0, b1, b2 = alpha, 0, -alpha
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	default:
+		panic("eq: invalid band type")
+	}
+
+	return coefficients{b0 / a0, b1 / a0, b2 / a0, a1 / a0, a2 / a0}
+}
+
+// response returns the complex frequency response of a biquad.
+func (c coefficients) response(frequency float64, sampleRate int) complex128 {
+	z := cmplx.Exp(complex(0, -2*math.Pi*frequency/float64(sampleRate)))
+	return (complex(c.b0, 0) + complex(c.b1, 0)*z + complex(c.b2, 0)*z*z) /
+		(1 + complex(c.a1, 0)*z + complex(c.a2, 0)*z*z)
+}
+
+// Response returns the gain in dB of the band at a frequency, when applied
+// at a sample rate.
+func (b Band) Response(frequency float64, sampleRate int) float64 {
+	return 20 * math.Log10(cmplx.Abs(b.coefficients(sampleRate).response(frequency, sampleRate)))
+}
+
+// Filter represents an equalizer filter.
+type Filter struct {
+	bands []Band
+}
+
+// streamFilter represents a stream filter.
+type streamFilter struct {
+	stream     audio.Stream
+	bands      []Band
+	sampleRate int
+	biquads    []coefficients
+
+	// state holds the two delay elements of each biquad, in transposed
+	// direct form II.
+	state [][2]float64
+
+	buf     []int32
+	samples []float64
+}
+
+// NewFilter returns a new equalizer filter which applies the bands in
+// order.
+func NewFilter(bands ...Band) audio.Filter {
+	for _, b := range bands {
+		if b.Frequency <= 0 {
+			panic("eq: frequency must be positive")
+		}
+		if b.Type < Peaking || b.Type > BandPass {
+			panic("eq: invalid band type")
+		}
+	}
+
+	return &Filter{bands: append([]Band(nil), bands...)}
+}
+
+// Response returns the gain in dB of the equalizer at a frequency, when
+// applied at a sample rate.
+func (f *Filter) Response(frequency float64, sampleRate int) float64 {
+	var gain float64
+	for _, b := range f.bands {
+		gain += b.Response(frequency, sampleRate)
+	}
+
+	return gain
+}
+
+// Filter implements the Filter method for filters.
+func (f *Filter) Filter(stream audio.Stream) audio.Stream {
+	s := &streamFilter{
+		stream: stream,
+		bands:  f.bands,
+		state:  make([][2]float64, len(f.bands)),
+	}
+	s.configure(stream.SampleRate())
+
+	return s
+}
+
+// configure computes the coefficients of the bands at a sample rate, and
+// clears the biquads' state.
+func (f *streamFilter) configure(sampleRate int) {
+	f.sampleRate = sampleRate
+	f.biquads = make([]coefficients, len(f.bands))
+	for i, b := range f.bands {
+		f.biquads[i] = b.coefficients(sampleRate)
+		f.state[i] = [2]float64{}
+	}
+}
+
+func (f *streamFilter) SampleRate() int {
+	return f.stream.SampleRate()
+}
+
+func (f *streamFilter) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	if len(f.buf) < dstLen {
+		f.buf = make([]int32, dstLen)
+		f.samples = make([]float64, dstLen)
+	}
+
+	n, err := f.stream.Read(f.buf[:dstLen])
+	if n == 0 {
+		return 0, err
+	}
+
+	// Streams such as those received from the network may change sample
+	// rate, which changes the coefficients.
+	if sampleRate := f.stream.SampleRate(); sampleRate != f.sampleRate {
+		f.configure(sampleRate)
+	}
+
+	dsp.FromInt32(f.samples[:n], f.buf[:n])
+	for i, c := range f.biquads {
+		z := &f.state[i]
+		for j, x := range f.samples[:n] {
+			y := c.b0*x + z[0]
+			z[0] = c.b1*x - c.a1*y + z[1]
+			z[1] = c.b2*x - c.a2*y
+			f.samples[j] = y
+		}
+	}
+	dsp.ToInt32(f.buf[:n], f.samples[:n])
+
+	if convErr := audio.ReadFromInt32(dst, f.buf, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
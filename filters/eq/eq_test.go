@@ -0,0 +1,142 @@
+package eq
+
+import (
+	"math"
+	"testing"
+)
+
+const testSampleRate = 48000
+
+// sineStream is a sine wave at a sample rate which can be changed between
+// reads.
+type sineStream struct {
+	frequency  float64
+	amplitude  float64
+	sampleRate int
+	phase      float64
+}
+
+func (s *sineStream) SampleRate() int {
+	return s.sampleRate
+}
+
+func (s *sineStream) Read(dst interface{}) (int, error) {
+	buf := dst.([]int32)
+	for i := range buf {
+		buf[i] = int32(s.amplitude * math.Sin(s.phase) * math.MaxInt32)
+		s.phase += 2 * math.Pi * s.frequency / float64(s.sampleRate)
+	}
+
+	return len(buf), nil
+}
+
+// measure returns the gain in dB of a stream of a sine wave, after the
+// filter's transient has passed.
+func measure(t *testing.T, filtered interface {
+	Read(dst interface{}) (int, error)
+}, amplitude float64, samples int) float64 {
+	t.Helper()
+
+	buf := make([]int32, samples)
+	for i := 0; i < 2; i++ {
+		if _, err := filtered.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sum float64
+	for _, x := range buf {
+		v := float64(x) / math.MaxInt32
+		sum += v * v
+	}
+
+	return 20 * math.Log10(math.Sqrt(sum/float64(len(buf)))/(amplitude/math.Sqrt2))
+}
+
+var testBands = []Band{
+	{Type: Peaking, Frequency: 1000, Gain: 6},
+	{Type: LowShelf, Frequency: 1000, Gain: 6},
+	{Type: HighShelf, Frequency: 1000, Gain: 6},
+	{Type: LowPass, Frequency: 1000},
+	{Type: HighPass, Frequency: 1000},
+	{Type: Notch, Frequency: 1000},
+	{Type: BandPass, Frequency: 1000},
+}
+
+func TestResponse(t *testing.T) {
+	// The expected gains in dB at 20 Hz, 1000 Hz and 20 kHz, where -100
+	// means at most -20 dB.
+	expected := [][3]float64{
+		{0, 6, 0},
+		{6, 3, 0},
+		{0, 3, 6},
+		{0, -3, -100},
+		{-100, -3, 0},
+		{0, -100, 0},
+		{-100, 0, -100},
+	}
+
+	for i, b := range testBands {
+		for j, frequency := range []float64{20, 1000, 20000} {
+			got := b.Response(frequency, testSampleRate)
+			if expected[i][j] == -100 {
+				if got > -20 {
+					t.Errorf("type %d at %.0f Hz: got %.2f dB, expected at most -20 dB",
+						b.Type, frequency, got)
+				}
+			} else if math.Abs(got-expected[i][j]) > 0.1 {
+				t.Errorf("type %d at %.0f Hz: got %.2f dB, expected %.0f dB",
+					b.Type, frequency, got, expected[i][j])
+			}
+		}
+	}
+}
+
+func TestFilterMatchesResponse(t *testing.T) {
+	const amplitude = 0.25
+
+	for _, b := range testBands {
+		f := NewFilter(b).(*Filter)
+		for _, frequency := range []float64{200, 1000, 5000} {
+			response := f.Response(frequency, testSampleRate)
+			if response < -40 {
+				continue
+			}
+
+			stream := &sineStream{frequency: frequency, amplitude: amplitude,
+				sampleRate: testSampleRate}
+			got := measure(t, f.Filter(stream), amplitude, testSampleRate/2)
+			if math.Abs(got-response) > 0.1 {
+				t.Errorf("type %d at %.0f Hz: got %.2f dB, expected %.2f dB",
+					b.Type, frequency, got, response)
+			}
+		}
+	}
+}
+
+func TestSampleRateChange(t *testing.T) {
+	const (
+		amplitude = 0.25
+		frequency = 3000
+	)
+
+	f := NewFilter(Band{Type: LowPass, Frequency: 4000}).(*Filter)
+	stream := &sineStream{frequency: frequency, amplitude: amplitude,
+		sampleRate: testSampleRate}
+	filtered := f.Filter(stream)
+
+	if got, expected := measure(t, filtered, amplitude, testSampleRate/2),
+		f.Response(frequency, testSampleRate); math.Abs(got-expected) > 0.1 {
+		t.Errorf("at %d Hz: got %.2f dB, expected %.2f dB", testSampleRate, got, expected)
+	}
+
+	// Relative to the old rate, the tone is far above the corner, so it
+	// would be heavily attenuated if the coefficients were stale.
+	const newRate = 8000
+	stream.sampleRate = newRate
+
+	if got, expected := measure(t, filtered, amplitude, newRate/2),
+		f.Response(frequency, newRate); math.Abs(got-expected) > 0.1 {
+		t.Errorf("at %d Hz: got %.2f dB, expected %.2f dB", newRate, got, expected)
+	}
+}
@@ -0,0 +1,213 @@
+// Package gate implements a noise gate, which attenuates audio while it is
+// quieter than a threshold, such as between a participant's sentences.
+//
+// Unlike the VAD filter, which cuts abruptly to silence, the gate opens and
+// closes with smooth attack and release ramps, stays open for a hold time
+// after the level falls, and uses separate open and close thresholds so
+// that levels near the threshold don't make it chatter. When closed, audio
+// is attenuated to a floor rather than silenced, which sounds less
+// unnatural to the far end.
+package gate
+
+import (
+	"math"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+// Defaults for the configuration of a gate.
+const (
+	DefaultOpen       = -45
+	DefaultHysteresis = 5
+	DefaultAttack     = 5 * time.Millisecond
+	DefaultHold       = 200 * time.Millisecond
+	DefaultRelease    = 150 * time.Millisecond
+	DefaultFloor      = -40
+	DefaultLookahead  = 10 * time.Millisecond
+)
+
+// detectorTime is the time constant of the level detector.
+const detectorTime = 10 * time.Millisecond
+
+// Config represents the configuration of a gate. Zero values are replaced
+// with their defaults.
+type Config struct {
+	// Open is the level in dBFS above which the gate opens.
+	Open float64
+
+	// Close is the level in dBFS below which the gate closes, after the
+	// hold time. It defaults to 5 dB below Open.
+	Close float64
+
+	// Attack is how long the gate takes to open fully.
+	Attack time.Duration
+
+	// Hold is how long the gate stays open after the level falls below
+	// Close. A negative hold closes the gate straight away.
+	Hold time.Duration
+
+	// Release is how long the gate takes to close fully.
+	Release time.Duration
+
+	// Floor is the attenuation in dB applied while the gate is closed.
+	Floor float64
+
+	// Lookahead is how far ahead the gate sees the level rising, so that it
+	// opens before the start of speech rather than cutting it off. It is
+	// also the latency the gate adds. A negative lookahead disables it.
+	Lookahead time.Duration
+}
+
+// Filter represents a gate filter.
+type Filter struct {
+	config Config
+}
+
+// streamFilter represents a stream filter.
+type streamFilter struct {
+	stream audio.Stream
+	config Config
+
+	detector    float64
+	envelope    float64 // smoothed power of the input
+	open        bool
+	hold        int
+	holdSamples int
+	attackStep  float64 // change of gain in dB per sample while opening
+	releaseStep float64 // change of gain in dB per sample while closing
+	gain        float64 // current gain in dB
+
+	delay    []float64
+	delayPos int
+
+	buf     []int32
+	samples []float64
+}
+
+// NewFilter returns a new gate filter with the given configuration.
+func NewFilter(config Config) audio.Filter {
+	if config.Open == 0 {
+		config.Open = DefaultOpen
+	}
+	if config.Close == 0 {
+		config.Close = config.Open - DefaultHysteresis
+	}
+	if config.Attack <= 0 {
+		config.Attack = DefaultAttack
+	}
+	if config.Hold < 0 {
+		config.Hold = 0
+	} else if config.Hold == 0 {
+		config.Hold = DefaultHold
+	}
+	if config.Release <= 0 {
+		config.Release = DefaultRelease
+	}
+	if config.Floor == 0 {
+		config.Floor = DefaultFloor
+	}
+	if config.Lookahead < 0 {
+		config.Lookahead = 0
+	} else if config.Lookahead == 0 {
+		config.Lookahead = DefaultLookahead
+	}
+
+	if config.Close > config.Open {
+		panic("gate: close threshold must not be above open threshold")
+	}
+	if config.Floor > 0 {
+		panic("gate: floor must not be positive")
+	}
+
+	return &Filter{config: config}
+}
+
+// Filter implements the Filter method for filters.
+func (f *Filter) Filter(stream audio.Stream) audio.Stream {
+	sampleRate := stream.SampleRate()
+	samples := func(d time.Duration) int {
+		return int(int64(d) * int64(sampleRate) / int64(time.Second))
+	}
+
+	return &streamFilter{
+		stream:      stream,
+		config:      f.config,
+		detector:    1 - math.Exp(-1/(detectorTime.Seconds()*float64(sampleRate))),
+		holdSamples: samples(f.config.Hold),
+		attackStep:  -f.config.Floor / math.Max(1, float64(samples(f.config.Attack))),
+		releaseStep: -f.config.Floor / math.Max(1, float64(samples(f.config.Release))),
+		gain:        f.config.Floor,
+		delay:       make([]float64, samples(f.config.Lookahead)),
+	}
+}
+
+func (f *streamFilter) SampleRate() int {
+	return f.stream.SampleRate()
+}
+
+// Read reads gated audio. The output is delayed by the lookahead.
+func (f *streamFilter) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	if len(f.buf) < dstLen {
+		f.buf = make([]int32, dstLen)
+		f.samples = make([]float64, dstLen)
+	}
+
+	n, err := f.stream.Read(f.buf[:dstLen])
+	if n == 0 {
+		return 0, err
+	}
+
+	dsp.FromInt32(f.samples[:n], f.buf[:n])
+	for i, x := range f.samples[:n] {
+		f.samples[i] = f.process(x)
+	}
+	dsp.ToInt32(f.buf[:n], f.samples[:n])
+
+	if convErr := audio.ReadFromInt32(dst, f.buf, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+// process takes the next input sample, and returns the next output sample.
+func (f *streamFilter) process(x float64) float64 {
+	f.envelope += f.detector * (x*x - f.envelope)
+
+	level := math.Inf(-1)
+	if f.envelope > 0 {
+		level = 10 * math.Log10(f.envelope)
+	}
+
+	switch {
+	case level > f.config.Open:
+		f.open = true
+		f.hold = f.holdSamples
+	case level < f.config.Close && f.open:
+		if f.hold > 0 {
+			f.hold--
+		} else {
+			f.open = false
+		}
+	}
+
+	if f.open {
+		f.gain = math.Min(f.gain+f.attackStep, 0)
+	} else {
+		f.gain = math.Max(f.gain-f.releaseStep, f.config.Floor)
+	}
+
+	gain := math.Pow(10, f.gain/20)
+	if len(f.delay) == 0 {
+		return x * gain
+	}
+
+	out := f.delay[f.delayPos] * gain
+	f.delay[f.delayPos] = x
+	f.delayPos = (f.delayPos + 1) % len(f.delay)
+
+	return out
+}
@@ -0,0 +1,178 @@
+package gate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/1lann/dissonance/dsp"
+)
+
+const testSampleRate = 16000
+
+// sliceStream is a stream of precomputed samples, followed by silence.
+type sliceStream struct {
+	samples []int32
+}
+
+func (s *sliceStream) SampleRate() int {
+	return testSampleRate
+}
+
+func (s *sliceStream) Read(dst interface{}) (int, error) {
+	buf := dst.([]int32)
+	n := copy(buf, s.samples)
+	s.samples = s.samples[n:]
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+
+	return len(buf), nil
+}
+
+func samples(d time.Duration) int {
+	return int(int64(d) * testSampleRate / int64(time.Second))
+}
+
+// constant appends a duration of a constant signal at the given level in
+// dBFS to x.
+func constant(x []float64, level float64, d time.Duration) []float64 {
+	amplitude := math.Pow(10, level/20)
+	for i := 0; i < samples(d); i++ {
+		x = append(x, amplitude)
+	}
+
+	return x
+}
+
+// process runs x through a gate with the given configuration.
+func process(t *testing.T, config Config, x []float64) []float64 {
+	t.Helper()
+
+	in := make([]int32, len(x))
+	dsp.ToInt32(in, x)
+	filtered := NewFilter(config).Filter(&sliceStream{samples: in})
+
+	buf := make([]int32, len(x))
+	for read := 0; read < len(buf); {
+		end := read + 160
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		n, err := filtered.Read(buf[read:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += n
+	}
+
+	out := make([]float64, len(x))
+	dsp.FromInt32(out, buf)
+
+	return out
+}
+
+// gainAt returns the gain in dB applied to the input sample which is output
+// at the given index, after the latency.
+func gainAt(x, out []float64, index, latency int) float64 {
+	return 20 * math.Log10(math.Abs(out[index])/math.Abs(x[index-latency]))
+}
+
+func TestLatency(t *testing.T) {
+	tests := []struct {
+		lookahead time.Duration
+		latency   int
+	}{
+		{-1, 0},
+		{time.Second / testSampleRate, 1},
+		{DefaultLookahead, samples(DefaultLookahead)},
+		{0, samples(DefaultLookahead)},
+	}
+
+	const start = 1000
+	x := constant(make([]float64, start), -20, 100*time.Millisecond)
+
+	for _, test := range tests {
+		out := process(t, Config{Lookahead: test.lookahead}, x)
+
+		first := -1
+		for i, v := range out {
+			if v != 0 {
+				first = i
+				break
+			}
+		}
+
+		if first != start+test.latency {
+			t.Errorf("lookahead %v: got the signal at sample %d, expected %d",
+				test.lookahead, first, start+test.latency)
+		}
+	}
+}
+
+func TestOpenHoldClose(t *testing.T) {
+	x := constant(nil, -20, 500*time.Millisecond)
+	x = constant(x, -60, time.Second)
+	out := process(t, Config{Lookahead: -1}, x)
+
+	quiet := samples(500 * time.Millisecond)
+	tests := []struct {
+		name  string
+		index int
+		gain  float64
+	}{
+		{"open", quiet - 1, 0},
+		{"holding", quiet + samples(DefaultHold), 0},
+		{"closed", len(x) - 1, DefaultFloor},
+	}
+
+	for _, test := range tests {
+		if gain := gainAt(x, out, test.index, 0); math.Abs(gain-test.gain) > 0.1 {
+			t.Errorf("%s: got gain %.2f dB, expected %.0f dB", test.name, gain, test.gain)
+		}
+	}
+
+	// The gate opens smoothly over the attack time rather than in a step.
+	ramp := 0
+	for i := range x[:quiet] {
+		if gain := gainAt(x, out, i, 0); gain > DefaultFloor+0.01 && gain < -0.01 {
+			ramp++
+		}
+	}
+
+	if expected := samples(DefaultAttack); ramp < expected-2 || ramp > expected {
+		t.Errorf("opened over %d samples, expected %d", ramp, expected)
+	}
+
+	// It also closes smoothly, over the release time.
+	ramp = 0
+	for i := quiet; i < len(x); i++ {
+		if gain := gainAt(x, out, i, 0); gain > DefaultFloor+0.01 && gain < -0.01 {
+			ramp++
+		}
+	}
+
+	if expected := samples(DefaultRelease); ramp < expected-2 || ramp > expected {
+		t.Errorf("closed over %d samples, expected %d", ramp, expected)
+	}
+}
+
+func TestHysteresis(t *testing.T) {
+	// A level between the close and open thresholds neither opens a closed
+	// gate nor closes an open one.
+	const between = DefaultOpen - DefaultHysteresis/2
+
+	closed := constant(nil, between, time.Second)
+	out := process(t, Config{Lookahead: -1}, closed)
+	if gain := gainAt(closed, out, len(closed)-1, 0); math.Abs(gain-DefaultFloor) > 0.1 {
+		t.Errorf("closed: got gain %.2f dB, expected %.0f dB", gain, float64(DefaultFloor))
+	}
+
+	open := constant(nil, -20, 100*time.Millisecond)
+	open = constant(open, between, time.Second)
+	out = process(t, Config{Lookahead: -1}, open)
+	if gain := gainAt(open, out, len(open)-1, 0); math.Abs(gain) > 0.1 {
+		t.Errorf("open: got gain %.2f dB, expected 0 dB", gain)
+	}
+}
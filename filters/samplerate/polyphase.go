@@ -0,0 +1,287 @@
+package samplerate
+
+import (
+	"math"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
+)
+
+// Quality represents a trade off between the quality and the cost of a
+// Resampler.
+type Quality int
+
+// Qualities of a resampler.
+const (
+	// LowQuality suits voice over constrained devices, with about 60 dB of
+	// stopband attenuation.
+	LowQuality Quality = iota
+
+	// MediumQuality suits voice generally, with about 80 dB of stopband
+	// attenuation.
+	MediumQuality
+
+	// HighQuality suits music, with about 100 dB of stopband attenuation and
+	// a narrower transition band.
+	HighQuality
+)
+
+// qualitySettings holds, for each quality, the number of zero crossings of
+// the sinc on each side of its center, the Kaiser window's beta, and the
+// cutoff relative to the lower of the two Nyquist frequencies.
+var qualitySettings = [...]struct {
+	zeroCrossings int
+	beta          float64
+	cutoff        float64
+}{
+	LowQuality:    {8, 6, 0.85},
+	MediumQuality: {16, 8, 0.9},
+	HighQuality:   {32, 10, 0.94},
+}
+
+// maxTablePhases is the largest number of phases whose coefficients are
+// precomputed. Ratios needing more compute their coefficients as they go.
+const maxTablePhases = 1024
+
+// Resampler represents a band-limited sample rate converter.
+type Resampler struct {
+	sampleRate int
+	quality    Quality
+}
+
+// resamplerStream represents a stream converted by a Resampler.
+type resamplerStream struct {
+	stream     audio.Stream
+	sampleRate int
+
+	// The output rate is up/down times the input rate, in lowest terms.
+	up   int64
+	down int64
+
+	halfTaps int     // input samples on each side of an output's position
+	cutoff   float64 // relative to the input's Nyquist frequency
+	beta     float64
+	table    [][]float64 // coefficients of each phase, if precomputed
+
+	input      []float64
+	inputStart int64 // position of input[0] in the stream
+	position   int64 // output samples produced
+	ended      bool
+	endAt      int64 // input samples in the stream, once it has ended
+	err        error // error the input ended with
+
+	buf []int32
+}
+
+// NewResampler returns a new polyphase windowed-sinc resampler which
+// converts into the given sample rate at a quality. The ratio between the
+// sample rates is exact, and the output is aligned in time with the input,
+// with the filter's delay compensated for.
+func NewResampler(sampleRate int, quality Quality) audio.Filter {
+	if sampleRate <= 0 {
+		panic("samplerate: sample rate must be positive")
+	}
+	if quality < LowQuality || quality > HighQuality {
+		panic("samplerate: invalid quality")
+	}
+
+	return &Resampler{sampleRate: sampleRate, quality: quality}
+}
+
+// Filter implements the Filter method for filters.
+func (r *Resampler) Filter(stream audio.Stream) audio.Stream {
+	divisor := gcd(r.sampleRate, stream.SampleRate())
+	settings := qualitySettings[r.quality]
+
+	s := &resamplerStream{
+		stream:     stream,
+		sampleRate: r.sampleRate,
+		up:         int64(r.sampleRate / divisor),
+		down:       int64(stream.SampleRate() / divisor),
+		cutoff:     settings.cutoff,
+		beta:       settings.beta,
+		halfTaps:   settings.zeroCrossings,
+	}
+
+	// When downsampling, the filter's cutoff is lowered to the output's
+	// Nyquist frequency, and it is widened to keep its transition band.
+	if s.up < s.down {
+		s.cutoff *= float64(s.up) / float64(s.down)
+		s.halfTaps = int(math.Ceil(float64(settings.zeroCrossings) *
+			float64(s.down) / float64(s.up)))
+	}
+
+	if s.up <= maxTablePhases {
+		s.table = make([][]float64, s.up)
+		for phase := range s.table {
+			s.table[phase] = s.coefficients(int64(phase))
+		}
+	}
+
+	return s
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
+
+// coefficients returns the filter's coefficients for a phase, which is the
+// fractional position of an output sample between input samples in units of
+// 1/up. Coefficient k applies to the input sample halfTaps-1-k before the
+// output's position.
+func (s *resamplerStream) coefficients(phase int64) []float64 {
+	c := make([]float64, 2*s.halfTaps)
+	fraction := float64(phase) / float64(s.up)
+	for k := range c {
+		distance := float64(s.halfTaps-1-k) + fraction
+		c[k] = s.cutoff * sinc(s.cutoff*distance) *
+			kaiser(distance/float64(s.halfTaps), s.beta)
+	}
+
+	return c
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser returns the Kaiser window at x between -1 and 1.
+func kaiser(x, beta float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+
+	return besselI0(beta*math.Sqrt(1-x*x)) / besselI0(beta)
+}
+
+// besselI0 returns the zeroth order modified Bessel function of the first
+// kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > sum*1e-12; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+
+	return sum
+}
+
+func (s *resamplerStream) SampleRate() int {
+	return s.sampleRate
+}
+
+// Read reads resampled audio, returning at most as many samples as fit in
+// dst. The error that ended the input, such as io.EOF, is returned once the
+// output covering all of the input has been read.
+func (s *resamplerStream) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	result := make([]float64, 0, dstLen)
+
+	for len(result) == 0 && dstLen > 0 {
+		if !s.ended {
+			s.readInput(dstLen)
+		}
+
+		available := s.inputStart + int64(len(s.input))
+		for len(result) < dstLen {
+			center := s.position * s.down / s.up
+			if s.ended {
+				// Past the end, the input is taken to be silent, so output
+				// continues until it covers the whole input.
+				if s.position*s.down >= s.endAt*s.up {
+					break
+				}
+			} else if center+int64(s.halfTaps) >= available {
+				break
+			}
+
+			result = append(result, s.sample(center, s.position*s.down%s.up))
+			s.position++
+		}
+
+		if s.ended {
+			break
+		}
+	}
+
+	// Discard input no longer needed.
+	oldest := s.position*s.down/s.up - int64(s.halfTaps) + 1
+	if drop := oldest - s.inputStart; drop > 0 {
+		if drop > int64(len(s.input)) {
+			drop = int64(len(s.input))
+		}
+		s.input = append(s.input[:0], s.input[drop:]...)
+		s.inputStart += drop
+	}
+
+	var err error
+	if s.ended && s.position*s.down >= s.endAt*s.up {
+		err = s.err
+	}
+
+	out := make([]int32, len(result))
+	dsp.ToInt32(out, result)
+	if convErr := audio.ReadFromInt32(dst, out, len(out)); convErr != nil {
+		return 0, convErr
+	}
+
+	return len(out), err
+}
+
+// readInput reads the input needed for the next count output samples,
+// including the filter's lookahead.
+func (s *resamplerStream) readInput(count int) {
+	last := (s.position + int64(count) - 1) * s.down / s.up
+	needed := last + int64(s.halfTaps) + 1 - (s.inputStart + int64(len(s.input)))
+	if needed <= 0 {
+		return
+	}
+
+	if len(s.buf) < int(needed) {
+		s.buf = make([]int32, needed)
+	}
+
+	n, err := s.stream.Read(s.buf[:needed])
+	samples := make([]float64, n)
+	dsp.FromInt32(samples, s.buf[:n])
+	s.input = append(s.input, samples...)
+
+	if err != nil {
+		s.ended = true
+		s.endAt = s.inputStart + int64(len(s.input))
+		s.err = err
+	}
+}
+
+// sample returns the output sample at the given input position and phase.
+func (s *resamplerStream) sample(center, phase int64) float64 {
+	c := s.coefficientsFor(phase)
+
+	var sum float64
+	first := center - int64(s.halfTaps) + 1
+	for k := range c {
+		j := first + int64(k) - s.inputStart
+		if j < 0 || j >= int64(len(s.input)) {
+			continue
+		}
+		sum += c[k] * s.input[j]
+	}
+
+	return sum
+}
+
+func (s *resamplerStream) coefficientsFor(phase int64) []float64 {
+	if s.table != nil {
+		return s.table[phase]
+	}
+
+	return s.coefficients(phase)
+}
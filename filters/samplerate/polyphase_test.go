@@ -0,0 +1,180 @@
+package samplerate
+
+import (
+	"io"
+	"math"
+	"testing"
+
+	"github.com/1lann/dissonance/dsp"
+)
+
+// sliceStream is a stream of precomputed samples, which ends with io.EOF.
+type sliceStream struct {
+	sampleRate int
+	samples    []int32
+}
+
+func newSliceStream(sampleRate int, x []float64) *sliceStream {
+	samples := make([]int32, len(x))
+	dsp.ToInt32(samples, x)
+	return &sliceStream{sampleRate: sampleRate, samples: samples}
+}
+
+func (s *sliceStream) SampleRate() int {
+	return s.sampleRate
+}
+
+func (s *sliceStream) Read(dst interface{}) (int, error) {
+	n := copy(dst.([]int32), s.samples)
+	s.samples = s.samples[n:]
+	if len(s.samples) == 0 {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// resample converts x from one sample rate to another, reading readSize
+// samples at a time, and returns the output up to io.EOF.
+func resample(t *testing.T, x []float64, from, to int, quality Quality,
+	readSize int) []float64 {
+	t.Helper()
+
+	stream := NewResampler(to, quality).Filter(newSliceStream(from, x))
+	if stream.SampleRate() != to {
+		t.Fatalf("got sample rate %d, expected %d", stream.SampleRate(), to)
+	}
+
+	var out []float64
+	buf := make([]int32, readSize)
+	f := make([]float64, readSize)
+	for {
+		n, err := stream.Read(buf)
+		if n > readSize {
+			t.Fatalf("read %d samples into a buffer of %d", n, readSize)
+		}
+
+		dsp.FromInt32(f[:n], buf[:n])
+		out = append(out, f[:n]...)
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing follows the end of the stream.
+	if n, err := stream.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("read after EOF: got %d samples and %v, expected 0 and io.EOF", n, err)
+	}
+
+	return out
+}
+
+func tone(sampleRate int, frequency, amplitude float64, n int) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate))
+	}
+
+	return x
+}
+
+func rms(x []float64) float64 {
+	var sum float64
+	for _, v := range x {
+		sum += v * v
+	}
+
+	return math.Sqrt(sum / float64(len(x)))
+}
+
+func TestOutputLength(t *testing.T) {
+	tests := []struct {
+		from, to int
+		input    int
+		expected int
+	}{
+		{44100, 48000, 44100, 48000},
+		{48000, 8000, 48000, 8000},
+		{48000, 8000, 48001, 8001},
+		{8000, 48000, 8000, 48000},
+		{44100, 48000, 1, 2},
+	}
+
+	for _, test := range tests {
+		for _, readSize := range []int{1, 97, 4096} {
+			x := tone(test.from, 440, 0.5, test.input)
+			out := resample(t, x, test.from, test.to, MediumQuality, readSize)
+			if len(out) != test.expected {
+				t.Errorf("%d to %d Hz, %d samples, reads of %d: got %d samples, expected %d",
+					test.from, test.to, test.input, readSize, len(out), test.expected)
+			}
+		}
+	}
+}
+
+func TestStopband(t *testing.T) {
+	tests := []struct {
+		quality     Quality
+		attenuation float64
+	}{
+		{LowQuality, 60},
+		{MediumQuality, 80},
+		{HighQuality, 100},
+	}
+
+	// A 6 kHz tone is above the 4 kHz Nyquist frequency of the output, so
+	// it must be removed rather than aliased to 2 kHz.
+	x := tone(48000, 6000, 0.9, 48000)
+	for _, test := range tests {
+		out := resample(t, x, 48000, 8000, test.quality, 160)
+
+		// The edges are skipped, where the tone starts and stops abruptly.
+		rejection := 20 * math.Log10(rms(x)/rms(out[800:len(out)-800]))
+		if rejection < test.attenuation {
+			t.Errorf("quality %d: got %.1f dB of rejection, expected at least %.0f dB",
+				test.quality, rejection, test.attenuation)
+		}
+	}
+
+	// A tone in the passband is kept.
+	out := resample(t, tone(48000, 1000, 0.5, 48000), 48000, 8000, LowQuality, 160)
+	if level := rms(out[800 : len(out)-800]); math.Abs(level-0.5/math.Sqrt2) > 0.01 {
+		t.Errorf("1 kHz tone: got RMS %.3f, expected %.3f", level, 0.5/math.Sqrt2)
+	}
+}
+
+func TestImpulseAlignment(t *testing.T) {
+	tests := []struct {
+		from, to int
+	}{
+		{44100, 48000},
+		{48000, 44100},
+		{48000, 8000},
+		{8000, 48000},
+		{16000, 48000},
+	}
+
+	for _, test := range tests {
+		// The impulse is at a time which falls on a sample at both rates.
+		x := make([]float64, test.from)
+		at := test.from / 2
+		x[at] = 0.5
+
+		out := resample(t, x, test.from, test.to, MediumQuality, 256)
+
+		peak := 0
+		for i := range out {
+			if math.Abs(out[i]) > math.Abs(out[peak]) {
+				peak = i
+			}
+		}
+
+		if expected := test.to / 2; peak != expected {
+			t.Errorf("%d to %d Hz: got the impulse at sample %d, expected %d",
+				test.from, test.to, peak, expected)
+		}
+	}
+}
@@ -1,9 +1,11 @@
-// Package samplerate contains a sample rate converter using linear
-// interpolation.
+// Package samplerate contains sample rate converters. NewFilter converts
+// cheaply with linear interpolation, which aliases when downsampling, and
+// NewResampler converts with a band-limited polyphase filter.
 package samplerate
 
 import (
 	"github.com/1lann/dissonance/audio"
+	"github.com/1lann/dissonance/dsp"
 )
 
 // Filter represents the sample rate audio.Filter
@@ -17,6 +19,7 @@ type streamFilter struct {
 	buffer       []int32
 	lastPosition float64
 	ratio        float64
+	output       []int32 // converted samples which didn't fit in dst
 }
 
 // NewFilter returns a new sample rate filter to convert into the given
@@ -25,12 +28,6 @@ func NewFilter(sampleRate int) audio.Filter {
 	return &Filter{sampleRate: sampleRate}
 }
 
-func interpolate(buffer []int32, position float64) int32 {
-	i := int(position)
-	between := position - float64(int(position))
-	return int32(float64(buffer[i]) + float64(buffer[i+1]-buffer[i])*between)
-}
-
 // Filter implements the Filter method for filters.
 func (f *Filter) Filter(stream audio.Stream) audio.Stream {
 	return &streamFilter{
@@ -48,6 +45,10 @@ func (f *streamFilter) SampleRate() int {
 
 func (f *streamFilter) Read(dst interface{}) (int, error) {
 	dstLen := audio.SliceLength(dst)
+	if len(f.output) > 0 {
+		return f.drain(dst, dstLen)
+	}
+
 	required := int(float64(dstLen)*f.ratio+1) - len(f.buffer)
 	buf := make([]int32, required)
 	_, err := f.stream.Read(buf)
@@ -61,15 +62,30 @@ func (f *streamFilter) Read(dst interface{}) (int, error) {
 
 	var i float64
 	for ; i*f.ratio+f.lastPosition < float64(len(f.buffer)-1); i++ {
-		result = append(result, interpolate(f.buffer, i*f.ratio+f.lastPosition))
+		result = append(result, dsp.Interpolate(f.buffer, i*f.ratio+f.lastPosition))
 	}
 
 	f.lastPosition = i*f.ratio - float64(int(i*f.ratio))
 	f.buffer = f.buffer[len(f.buffer)-1:]
 
-	if err := audio.ReadFromInt32(dst, result, len(result)); err != nil {
+	f.output = result
+
+	return f.drain(dst, dstLen)
+}
+
+// drain reads converted samples into dst, keeping those which don't fit for
+// the next read.
+func (f *streamFilter) drain(dst interface{}, dstLen int) (int, error) {
+	n := len(f.output)
+	if n > dstLen {
+		n = dstLen
+	}
+
+	if err := audio.ReadFromInt32(dst, f.output, n); err != nil {
 		return 0, err
 	}
 
-	return len(result), nil
+	f.output = f.output[n:]
+
+	return n, nil
 }
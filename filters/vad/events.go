@@ -0,0 +1,332 @@
+package vad
+
+import (
+	"io"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+)
+
+// EventType represents whether an event is the start or end of speech.
+type EventType int
+
+// Types of events.
+const (
+	SpeechStart EventType = iota
+	SpeechEnd
+)
+
+// Event represents the start or end of speech in a stream.
+type Event struct {
+	Type EventType
+
+	// Sample is the position of the event in samples since the start of
+	// the stream. For SpeechStart it is the first sample of speech, and for
+	// SpeechEnd the sample after the last frame the detector classified as
+	// speech, not counting its hangover. SpeechEnd is only known once the
+	// hangover runs out, so it is reported after the position it gives.
+	Sample int64
+
+	// Time is the position of the event as a duration since the start of
+	// the stream.
+	Time time.Duration
+}
+
+// tracker runs a Detector over arbitrarily sized writes of samples, and
+// turns its decisions into events.
+type tracker struct {
+	detector   *Detector
+	sampleRate int
+	pending    []int32
+	position   int64 // samples processed into frames
+	speaking   bool
+	speechEnd  int64 // the end of the last frame of speech, before the hangover
+}
+
+func newTracker(sampleRate int, mode Mode) *tracker {
+	return &tracker{
+		detector:   NewDetector(sampleRate, mode),
+		sampleRate: sampleRate,
+	}
+}
+
+// event returns an event of the given type at a position.
+func (t *tracker) event(eventType EventType, sample int64) Event {
+	return Event{
+		Type:   eventType,
+		Sample: sample,
+		Time:   time.Duration(sample * int64(time.Second) / int64(t.sampleRate)),
+	}
+}
+
+// write processes samples, calling frame after each complete frame with the
+// position of the end of the frame, and the event it caused if any.
+func (t *tracker) write(samples []int32, frame func(end int64, event *Event)) {
+	t.pending = append(t.pending, samples...)
+
+	frameSize := t.detector.FrameSize()
+	for len(t.pending) >= frameSize {
+		start := t.position
+		speech := t.detector.Process(t.pending[:frameSize])
+		t.pending = t.pending[frameSize:]
+		t.position += int64(frameSize)
+		if t.detector.speech {
+			t.speechEnd = t.position
+		}
+
+		var e *Event
+		if speech != t.speaking {
+			t.speaking = speech
+			event := t.event(SpeechEnd, t.speechEnd)
+			if speech {
+				event = t.event(SpeechStart, start)
+			}
+			e = &event
+		}
+
+		frame(t.position, e)
+	}
+}
+
+// end returns the event ending speech in progress at the end of the
+// stream, if any. If the stream ends during the hangover, speech ended
+// before it.
+func (t *tracker) end() *Event {
+	if !t.speaking {
+		return nil
+	}
+
+	t.speaking = false
+	end := t.position + int64(len(t.pending))
+	if !t.detector.speech {
+		end = t.speechEnd
+	}
+
+	e := t.event(SpeechEnd, end)
+	return &e
+}
+
+// EventFilter represents a filter which reports when speech starts and ends
+// in a stream, without changing its audio.
+type EventFilter struct {
+	mode    Mode
+	onEvent func(e Event)
+}
+
+// eventStream represents a stream filtered by an EventFilter.
+type eventStream struct {
+	stream  audio.Stream
+	tracker *tracker
+	onEvent func(e Event)
+	ended   bool
+	buf     []int32
+}
+
+// NewEventFilter returns a new filter which calls onEvent from Read when
+// speech starts and ends, as classified by a Detector with the given mode.
+// SpeechStart is reported once the frame it occurs in has been read, and
+// SpeechEnd once the detector's hangover runs out. Speech in progress when
+// the stream ends is ended at its last sample.
+func NewEventFilter(mode Mode, onEvent func(e Event)) audio.Filter {
+	return &EventFilter{mode: mode, onEvent: onEvent}
+}
+
+// Filter implements the Filter method for filters.
+func (f *EventFilter) Filter(stream audio.Stream) audio.Stream {
+	return &eventStream{
+		stream:  stream,
+		tracker: newTracker(stream.SampleRate(), f.mode),
+		onEvent: f.onEvent,
+	}
+}
+
+func (e *eventStream) SampleRate() int {
+	return e.stream.SampleRate()
+}
+
+func (e *eventStream) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+	if len(e.buf) < dstLen {
+		e.buf = make([]int32, dstLen)
+	}
+
+	n, err := e.stream.Read(e.buf[:dstLen])
+	e.tracker.write(e.buf[:n], func(end int64, event *Event) {
+		if event != nil {
+			e.onEvent(*event)
+		}
+	})
+
+	if err != nil && !e.ended {
+		e.ended = true
+		if event := e.tracker.end(); event != nil {
+			e.onEvent(*event)
+		}
+	}
+
+	if convErr := audio.ReadFromInt32(dst, e.buf, n); convErr != nil {
+		return 0, convErr
+	}
+
+	return n, err
+}
+
+// Segment represents an utterance cut from a stream, with its padding.
+type Segment struct {
+	// Start and End are the positions of the segment's first sample and the
+	// sample after its last, since the start of the stream.
+	Start int64
+	End   int64
+
+	Samples []int32
+}
+
+// Defaults for the padding of segments.
+const (
+	DefaultPreRoll  = 300 * time.Millisecond
+	DefaultPostRoll = 300 * time.Millisecond
+)
+
+// Segmenter represents a splitter of audio into utterances. Speech
+// separated by less than the post roll is kept in one segment.
+type Segmenter struct {
+	// PreRoll and PostRoll are the padding kept before the start and after
+	// the end of speech in each segment. They must be set before samples
+	// are written.
+	PreRoll  time.Duration
+	PostRoll time.Duration
+
+	tracker   *tracker
+	onSegment func(s Segment)
+
+	// recent holds the samples from bufferStart onwards, which are those of
+	// the segment in progress, or the pre roll otherwise.
+	recent      []int32
+	bufferStart int64
+
+	inSegment    bool
+	segmentStart int64
+	speechEnd    int64 // where speech ended, or -1 while it continues
+}
+
+// NewSegmenter returns a new segmenter for audio at the given sample rate,
+// which calls onSegment with each utterance, as classified by a Detector
+// with the given mode.
+func NewSegmenter(sampleRate int, mode Mode, onSegment func(s Segment)) *Segmenter {
+	return &Segmenter{
+		PreRoll:   DefaultPreRoll,
+		PostRoll:  DefaultPostRoll,
+		tracker:   newTracker(sampleRate, mode),
+		onSegment: onSegment,
+	}
+}
+
+func (s *Segmenter) samples(d time.Duration) int64 {
+	return int64(d) * int64(s.tracker.sampleRate) / int64(time.Second)
+}
+
+// Write writes samples to the segmenter, calling onSegment with any
+// utterances they complete.
+func (s *Segmenter) Write(samples []int32) {
+	s.recent = append(s.recent, samples...)
+
+	s.tracker.write(samples, func(end int64, event *Event) {
+		if event != nil {
+			s.handle(*event)
+		}
+
+		if s.inSegment && s.speechEnd >= 0 &&
+			end >= s.speechEnd+s.samples(s.PostRoll) {
+			s.emit(s.speechEnd + s.samples(s.PostRoll))
+		}
+
+		if !s.inSegment {
+			s.trim(end - s.samples(s.PreRoll))
+		}
+	})
+}
+
+// handle updates the segment in progress with an event.
+func (s *Segmenter) handle(e Event) {
+	if e.Type == SpeechEnd {
+		s.speechEnd = e.Sample
+		return
+	}
+
+	s.speechEnd = -1
+	if s.inSegment {
+		return
+	}
+
+	s.inSegment = true
+	s.segmentStart = e.Sample - s.samples(s.PreRoll)
+	if s.segmentStart < s.bufferStart {
+		s.segmentStart = s.bufferStart
+	}
+}
+
+// emit calls onSegment with the segment in progress, ending at end.
+func (s *Segmenter) emit(end int64) {
+	available := s.bufferStart + int64(len(s.recent))
+	if end > available {
+		end = available
+	}
+
+	segment := Segment{
+		Start:   s.segmentStart,
+		End:     end,
+		Samples: append([]int32(nil), s.recent[s.segmentStart-s.bufferStart:end-s.bufferStart]...),
+	}
+
+	s.inSegment = false
+	s.onSegment(segment)
+}
+
+// trim discards samples before a position.
+func (s *Segmenter) trim(position int64) {
+	if position <= s.bufferStart {
+		return
+	}
+
+	drop := position - s.bufferStart
+	if drop > int64(len(s.recent)) {
+		drop = int64(len(s.recent))
+	}
+
+	s.recent = append(s.recent[:0], s.recent[drop:]...)
+	s.bufferStart += drop
+}
+
+// Flush ends the segment in progress, if any, at the last sample written,
+// and calls onSegment with it.
+func (s *Segmenter) Flush() {
+	if event := s.tracker.end(); event != nil {
+		s.handle(*event)
+	}
+
+	if s.inSegment {
+		s.emit(s.bufferStart + int64(len(s.recent)))
+	}
+}
+
+// ReadFrom writes the audio of a stream to the segmenter until the stream
+// ends, then flushes it. It returns nil if the stream ended with io.EOF.
+func (s *Segmenter) ReadFrom(stream audio.Stream) error {
+	if stream.SampleRate() != s.tracker.sampleRate {
+		panic("vad: stream sample rate must match segmenter")
+	}
+
+	buf := make([]int32, s.tracker.detector.FrameSize()*10)
+	for {
+		n, err := stream.Read(buf)
+		s.Write(buf[:n])
+
+		if err != nil {
+			s.Flush()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
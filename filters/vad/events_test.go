@@ -0,0 +1,55 @@
+package vad
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/1lann/dissonance/filters/vad/vadtest"
+)
+
+func TestSpeechEndBeforeHangover(t *testing.T) {
+	const sampleRate = 16000
+
+	r := rand.New(rand.NewSource(1))
+	n := 10 * sampleRate
+	s, active := vadtest.Speech(r, n, sampleRate)
+	frameSize := NewDetector(sampleRate, Quality).FrameSize()
+	sig := vadtest.Mix(s, active, vadtest.Noises[1].Generate(r, n, sampleRate), 20, frameSize)
+
+	var ends []int64
+	for i := 1; i < n; i++ {
+		if active[i-1] && !active[i] {
+			ends = append(ends, int64(i))
+		}
+	}
+
+	hangover := int64(modeSettings[Quality].hangover * frameSize)
+	var reported int
+	tr := newTracker(sampleRate, Quality)
+	tr.write(sig.Samples, func(end int64, event *Event) {
+		if event == nil || event.Type != SpeechEnd {
+			return
+		}
+
+		if reported >= len(ends) {
+			t.Fatalf("got an unexpected end of speech at %d", event.Sample)
+		}
+
+		// The end of speech is reported after the hangover, but is placed
+		// within a frame of where speech actually ended.
+		expected := ends[reported]
+		if event.Sample < expected-int64(frameSize) || event.Sample > expected+int64(frameSize) {
+			t.Errorf("got end of speech at %d, expected within a frame of %d",
+				event.Sample, expected)
+		}
+		if end-event.Sample < hangover {
+			t.Errorf("end of speech at %d reported at %d, before the hangover ran out",
+				event.Sample, end)
+		}
+		reported++
+	})
+
+	if reported != len(ends) {
+		t.Errorf("got %d ends of speech, expected %d", reported, len(ends))
+	}
+}
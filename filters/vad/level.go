@@ -0,0 +1,60 @@
+package vad
+
+import "math"
+
+// fullScale is the level of a full scale signal, or 0 dBov.
+const fullScale = 2147483647
+
+// silentLevel is the level of silence in -dBov, as used by RFC 6464.
+const silentLevel = 127
+
+// Energy returns the energy of samples that the VAD filter compares against
+// its threshold. It estimates the RMS of the samples from the peaks of their
+// waveform.
+func Energy(samples []int32) float64 {
+	var numPeaks float64
+	var sum float64
+
+	for i := 2; i < len(samples); i++ {
+		if samples[i]-samples[i-1] < 0 && samples[i-1]-samples[i-2] >= 0 ||
+			samples[i]-samples[i-1] >= 0 && samples[i-1]-samples[i-2] < 0 {
+			sum += math.Abs(float64(samples[i-1])) / math.Sqrt2
+			numPeaks++
+		}
+	}
+
+	if numPeaks == 0 {
+		return 0
+	}
+
+	return sum / numPeaks
+}
+
+// Level returns the level of samples in -dBov from their Energy, between 0
+// for a full scale signal and 127 for silence, for use in the audio level
+// header extension.
+func Level(samples []int32) uint8 {
+	energy := Energy(samples)
+	if energy <= 0 {
+		return silentLevel
+	}
+
+	level := math.Round(-20 * math.Log10(energy/fullScale))
+	if level < 0 {
+		return 0
+	} else if level > silentLevel {
+		return silentLevel
+	}
+
+	return uint8(level)
+}
+
+// Active returns whether samples contain voice activity, using the same
+// threshold between 0 and 1 as NewFilter.
+func Active(samples []int32, threshold float64) bool {
+	if threshold < 0 || threshold > 1 {
+		panic("vad: threshold must be between 0 and 1")
+	}
+
+	return Energy(samples) > energyThreshold(threshold)
+}
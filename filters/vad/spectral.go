@@ -0,0 +1,278 @@
+package vad
+
+import (
+	"math"
+	"time"
+
+	"github.com/1lann/dissonance/dsp"
+)
+
+// Mode represents how aggressively a Detector rejects non-speech, in the
+// same spirit as the modes of WebRTC's VAD. More aggressive modes miss more
+// soft speech, but let through less noise.
+type Mode int
+
+// Modes of a Detector, from least to most aggressive.
+const (
+	Quality Mode = iota
+	LowBitrate
+	Aggressive
+	VeryAggressive
+)
+
+// modeSettings holds, for each mode, the mean log likelihood ratio across
+// bands above which a frame is speech, the log likelihood ratio above which
+// a single band alone makes a frame speech, and the number of frames speech
+// is held for after it ends.
+var modeSettings = [...]struct {
+	threshold     float64
+	bandThreshold float64
+	hangover      int
+}{
+	Quality:        {0.5, 6, 10},
+	LowBitrate:     {1, 8, 8},
+	Aggressive:     {1.5, 10, 6},
+	VeryAggressive: {2.5, 12, 4},
+}
+
+// bandEdges are the edges of the sub-bands in Hz, and bandWeights how much
+// each band counts towards the decision, favouring those where the energy
+// of voiced speech is concentrated.
+var (
+	bandEdges   = []float64{80, 250, 500, 1000, 2000, 3000, 4000}
+	bandWeights = []float64{6, 8, 10, 12, 14, 16}
+)
+
+// Parameters of the Gaussian models of the log energy of each band.
+const (
+	// initialNoiseStd and initialSpeechStd are the standard deviations of
+	// the models before they adapt, in dB.
+	initialNoiseStd  = 5
+	initialSpeechStd = 12
+
+	// minSeparation is the smallest distance in dB kept between the means
+	// of the speech and noise models.
+	minSeparation = 6
+
+	// minStd is the smallest standard deviation of a model, in dB.
+	minStd = 2
+
+	// noiseAdaptation and speechAdaptation are the rates the models adapt
+	// at, weighted by the probability a frame belongs to them.
+	noiseAdaptation  = 0.02
+	speechAdaptation = 0.01
+
+	// noiseFall is the rate the noise mean falls at to bands quieter than
+	// it, so that the noise model tracks the noise floor.
+	noiseFall = 0.1
+
+	// minSpeechRun is the number of consecutive speech frames needed before
+	// speech is held for the hangover.
+	minSpeechRun = 3
+
+	// learningFrames is the number of frames at the start which are assumed
+	// to be noise.
+	learningFrames = 10
+)
+
+// DetectorFrameDuration is the duration of the frames processed by a
+// Detector.
+const DetectorFrameDuration = 10 * time.Millisecond
+
+// Detector represents a voice activity detector which classifies frames
+// from their energy in sub-bands of the speech spectrum. Each band's log
+// energy is modelled by a Gaussian for noise and another for speech, which
+// adapt to the signal, so the detector follows the noise floor rather than
+// using a fixed threshold.
+type Detector struct {
+	mode      Mode
+	frameSize int
+	fft       *dsp.FFT
+	window    []float64
+	spectrum  []complex128
+	samples   []float64
+
+	bands       [][2]int // ranges of FFT bins of each band
+	noiseMean   []float64
+	noiseStd    []float64
+	speechMean  []float64
+	speechStd   []float64
+	frames      int
+	run         int // consecutive frames classified as speech
+	hangover    int
+	speech      bool // whether the last frame was speech, before the hangover
+	probability float64
+}
+
+// NewDetector returns a new detector for audio at the given sample rate,
+// which must be at least 8000 Hz.
+func NewDetector(sampleRate int, mode Mode) *Detector {
+	if sampleRate < 8000 {
+		panic("vad: sample rate must be at least 8000 Hz")
+	}
+	if mode < Quality || mode > VeryAggressive {
+		panic("vad: invalid mode")
+	}
+
+	frameSize := int(int64(DetectorFrameDuration) * int64(sampleRate) / int64(time.Second))
+	fftSize := dsp.NextPowerOfTwo(frameSize)
+
+	d := &Detector{
+		mode:      mode,
+		frameSize: frameSize,
+		fft:       dsp.NewFFT(fftSize),
+		window:    dsp.HannWindow(frameSize),
+		spectrum:  make([]complex128, fftSize),
+		samples:   make([]float64, frameSize),
+	}
+
+	binWidth := float64(sampleRate) / float64(fftSize)
+	for i := 0; i < len(bandWeights); i++ {
+		low := int(math.Ceil(bandEdges[i] / binWidth))
+		high := int(math.Ceil(bandEdges[i+1] / binWidth))
+		if high > fftSize/2 {
+			high = fftSize / 2
+		}
+		if high <= low {
+			high = low + 1
+		}
+		d.bands = append(d.bands, [2]int{low, high})
+	}
+
+	d.noiseMean = make([]float64, len(d.bands))
+	d.noiseStd = make([]float64, len(d.bands))
+	d.speechMean = make([]float64, len(d.bands))
+	d.speechStd = make([]float64, len(d.bands))
+
+	return d
+}
+
+// FrameSize returns the number of samples in each frame passed to Process.
+func (d *Detector) FrameSize() int {
+	return d.frameSize
+}
+
+// Probability returns the probability the last frame processed contains
+// speech, before the mode's threshold and hangover are applied.
+func (d *Detector) Probability() float64 {
+	return d.probability
+}
+
+// Process classifies a frame of FrameSize samples, and returns whether it
+// contains speech.
+func (d *Detector) Process(frame []int32) bool {
+	if len(frame) != d.frameSize {
+		panic("vad: frame must be FrameSize samples long")
+	}
+
+	energies := d.bandEnergies(frame)
+
+	d.frames++
+	if d.frames <= learningFrames {
+		for i, e := range energies {
+			d.noiseMean[i] += (e - d.noiseMean[i]) / float64(d.frames)
+			d.noiseStd[i] = initialNoiseStd
+			d.speechMean[i] = d.noiseMean[i] + 2*minSeparation
+			d.speechStd[i] = initialSpeechStd
+		}
+		d.probability = 0
+		d.speech = false
+		return false
+	}
+
+	settings := modeSettings[d.mode]
+
+	var weighted, totalWeight float64
+	bandSpeech := false
+	ratios := make([]float64, len(energies))
+	for i, e := range energies {
+		ratios[i] = logGaussian(e, d.speechMean[i], d.speechStd[i]) -
+			logGaussian(e, d.noiseMean[i], d.noiseStd[i])
+		weighted += bandWeights[i] * ratios[i]
+		totalWeight += bandWeights[i]
+		if ratios[i] > settings.bandThreshold {
+			bandSpeech = true
+		}
+	}
+	meanRatio := weighted / totalWeight
+
+	d.probability = 1 / (1 + math.Exp(-meanRatio))
+	d.adapt(energies)
+
+	d.speech = meanRatio > settings.threshold || bandSpeech
+	if d.speech {
+		// Short bursts, such as clicks, aren't held.
+		d.run++
+		if d.run >= minSpeechRun {
+			d.hangover = settings.hangover
+		}
+		return true
+	}
+
+	d.run = 0
+
+	if d.hangover > 0 {
+		d.hangover--
+		return true
+	}
+
+	return false
+}
+
+// bandEnergies returns the log energy of each band of a frame, in dB.
+func (d *Detector) bandEnergies(frame []int32) []float64 {
+	dsp.FromInt32(d.samples, frame)
+	for i := range d.spectrum {
+		d.spectrum[i] = 0
+	}
+	for i, x := range d.samples {
+		d.spectrum[i] = complex(x*d.window[i], 0)
+	}
+	d.fft.Forward(d.spectrum)
+
+	energies := make([]float64, len(d.bands))
+	for i, band := range d.bands {
+		var sum float64
+		for k := band[0]; k < band[1]; k++ {
+			x := d.spectrum[k]
+			sum += real(x)*real(x) + imag(x)*imag(x)
+		}
+		energies[i] = 10 * math.Log10(sum/float64(band[1]-band[0])+1e-12)
+	}
+
+	return energies
+}
+
+// adapt updates the models of each band, weighting each by the probability
+// the frame belongs to it.
+func (d *Detector) adapt(energies []float64) {
+	p := d.probability
+	for i, e := range energies {
+		if e < d.noiseMean[i] {
+			d.noiseMean[i] += noiseFall * (e - d.noiseMean[i])
+		} else {
+			d.noiseMean[i] += noiseAdaptation * (1 - p) * (e - d.noiseMean[i])
+		}
+		d.noiseStd[i] = adaptStd(d.noiseStd[i], e-d.noiseMean[i], noiseAdaptation*(1-p))
+
+		d.speechMean[i] += speechAdaptation * p * (e - d.speechMean[i])
+		d.speechStd[i] = adaptStd(d.speechStd[i], e-d.speechMean[i], speechAdaptation*p)
+
+		if d.speechMean[i] < d.noiseMean[i]+minSeparation {
+			d.speechMean[i] = d.noiseMean[i] + minSeparation
+		}
+	}
+}
+
+// adaptStd moves a standard deviation towards a deviation at a rate.
+func adaptStd(std, deviation, rate float64) float64 {
+	variance := std * std
+	variance += rate * (deviation*deviation - variance)
+	return math.Max(math.Sqrt(variance), minStd)
+}
+
+// logGaussian returns the log density of a Gaussian at x.
+func logGaussian(x, mean, std float64) float64 {
+	z := (x - mean) / std
+	return -0.5*z*z - math.Log(std) - 0.5*math.Log(2*math.Pi)
+}
@@ -0,0 +1,66 @@
+package vad
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/1lann/dissonance/filters/vad/vadtest"
+)
+
+func TestDetectorModes(t *testing.T) {
+	const (
+		sampleRate = 16000
+		duration   = 20
+	)
+
+	// Minimum hit rates and maximum false alarm rates of each mode, from
+	// least to most aggressive. White noise masks every band, so it's
+	// tested at a higher SNR.
+	tests := []struct {
+		noise       string
+		snr         float64
+		hits        [4]float64
+		falseAlarms [4]float64
+	}{
+		{"white", 20, [4]float64{0.95, 0.95, 0.9, 0.8}, [4]float64{0.12, 0.1, 0.08, 0.06}},
+		{"brown", 10, [4]float64{0.95, 0.93, 0.85, 0.75}, [4]float64{0.12, 0.1, 0.07, 0.05}},
+		{"hum", 10, [4]float64{0.95, 0.95, 0.95, 0.9}, [4]float64{0.12, 0.1, 0.08, 0.06}},
+		{"keyboard", 10, [4]float64{0.95, 0.95, 0.9, 0.83}, [4]float64{0.18, 0.16, 0.13, 0.11}},
+	}
+
+	frameSize := NewDetector(sampleRate, Quality).FrameSize()
+	for _, test := range tests {
+		var generate func(r *rand.Rand, n, sampleRate int) []float64
+		for _, noise := range vadtest.Noises {
+			if noise.Name == test.noise {
+				generate = noise.Generate
+			}
+		}
+
+		r := rand.New(rand.NewSource(1))
+		n := duration * sampleRate
+		s, active := vadtest.Speech(r, n, sampleRate)
+		sig := vadtest.Mix(s, active, generate(r, n, sampleRate), test.snr, frameSize)
+
+		lastFalseAlarms := math.Inf(1)
+		for mode := Quality; mode <= VeryAggressive; mode++ {
+			d := NewDetector(sampleRate, mode)
+			hits, falseAlarms := vadtest.Score(sig, frameSize, d.Process)
+
+			if hits < test.hits[mode] {
+				t.Errorf("%s at %.0f dB, mode %d: got hit rate %.3f, expected at least %.2f",
+					test.noise, test.snr, mode, hits, test.hits[mode])
+			}
+			if falseAlarms > test.falseAlarms[mode] {
+				t.Errorf("%s at %.0f dB, mode %d: got false alarm rate %.3f, expected at most %.2f",
+					test.noise, test.snr, mode, falseAlarms, test.falseAlarms[mode])
+			}
+			if falseAlarms >= lastFalseAlarms {
+				t.Errorf("%s at %.0f dB, mode %d: got false alarm rate %.3f, expected below %.3f",
+					test.noise, test.snr, mode, falseAlarms, lastFalseAlarms)
+			}
+			lastFalseAlarms = falseAlarms
+		}
+	}
+}
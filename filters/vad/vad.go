@@ -2,7 +2,15 @@
 // through reading the data with a buffer of 0.2 seconds. That means
 // you cannot Read greater than 0.2 seconds worth of samples. If
 // you need to be able to read more, then use a RealtimeStream on top of the
-// VAD stream.
+// VAD stream. The filter switches between passing audio through and
+// silence without any attack or release ramp, which can be heard as a
+// click. The gate package fades in and out instead.
+//
+// The package also has a Detector, which classifies 10ms frames from their
+// energy in sub-bands against adaptive models of speech and noise. It copes
+// with louder background noise and softer speech than the filter's energy
+// threshold, and examples/vad_eval compares the two on labelled synthetic
+// signals.
 package vad
 
 import (
@@ -25,13 +33,19 @@ type streamFilter struct {
 	taperOff      int
 }
 
-// NewFilter creates a new VAD filter with a threshold between 0 and 1.
+// NewFilter creates a new VAD filter with a threshold between 0 and 1. It
+// cuts straight to silence, so for smooth transitions use the gate package.
 func NewFilter(threshold float64) audio.Filter {
 	if threshold < 0 || threshold > 1 {
 		panic("vad: threshold must be between 0 and 1")
 	}
 
-	return &Filter{math.Pow(threshold, 10) * 2147483646}
+	return &Filter{energyThreshold(threshold)}
+}
+
+// energyThreshold maps a threshold between 0 and 1 to an energy.
+func energyThreshold(threshold float64) float64 {
+	return math.Pow(threshold, 10) * 2147483646
 }
 
 // Filter implements the Filter method for filters.
@@ -43,6 +57,8 @@ func (f *Filter) Filter(stream audio.Stream) audio.Stream {
 	}
 }
 
+// Read reads the buffered audio if voice is detected in it, or silence
+// otherwise. Audio is cut straight to silence, with no ramp.
 func (f *streamFilter) Read(dst interface{}) (int, error) {
 	dstLen := audio.SliceLength(dst)
 	if dstLen > len(f.buffer) {
@@ -90,18 +106,7 @@ func (f *streamFilter) SampleRate() int {
 }
 
 func (f *streamFilter) getRMS() float64 {
-	var numPeaks float64
-	var sum float64
-
-	for i := 2; i < len(f.buffer); i++ {
-		if f.buffer[i]-f.buffer[i-1] < 0 && f.buffer[i-1]-f.buffer[i-2] >= 0 ||
-			f.buffer[i]-f.buffer[i-1] >= 0 && f.buffer[i-1]-f.buffer[i-2] < 0 {
-			sum += math.Abs(float64(f.buffer[i-1])) / math.Sqrt2
-			numPeaks++
-		}
-	}
-
-	return sum / numPeaks
+	return Energy(f.buffer)
 }
 
 func (f *streamFilter) readToBuffer(num int) error {
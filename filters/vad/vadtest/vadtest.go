@@ -0,0 +1,171 @@
+// Package vadtest generates labelled synthetic speech in noise, for
+// evaluating voice activity detectors.
+package vadtest
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Signal represents a labelled synthetic signal, with whether each frame
+// contains speech.
+type Signal struct {
+	Samples []int32
+	Labels  []bool
+}
+
+// Noise represents a kind of background noise.
+type Noise struct {
+	Name     string
+	Generate func(r *rand.Rand, n, sampleRate int) []float64
+}
+
+// Noises are the kinds of noise signals are evaluated in.
+var Noises = []Noise{
+	{"white", func(r *rand.Rand, n, sampleRate int) []float64 {
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = r.NormFloat64()
+		}
+		return out
+	}},
+	{"brown", func(r *rand.Rand, n, sampleRate int) []float64 {
+		out := make([]float64, n)
+		var y float64
+		for i := range out {
+			y = 0.98*y + 0.2*r.NormFloat64()
+			out[i] = y
+		}
+		return out
+	}},
+	{"hum", func(r *rand.Rand, n, sampleRate int) []float64 {
+		out := make([]float64, n)
+		for i := range out {
+			t := float64(i) / float64(sampleRate)
+			for k := 1; k <= 5; k++ {
+				out[i] += math.Sin(2*math.Pi*50*float64(k)*t) / float64(k)
+			}
+			out[i] += 0.1 * r.NormFloat64()
+		}
+		return out
+	}},
+	{"keyboard", func(r *rand.Rand, n, sampleRate int) []float64 {
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = 0.05 * r.NormFloat64()
+			if r.Intn(sampleRate/8) == 0 {
+				for j := 0; j < sampleRate/200 && i+j < n; j++ {
+					out[i+j] += 8 * r.NormFloat64() * math.Exp(-float64(j)/20)
+				}
+			}
+		}
+		return out
+	}},
+}
+
+// Speech generates synthetic voiced speech: a harmonic source with a
+// gliding pitch, shaped by formants that change each syllable, in segments
+// of a few syllables separated by pauses. It returns the signal and which
+// samples are within speech segments.
+func Speech(r *rand.Rand, n, sampleRate int) ([]float64, []bool) {
+	out := make([]float64, n)
+	active := make([]bool, n)
+
+	i := sampleRate / 2
+	for i < n {
+		length := sampleRate/2 + r.Intn(3*sampleRate/2)
+		level := math.Pow(10, -r.Float64()*12/20)
+		pitch := 100 + 150*r.Float64()
+		syllable := sampleRate / 4
+		var formants [3]float64
+		var phase float64
+
+		for j := 0; j < length && i+j < n; j++ {
+			if j%syllable == 0 {
+				formants = [3]float64{300 + 600*r.Float64(),
+					900 + 1400*r.Float64(), 2300 + 800*r.Float64()}
+			}
+
+			t := float64(j) / float64(sampleRate)
+			f0 := pitch * (1 + 0.1*math.Sin(2*math.Pi*1.5*t))
+			phase += 2 * math.Pi * f0 / float64(sampleRate)
+			envelope := math.Pow(math.Sin(math.Pi*float64(j%syllable)/float64(syllable)), 2)
+
+			var y float64
+			for k := 1; float64(k)*f0 < 4000; k++ {
+				freq := float64(k) * f0
+				var gain float64
+				for _, formant := range formants {
+					gain += 1 / (1 + math.Pow((freq-formant)/100, 2))
+				}
+				y += gain * math.Sin(float64(k)*phase) / float64(k)
+			}
+
+			out[i+j] = level * envelope * y
+			active[i+j] = true
+		}
+
+		i += length + sampleRate/2 + r.Intn(sampleRate)
+	}
+
+	return out, active
+}
+
+// Mix mixes speech and noise at an SNR in dB, with speech peaking at a
+// moderate level.
+func Mix(s []float64, active []bool, noise []float64, snr float64, frameSize int) Signal {
+	var speechPower, noisePower float64
+	var count int
+	for i := range s {
+		if active[i] {
+			speechPower += s[i] * s[i]
+			count++
+		}
+		noisePower += noise[i] * noise[i]
+	}
+	speechPower /= float64(count)
+	noisePower /= float64(len(noise))
+
+	speechGain := 0.05 / math.Sqrt(speechPower)
+	noiseGain := speechGain * math.Sqrt(speechPower/noisePower) * math.Pow(10, -snr/20)
+
+	sig := Signal{Samples: make([]int32, len(s))}
+	for i := range s {
+		v := math.Max(-1, math.Min(1, s[i]*speechGain+noise[i]*noiseGain))
+		sig.Samples[i] = int32(v * math.MaxInt32)
+	}
+
+	for start := 0; start+frameSize <= len(s); start += frameSize {
+		speech := 0
+		for _, a := range active[start : start+frameSize] {
+			if a {
+				speech++
+			}
+		}
+		sig.Labels = append(sig.Labels, speech > frameSize/2)
+	}
+
+	return sig
+}
+
+// Score returns the fraction of speech frames detected and of non-speech
+// frames falsely detected.
+func Score(sig Signal, frameSize int, detect func(frame []int32) bool) (float64, float64) {
+	var hits, speech, falseAlarms, silence int
+	for i, label := range sig.Labels {
+		detected := detect(sig.Samples[i*frameSize : (i+1)*frameSize])
+		if label {
+			speech++
+			if detected {
+				hits++
+			}
+		} else {
+			silence++
+			if detected {
+				falseAlarms++
+			}
+		}
+	}
+
+	return float64(hits) / float64(speech), float64(falseAlarms) / float64(silence)
+}
@@ -3,3 +3,10 @@ module github.com/1lann/dissonance
 go 1.18
 
 require github.com/gordonklaus/portaudio v0.0.0-20221027163845-7c3b689db3cc
+
+require github.com/gorilla/websocket v1.5.3
+
+require (
+	golang.org/x/crypto v0.17.0
+	golang.org/x/sys v0.15.0 // indirect
+)
@@ -0,0 +1,637 @@
+// Package ice implements Interactive Connectivity Establishment (RFC 8445)
+// for finding a working path between two dissonance peers behind NATs. An
+// agent gathers host, server-reflexive and relayed candidates, runs
+// connectivity checks against the remote peer's candidates, nominates a
+// pair and then yields a net.PacketConn for carrying audio, which is kept
+// alive with consent freshness checks (RFC 7675).
+//
+// Candidates and credentials are exchanged out of band, such as with the
+// signaling package.
+package ice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/stun"
+)
+
+// Default timing parameters.
+const (
+	DefaultCheckInterval    = 20 * time.Millisecond
+	DefaultCheckRTO         = 200 * time.Millisecond
+	DefaultCheckRetransmits = 5
+	DefaultNominationDelay  = 200 * time.Millisecond
+	DefaultConsentInterval  = 5 * time.Second
+	DefaultConsentTimeout   = 30 * time.Second
+)
+
+// Errors returned by the agent.
+var (
+	ErrClosed        = errors.New("ice: agent closed")
+	ErrNoCredentials = errors.New("ice: remote credentials not set")
+	ErrConsentLost   = errors.New("ice: consent to send expired")
+)
+
+// Config represents the configuration of an agent.
+type Config struct {
+	// Controlling is whether the agent starts in the controlling role,
+	// which nominates the pair to use. Usually the caller is controlling.
+	Controlling bool
+
+	// HostAddrs are the local IP addresses to gather host candidates on. If
+	// empty, all unicast addresses of the machine's interfaces are used.
+	HostAddrs []net.IP
+
+	// STUNServers are used to gather server-reflexive candidates.
+	STUNServers []*net.UDPAddr
+
+	// Relays are connections to relays, such as TURN allocations, to use as
+	// relayed candidates. Their LocalAddr must be the relayed address.
+	Relays []net.PacketConn
+
+	// Listen, if set, is used to open the socket for a host candidate on
+	// the given IP address instead of net.ListenUDP, such as to wrap it with
+	// a simulated NAT or network impairments.
+	Listen func(ip net.IP) (net.PacketConn, error)
+
+	// Timing parameters, which default to the Default constants.
+	CheckInterval    time.Duration
+	CheckRTO         time.Duration
+	CheckRetransmits int
+	NominationDelay  time.Duration
+	ConsentInterval  time.Duration
+	ConsentTimeout   time.Duration
+}
+
+// base represents a socket that candidates send from.
+type base struct {
+	conn  net.PacketConn
+	addr  *net.UDPAddr
+	relay bool
+}
+
+// localCandidate represents a local candidate and the socket it sends from.
+type localCandidate struct {
+	Candidate
+	base *base
+}
+
+type pairState int
+
+const (
+	pairWaiting pairState = iota
+	pairInProgress
+	pairSucceeded
+	pairFailed
+)
+
+// pair represents a candidate pair.
+type pair struct {
+	local  *localCandidate
+	remote Candidate
+	state  pairState
+
+	// nominateRequested is set on the controlled agent when the
+	// controlling agent has sent USE-CANDIDATE for the pair.
+	nominateRequested bool
+	lastConsent       time.Time
+}
+
+type response struct {
+	msg  *stun.Message
+	from *net.UDPAddr
+}
+
+// Agent represents an ICE agent for one media stream.
+type Agent struct {
+	config      Config
+	controlling bool
+	tieBreaker  uint64
+
+	localUfrag  string
+	localPwd    string
+	remoteUfrag string
+	remotePwd   string
+
+	bases     []*base
+	local     []*localCandidate
+	remote    []Candidate
+	pairs     []*pair
+	triggered []*pair
+
+	firstSuccess time.Time
+	nominating   bool
+	selected     *pair
+	selectedCh   chan struct{}
+	conn         *Conn
+
+	transactions map[stun.TransactionID]chan response
+	running      bool
+	closed       bool
+	done         chan struct{}
+	usageLock    *sync.Mutex
+}
+
+const credentialChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(credentialChars)))
+	for i := range b {
+		v, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic("ice: failed to generate credentials: " + err.Error())
+		}
+		b[i] = credentialChars[v.Int64()]
+	}
+
+	return string(b)
+}
+
+// NewAgent returns a new agent with the given configuration and random local
+// credentials.
+func NewAgent(config Config) *Agent {
+	if config.CheckInterval == 0 {
+		config.CheckInterval = DefaultCheckInterval
+	}
+	if config.CheckRTO == 0 {
+		config.CheckRTO = DefaultCheckRTO
+	}
+	if config.CheckRetransmits == 0 {
+		config.CheckRetransmits = DefaultCheckRetransmits
+	}
+	if config.NominationDelay == 0 {
+		config.NominationDelay = DefaultNominationDelay
+	}
+	if config.ConsentInterval == 0 {
+		config.ConsentInterval = DefaultConsentInterval
+	}
+	if config.ConsentTimeout == 0 {
+		config.ConsentTimeout = DefaultConsentTimeout
+	}
+
+	var tieBreaker [8]byte
+	if _, err := rand.Read(tieBreaker[:]); err != nil {
+		panic("ice: failed to generate tie breaker: " + err.Error())
+	}
+
+	return &Agent{
+		config:       config,
+		controlling:  config.Controlling,
+		tieBreaker:   binary.BigEndian.Uint64(tieBreaker[:]),
+		localUfrag:   randomString(8),
+		localPwd:     randomString(24),
+		selectedCh:   make(chan struct{}),
+		transactions: make(map[stun.TransactionID]chan response),
+		done:         make(chan struct{}),
+		usageLock:    new(sync.Mutex),
+	}
+}
+
+// LocalCredentials returns the username fragment and password the remote
+// agent must use.
+func (a *Agent) LocalCredentials() (ufrag, pwd string) {
+	return a.localUfrag, a.localPwd
+}
+
+// SetRemoteCredentials sets the username fragment and password of the
+// remote agent.
+func (a *Agent) SetRemoteCredentials(ufrag, pwd string) {
+	a.usageLock.Lock()
+	defer a.usageLock.Unlock()
+
+	a.remoteUfrag = ufrag
+	a.remotePwd = pwd
+}
+
+func hostAddrs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips, loopback []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() || ipNet.IP.IsMulticast() {
+			continue
+		}
+
+		if ipNet.IP.IsLoopback() {
+			loopback = append(loopback, ipNet.IP)
+		} else {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+
+	if len(ips) == 0 {
+		return loopback, nil
+	}
+
+	return ips, nil
+}
+
+// GatherCandidates gathers the local candidates, which should then be sent
+// to the remote agent.
+func (a *Agent) GatherCandidates() ([]Candidate, error) {
+	ips := a.config.HostAddrs
+	if len(ips) == 0 {
+		var err error
+		ips, err = hostAddrs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listen := a.config.Listen
+	if listen == nil {
+		listen = func(ip net.IP) (net.PacketConn, error) {
+			return net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+		}
+	}
+
+	var hosts []*localCandidate
+	for i, ip := range ips {
+		conn, err := listen(ip)
+		if err != nil {
+			continue
+		}
+
+		addr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			conn.Close()
+			continue
+		}
+
+		b := &base{conn: conn, addr: addr}
+		hosts = append(hosts, &localCandidate{
+			Candidate: Candidate{
+				Type:       Host,
+				Foundation: foundation(Host, ip, ""),
+				Priority:   priority(Host, uint32(65535-i)),
+				Addr:       b.addr,
+			},
+			base: b,
+		})
+	}
+
+	if len(hosts) == 0 && len(a.config.Relays) == 0 {
+		return nil, errors.New("ice: no usable host addresses")
+	}
+
+	var relays []*localCandidate
+	for i, conn := range a.config.Relays {
+		addr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		b := &base{conn: conn, addr: addr, relay: true}
+		relays = append(relays, &localCandidate{
+			Candidate: Candidate{
+				Type:       Relay,
+				Foundation: foundation(Relay, addr.IP, ""),
+				Priority:   priority(Relay, uint32(65535-i)),
+				Addr:       addr,
+			},
+			base: b,
+		})
+	}
+
+	a.usageLock.Lock()
+	if a.closed {
+		a.usageLock.Unlock()
+		return nil, ErrClosed
+	}
+
+	for _, c := range append(hosts, relays...) {
+		a.bases = append(a.bases, c.base)
+		a.local = append(a.local, c)
+		go a.readLoop(c.base)
+	}
+	a.usageLock.Unlock()
+
+	reflexive := a.gatherReflexive(hosts)
+
+	a.usageLock.Lock()
+	defer a.usageLock.Unlock()
+
+	a.local = append(a.local, reflexive...)
+	a.formPairs()
+
+	candidates := make([]Candidate, len(a.local))
+	for i, c := range a.local {
+		candidates[i] = c.Candidate
+	}
+
+	return candidates, nil
+}
+
+// gatherReflexive sends binding requests to the STUN servers from each host
+// candidate and returns the distinct server-reflexive candidates found.
+func (a *Agent) gatherReflexive(hosts []*localCandidate) []*localCandidate {
+	var wg sync.WaitGroup
+	resultsLock := new(sync.Mutex)
+	var results []*localCandidate
+
+	for i, host := range hosts {
+		for _, server := range a.config.STUNServers {
+			if !sameFamily(host.Addr, server) {
+				continue
+			}
+
+			wg.Add(1)
+			go func(i int, host *localCandidate, server *net.UDPAddr) {
+				defer wg.Done()
+
+				req := stun.NewMessage(stun.MethodBinding, stun.ClassRequest)
+				req.AddFingerprint()
+
+				resp, err := a.transact(host.base, server, req)
+				if err != nil || resp.msg.Class != stun.ClassSuccessResponse {
+					return
+				}
+
+				addr, err := resp.msg.XORAddress(stun.AttrXORMappedAddress)
+				if err != nil || sameAddr(addr, host.Addr) {
+					return
+				}
+
+				resultsLock.Lock()
+				defer resultsLock.Unlock()
+
+				for _, c := range results {
+					if sameAddr(c.Addr, addr) {
+						return
+					}
+				}
+
+				results = append(results, &localCandidate{
+					Candidate: Candidate{
+						Type: ServerReflexive,
+						Foundation: foundation(ServerReflexive, host.Addr.IP,
+							server.String()),
+						Priority:    priority(ServerReflexive, uint32(65535-i)),
+						Addr:        addr,
+						RelatedAddr: host.Addr,
+					},
+					base: host.base,
+				})
+			}(i, host, server)
+		}
+	}
+
+	wg.Wait()
+	return results
+}
+
+// AddRemoteCandidate adds a candidate received from the remote agent.
+func (a *Agent) AddRemoteCandidate(c Candidate) {
+	a.usageLock.Lock()
+	defer a.usageLock.Unlock()
+
+	for _, existing := range a.remote {
+		if sameAddr(existing.Addr, c.Addr) {
+			return
+		}
+	}
+
+	a.remote = append(a.remote, c)
+	a.formPairs()
+}
+
+// pairPriority computes the priority of a pair as described in RFC 8445
+// section 6.1.2.3.
+func (a *Agent) pairPriority(p *pair) uint64 {
+	g, d := uint64(p.local.Priority), uint64(p.remote.Priority)
+	if !a.controlling {
+		g, d = d, g
+	}
+
+	min, max := g, d
+	if min > max {
+		min, max = max, min
+	}
+
+	prio := min<<32 | max<<1
+	if g > d {
+		prio |= 1
+	}
+
+	return prio
+}
+
+// formPairs pairs every local candidate with every remote candidate that
+// has not been paired yet. Server-reflexive candidates are not paired, as
+// checks are sent from their base which is already paired as a host
+// candidate. The usageLock must be held.
+func (a *Agent) formPairs() {
+	for _, local := range a.local {
+		if local.Type == ServerReflexive || local.Type == PeerReflexive {
+			continue
+		}
+
+		for _, remote := range a.remote {
+			if !sameFamily(local.Addr, remote.Addr) {
+				continue
+			}
+
+			if a.findPair(local.base, remote.Addr) != nil {
+				continue
+			}
+
+			a.pairs = append(a.pairs, &pair{local: local, remote: remote})
+		}
+	}
+}
+
+// findPair returns the pair which sends from the given base to the given
+// remote address. The usageLock must be held.
+func (a *Agent) findPair(b *base, remote *net.UDPAddr) *pair {
+	for _, p := range a.pairs {
+		if p.local.base == b && sameAddr(p.remote.Addr, remote) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Connect runs connectivity checks until a pair has been nominated, and
+// returns a connection which sends and receives over it. The local
+// candidates must have been gathered, and the remote credentials set.
+func (a *Agent) Connect(ctx context.Context) (*Conn, error) {
+	a.usageLock.Lock()
+	if a.closed {
+		a.usageLock.Unlock()
+		return nil, ErrClosed
+	}
+
+	if a.remotePwd == "" {
+		a.usageLock.Unlock()
+		return nil, ErrNoCredentials
+	}
+
+	if !a.running {
+		a.running = true
+		go a.runChecks()
+	}
+	a.usageLock.Unlock()
+
+	select {
+	case <-a.selectedCh:
+	case <-a.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	a.usageLock.Lock()
+	defer a.usageLock.Unlock()
+
+	if a.conn == nil {
+		a.conn = newConn(a)
+		go a.runConsent()
+	}
+
+	return a.conn, nil
+}
+
+// Close closes the agent and all of its sockets, including relays.
+func (a *Agent) Close() error {
+	a.usageLock.Lock()
+	if a.closed {
+		a.usageLock.Unlock()
+		return nil
+	}
+
+	a.closed = true
+	close(a.done)
+	bases := a.bases
+	conn := a.conn
+	a.usageLock.Unlock()
+
+	for _, b := range bases {
+		b.conn.Close()
+	}
+
+	if conn != nil {
+		conn.closeWithError(ErrClosed)
+	}
+
+	return nil
+}
+
+// readLoop reads packets from a base, handling STUN messages and passing
+// other packets from validated pairs to the connection.
+func (a *Agent) readLoop(b *base) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := b.conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+
+			return
+		}
+
+		from, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		if stun.IsMessage(buf[:n]) {
+			msg, err := stun.Parse(buf[:n])
+			if err == nil && msg.CheckFingerprint() == nil {
+				a.handleSTUN(b, from, msg)
+				continue
+			}
+		}
+
+		a.usageLock.Lock()
+		conn := a.conn
+		valid := a.validated(b, from)
+		a.usageLock.Unlock()
+
+		if conn != nil && valid {
+			conn.deliver(buf[:n], from)
+		}
+	}
+}
+
+// validated returns whether packets received on a base from an address
+// belong to a pair which has passed a connectivity check. Packets from
+// anywhere else, such as hosts spoofing media, are dropped. The usageLock
+// must be held.
+func (a *Agent) validated(b *base, from *net.UDPAddr) bool {
+	p := a.findPair(b, from)
+	return p != nil && p.state == pairSucceeded
+}
+
+func (a *Agent) handleSTUN(b *base, from *net.UDPAddr, msg *stun.Message) {
+	switch msg.Class {
+	case stun.ClassRequest:
+		if msg.Method == stun.MethodBinding {
+			a.handleRequest(b, from, msg)
+		}
+	case stun.ClassSuccessResponse, stun.ClassErrorResponse:
+		a.usageLock.Lock()
+		ch, found := a.transactions[msg.TransactionID]
+		a.usageLock.Unlock()
+
+		if found {
+			select {
+			case ch <- response{msg: msg, from: from}:
+			default:
+			}
+		}
+	}
+}
+
+// transact sends a request from the given base and waits for a response,
+// retransmitting it if needed.
+func (a *Agent) transact(b *base, to *net.UDPAddr, req *stun.Message) (response, error) {
+	ch := make(chan response, 1)
+
+	a.usageLock.Lock()
+	a.transactions[req.TransactionID] = ch
+	a.usageLock.Unlock()
+
+	defer func() {
+		a.usageLock.Lock()
+		delete(a.transactions, req.TransactionID)
+		a.usageLock.Unlock()
+	}()
+
+	raw := req.Marshal()
+	rto := a.config.CheckRTO
+	for i := 0; i < a.config.CheckRetransmits; i++ {
+		if _, err := b.conn.WriteTo(raw, to); err != nil {
+			return response{}, err
+		}
+
+		timer := time.NewTimer(rto)
+		select {
+		case resp := <-ch:
+			timer.Stop()
+			return resp, nil
+		case <-a.done:
+			timer.Stop()
+			return response{}, ErrClosed
+		case <-timer.C:
+		}
+
+		rto *= 2
+	}
+
+	return response{}, stun.ErrTimeout
+}
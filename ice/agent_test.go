@@ -0,0 +1,259 @@
+package ice
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+var loopback = net.IPv4(127, 0, 0, 1)
+
+func newTestAgent(t *testing.T, config Config) *Agent {
+	t.Helper()
+
+	config.HostAddrs = []net.IP{loopback}
+	config.CheckInterval = 5 * time.Millisecond
+	config.CheckRTO = 50 * time.Millisecond
+	config.CheckRetransmits = 4
+	config.NominationDelay = 50 * time.Millisecond
+
+	a := NewAgent(config)
+	t.Cleanup(func() { a.Close() })
+
+	return a
+}
+
+// connect exchanges the candidates and credentials of two agents, and
+// connects them.
+func connect(t *testing.T, a, b *Agent) (*Conn, *Conn) {
+	t.Helper()
+
+	aCandidates, err := a.GatherCandidates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bCandidates, err := b.GatherCandidates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range aCandidates {
+		b.AddRemoteCandidate(c)
+	}
+	for _, c := range bCandidates {
+		a.AddRemoteCandidate(c)
+	}
+
+	a.SetRemoteCredentials(b.LocalCredentials())
+	b.SetRemoteCredentials(a.LocalCredentials())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+
+	results := make(chan result, 1)
+	go func() {
+		conn, err := b.Connect(ctx)
+		results <- result{conn, err}
+	}()
+
+	aConn, err := a.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	return aConn, r.conn
+}
+
+func readPacket(t *testing.T, conn *Conn) []byte {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf[:n]
+}
+
+// exchange checks that packets are carried in both directions.
+func exchange(t *testing.T, aConn, bConn *Conn) {
+	t.Helper()
+
+	if _, err := aConn.Write([]byte("from a")); err != nil {
+		t.Fatal(err)
+	}
+	if data := readPacket(t, bConn); string(data) != "from a" {
+		t.Errorf("b read %q, expected \"from a\"", data)
+	}
+
+	if _, err := bConn.Write([]byte("from b")); err != nil {
+		t.Fatal(err)
+	}
+	if data := readPacket(t, aConn); string(data) != "from b" {
+		t.Errorf("a read %q, expected \"from b\"", data)
+	}
+}
+
+func isControlling(a *Agent) bool {
+	a.usageLock.Lock()
+	defer a.usageLock.Unlock()
+
+	return a.controlling
+}
+
+func TestConnect(t *testing.T) {
+	a := newTestAgent(t, Config{Controlling: true})
+	b := newTestAgent(t, Config{})
+
+	aConn, bConn := connect(t, a, b)
+	exchange(t, aConn, bConn)
+
+	aLocal, aRemote := aConn.Selected()
+	bLocal, bRemote := bConn.Selected()
+	if !sameAddr(aLocal.Addr, bRemote.Addr) || !sameAddr(bLocal.Addr, aRemote.Addr) {
+		t.Errorf("agents selected different pairs: %v -> %v, %v -> %v",
+			aLocal, aRemote, bLocal, bRemote)
+	}
+}
+
+func TestDropsUnvalidatedSources(t *testing.T) {
+	a := newTestAgent(t, Config{Controlling: true})
+	b := newTestAgent(t, Config{})
+
+	aConn, bConn := connect(t, a, b)
+
+	spoofer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Close()
+
+	if _, err := spoofer.WriteTo([]byte("spoofed"), bConn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Packets are delivered in order, so the spoofed packet would be read
+	// first if it had been delivered.
+	time.Sleep(50 * time.Millisecond)
+	if _, err := aConn.Write([]byte("genuine")); err != nil {
+		t.Fatal(err)
+	}
+
+	if data := readPacket(t, bConn); !bytes.Equal(data, []byte("genuine")) {
+		t.Errorf("read %q, expected \"genuine\"", data)
+	}
+}
+
+// natConn simulates a NAT which rewrites the source port of a host
+// candidate: the agent believes its socket is bound to private, which
+// silently drops packets, while the remote agent sees packets come from the
+// socket's real port.
+type natConn struct {
+	net.PacketConn
+	private net.PacketConn
+}
+
+func (c *natConn) LocalAddr() net.Addr {
+	return c.private.LocalAddr()
+}
+
+func (c *natConn) Close() error {
+	c.private.Close()
+	return c.PacketConn.Close()
+}
+
+func listenBehindNAT(ip net.IP) (net.PacketConn, error) {
+	public, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+	if err != nil {
+		public.Close()
+		return nil, err
+	}
+
+	return &natConn{PacketConn: public, private: private}, nil
+}
+
+func TestNAT(t *testing.T) {
+	for _, controlling := range []bool{true, false} {
+		a := newTestAgent(t, Config{Controlling: controlling, Listen: listenBehindNAT})
+		b := newTestAgent(t, Config{Controlling: !controlling})
+
+		aConn, bConn := connect(t, a, b)
+		exchange(t, aConn, bConn)
+
+		// b can only reach a at the address its checks came from, which it
+		// learns as a peer-reflexive candidate.
+		_, remote := bConn.Selected()
+		if remote.Type != PeerReflexive {
+			t.Errorf("controlling %v: b selected a %v candidate, expected prflx",
+				controlling, remote.Type)
+		}
+
+		if sameAddr(remote.Addr, aConn.LocalAddr().(*net.UDPAddr)) {
+			t.Errorf("controlling %v: b sends to a's private address", controlling)
+		}
+	}
+}
+
+func TestRoleConflict(t *testing.T) {
+	for _, controlling := range []bool{true, false} {
+		a := newTestAgent(t, Config{Controlling: controlling})
+		b := newTestAgent(t, Config{Controlling: controlling})
+
+		aConn, bConn := connect(t, a, b)
+		exchange(t, aConn, bConn)
+
+		if isControlling(a) == isControlling(b) {
+			t.Errorf("both agents controlling %v: conflict wasn't resolved",
+				isControlling(a))
+		}
+	}
+}
+
+func TestConsentFailure(t *testing.T) {
+	a := newTestAgent(t, Config{
+		Controlling:     true,
+		ConsentInterval: 50 * time.Millisecond,
+		ConsentTimeout:  300 * time.Millisecond,
+	})
+	b := newTestAgent(t, Config{})
+
+	aConn, bConn := connect(t, a, b)
+	exchange(t, aConn, bConn)
+
+	// Consent is kept while the remote agent responds.
+	time.Sleep(500 * time.Millisecond)
+	exchange(t, aConn, bConn)
+
+	b.Close()
+
+	aConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := aConn.Read(make([]byte, 1500)); err != ErrConsentLost {
+		t.Fatalf("got %v, expected ErrConsentLost", err)
+	}
+
+	if _, err := aConn.Write([]byte("after")); err != ErrConsentLost {
+		t.Errorf("write after consent lost: got %v, expected ErrConsentLost", err)
+	}
+}
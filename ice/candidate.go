@@ -0,0 +1,150 @@
+package ice
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// CandidateType represents the type of a candidate.
+type CandidateType int
+
+// Possible CandidateTypes.
+const (
+	Host CandidateType = iota
+	ServerReflexive
+	PeerReflexive
+	Relay
+)
+
+// ErrInvalidCandidate is returned when a candidate cannot be parsed.
+var ErrInvalidCandidate = errors.New("ice: invalid candidate")
+
+var candidateTypeNames = map[CandidateType]string{
+	Host:            "host",
+	ServerReflexive: "srflx",
+	PeerReflexive:   "prflx",
+	Relay:           "relay",
+}
+
+func (t CandidateType) String() string {
+	return candidateTypeNames[t]
+}
+
+// preference returns the type preference of the candidate type, as
+// recommended by RFC 8445 section 5.1.2.2.
+func (t CandidateType) preference() uint32 {
+	switch t {
+	case Host:
+		return 126
+	case PeerReflexive:
+		return 110
+	case ServerReflexive:
+		return 100
+	default:
+		return 0
+	}
+}
+
+// component is the only component used, as audio is carried with RTP and
+// RTCP multiplexed on one transport.
+const component = 1
+
+// Candidate represents a transport address a peer may be reachable at.
+type Candidate struct {
+	Type        CandidateType
+	Foundation  string
+	Priority    uint32
+	Addr        *net.UDPAddr
+	RelatedAddr *net.UDPAddr
+}
+
+// priority computes the priority of a candidate as described in RFC 8445
+// section 5.1.2.1.
+func priority(t CandidateType, localPreference uint32) uint32 {
+	return t.preference()<<24 | (localPreference&0xffff)<<8 | (256 - component)
+}
+
+func foundation(t CandidateType, baseIP net.IP, server string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE(
+		[]byte(t.String()+baseIP.String()+server))), 10)
+}
+
+// String returns the candidate in the SDP candidate attribute format of
+// RFC 8839, such as "candidate:1 1 udp 2130706431 192.0.2.1 5000 typ host".
+func (c Candidate) String() string {
+	s := fmt.Sprintf("candidate:%s %d udp %d %s %d typ %s", c.Foundation,
+		component, c.Priority, c.Addr.IP, c.Addr.Port, c.Type)
+	if c.RelatedAddr != nil {
+		s += fmt.Sprintf(" raddr %s rport %d", c.RelatedAddr.IP,
+			c.RelatedAddr.Port)
+	}
+
+	return s
+}
+
+// ParseCandidate parses a candidate in the SDP candidate attribute format.
+// Only UDP candidates are supported.
+func ParseCandidate(s string) (Candidate, error) {
+	fields := strings.Fields(strings.TrimPrefix(s, "a="))
+	if len(fields) < 8 || !strings.HasPrefix(fields[0], "candidate:") ||
+		!strings.EqualFold(fields[2], "udp") || fields[6] != "typ" {
+		return Candidate{}, ErrInvalidCandidate
+	}
+
+	prio, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return Candidate{}, ErrInvalidCandidate
+	}
+
+	ip := net.ParseIP(fields[4])
+	port, err := strconv.Atoi(fields[5])
+	if ip == nil || err != nil {
+		return Candidate{}, ErrInvalidCandidate
+	}
+
+	c := Candidate{
+		Foundation: strings.TrimPrefix(fields[0], "candidate:"),
+		Priority:   uint32(prio),
+		Addr:       &net.UDPAddr{IP: ip, Port: port},
+		Type:       -1,
+	}
+
+	for t, name := range candidateTypeNames {
+		if fields[7] == name {
+			c.Type = t
+		}
+	}
+
+	if c.Type < 0 {
+		return Candidate{}, ErrInvalidCandidate
+	}
+
+	var raddr net.IP
+	var rport int
+	for i := 8; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "raddr":
+			raddr = net.ParseIP(fields[i+1])
+		case "rport":
+			rport, _ = strconv.Atoi(fields[i+1])
+		}
+	}
+
+	if raddr != nil {
+		c.RelatedAddr = &net.UDPAddr{IP: raddr, Port: rport}
+	}
+
+	return c, nil
+}
+
+func sameFamily(a, b *net.UDPAddr) bool {
+	return (a.IP.To4() == nil) == (b.IP.To4() == nil)
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
@@ -0,0 +1,388 @@
+package ice
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/1lann/dissonance/stun"
+)
+
+// prflxPriority returns the priority a peer-reflexive candidate learned from
+// a check sent by the local candidate would have.
+func prflxPriority(local *localCandidate) uint32 {
+	return priority(PeerReflexive, (local.Priority>>8)&0xffff)
+}
+
+func (a *Agent) roleAttribute(msg *stun.Message) {
+	var tieBreaker [8]byte
+	binary.BigEndian.PutUint64(tieBreaker[:], a.tieBreaker)
+
+	if a.controlling {
+		msg.Add(stun.AttrICEControlling, tieBreaker[:])
+	} else {
+		msg.Add(stun.AttrICEControlled, tieBreaker[:])
+	}
+}
+
+// switchRole switches between the controlling and controlled roles. Pair
+// priorities depend on the role, so are always computed when needed. The
+// usageLock must be held.
+func (a *Agent) switchRole() {
+	a.controlling = !a.controlling
+	a.firstSuccess = time.Time{}
+}
+
+// handleRequest responds to a connectivity check from the remote agent.
+func (a *Agent) handleRequest(b *base, from *net.UDPAddr, req *stun.Message) {
+	a.usageLock.Lock()
+	defer a.usageLock.Unlock()
+
+	resp := a.checkRequest(b, from, req)
+	if resp == nil {
+		return
+	}
+
+	b.conn.WriteTo(resp.Marshal(), from)
+}
+
+// checkRequest validates a connectivity check and updates the check list,
+// returning the response to send, or nil if the request should be ignored.
+// The usageLock must be held.
+func (a *Agent) checkRequest(b *base, from *net.UDPAddr, req *stun.Message) *stun.Message {
+	if a.remotePwd == "" {
+		// Checks can't be answered until the remote credentials are known.
+		return nil
+	}
+
+	errorResponse := func(code int, reason string) *stun.Message {
+		resp := stun.NewResponse(req, stun.ClassErrorResponse)
+		resp.AddErrorCode(code, reason)
+		resp.AddIntegrity(stun.ShortTermKey(a.localPwd))
+		resp.AddFingerprint()
+		return resp
+	}
+
+	username, err := req.GetString(stun.AttrUsername)
+	if err != nil || username != a.localUfrag+":"+a.remoteUfrag ||
+		req.CheckIntegrity(stun.ShortTermKey(a.localPwd)) != nil {
+		resp := stun.NewResponse(req, stun.ClassErrorResponse)
+		resp.AddErrorCode(stun.CodeUnauthorized, "Unauthorized")
+		resp.AddFingerprint()
+		return resp
+	}
+
+	// Resolve role conflicts as described in RFC 8445 section 7.3.1.1.
+	if value, found := req.Get(stun.AttrICEControlling); found && a.controlling {
+		if len(value) != 8 {
+			return errorResponse(stun.CodeBadRequest, "Bad Request")
+		}
+
+		if a.tieBreaker >= binary.BigEndian.Uint64(value) {
+			return errorResponse(stun.CodeRoleConflict, "Role Conflict")
+		}
+
+		a.switchRole()
+	} else if value, found := req.Get(stun.AttrICEControlled); found && !a.controlling {
+		if len(value) != 8 {
+			return errorResponse(stun.CodeBadRequest, "Bad Request")
+		}
+
+		if a.tieBreaker >= binary.BigEndian.Uint64(value) {
+			a.switchRole()
+		} else {
+			return errorResponse(stun.CodeRoleConflict, "Role Conflict")
+		}
+	}
+
+	p := a.findPair(b, from)
+	if p == nil {
+		// The request came from an address not signaled by the remote
+		// agent, so learn it as a peer-reflexive candidate.
+		var prio uint32
+		if value, found := req.Get(stun.AttrPriority); found && len(value) == 4 {
+			prio = binary.BigEndian.Uint32(value)
+		}
+
+		remote := Candidate{
+			Type:       PeerReflexive,
+			Foundation: foundation(PeerReflexive, from.IP, ""),
+			Priority:   prio,
+			Addr:       from,
+		}
+
+		known := false
+		for _, c := range a.remote {
+			if sameAddr(c.Addr, from) {
+				known = true
+				remote = c
+			}
+		}
+		if !known {
+			a.remote = append(a.remote, remote)
+		}
+
+		var local *localCandidate
+		for _, c := range a.local {
+			if c.base == b && (c.Type == Host || c.Type == Relay) {
+				local = c
+				break
+			}
+		}
+
+		if local != nil {
+			p = &pair{local: local, remote: remote}
+			a.pairs = append(a.pairs, p)
+		}
+	}
+
+	if p != nil {
+		if req.Contains(stun.AttrUseCandidate) && !a.controlling {
+			p.nominateRequested = true
+			if p.state == pairSucceeded {
+				a.selectPair(p)
+			}
+		}
+
+		// Trigger a check back to the remote agent, as described in RFC 8445
+		// section 7.3.1.4.
+		if p.state == pairWaiting || p.state == pairFailed {
+			p.state = pairWaiting
+			a.triggered = append(a.triggered, p)
+		}
+	}
+
+	resp := stun.NewResponse(req, stun.ClassSuccessResponse)
+	resp.AddXORAddress(stun.AttrXORMappedAddress, from)
+	resp.AddIntegrity(stun.ShortTermKey(a.localPwd))
+	resp.AddFingerprint()
+
+	return resp
+}
+
+// runChecks sends connectivity checks at the check interval until the agent
+// is closed, and nominates a pair when controlling.
+func (a *Agent) runChecks() {
+	ticker := time.NewTicker(a.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+
+		a.usageLock.Lock()
+		p := a.nextCheck()
+		if p != nil {
+			p.state = pairInProgress
+			go a.check(p, false)
+		}
+
+		if nominee := a.nominee(); nominee != nil {
+			a.nominating = true
+			go a.check(nominee, true)
+		}
+		a.usageLock.Unlock()
+	}
+}
+
+// nextCheck returns the next pair to check, taking triggered checks first,
+// followed by the highest priority waiting pair. The usageLock must be held.
+func (a *Agent) nextCheck() *pair {
+	for len(a.triggered) > 0 {
+		p := a.triggered[0]
+		a.triggered = a.triggered[1:]
+		if p.state == pairWaiting {
+			return p
+		}
+	}
+
+	var best *pair
+	for _, p := range a.pairs {
+		if p.state != pairWaiting {
+			continue
+		}
+
+		if best == nil || a.pairPriority(p) > a.pairPriority(best) {
+			best = p
+		}
+	}
+
+	return best
+}
+
+// nominee returns the pair the controlling agent should nominate, if it is
+// time to nominate one. A pair is nominated once no higher priority pair is
+// still being checked, or the nomination delay has passed since the first
+// successful check. The usageLock must be held.
+func (a *Agent) nominee() *pair {
+	if !a.controlling || a.nominating || a.selected != nil ||
+		a.firstSuccess.IsZero() {
+		return nil
+	}
+
+	var best *pair
+	for _, p := range a.pairs {
+		if p.state == pairSucceeded &&
+			(best == nil || a.pairPriority(p) > a.pairPriority(best)) {
+			best = p
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	if time.Since(a.firstSuccess) >= a.config.NominationDelay {
+		return best
+	}
+
+	for _, p := range a.pairs {
+		if (p.state == pairWaiting || p.state == pairInProgress) &&
+			a.pairPriority(p) > a.pairPriority(best) {
+			return nil
+		}
+	}
+
+	return best
+}
+
+// request returns a new connectivity check request sent from the given
+// local candidate. The usageLock must be held.
+func (a *Agent) request(local *localCandidate, nominate bool) *stun.Message {
+	req := stun.NewMessage(stun.MethodBinding, stun.ClassRequest)
+	req.AddString(stun.AttrUsername, a.remoteUfrag+":"+a.localUfrag)
+
+	var prio [4]byte
+	binary.BigEndian.PutUint32(prio[:], prflxPriority(local))
+	req.Add(stun.AttrPriority, prio[:])
+
+	a.roleAttribute(req)
+	if nominate {
+		req.Add(stun.AttrUseCandidate, nil)
+	}
+
+	req.AddIntegrity(stun.ShortTermKey(a.remotePwd))
+	req.AddFingerprint()
+
+	return req
+}
+
+// check sends a connectivity check for the pair, nominating it if
+// requested, and updates its state with the result.
+func (a *Agent) check(p *pair, nominate bool) {
+	a.usageLock.Lock()
+	req := a.request(p.local, nominate)
+	key := stun.ShortTermKey(a.remotePwd)
+	a.usageLock.Unlock()
+
+	resp, err := a.transact(p.local.base, p.remote.Addr, req)
+
+	a.usageLock.Lock()
+	defer a.usageLock.Unlock()
+
+	if nominate {
+		a.nominating = false
+	}
+
+	if err == ErrClosed {
+		return
+	}
+
+	if err != nil || resp.msg.CheckIntegrity(key) != nil ||
+		!sameAddr(resp.from, p.remote.Addr) {
+		p.state = pairFailed
+		return
+	}
+
+	if resp.msg.Class == stun.ClassErrorResponse {
+		if stunErr, err := resp.msg.ErrorCode(); err == nil &&
+			stunErr.Code == stun.CodeRoleConflict {
+			if a.controlling == req.Contains(stun.AttrICEControlling) {
+				a.switchRole()
+			}
+
+			p.state = pairWaiting
+			a.triggered = append(a.triggered, p)
+			return
+		}
+
+		p.state = pairFailed
+		return
+	}
+
+	p.state = pairSucceeded
+	p.lastConsent = time.Now()
+	if a.firstSuccess.IsZero() {
+		a.firstSuccess = time.Now()
+	}
+
+	if (nominate && a.controlling) || (!a.controlling && p.nominateRequested) {
+		a.selectPair(p)
+	}
+}
+
+// selectPair selects the pair to send media over. The usageLock must be
+// held.
+func (a *Agent) selectPair(p *pair) {
+	if a.selected != nil {
+		return
+	}
+
+	a.selected = p
+	close(a.selectedCh)
+}
+
+// runConsent sends consent freshness checks on the selected pair, closing
+// the connection if the remote agent stops responding, as described in
+// RFC 7675.
+func (a *Agent) runConsent() {
+	for {
+		// Randomize the interval by +/- 20% to avoid synchronization.
+		interval := a.config.ConsentInterval
+		interval += time.Duration((rand.Float64()*0.4 - 0.2) * float64(interval))
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-a.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		a.usageLock.Lock()
+		p := a.selected
+		expired := time.Since(p.lastConsent) > a.config.ConsentTimeout
+		conn := a.conn
+		a.usageLock.Unlock()
+
+		if expired {
+			conn.closeWithError(ErrConsentLost)
+			return
+		}
+
+		go a.refreshConsent(p)
+	}
+}
+
+// refreshConsent sends a consent freshness check on the pair, and updates
+// when consent was last granted if it succeeds.
+func (a *Agent) refreshConsent(p *pair) {
+	a.usageLock.Lock()
+	req := a.request(p.local, false)
+	key := stun.ShortTermKey(a.remotePwd)
+	a.usageLock.Unlock()
+
+	resp, err := a.transact(p.local.base, p.remote.Addr, req)
+	if err != nil || resp.msg.Class != stun.ClassSuccessResponse ||
+		resp.msg.CheckIntegrity(key) != nil {
+		return
+	}
+
+	a.usageLock.Lock()
+	p.lastConsent = time.Now()
+	a.usageLock.Unlock()
+}
@@ -0,0 +1,174 @@
+package ice
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// readQueueLength is the number of received packets buffered before packets
+// are dropped.
+const readQueueLength = 256
+
+type packet struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// Conn represents the connection over the selected candidate pair. It
+// implements net.PacketConn, but always sends to the selected remote
+// candidate regardless of the address given to WriteTo.
+type Conn struct {
+	agent  *Agent
+	queue  chan packet
+	closed chan struct{}
+	err    error
+
+	deadline       time.Time
+	deadlineNotify chan struct{}
+	usageLock      *sync.Mutex
+}
+
+func newConn(a *Agent) *Conn {
+	return &Conn{
+		agent:          a,
+		queue:          make(chan packet, readQueueLength),
+		closed:         make(chan struct{}),
+		deadlineNotify: make(chan struct{}),
+		usageLock:      new(sync.Mutex),
+	}
+}
+
+func (c *Conn) deliver(data []byte, from *net.UDPAddr) {
+	select {
+	case c.queue <- packet{data: append([]byte(nil), data...), from: from}:
+	default:
+	}
+}
+
+func (c *Conn) closeWithError(err error) {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	if c.err == nil {
+		c.err = err
+		close(c.closed)
+	}
+}
+
+// ReadFrom reads a packet received from the remote agent over a validated
+// candidate pair.
+func (c *Conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.usageLock.Lock()
+		deadline := c.deadline
+		notify := c.deadlineNotify
+		c.usageLock.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case p := <-c.queue:
+			stopTimer(timer)
+			return copy(b, p.data), p.from, nil
+		case <-c.closed:
+			stopTimer(timer)
+			return 0, nil, c.err
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-notify:
+			stopTimer(timer)
+		}
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// Read reads a packet received from the remote agent.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// WriteTo sends a packet to the selected remote candidate. The address is
+// ignored.
+func (c *Conn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+// Write sends a packet to the selected remote candidate.
+func (c *Conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, c.err
+	default:
+	}
+
+	p := c.selected()
+	return p.local.base.conn.WriteTo(b, p.remote.Addr)
+}
+
+func (c *Conn) selected() *pair {
+	c.agent.usageLock.Lock()
+	defer c.agent.usageLock.Unlock()
+
+	return c.agent.selected
+}
+
+// Close closes the connection and its agent.
+func (c *Conn) Close() error {
+	return c.agent.Close()
+}
+
+// LocalAddr returns the address of the selected local candidate's base.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.selected().local.base.addr
+}
+
+// RemoteAddr returns the address of the selected remote candidate.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.selected().remote.Addr
+}
+
+// Selected returns the selected local and remote candidates.
+func (c *Conn) Selected() (local, remote Candidate) {
+	p := c.selected()
+	return p.local.Candidate, p.remote
+}
+
+// SetDeadline sets the read deadline. Writes never block.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for pending and future reads.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	c.deadline = t
+	close(c.deadlineNotify)
+	c.deadlineNotify = make(chan struct{})
+
+	return nil
+}
+
+// SetWriteDeadline has no effect, as writes never block.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package netem
+
+import "math/rand"
+
+// GilbertElliott represents the Gilbert-Elliott model of bursty loss. The
+// link alternates between a good and a bad state, each with its own loss
+// probability, so losses cluster together as they do on real networks.
+type GilbertElliott struct {
+	// P is the probability of moving from the good state to the bad state
+	// with each packet.
+	P float64
+
+	// R is the probability of moving from the bad state to the good state
+	// with each packet.
+	R float64
+
+	// LossGood and LossBad are the probabilities a packet is lost in the
+	// good and bad states. The simple Gilbert model has a LossGood of 0 and
+	// a LossBad of 1.
+	LossGood float64
+	LossBad  float64
+}
+
+// gilbertState represents the state of a Gilbert-Elliott loss model.
+type gilbertState struct {
+	model *GilbertElliott
+	bad   bool
+}
+
+// lost moves the model to its next state and returns whether the packet is
+// lost.
+func (g *gilbertState) lost(random *rand.Rand) bool {
+	if g.bad {
+		if random.Float64() < g.model.R {
+			g.bad = false
+		}
+	} else if random.Float64() < g.model.P {
+		g.bad = true
+	}
+
+	if g.bad {
+		return random.Float64() < g.model.LossBad
+	}
+
+	return random.Float64() < g.model.LossGood
+}
@@ -0,0 +1,305 @@
+// Package netem emulates impaired networks for testing calls, in the style
+// of Linux's netem. It wraps a net.PacketConn, and adds latency, jitter,
+// random and bursty loss, duplication, reordering and a bandwidth cap to the
+// packets written to it. Decisions are made with a seeded random number
+// generator, so a test on loopback sees the same impairments every run.
+//
+// Only packets written are impaired. To impair both directions of a call,
+// wrap the connections of both peers.
+package netem
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when writing to a closed connection.
+var ErrClosed = errors.New("netem: connection closed")
+
+// Config represents the impairments of an emulated network.
+type Config struct {
+	// Latency is the delay added to every packet.
+	Latency time.Duration
+
+	// Jitter is the largest random variation of the latency, in either
+	// direction. Packets may be reordered by jitter.
+	Jitter time.Duration
+
+	// Loss is the probability a packet is lost at random.
+	Loss float64
+
+	// Burst, if set, adds bursty loss following the Gilbert-Elliott model.
+	Burst *GilbertElliott
+
+	// Duplicate is the probability a packet is sent twice.
+	Duplicate float64
+
+	// Reorder is the probability a packet is sent straight away, without
+	// the latency, jumping ahead of packets still in flight.
+	Reorder float64
+
+	// Bandwidth caps the rate packets are sent at, in bits per second. Zero
+	// means unlimited.
+	Bandwidth int
+
+	// QueueLength is the longest time a packet waits for bandwidth before
+	// being dropped, when Bandwidth is set. Zero means unlimited.
+	QueueLength time.Duration
+
+	// Seed seeds the random number generator.
+	Seed int64
+}
+
+// Stats represents the counts of packets written to a connection and what
+// happened to them.
+type Stats struct {
+	Written    int
+	Sent       int
+	Lost       int
+	Dropped    int
+	Duplicated int
+	Reordered  int
+}
+
+// Conn represents a packet connection with emulated impairments.
+type Conn struct {
+	net.PacketConn
+
+	config   Config
+	random   *rand.Rand
+	burst    *gilbertState
+	linkFree time.Time
+	stats    Stats
+
+	queue     delayQueue
+	sequence  uint64
+	notify    chan struct{}
+	closed    chan struct{}
+	usageLock *sync.Mutex
+}
+
+// delayed represents a packet waiting to be sent.
+type delayed struct {
+	at       time.Time
+	sequence uint64
+	data     []byte
+	addr     net.Addr
+}
+
+// delayQueue is a priority queue of packets ordered by the time they are
+// sent, and the order they were written in for packets sent at the same
+// time.
+type delayQueue []*delayed
+
+func (q delayQueue) Len() int { return len(q) }
+
+func (q delayQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].sequence < q[j].sequence
+	}
+
+	return q[i].at.Before(q[j].at)
+}
+
+func (q delayQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *delayQueue) Push(x interface{}) { *q = append(*q, x.(*delayed)) }
+
+func (q *delayQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// New returns a connection which impairs the packets written to conn as
+// described by config. Reads pass through unchanged.
+func New(conn net.PacketConn, config Config) *Conn {
+	c := &Conn{
+		PacketConn: conn,
+		config:     config,
+		random:     rand.New(rand.NewSource(config.Seed)),
+		notify:     make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+		usageLock:  new(sync.Mutex),
+	}
+
+	if config.Burst != nil {
+		c.burst = &gilbertState{model: config.Burst}
+	}
+
+	go c.run()
+
+	return c
+}
+
+// WriteTo schedules a packet to be sent to addr after the emulated
+// impairments. It never blocks, and reports success even if the packet is
+// lost.
+func (c *Conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	select {
+	case <-c.closed:
+		return 0, ErrClosed
+	default:
+	}
+
+	c.stats.Written++
+
+	lost := c.random.Float64() < c.config.Loss
+	if c.burst != nil && c.burst.lost(c.random) {
+		lost = true
+	}
+
+	if lost {
+		c.stats.Lost++
+		return len(b), nil
+	}
+
+	copies := 1
+	if c.random.Float64() < c.config.Duplicate {
+		copies = 2
+		c.stats.Duplicated++
+	}
+
+	now := time.Now()
+	for i := 0; i < copies; i++ {
+		at, ok := c.departure(now, len(b))
+		if !ok {
+			c.stats.Dropped++
+			continue
+		}
+
+		if c.random.Float64() < c.config.Reorder {
+			c.stats.Reordered++
+		} else {
+			at = at.Add(c.delay())
+		}
+
+		c.sequence++
+		heap.Push(&c.queue, &delayed{
+			at:       at,
+			sequence: c.sequence,
+			data:     append([]byte(nil), b...),
+			addr:     addr,
+		})
+	}
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+
+	return len(b), nil
+}
+
+// departure returns when a packet of the given size leaves the emulated
+// link after waiting for bandwidth, and false if the queue is too long for
+// it. The usageLock must be held.
+func (c *Conn) departure(now time.Time, size int) (time.Time, bool) {
+	if c.config.Bandwidth <= 0 {
+		return now, true
+	}
+
+	start := now
+	if c.linkFree.After(now) {
+		start = c.linkFree
+	}
+
+	if c.config.QueueLength > 0 && start.Sub(now) > c.config.QueueLength {
+		return time.Time{}, false
+	}
+
+	serialization := time.Duration(int64(size) * 8 * int64(time.Second) /
+		int64(c.config.Bandwidth))
+	c.linkFree = start.Add(serialization)
+
+	return c.linkFree, true
+}
+
+// delay returns the latency of a packet with jitter applied. The usageLock
+// must be held.
+func (c *Conn) delay() time.Duration {
+	d := c.config.Latency
+	if c.config.Jitter > 0 {
+		d += time.Duration((c.random.Float64()*2 - 1) * float64(c.config.Jitter))
+	}
+
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
+
+// run sends packets as they become due.
+func (c *Conn) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		c.usageLock.Lock()
+		var due []*delayed
+		now := time.Now()
+		for len(c.queue) > 0 && !c.queue[0].at.After(now) {
+			due = append(due, heap.Pop(&c.queue).(*delayed))
+		}
+
+		wait := time.Hour
+		if len(c.queue) > 0 {
+			wait = c.queue[0].at.Sub(now)
+		}
+		c.stats.Sent += len(due)
+		c.usageLock.Unlock()
+
+		for _, p := range due {
+			c.PacketConn.WriteTo(p.data, p.addr)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-c.closed:
+			return
+		case <-c.notify:
+		case <-timer.C:
+		}
+	}
+}
+
+// Stats returns the counts of packets written so far.
+func (c *Conn) Stats() Stats {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	return c.stats
+}
+
+// Close closes the connection, discarding packets still in flight.
+func (c *Conn) Close() error {
+	c.usageLock.Lock()
+	select {
+	case <-c.closed:
+		c.usageLock.Unlock()
+		return nil
+	default:
+	}
+
+	close(c.closed)
+	c.queue = nil
+	c.usageLock.Unlock()
+
+	return c.PacketConn.Close()
+}
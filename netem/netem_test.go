@@ -0,0 +1,170 @@
+package netem
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// impair writes numbered packets through an emulated network with the given
+// config, and returns the numbers in the order they were received and the
+// connection's stats.
+func impair(t *testing.T, config Config, packets int) ([]uint32, Stats) {
+	t.Helper()
+
+	receiver := listen(t)
+	conn := New(listen(t), config)
+	defer conn.Close()
+
+	for i := 0; i < packets; i++ {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		if _, err := conn.WriteTo(b[:], receiver.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var received []uint32
+	buf := make([]byte, 16)
+	for {
+		receiver.SetReadDeadline(time.Now().Add(config.Latency + 500*time.Millisecond))
+		n, _, err := receiver.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 4 {
+			t.Fatalf("got a packet of %d bytes", n)
+		}
+		received = append(received, binary.BigEndian.Uint32(buf))
+	}
+
+	return received, conn.Stats()
+}
+
+func TestSeedReproducible(t *testing.T) {
+	config := Config{
+		Latency:   50 * time.Millisecond,
+		Loss:      0.1,
+		Burst:     &GilbertElliott{P: 0.05, R: 0.3, LossBad: 0.8},
+		Duplicate: 0.1,
+		Reorder:   0.1,
+		Seed:      42,
+	}
+
+	const packets = 300
+	first, firstStats := impair(t, config, packets)
+	second, secondStats := impair(t, config, packets)
+
+	if firstStats.Lost == 0 || firstStats.Duplicated == 0 || firstStats.Reordered == 0 {
+		t.Fatalf("expected every impairment to occur, got %+v", firstStats)
+	}
+
+	if firstStats != secondStats {
+		t.Errorf("got stats %+v and %+v with the same seed", firstStats, secondStats)
+	}
+
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("got different packets with the same seed:\n%v\n%v", first, second)
+	}
+
+	expected := firstStats.Written - firstStats.Lost + firstStats.Duplicated
+	if len(first) != expected || firstStats.Sent != expected {
+		t.Errorf("received %d and sent %d packets, expected %d", len(first),
+			firstStats.Sent, expected)
+	}
+
+	config.Seed++
+	other, _ := impair(t, config, packets)
+	if reflect.DeepEqual(first, other) {
+		t.Error("got the same packets with a different seed")
+	}
+}
+
+func TestGilbertElliottLossRate(t *testing.T) {
+	tests := []GilbertElliott{
+		{P: 0.01, R: 0.5, LossBad: 1},
+		{P: 0.05, R: 0.25, LossBad: 1},
+		{P: 0.1, R: 0.3, LossGood: 0.01, LossBad: 0.6},
+	}
+
+	for _, model := range tests {
+		// The stationary probability of the bad state is P/(P+R).
+		bad := model.P / (model.P + model.R)
+		expected := (1-bad)*model.LossGood + bad*model.LossBad
+
+		state := &gilbertState{model: &model}
+		random := rand.New(rand.NewSource(1))
+
+		const packets = 1000000
+		var lost, bursts int
+		previous := false
+		for i := 0; i < packets; i++ {
+			l := state.lost(random)
+			if l {
+				lost++
+				if !previous {
+					bursts++
+				}
+			}
+			previous = l
+		}
+
+		if rate := float64(lost) / packets; math.Abs(rate-expected) > 0.05*expected {
+			t.Errorf("%+v: got loss rate %.4f, expected %.4f", model, rate, expected)
+		}
+
+		// In the simple Gilbert model, losses come in bursts of 1/R packets
+		// on average.
+		if model.LossGood == 0 && model.LossBad == 1 {
+			length := float64(lost) / float64(bursts)
+			if math.Abs(length-1/model.R) > 0.05/model.R {
+				t.Errorf("%+v: got mean burst length %.2f, expected %.2f", model,
+					length, 1/model.R)
+			}
+		}
+	}
+}
+
+func TestGilbertElliottConn(t *testing.T) {
+	model := &GilbertElliott{P: 0.05, R: 0.25, LossBad: 1}
+	bad := model.P / (model.P + model.R)
+
+	conn := New(listen(t), Config{Burst: model, Seed: 7})
+	defer conn.Close()
+
+	// Packets are sent to a socket which is never read, as only the loss
+	// decisions matter.
+	discard := listen(t).LocalAddr()
+	const packets = 20000
+	for i := 0; i < packets; i++ {
+		if _, err := conn.WriteTo([]byte{0}, discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := conn.Stats()
+	if rate := float64(stats.Lost) / float64(stats.Written); math.Abs(rate-bad) > 0.02 {
+		t.Errorf("got loss rate %.4f, expected %.4f", rate, bad)
+	}
+}
@@ -0,0 +1,39 @@
+package rtp
+
+// AudioLevelURI is the URI identifying the client-to-mixer audio level
+// header extension (RFC 6464) when negotiating extension IDs.
+const AudioLevelURI = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+// DefaultAudioLevelID is the extension ID dissonance uses for the audio level
+// extension unless another ID is negotiated.
+const DefaultAudioLevelID = 1
+
+// MaxAudioLevel is the audio level of silence, in -dBov. The level of the
+// loudest possible signal is 0.
+const MaxAudioLevel = 127
+
+// SetAudioLevel sets the audio level extension with the given ID, with the
+// level of the packet's audio in -dBov, and whether the sender detected
+// voice activity in it. Levels above MaxAudioLevel are clamped.
+func (h *Header) SetAudioLevel(id uint8, level uint8, voice bool) {
+	if level > MaxAudioLevel {
+		level = MaxAudioLevel
+	}
+
+	if voice {
+		level |= 0x80
+	}
+
+	h.SetExtension(id, []byte{level})
+}
+
+// AudioLevel returns the level in -dBov and voice activity flag of the audio
+// level extension with the given ID, and whether the extension is present.
+func (h *Header) AudioLevel(id uint8) (level uint8, voice bool, ok bool) {
+	payload, found := h.GetExtension(id)
+	if !found || len(payload) < 1 {
+		return MaxAudioLevel, false, false
+	}
+
+	return payload[0] & 0x7f, payload[0]&0x80 != 0, true
+}
@@ -0,0 +1,194 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Profiles of the header extension formats from RFC 8285.
+const (
+	oneByteProfile = 0xbede
+	twoByteProfile = 0x1000
+
+	// twoByteProfileMask matches the 4 bit appbits field of the two-byte
+	// profile, which is ignored.
+	twoByteProfileMask = 0xfff0
+)
+
+// Limits of extension IDs and lengths in the one-byte and two-byte header
+// extension formats.
+const (
+	maxOneByteID     = 14
+	maxOneByteLength = 16
+	maxTwoByteLength = 255
+)
+
+// ErrInvalidExtension is returned when a header extension has an ID or
+// length that cannot be encoded.
+var ErrInvalidExtension = errors.New("rtp: invalid header extension")
+
+// Extension represents an element of a header extension (RFC 8285). IDs are
+// negotiated by signaling, and range from 1 to 255.
+type Extension struct {
+	ID      uint8
+	Payload []byte
+}
+
+// SetExtension sets the payload of the extension with the given ID,
+// replacing any existing payload.
+func (h *Header) SetExtension(id uint8, payload []byte) {
+	for i, ext := range h.Extensions {
+		if ext.ID == id {
+			h.Extensions[i].Payload = payload
+			return
+		}
+	}
+
+	h.Extensions = append(h.Extensions, Extension{ID: id, Payload: payload})
+}
+
+// GetExtension returns the payload of the extension with the given ID.
+func (h *Header) GetExtension(id uint8) ([]byte, bool) {
+	for _, ext := range h.Extensions {
+		if ext.ID == id {
+			return ext.Payload, true
+		}
+	}
+
+	return nil, false
+}
+
+// DeleteExtension removes the extension with the given ID.
+func (h *Header) DeleteExtension(id uint8) {
+	for i, ext := range h.Extensions {
+		if ext.ID == id {
+			h.Extensions = append(h.Extensions[:i], h.Extensions[i+1:]...)
+			return
+		}
+	}
+}
+
+// useOneByte returns whether the extensions fit in the one-byte format,
+// which is preferred as it is smaller and more widely supported.
+func (h *Header) useOneByte() bool {
+	for _, ext := range h.Extensions {
+		if ext.ID > maxOneByteID || len(ext.Payload) == 0 ||
+			len(ext.Payload) > maxOneByteLength {
+			return false
+		}
+	}
+
+	return true
+}
+
+// extensionSize returns the size of the header extension, including its 4
+// byte header and padding, or 0 if there are no extensions.
+func (h *Header) extensionSize() int {
+	if len(h.Extensions) == 0 {
+		return 0
+	}
+
+	elementHeader := 2
+	if h.useOneByte() {
+		elementHeader = 1
+	}
+
+	n := 0
+	for _, ext := range h.Extensions {
+		n += elementHeader + len(ext.Payload)
+	}
+
+	return 4 + (n+3)/4*4
+}
+
+// marshalExtensions encodes the header extension into b, which must be
+// extensionSize bytes long.
+func (h *Header) marshalExtensions(b []byte) error {
+	oneByte := h.useOneByte()
+	profile := uint16(twoByteProfile)
+	if oneByte {
+		profile = oneByteProfile
+	}
+
+	binary.BigEndian.PutUint16(b, profile)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)/4-1))
+
+	n := 4
+	for _, ext := range h.Extensions {
+		if ext.ID == 0 || len(ext.Payload) > maxTwoByteLength {
+			return ErrInvalidExtension
+		}
+
+		if oneByte {
+			b[n] = ext.ID<<4 | uint8(len(ext.Payload)-1)
+			n++
+		} else {
+			b[n] = ext.ID
+			b[n+1] = uint8(len(ext.Payload))
+			n += 2
+		}
+
+		n += copy(b[n:], ext.Payload)
+	}
+
+	// The remainder is already zero padding.
+	return nil
+}
+
+// unmarshalExtensions decodes the elements of a header extension with the
+// given profile. Extensions in formats other than those of RFC 8285 are
+// ignored.
+func (h *Header) unmarshalExtensions(profile uint16, b []byte) error {
+	h.Extensions = nil
+
+	switch {
+	case profile == oneByteProfile:
+		for n := 0; n < len(b); {
+			id := b[n] >> 4
+			if id == 0 {
+				// Padding.
+				n++
+				continue
+			} else if id == 15 {
+				// Reserved, and stops parsing.
+				return nil
+			}
+
+			length := int(b[n]&0x0f) + 1
+			if n+1+length > len(b) {
+				return ErrShortPacket
+			}
+
+			h.Extensions = append(h.Extensions, Extension{
+				ID:      id,
+				Payload: b[n+1 : n+1+length],
+			})
+			n += 1 + length
+		}
+	case profile&twoByteProfileMask == twoByteProfile:
+		for n := 0; n < len(b); {
+			id := b[n]
+			if id == 0 {
+				n++
+				continue
+			}
+
+			if n+2 > len(b) {
+				return ErrShortPacket
+			}
+
+			length := int(b[n+1])
+			if n+2+length > len(b) {
+				return ErrShortPacket
+			}
+
+			h.Extensions = append(h.Extensions, Extension{
+				ID:      id,
+				Payload: b[n+2 : n+2+length],
+			})
+			n += 2 + length
+		}
+	}
+
+	return nil
+}
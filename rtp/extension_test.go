@@ -0,0 +1,207 @@
+package rtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func roundTrip(t *testing.T, p *Packet) (*Packet, []byte) {
+	t.Helper()
+
+	b, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != p.MarshalSize() {
+		t.Errorf("got %d bytes, expected MarshalSize %d", len(b), p.MarshalSize())
+	}
+
+	decoded := new(Packet)
+	if err := decoded.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded, b
+}
+
+func TestExtensionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []Extension
+		profile    uint16
+		size       int
+	}{
+		{"one byte", []Extension{{1, []byte{0xaa}}}, oneByteProfile, 8},
+		{"one byte, no padding", []Extension{{1, []byte{1, 2, 3}}}, oneByteProfile, 8},
+		{"one byte, longest", []Extension{{14, bytes.Repeat([]byte{1}, 16)},
+			{2, []byte{2, 3}}}, oneByteProfile, 24},
+		// ID 15 is reserved in the one-byte format.
+		{"ID 15", []Extension{{15, []byte{1}}}, twoByteProfile, 8},
+		{"empty payload", []Extension{{1, []byte{}}, {2, []byte{1}}}, twoByteProfile, 12},
+		{"long payload", []Extension{{1, bytes.Repeat([]byte{1}, 17)}}, twoByteProfile, 24},
+		{"large ID", []Extension{{1, []byte{1}}, {200, bytes.Repeat([]byte{2}, 255)}},
+			twoByteProfile, 264},
+	}
+
+	for _, test := range tests {
+		p := &Packet{
+			Header:  Header{PayloadType: 111, SequenceNumber: 1, Extensions: test.extensions},
+			Payload: []byte{1, 2, 3},
+		}
+
+		decoded, b := roundTrip(t, p)
+
+		if profile := binary.BigEndian.Uint16(b[headerLength:]); profile != test.profile {
+			t.Errorf("%s: got profile %#x, expected %#x", test.name, profile, test.profile)
+		}
+		if size := p.extensionSize(); size != test.size {
+			t.Errorf("%s: got extension size %d, expected %d", test.name, size, test.size)
+		}
+
+		if !reflect.DeepEqual(decoded.Extensions, test.extensions) {
+			t.Errorf("%s: got extensions %v, expected %v", test.name, decoded.Extensions,
+				test.extensions)
+		}
+		if !bytes.Equal(decoded.Payload, p.Payload) {
+			t.Errorf("%s: got payload %v, expected %v", test.name, decoded.Payload, p.Payload)
+		}
+	}
+
+	invalid := map[string][]Extension{
+		"ID 0":           {{0, []byte{1}}},
+		"too long":       {{1, make([]byte, maxTwoByteLength+1)}},
+		"ID 0, two byte": {{0, []byte{}}},
+	}
+
+	for name, extensions := range invalid {
+		p := &Packet{Header: Header{Extensions: extensions}}
+		if _, err := p.Marshal(); err != ErrInvalidExtension {
+			t.Errorf("%s: got %v, expected ErrInvalidExtension", name, err)
+		}
+	}
+}
+
+// extensionPacket returns a packet with a header extension of the given
+// profile and elements, which must be a multiple of 4 bytes.
+func extensionPacket(profile uint16, elements ...byte) []byte {
+	b := []byte{0x90, 111, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+		byte(profile >> 8), byte(profile), 0, byte(len(elements) / 4)}
+	b = append(b, elements...)
+
+	return append(b, 0xff)
+}
+
+func TestExtensionUnmarshal(t *testing.T) {
+	tests := []struct {
+		name       string
+		packet     []byte
+		extensions []Extension
+	}{
+		{"one byte padding", extensionPacket(oneByteProfile,
+			0x10, 0xaa, 0, 0,
+			0, 0x21, 0xbb, 0xcc),
+			[]Extension{{1, []byte{0xaa}}, {2, []byte{0xbb, 0xcc}}}},
+		// ID 15 stops parsing, even when followed by valid elements.
+		{"one byte ID 15", extensionPacket(oneByteProfile,
+			0x10, 0xaa, 0xf0, 0x10,
+			0xbb, 0, 0, 0),
+			[]Extension{{1, []byte{0xaa}}}},
+		{"two byte padding", extensionPacket(twoByteProfile,
+			1, 0, 0, 2,
+			1, 0xaa, 0, 0),
+			[]Extension{{1, []byte{}}, {2, []byte{0xaa}}}},
+		// The appbits of the two-byte profile are ignored.
+		{"two byte appbits", extensionPacket(twoByteProfile|0xf,
+			15, 1, 0xaa, 0),
+			[]Extension{{15, []byte{0xaa}}}},
+		{"other profile", extensionPacket(0x1234, 0x10, 0xaa, 0, 0), nil},
+	}
+
+	for _, test := range tests {
+		var p Packet
+		if err := p.Unmarshal(test.packet); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(p.Extensions, test.extensions) {
+			t.Errorf("%s: got extensions %v, expected %v", test.name, p.Extensions,
+				test.extensions)
+		}
+		if !bytes.Equal(p.Payload, []byte{0xff}) {
+			t.Errorf("%s: got payload %v, expected [255]", test.name, p.Payload)
+		}
+	}
+
+	short := map[string][]byte{
+		"one byte element": extensionPacket(oneByteProfile, 0, 0, 0, 0x13),
+		"two byte header":  extensionPacket(twoByteProfile, 0, 0, 0, 1),
+		"two byte element": extensionPacket(twoByteProfile, 1, 3, 0xaa, 0xbb),
+		"extension length": extensionPacket(oneByteProfile, 0x10, 0xaa, 0, 0)[:18],
+	}
+
+	for name, b := range short {
+		var p Packet
+		if err := p.Unmarshal(b); err != ErrShortPacket {
+			t.Errorf("%s: got %v, expected ErrShortPacket", name, err)
+		}
+	}
+}
+
+func TestExtensionAccessors(t *testing.T) {
+	var h Header
+	h.SetExtension(1, []byte{1})
+	h.SetExtension(2, []byte{2})
+	h.SetExtension(1, []byte{3})
+
+	if payload, ok := h.GetExtension(1); !ok || !bytes.Equal(payload, []byte{3}) {
+		t.Errorf("got extension 1 %v, %v, expected [3]", payload, ok)
+	}
+
+	h.DeleteExtension(1)
+	if _, ok := h.GetExtension(1); ok {
+		t.Error("got extension 1 after deleting it")
+	}
+	if !reflect.DeepEqual(h.Extensions, []Extension{{2, []byte{2}}}) {
+		t.Errorf("got extensions %v, expected only extension 2", h.Extensions)
+	}
+}
+
+func TestAudioLevel(t *testing.T) {
+	tests := []struct {
+		level    uint8
+		voice    bool
+		expected uint8
+	}{
+		{0, true, 0},
+		{30, false, 30},
+		{MaxAudioLevel, true, MaxAudioLevel},
+		// Levels quieter than silence are clamped.
+		{128, false, MaxAudioLevel},
+		{255, true, MaxAudioLevel},
+	}
+
+	for _, test := range tests {
+		p := &Packet{Payload: []byte{1}}
+		p.SetAudioLevel(DefaultAudioLevelID, test.level, test.voice)
+
+		decoded, b := roundTrip(t, p)
+		if len(b) != headerLength+8+1 {
+			t.Errorf("level %d: got %d bytes, expected a one-byte extension", test.level, len(b))
+		}
+
+		level, voice, ok := decoded.AudioLevel(DefaultAudioLevelID)
+		if !ok || level != test.expected || voice != test.voice {
+			t.Errorf("level %d: got %d, %v, %v, expected %d, %v, true", test.level, level,
+				voice, ok, test.expected, test.voice)
+		}
+	}
+
+	var h Header
+	if level, voice, ok := h.AudioLevel(DefaultAudioLevelID); ok || voice ||
+		level != MaxAudioLevel {
+		t.Errorf("missing extension: got %d, %v, %v, expected silence", level, voice, ok)
+	}
+}
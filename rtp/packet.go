@@ -0,0 +1,162 @@
+// Package rtp implements the parts of the Real-time Transport Protocol
+// (RFC 3550) used to carry dissonance audio over a network, along with the
+// payload formats built on top of it.
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Version is the RTP version implemented by this package.
+const Version = 2
+
+const headerLength = 12
+
+// ErrShortPacket is returned when a packet is too short to contain the
+// fields its header claims to have.
+var ErrShortPacket = errors.New("rtp: packet is too short")
+
+// ErrInvalidVersion is returned when a packet does not have an RTP version
+// of 2.
+var ErrInvalidVersion = errors.New("rtp: invalid version")
+
+// Header represents the fixed header of an RTP packet, its CSRC list and its
+// header extensions.
+type Header struct {
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	CSRC           []uint32
+	Extensions     []Extension
+}
+
+// Packet represents an RTP packet.
+type Packet struct {
+	Header
+	Payload []byte
+}
+
+// MarshalSize returns the size of the packet once marshalled.
+func (p *Packet) MarshalSize() int {
+	return headerLength + 4*len(p.CSRC) + p.extensionSize() + len(p.Payload)
+}
+
+// Marshal encodes the packet into its wire format.
+func (p *Packet) Marshal() ([]byte, error) {
+	if len(p.CSRC) > 15 {
+		return nil, errors.New("rtp: too many CSRCs")
+	}
+
+	if p.PayloadType > 127 {
+		return nil, errors.New("rtp: payload type out of range")
+	}
+
+	b := make([]byte, p.MarshalSize())
+	b[0] = Version<<6 | uint8(len(p.CSRC))
+	if len(p.Extensions) > 0 {
+		b[0] |= 0x10
+	}
+	b[1] = p.PayloadType
+	if p.Marker {
+		b[1] |= 0x80
+	}
+
+	binary.BigEndian.PutUint16(b[2:], p.SequenceNumber)
+	binary.BigEndian.PutUint32(b[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(b[8:], p.SSRC)
+
+	n := headerLength
+	for _, csrc := range p.CSRC {
+		binary.BigEndian.PutUint32(b[n:], csrc)
+		n += 4
+	}
+
+	if size := p.extensionSize(); size > 0 {
+		if err := p.marshalExtensions(b[n : n+size]); err != nil {
+			return nil, err
+		}
+		n += size
+	}
+
+	copy(b[n:], p.Payload)
+
+	return b, nil
+}
+
+// Unmarshal decodes a packet from its wire format. The payload and extension
+// payloads reference the given buffer rather than being copied.
+func (p *Packet) Unmarshal(b []byte) error {
+	if len(b) < headerLength {
+		return ErrShortPacket
+	}
+
+	if b[0]>>6 != Version {
+		return ErrInvalidVersion
+	}
+
+	padding := b[0]&0x20 != 0
+	extension := b[0]&0x10 != 0
+	csrcCount := int(b[0] & 0x0f)
+
+	p.Marker = b[1]&0x80 != 0
+	p.PayloadType = b[1] & 0x7f
+	p.SequenceNumber = binary.BigEndian.Uint16(b[2:])
+	p.Timestamp = binary.BigEndian.Uint32(b[4:])
+	p.SSRC = binary.BigEndian.Uint32(b[8:])
+
+	n := headerLength
+	if len(b) < n+4*csrcCount {
+		return ErrShortPacket
+	}
+
+	p.CSRC = nil
+	for i := 0; i < csrcCount; i++ {
+		p.CSRC = append(p.CSRC, binary.BigEndian.Uint32(b[n:]))
+		n += 4
+	}
+
+	p.Extensions = nil
+	if extension {
+		if len(b) < n+4 {
+			return ErrShortPacket
+		}
+
+		profile := binary.BigEndian.Uint16(b[n:])
+		end := n + 4 + 4*int(binary.BigEndian.Uint16(b[n+2:]))
+		if len(b) < end {
+			return ErrShortPacket
+		}
+
+		if err := p.unmarshalExtensions(profile, b[n+4:end]); err != nil {
+			return err
+		}
+		n = end
+	}
+
+	end := len(b)
+	if padding {
+		end -= int(b[len(b)-1])
+		if end < n {
+			return ErrShortPacket
+		}
+	}
+
+	p.Payload = b[n:end]
+
+	return nil
+}
+
+// SequenceNewer returns whether sequence number a comes after b, accounting
+// for wrap around.
+func SequenceNewer(a, b uint16) bool {
+	return int16(a-b) > 0
+}
+
+// TimestampNewer returns whether timestamp a comes after b, accounting for
+// wrap around.
+func TimestampNewer(a, b uint32) bool {
+	return int32(a-b) > 0
+}
@@ -0,0 +1,206 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Limits imposed by the RED block header format.
+const (
+	maxREDTimestampOffset = 1<<14 - 1
+	maxREDBlockLength     = 1<<10 - 1
+)
+
+// ErrInvalidRED is returned when a RED payload cannot be parsed.
+var ErrInvalidRED = errors.New("rtp: invalid RED payload")
+
+// Frame represents a single encoded audio frame carried by a packet.
+type Frame struct {
+	PayloadType uint8
+	Timestamp   uint32
+	Data        []byte
+
+	// Recovered is set if the frame was recovered from the redundant
+	// data of a later packet rather than received as a primary frame.
+	Recovered bool
+}
+
+// REDEncoder encodes frames into RED (RFC 2198) payloads, carrying up to
+// a given number of previous frames alongside each new frame.
+type REDEncoder struct {
+	distance int
+	history  []Frame
+}
+
+// NewREDEncoder returns a new RED encoder which carries the previous
+// distance frames in every payload.
+func NewREDEncoder(distance int) *REDEncoder {
+	if distance < 0 {
+		panic("rtp: RED distance must not be negative")
+	}
+
+	return &REDEncoder{distance: distance}
+}
+
+// SetDistance sets the number of previous frames carried in every payload,
+// such as when a congestion controller changes the level of redundancy.
+func (e *REDEncoder) SetDistance(distance int) {
+	if distance < 0 {
+		panic("rtp: RED distance must not be negative")
+	}
+
+	e.distance = distance
+	if len(e.history) > distance {
+		e.history = e.history[len(e.history)-distance:]
+	}
+}
+
+// Encode returns the RED payload for the given primary frame. The returned
+// payload should be sent with the timestamp of the primary frame.
+func (e *REDEncoder) Encode(frame Frame) ([]byte, error) {
+	if frame.PayloadType > 127 {
+		return nil, errors.New("rtp: payload type out of range")
+	}
+
+	var redundant []Frame
+	for _, old := range e.history {
+		offset := frame.Timestamp - old.Timestamp
+		if offset == 0 || offset > maxREDTimestampOffset ||
+			len(old.Data) > maxREDBlockLength {
+			continue
+		}
+
+		redundant = append(redundant, old)
+	}
+
+	size := 1 + len(frame.Data)
+	for _, old := range redundant {
+		size += 4 + len(old.Data)
+	}
+
+	b := make([]byte, 0, size)
+	for _, old := range redundant {
+		offset := frame.Timestamp - old.Timestamp
+		var block [4]byte
+		binary.BigEndian.PutUint32(block[:], uint32(0x80|old.PayloadType)<<24|
+			offset<<10|uint32(len(old.Data)))
+		b = append(b, block[:]...)
+	}
+
+	b = append(b, frame.PayloadType)
+	for _, old := range redundant {
+		b = append(b, old.Data...)
+	}
+	b = append(b, frame.Data...)
+
+	if e.distance > 0 {
+		e.history = append(e.history, Frame{
+			PayloadType: frame.PayloadType,
+			Timestamp:   frame.Timestamp,
+			Data:        append([]byte(nil), frame.Data...),
+		})
+
+		if len(e.history) > e.distance {
+			e.history = e.history[len(e.history)-e.distance:]
+		}
+	}
+
+	return b, nil
+}
+
+// ParseRED parses a RED payload sent with the given timestamp into its
+// frames, from oldest to newest. The last frame is always the primary frame.
+// Frame data references the given payload rather than being copied.
+func ParseRED(timestamp uint32, payload []byte) ([]Frame, error) {
+	type blockHeader struct {
+		payloadType uint8
+		offset      uint32
+		length      int
+	}
+
+	var headers []blockHeader
+	n := 0
+	for {
+		if n >= len(payload) {
+			return nil, ErrInvalidRED
+		}
+
+		if payload[n]&0x80 == 0 {
+			headers = append(headers, blockHeader{
+				payloadType: payload[n] & 0x7f,
+			})
+			n++
+			break
+		}
+
+		if n+4 > len(payload) {
+			return nil, ErrInvalidRED
+		}
+
+		v := binary.BigEndian.Uint32(payload[n:])
+		headers = append(headers, blockHeader{
+			payloadType: uint8(v>>24) & 0x7f,
+			offset:      (v >> 10) & maxREDTimestampOffset,
+			length:      int(v & maxREDBlockLength),
+		})
+		n += 4
+	}
+
+	frames := make([]Frame, len(headers))
+	for i, h := range headers {
+		length := h.length
+		if i == len(headers)-1 {
+			length = len(payload) - n
+		}
+
+		if length < 0 || n+length > len(payload) {
+			return nil, ErrInvalidRED
+		}
+
+		frames[i] = Frame{
+			PayloadType: h.payloadType,
+			Timestamp:   timestamp - h.offset,
+			Data:        payload[n : n+length],
+		}
+		n += length
+	}
+
+	return frames, nil
+}
+
+// REDDecoder recovers frames from a sequence of received RED payloads,
+// using redundant data to fill in frames that were lost in transit.
+type REDDecoder struct {
+	started bool
+	last    uint32
+}
+
+// NewREDDecoder returns a new RED decoder.
+func NewREDDecoder() *REDDecoder {
+	return &REDDecoder{}
+}
+
+// Decode parses the payload of a received RED packet and returns the frames
+// that have not already been returned, from oldest to newest, ready to be
+// handed to a decoder. Frames older than those already returned, such as
+// those of reordered packets, are dropped.
+func (d *REDDecoder) Decode(p *Packet) ([]Frame, error) {
+	frames, err := ParseRED(p.Timestamp, p.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Frame
+	for i, frame := range frames {
+		if d.started && !TimestampNewer(frame.Timestamp, d.last) {
+			continue
+		}
+
+		frame.Recovered = i != len(frames)-1
+		result = append(result, frame)
+		d.started = true
+		d.last = frame.Timestamp
+	}
+
+	return result, nil
+}
@@ -0,0 +1,248 @@
+package rtp
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+const (
+	testPayloadType = 111
+	testFrameLength = 960
+)
+
+func testFrame(i int) Frame {
+	return Frame{
+		PayloadType: testPayloadType,
+		Timestamp:   uint32(i * testFrameLength),
+		Data:        bytes.Repeat([]byte{byte(i)}, 10+i),
+	}
+}
+
+// encodeRED encodes count frames with the given distance, and returns the
+// packets carrying them.
+func encodeRED(t *testing.T, distance, count int) []*Packet {
+	t.Helper()
+
+	encoder := NewREDEncoder(distance)
+	packets := make([]*Packet, count)
+	for i := range packets {
+		frame := testFrame(i)
+		payload, err := encoder.Encode(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		packets[i] = &Packet{
+			Header: Header{
+				SequenceNumber: uint16(i),
+				Timestamp:      frame.Timestamp,
+			},
+			Payload: payload,
+		}
+	}
+
+	return packets
+}
+
+// checkFrames checks that frames are the test frames with the given
+// indexes, and whether each is expected to be recovered.
+func checkFrames(t *testing.T, name string, frames []Frame, indexes []int,
+	recovered []bool) {
+	t.Helper()
+
+	if len(frames) != len(indexes) {
+		t.Errorf("%s: got %d frames, expected %d", name, len(frames), len(indexes))
+		return
+	}
+
+	for i, frame := range frames {
+		expected := testFrame(indexes[i])
+		if frame.PayloadType != expected.PayloadType ||
+			frame.Timestamp != expected.Timestamp ||
+			!bytes.Equal(frame.Data, expected.Data) {
+			t.Errorf("%s: frame %d: got %+v, expected %+v", name, i, frame, expected)
+		}
+
+		if frame.Recovered != recovered[i] {
+			t.Errorf("%s: frame %d: got recovered %v, expected %v", name, i,
+				frame.Recovered, recovered[i])
+		}
+	}
+}
+
+func TestParseRED(t *testing.T) {
+	packets := encodeRED(t, 2, 4)
+
+	// The first packets carry as many previous frames as there are.
+	for i, p := range packets {
+		frames, err := ParseRED(p.Timestamp, p.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first := i - 2
+		if first < 0 {
+			first = 0
+		}
+
+		var indexes []int
+		for j := first; j <= i; j++ {
+			indexes = append(indexes, j)
+		}
+		checkFrames(t, "packet", frames, indexes, make([]bool, len(indexes)))
+	}
+
+	// Without redundancy, the payload is the primary frame after a one
+	// byte header.
+	encoder := NewREDEncoder(0)
+	for i := 0; i < 2; i++ {
+		payload, err := encoder.Encode(testFrame(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payload) != 1+len(testFrame(i).Data) || payload[0] != testPayloadType {
+			t.Errorf("distance 0: got payload %v", payload)
+		}
+	}
+}
+
+func TestREDTimestampOffsets(t *testing.T) {
+	encoder := NewREDEncoder(3)
+	frames := []Frame{
+		{PayloadType: 0, Timestamp: 0xfffffff0, Data: []byte{1}},
+		// Too old to be carried, as its offset doesn't fit in 14 bits.
+		{PayloadType: 1, Timestamp: 0xfffffff8 - maxREDTimestampOffset, Data: []byte{2}},
+		// Too long to be carried.
+		{PayloadType: 2, Timestamp: 0xfffffff8, Data: make([]byte, maxREDBlockLength+1)},
+		// The timestamp wraps around.
+		{PayloadType: 3, Timestamp: 0x10, Data: []byte{3}},
+	}
+
+	var payload []byte
+	for _, frame := range frames {
+		var err error
+		if payload, err = encoder.Encode(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parsed, err := ParseRED(0x10, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Frame{frames[0], frames[3]}
+	if len(parsed) != len(expected) {
+		t.Fatalf("got %d frames, expected %d", len(parsed), len(expected))
+	}
+
+	for i, frame := range parsed {
+		if frame.PayloadType != expected[i].PayloadType ||
+			frame.Timestamp != expected[i].Timestamp ||
+			!bytes.Equal(frame.Data, expected[i].Data) {
+			t.Errorf("frame %d: got %+v, expected %+v", i, frame, expected[i])
+		}
+	}
+
+	if _, err := encoder.Encode(Frame{PayloadType: 128}); err == nil {
+		t.Error("encoded a payload type out of range")
+	}
+}
+
+func TestREDDecoder(t *testing.T) {
+	packets := encodeRED(t, 2, 8)
+
+	tests := []struct {
+		name      string
+		packet    int
+		indexes   []int
+		recovered []bool
+	}{
+		{"first", 0, []int{0}, []bool{false}},
+		{"in order", 1, []int{1}, []bool{false}},
+		// Packet 2 is lost, and its frame is recovered from packet 3.
+		{"after a loss", 3, []int{2, 3}, []bool{true, false}},
+		{"duplicate", 3, nil, nil},
+		// Packets 4 and 5 are reordered.
+		{"early", 5, []int{4, 5}, []bool{true, false}},
+		{"late", 4, nil, nil},
+		// Packet 6 is lost, but the frame was already recovered.
+		{"after a loss", 7, []int{6, 7}, []bool{true, false}},
+	}
+
+	decoder := NewREDDecoder()
+	for _, test := range tests {
+		frames, err := decoder.Decode(packets[test.packet])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkFrames(t, test.name, frames, test.indexes, test.recovered)
+	}
+
+	// Frames lost further back than the distance can't be recovered, so
+	// frame 1 is missing after a burst of three losses.
+	decoder = NewREDDecoder()
+	for _, test := range []struct {
+		packet    int
+		indexes   []int
+		recovered []bool
+	}{
+		{0, []int{0}, []bool{false}},
+		{4, []int{2, 3, 4}, []bool{true, true, false}},
+	} {
+		frames, err := decoder.Decode(packets[test.packet])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkFrames(t, "burst", frames, test.indexes, test.recovered)
+	}
+}
+
+func TestMalformedRED(t *testing.T) {
+	tests := map[string][]byte{
+		"empty": {},
+		// The block header is cut short.
+		"short header": {0x80 | testPayloadType, 0, 0},
+		// No primary header follows the redundant block's header.
+		"no primary": {0x80 | testPayloadType, 0, 0x0c, 0x02},
+		// The redundant block is longer than the payload.
+		"long block": {0x80 | testPayloadType, 0, 0x0c, 0x05, testPayloadType, 1, 2},
+	}
+
+	for name, payload := range tests {
+		if _, err := ParseRED(0, payload); err != ErrInvalidRED {
+			t.Errorf("%s: got %v, expected ErrInvalidRED", name, err)
+		}
+
+		if _, err := NewREDDecoder().Decode(&Packet{Payload: payload}); err != ErrInvalidRED {
+			t.Errorf("%s: decoder got %v, expected ErrInvalidRED", name, err)
+		}
+	}
+
+	// Random payloads must be rejected or parsed within their bounds, and
+	// never panic.
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		payload := make([]byte, r.Intn(32))
+		r.Read(payload)
+		if r.Intn(2) == 0 && len(payload) > 0 {
+			payload[0] |= 0x80
+		}
+
+		frames, err := ParseRED(0, payload)
+		if err != nil {
+			continue
+		}
+
+		total := len(frames)
+		for _, frame := range frames {
+			total += len(frame.Data)
+		}
+		if total > len(payload) {
+			t.Fatalf("payload %v: got frames %+v longer than the payload", payload, frames)
+		}
+	}
+}
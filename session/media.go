@@ -0,0 +1,96 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/1lann/dissonance/srtp"
+)
+
+// mkiLength is the length of the MKI of media packets, which holds the
+// epoch of the keys they're protected with.
+const mkiLength = 4
+
+// ErrUnknownEpoch is returned if a media packet is protected with keys of an
+// epoch which is no longer, or not yet, accepted.
+var ErrUnknownEpoch = errors.New("session: media keys of unknown epoch")
+
+// MediaReceiver represents the receiving side of media from the peer. Each
+// packet is unprotected with the keys of the epoch given by its MKI, so
+// packets are accepted across a rekey: those protected with the previous
+// keys which were still in flight, and those protected with the next keys
+// which arrive before the peer's rekey message is read.
+type MediaReceiver struct {
+	session   *Session
+	contexts  map[uint32]*srtp.Context
+	usageLock *sync.Mutex
+}
+
+// MediaReceiver returns a new receiver for media from the peer.
+func (s *Session) MediaReceiver() *MediaReceiver {
+	return &MediaReceiver{
+		session:   s,
+		contexts:  make(map[uint32]*srtp.Context),
+		usageLock: new(sync.Mutex),
+	}
+}
+
+// context returns the SRTP context for the epoch of a packet.
+func (m *MediaReceiver) context(packet []byte) (*srtp.Context, error) {
+	mki, err := srtp.PacketMKI(m.session.profile(), packet, mkiLength)
+	if err != nil {
+		return nil, err
+	}
+	epoch := binary.BigEndian.Uint32(mki)
+
+	m.usageLock.Lock()
+	defer m.usageLock.Unlock()
+
+	// Contexts of epochs before the previous one are no longer accepted.
+	current := m.session.remoteEpoch()
+	for e := range m.contexts {
+		if e+1 < current {
+			delete(m.contexts, e)
+		}
+	}
+
+	if c, found := m.contexts[epoch]; found {
+		return c, nil
+	}
+
+	keys, ok := m.session.remoteMediaKeys(epoch)
+	if !ok {
+		return nil, ErrUnknownEpoch
+	}
+
+	c, err := keys.Context()
+	if err != nil {
+		return nil, err
+	}
+
+	m.contexts[epoch] = c
+	return c, nil
+}
+
+// UnprotectRTP authenticates and decrypts an SRTP packet from the peer,
+// returning the marshalled RTP packet.
+func (m *MediaReceiver) UnprotectRTP(packet []byte) ([]byte, error) {
+	c, err := m.context(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.UnprotectRTP(packet)
+}
+
+// UnprotectRTCP authenticates and decrypts an SRTCP packet from the peer,
+// returning the marshalled RTCP packet.
+func (m *MediaReceiver) UnprotectRTCP(packet []byte) ([]byte, error) {
+	c, err := m.context(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.UnprotectRTCP(packet)
+}
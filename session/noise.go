@@ -0,0 +1,389 @@
+package session
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// protocolName is the full name of the Noise protocol used for the handshake.
+// It is exactly one hash length long, so is used as the initial hash as is.
+const protocolName = "Noise_XX_25519_ChaChaPoly_SHA256"
+
+// prologue binds the handshake to dissonance sessions.
+const prologue = "dissonance session v1"
+
+const (
+	keyLength  = 32
+	hashLength = sha256.Size
+)
+
+// ErrNonceExhausted is returned when a cipher has encrypted the maximum
+// number of messages allowed and must be rekeyed.
+var ErrNonceExhausted = errors.New("session: nonce exhausted")
+
+// ErrDecrypt is returned when a message fails to authenticate.
+var ErrDecrypt = errors.New("session: failed to decrypt message")
+
+// KeyPair represents a Curve25519 key pair.
+type KeyPair struct {
+	Public  [32]byte
+	Private [32]byte
+}
+
+// GenerateKeyPair returns a new random Curve25519 key pair, suitable for use
+// as a static key.
+func GenerateKeyPair() (KeyPair, error) {
+	var kp KeyPair
+	if _, err := rand.Read(kp.Private[:]); err != nil {
+		return KeyPair{}, err
+	}
+
+	pub, err := curve25519.X25519(kp.Private[:], curve25519.Basepoint)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	copy(kp.Public[:], pub)
+
+	return kp, nil
+}
+
+func dh(kp KeyPair, pub [32]byte) ([]byte, error) {
+	return curve25519.X25519(kp.Private[:], pub[:])
+}
+
+// hkdf implements the HKDF function defined by the Noise specification,
+// returning two outputs.
+func hkdf(chainingKey, ikm []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, chainingKey)
+	mac.Write(ikm)
+	tempKey := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, tempKey)
+	mac.Write([]byte{0x01})
+	out1 := mac.Sum(nil)
+
+	mac.Reset()
+	mac.Write(out1)
+	mac.Write([]byte{0x02})
+	out2 := mac.Sum(nil)
+
+	return out1, out2
+}
+
+// cipherState represents a Noise CipherState.
+type cipherState struct {
+	aead  cipher.AEAD
+	key   []byte
+	nonce uint64
+}
+
+func (c *cipherState) initializeKey(key []byte) {
+	c.key = key
+	c.nonce = 0
+	// chacha20poly1305.New only fails on invalid key lengths.
+	c.aead, _ = chacha20poly1305.New(key)
+}
+
+func (c *cipherState) hasKey() bool {
+	return c.aead != nil
+}
+
+func nonceBytes(n uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], n)
+	return nonce
+}
+
+func (c *cipherState) encrypt(ad, plaintext []byte) ([]byte, error) {
+	if !c.hasKey() {
+		return plaintext, nil
+	}
+
+	if c.nonce == math.MaxUint64 {
+		return nil, ErrNonceExhausted
+	}
+
+	out := c.aead.Seal(nil, nonceBytes(c.nonce), plaintext, ad)
+	c.nonce++
+
+	return out, nil
+}
+
+func (c *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if !c.hasKey() {
+		return ciphertext, nil
+	}
+
+	if c.nonce == math.MaxUint64 {
+		return nil, ErrNonceExhausted
+	}
+
+	out, err := c.aead.Open(nil, nonceBytes(c.nonce), ciphertext, ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	c.nonce++
+
+	return out, nil
+}
+
+// rekey replaces the key with a new key derived from the current key, as
+// described in section 11.3 of the Noise specification. The nonce is left
+// unchanged.
+func (c *cipherState) rekey() {
+	out := c.aead.Seal(nil, nonceBytes(math.MaxUint64), make([]byte, keyLength), nil)
+	nonce := c.nonce
+	c.initializeKey(out[:keyLength])
+	c.nonce = nonce
+}
+
+// symmetricState represents a Noise SymmetricState.
+type symmetricState struct {
+	cipherState
+	chainingKey []byte
+	hash        []byte
+}
+
+func newSymmetricState() *symmetricState {
+	s := &symmetricState{
+		hash: []byte(protocolName),
+	}
+	s.chainingKey = s.hash
+	s.mixHash([]byte(prologue))
+
+	return s
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	var tempKey []byte
+	s.chainingKey, tempKey = hkdf(s.chainingKey, ikm)
+	s.initializeKey(tempKey)
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.hash)
+	h.Write(data)
+	s.hash = h.Sum(nil)
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) ([]byte, error) {
+	out, err := s.encrypt(s.hash, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mixHash(out)
+	return out, nil
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	out, err := s.decrypt(s.hash, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mixHash(ciphertext)
+	return out, nil
+}
+
+func (s *symmetricState) split() (*cipherState, *cipherState) {
+	k1, k2 := hkdf(s.chainingKey, nil)
+	c1, c2 := new(cipherState), new(cipherState)
+	c1.initializeKey(k1)
+	c2.initializeKey(k2)
+
+	return c1, c2
+}
+
+// handshakeState represents a Noise HandshakeState for the XX pattern:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+type handshakeState struct {
+	*symmetricState
+	initiator bool
+	static    KeyPair
+	ephemeral KeyPair
+	remoteE   [32]byte
+	remoteS   [32]byte
+}
+
+func newHandshakeState(initiator bool, static KeyPair) *handshakeState {
+	return &handshakeState{
+		symmetricState: newSymmetricState(),
+		initiator:      initiator,
+		static:         static,
+	}
+}
+
+func (h *handshakeState) mixDH(kp KeyPair, pub [32]byte) error {
+	secret, err := dh(kp, pub)
+	if err != nil {
+		return err
+	}
+
+	h.mixKey(secret)
+	return nil
+}
+
+func (h *handshakeState) writeEphemeral() ([]byte, error) {
+	var err error
+	h.ephemeral, err = GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	h.mixHash(h.ephemeral.Public[:])
+	return h.ephemeral.Public[:], nil
+}
+
+func (h *handshakeState) readEphemeral(msg []byte) ([]byte, error) {
+	if len(msg) < keyLength {
+		return nil, ErrHandshake
+	}
+
+	copy(h.remoteE[:], msg)
+	h.mixHash(h.remoteE[:])
+
+	return msg[keyLength:], nil
+}
+
+func (h *handshakeState) writeStatic() ([]byte, error) {
+	return h.encryptAndHash(h.static.Public[:])
+}
+
+func (h *handshakeState) readStatic(msg []byte) ([]byte, error) {
+	length := keyLength
+	if h.hasKey() {
+		length += chacha20poly1305.Overhead
+	}
+
+	if len(msg) < length {
+		return nil, ErrHandshake
+	}
+
+	pub, err := h.decryptAndHash(msg[:length])
+	if err != nil {
+		return nil, err
+	}
+	copy(h.remoteS[:], pub)
+
+	return msg[length:], nil
+}
+
+// writeMessage1 writes "-> e".
+func (h *handshakeState) writeMessage1(payload []byte) ([]byte, error) {
+	msg, err := h.writeEphemeral()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := h.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(msg, out...), nil
+}
+
+func (h *handshakeState) readMessage1(msg []byte) ([]byte, error) {
+	msg, err := h.readEphemeral(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.decryptAndHash(msg)
+}
+
+// writeMessage2 writes "<- e, ee, s, es".
+func (h *handshakeState) writeMessage2(payload []byte) ([]byte, error) {
+	msg, err := h.writeEphemeral()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.mixDH(h.ephemeral, h.remoteE); err != nil {
+		return nil, err
+	}
+
+	s, err := h.writeStatic()
+	if err != nil {
+		return nil, err
+	}
+	msg = append(msg, s...)
+
+	if err := h.mixDH(h.static, h.remoteE); err != nil {
+		return nil, err
+	}
+
+	out, err := h.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(msg, out...), nil
+}
+
+func (h *handshakeState) readMessage2(msg []byte) ([]byte, error) {
+	msg, err := h.readEphemeral(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.mixDH(h.ephemeral, h.remoteE); err != nil {
+		return nil, err
+	}
+
+	msg, err = h.readStatic(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.mixDH(h.ephemeral, h.remoteS); err != nil {
+		return nil, err
+	}
+
+	return h.decryptAndHash(msg)
+}
+
+// writeMessage3 writes "-> s, se".
+func (h *handshakeState) writeMessage3(payload []byte) ([]byte, error) {
+	msg, err := h.writeStatic()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.mixDH(h.static, h.remoteE); err != nil {
+		return nil, err
+	}
+
+	out, err := h.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(msg, out...), nil
+}
+
+func (h *handshakeState) readMessage3(msg []byte) ([]byte, error) {
+	msg, err := h.readStatic(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.mixDH(h.ephemeral, h.remoteS); err != nil {
+		return nil, err
+	}
+
+	return h.decryptAndHash(msg)
+}
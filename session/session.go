@@ -0,0 +1,484 @@
+// Package session implements end-to-end encrypted call sessions between two
+// dissonance peers. Peers authenticate each other's static keys and agree on
+// keys with a Noise_XX handshake over a reliable control channel, such as a
+// TCP connection, without the need for a PKI. The session then carries
+// encrypted control messages and provides the SRTP keys used to protect
+// media in each direction, which can be rotated during long calls.
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/1lann/dissonance/srtp"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// maxMessageLength is the maximum length of a Noise message on the wire.
+const maxMessageLength = 65535
+
+// MaxPayloadLength is the maximum length of a payload that can be written
+// with WriteMessage.
+const MaxPayloadLength = maxMessageLength - chacha20poly1305.Overhead - 1
+
+// Message types of transport messages.
+const (
+	messageData = iota
+	messageRekey
+)
+
+// ErrHandshake is returned if the handshake fails due to a malformed
+// message.
+var ErrHandshake = errors.New("session: handshake failed")
+
+// ErrUnknownPeer is returned if the peer's static key is not in the list of
+// known peers.
+var ErrUnknownPeer = errors.New("session: unknown peer")
+
+// ErrMessageTooLarge is returned if a payload is too large to be written.
+var ErrMessageTooLarge = errors.New("session: message too large")
+
+// KnownPeers represents a list of trusted peer static public keys.
+type KnownPeers struct {
+	peers     map[[32]byte]string
+	usageLock *sync.Mutex
+}
+
+// NewKnownPeers returns a new, empty list of known peers.
+func NewKnownPeers() *KnownPeers {
+	return &KnownPeers{
+		peers:     make(map[[32]byte]string),
+		usageLock: new(sync.Mutex),
+	}
+}
+
+// Add adds a peer's static public key to the list of known peers under the
+// given name.
+func (k *KnownPeers) Add(name string, key [32]byte) {
+	k.usageLock.Lock()
+	defer k.usageLock.Unlock()
+
+	k.peers[key] = name
+}
+
+// Remove removes a peer's static public key from the list of known peers.
+func (k *KnownPeers) Remove(key [32]byte) {
+	k.usageLock.Lock()
+	defer k.usageLock.Unlock()
+
+	delete(k.peers, key)
+}
+
+// Lookup returns the name of the peer with the given static public key, and
+// whether the peer is known.
+func (k *KnownPeers) Lookup(key [32]byte) (string, bool) {
+	k.usageLock.Lock()
+	defer k.usageLock.Unlock()
+
+	name, found := k.peers[key]
+	return name, found
+}
+
+// Config represents the configuration of a session.
+type Config struct {
+	// StaticKey is the long term identity of the local peer.
+	StaticKey KeyPair
+
+	// KnownPeers is the list of peers that are allowed to connect.
+	KnownPeers *KnownPeers
+
+	// Profile is the SRTP protection profile media keys are derived for.
+	// It defaults to srtp.ProfileAEADAES128GCM.
+	Profile srtp.ProtectionProfile
+
+	// OnRekey, if set, is called from ReadMessage with the new remote media
+	// keys when the peer rotates its keys.
+	OnRekey func(remote MediaKeys)
+}
+
+// MediaKeys represents the SRTP master key and salt for media sent in one
+// direction of a session. Epoch counts the rekeys the keys come from, and
+// is carried in each packet as its MKI.
+type MediaKeys struct {
+	Epoch   uint32
+	Profile srtp.ProtectionProfile
+	Key     []byte
+	Salt    []byte
+}
+
+// MKI returns the master key identifier of packets protected with the media
+// keys, which is their epoch as a big endian uint32.
+func (m MediaKeys) MKI() []byte {
+	mki := make([]byte, mkiLength)
+	binary.BigEndian.PutUint32(mki, m.Epoch)
+	return mki
+}
+
+// Context returns a new SRTP context using the media keys, which adds their
+// MKI to the packets it protects.
+func (m MediaKeys) Context() (*srtp.Context, error) {
+	c, err := srtp.NewContext(m.Profile, m.Key, m.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetMKI(m.MKI())
+	return c, nil
+}
+
+// Session represents an established session with a peer.
+type Session struct {
+	conn      io.ReadWriter
+	config    *Config
+	initiator bool
+	peerKey   [32]byte
+	peerName  string
+
+	send      *cipherState
+	writeLock *sync.Mutex
+	recv      *cipherState
+	readLock  *sync.Mutex
+
+	sendSecret     []byte
+	sendEpoch      uint32
+	recvSecret     []byte
+	recvEpoch      uint32
+	prevRecvSecret []byte // the secret of the epoch before recvEpoch
+	secretsLock    *sync.Mutex
+}
+
+func writeFrame(wr io.Writer, msg []byte) error {
+	if len(msg) > maxMessageLength {
+		return ErrMessageTooLarge
+	}
+
+	b := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	copy(b[2:], msg)
+
+	_, err := wr.Write(b)
+	return err
+}
+
+func readFrame(rd io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(rd, length[:]); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(rd, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func newSession(conn io.ReadWriter, config *Config, initiator bool) *Session {
+	if config.KnownPeers == nil {
+		panic("session: KnownPeers must be set")
+	}
+
+	return &Session{
+		conn:        conn,
+		config:      config,
+		initiator:   initiator,
+		writeLock:   new(sync.Mutex),
+		readLock:    new(sync.Mutex),
+		secretsLock: new(sync.Mutex),
+	}
+}
+
+// Client performs the handshake as the initiator over the given control
+// channel and returns the established session.
+func Client(conn io.ReadWriter, config *Config) (*Session, error) {
+	s := newSession(conn, config, true)
+	hs := newHandshakeState(true, config.StaticKey)
+
+	msg, err := hs.writeMessage1(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFrame(conn, msg); err != nil {
+		return nil, err
+	}
+
+	msg, err = readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := hs.readMessage2(msg); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyPeer(hs.remoteS); err != nil {
+		return nil, err
+	}
+
+	msg, err = hs.writeMessage3(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFrame(conn, msg); err != nil {
+		return nil, err
+	}
+
+	s.finish(hs)
+	return s, nil
+}
+
+// Server performs the handshake as the responder over the given control
+// channel and returns the established session.
+func Server(conn io.ReadWriter, config *Config) (*Session, error) {
+	s := newSession(conn, config, false)
+	hs := newHandshakeState(false, config.StaticKey)
+
+	msg, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := hs.readMessage1(msg); err != nil {
+		return nil, err
+	}
+
+	msg, err = hs.writeMessage2(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFrame(conn, msg); err != nil {
+		return nil, err
+	}
+
+	msg, err = readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := hs.readMessage3(msg); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyPeer(hs.remoteS); err != nil {
+		return nil, err
+	}
+
+	s.finish(hs)
+	return s, nil
+}
+
+func (s *Session) verifyPeer(key [32]byte) error {
+	name, found := s.config.KnownPeers.Lookup(key)
+	if !found {
+		return ErrUnknownPeer
+	}
+
+	s.peerKey = key
+	s.peerName = name
+	return nil
+}
+
+func (s *Session) finish(hs *handshakeState) {
+	initiatorToResponder, responderToInitiator := hs.split()
+	if s.initiator {
+		s.send, s.recv = initiatorToResponder, responderToInitiator
+	} else {
+		s.send, s.recv = responderToInitiator, initiatorToResponder
+	}
+
+	// Media secrets are bound to the whole handshake, and are independent
+	// from the keys used for control messages.
+	initiatorSecret, responderSecret := hkdf(hs.chainingKey, hs.hash)
+	if s.initiator {
+		s.sendSecret, s.recvSecret = initiatorSecret, responderSecret
+	} else {
+		s.sendSecret, s.recvSecret = responderSecret, initiatorSecret
+	}
+}
+
+// PeerKey returns the static public key of the peer.
+func (s *Session) PeerKey() [32]byte {
+	return s.peerKey
+}
+
+// PeerName returns the name the peer's static key is known by.
+func (s *Session) PeerName() string {
+	return s.peerName
+}
+
+// WriteMessage encrypts and writes a control message to the peer.
+func (s *Session) WriteMessage(payload []byte) error {
+	if len(payload) > MaxPayloadLength {
+		return ErrMessageTooLarge
+	}
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	return s.write(messageData, payload)
+}
+
+func (s *Session) write(msgType byte, payload []byte) error {
+	msg, err := s.send.encrypt(nil, append([]byte{msgType}, payload...))
+	if err != nil {
+		return err
+	}
+
+	return writeFrame(s.conn, msg)
+}
+
+// ReadMessage reads and decrypts the next control message from the peer.
+// Rekey messages from the peer are handled internally.
+func (s *Session) ReadMessage() ([]byte, error) {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	for {
+		msg, err := readFrame(s.conn)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err = s.recv.decrypt(nil, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(msg) == 0 {
+			return nil, ErrDecrypt
+		}
+
+		switch msg[0] {
+		case messageData:
+			return msg[1:], nil
+		case messageRekey:
+			s.recv.rekey()
+
+			s.secretsLock.Lock()
+			s.prevRecvSecret = s.recvSecret
+			s.recvSecret = ratchet(s.recvSecret)
+			s.recvEpoch++
+			s.secretsLock.Unlock()
+
+			if s.config.OnRekey != nil {
+				s.config.OnRekey(s.RemoteMediaKeys())
+			}
+		default:
+			return nil, errors.New("session: unknown message type")
+		}
+	}
+}
+
+// Rekey rotates the keys used to send control messages and media to the
+// peer, and returns the new local media keys. The peer is notified so it can
+// switch to the new keys, and the previous local media keys should no longer
+// be used once Rekey returns. A MediaReceiver at the peer accepts packets
+// protected with either while the rekey is in progress.
+func (s *Session) Rekey() (MediaKeys, error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if err := s.write(messageRekey, nil); err != nil {
+		return MediaKeys{}, err
+	}
+
+	s.send.rekey()
+
+	s.secretsLock.Lock()
+	s.sendSecret = ratchet(s.sendSecret)
+	s.sendEpoch++
+	s.secretsLock.Unlock()
+
+	return s.LocalMediaKeys(), nil
+}
+
+// LocalMediaKeys returns the current keys for media sent to the peer.
+func (s *Session) LocalMediaKeys() MediaKeys {
+	s.secretsLock.Lock()
+	defer s.secretsLock.Unlock()
+
+	return s.mediaKeys(s.sendSecret, s.sendEpoch)
+}
+
+// RemoteMediaKeys returns the current keys for media received from the peer.
+func (s *Session) RemoteMediaKeys() MediaKeys {
+	s.secretsLock.Lock()
+	defer s.secretsLock.Unlock()
+
+	return s.mediaKeys(s.recvSecret, s.recvEpoch)
+}
+
+// ratchet returns the media secret for the next epoch. Secrets of previous
+// epochs cannot be derived from it.
+func ratchet(secret []byte) []byte {
+	next, _ := hkdf(secret, []byte("dissonance media rekey"))
+	return next
+}
+
+// remoteEpoch returns the current epoch of media received from the peer.
+func (s *Session) remoteEpoch() uint32 {
+	s.secretsLock.Lock()
+	defer s.secretsLock.Unlock()
+
+	return s.recvEpoch
+}
+
+// remoteMediaKeys returns the keys for media received from the peer in an
+// epoch, which may be the current epoch or the ones either side of it.
+func (s *Session) remoteMediaKeys(epoch uint32) (MediaKeys, bool) {
+	s.secretsLock.Lock()
+	defer s.secretsLock.Unlock()
+
+	switch {
+	case epoch == s.recvEpoch:
+		return s.mediaKeys(s.recvSecret, epoch), true
+	case epoch == s.recvEpoch+1:
+		return s.mediaKeys(ratchet(s.recvSecret), epoch), true
+	case epoch+1 == s.recvEpoch && s.prevRecvSecret != nil:
+		return s.mediaKeys(s.prevRecvSecret, epoch), true
+	}
+
+	return MediaKeys{}, false
+}
+
+// profile returns the SRTP protection profile of the media keys.
+func (s *Session) profile() srtp.ProtectionProfile {
+	if s.config.Profile == 0 {
+		return srtp.ProfileAEADAES128GCM
+	}
+
+	return s.config.Profile
+}
+
+// mediaKeys expands a media secret into the key and salt for the protection
+// profile.
+func (s *Session) mediaKeys(secret []byte, epoch uint32) MediaKeys {
+	profile := s.profile()
+
+	// HKDF-Expand with the media secret as the pseudorandom key.
+	length := profile.KeyLength() + profile.SaltLength()
+	var material, block []byte
+	for i := byte(1); len(material) < length; i++ {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(block)
+		mac.Write([]byte("dissonance media keys"))
+		mac.Write([]byte{i})
+		block = mac.Sum(nil)
+		material = append(material, block...)
+	}
+
+	return MediaKeys{
+		Epoch:   epoch,
+		Profile: profile,
+		Key:     material[:profile.KeyLength()],
+		Salt:    material[profile.KeyLength():length],
+	}
+}
@@ -0,0 +1,360 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func generateKey(t *testing.T) KeyPair {
+	t.Helper()
+
+	kp, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kp
+}
+
+// newConfigs returns the configs of two peers which know each other.
+func newConfigs(t *testing.T) (*Config, *Config) {
+	t.Helper()
+
+	client := &Config{StaticKey: generateKey(t), KnownPeers: NewKnownPeers()}
+	server := &Config{StaticKey: generateKey(t), KnownPeers: NewKnownPeers()}
+	client.KnownPeers.Add("server", server.StaticKey.Public)
+	server.KnownPeers.Add("client", client.StaticKey.Public)
+
+	return client, server
+}
+
+type result struct {
+	session *Session
+	err     error
+}
+
+// handshake runs a handshake between a client and a server over the given
+// connections, and returns the outcome of each side.
+func handshake(clientConn, serverConn net.Conn, client, server *Config) (result, result) {
+	results := make(chan result, 1)
+	go func() {
+		s, err := Server(serverConn, server)
+		if err != nil {
+			// Unblock the client if it's waiting for a message.
+			serverConn.Close()
+		}
+		results <- result{s, err}
+	}()
+
+	s, err := Client(clientConn, client)
+	if err != nil {
+		clientConn.Close()
+	}
+
+	return result{s, err}, <-results
+}
+
+func pipe(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	return a, b
+}
+
+func connect(t *testing.T, client, server *Config) (*Session, *Session) {
+	t.Helper()
+
+	clientConn, serverConn := pipe(t)
+	c, s := handshake(clientConn, serverConn, client, server)
+	if c.err != nil || s.err != nil {
+		t.Fatalf("handshake failed: client %v, server %v", c.err, s.err)
+	}
+
+	return c.session, s.session
+}
+
+// exchange writes a message from one session and reads it at another.
+func exchange(t *testing.T, from, to *Session, payload []byte) {
+	t.Helper()
+
+	errs := make(chan error, 1)
+	go func() { errs <- from.WriteMessage(payload) }()
+
+	msg, err := to.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(msg, payload) {
+		t.Errorf("got message %q, expected %q", msg, payload)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	clientConfig, serverConfig := newConfigs(t)
+	client, server := connect(t, clientConfig, serverConfig)
+
+	if client.PeerName() != "server" || client.PeerKey() != serverConfig.StaticKey.Public {
+		t.Errorf("client got peer %q, expected server", client.PeerName())
+	}
+	if server.PeerName() != "client" || server.PeerKey() != clientConfig.StaticKey.Public {
+		t.Errorf("server got peer %q, expected client", server.PeerName())
+	}
+
+	if !reflect.DeepEqual(client.LocalMediaKeys(), server.RemoteMediaKeys()) {
+		t.Error("client's local media keys differ from server's remote media keys")
+	}
+	if !reflect.DeepEqual(server.LocalMediaKeys(), client.RemoteMediaKeys()) {
+		t.Error("server's local media keys differ from client's remote media keys")
+	}
+	if bytes.Equal(client.LocalMediaKeys().Key, client.RemoteMediaKeys().Key) {
+		t.Error("both directions use the same media key")
+	}
+
+	exchange(t, client, server, []byte("hello server"))
+	exchange(t, server, client, []byte("hello client"))
+}
+
+func TestUnknownPeer(t *testing.T) {
+	clientConfig, serverConfig := newConfigs(t)
+	serverConfig.KnownPeers.Remove(clientConfig.StaticKey.Public)
+
+	clientConn, serverConn := pipe(t)
+	if _, s := handshake(clientConn, serverConn, clientConfig, serverConfig); s.err != ErrUnknownPeer {
+		t.Errorf("server: got %v, expected ErrUnknownPeer", s.err)
+	}
+
+	clientConfig, serverConfig = newConfigs(t)
+	clientConfig.KnownPeers.Remove(serverConfig.StaticKey.Public)
+
+	clientConn, serverConn = pipe(t)
+	if c, _ := handshake(clientConn, serverConn, clientConfig, serverConfig); c.err != ErrUnknownPeer {
+		t.Errorf("client: got %v, expected ErrUnknownPeer", c.err)
+	}
+}
+
+// tamperConn flips a bit in one of the messages written to it.
+type tamperConn struct {
+	net.Conn
+	message int
+	offset  int
+	written int
+}
+
+func (c *tamperConn) Write(b []byte) (int, error) {
+	if c.written == c.message {
+		b = append([]byte(nil), b...)
+		b[2+c.offset] ^= 1
+	}
+	c.written++
+
+	return c.Conn.Write(b)
+}
+
+func TestTamperedHandshake(t *testing.T) {
+	tests := []struct {
+		name string
+		// initiator is whether the message is written by the client, and
+		// message is its index among the messages that side writes.
+		initiator bool
+		message   int
+		offset    int
+	}{
+		{"message 1 ephemeral", true, 0, 0},
+		{"message 2 ephemeral", false, 0, 0},
+		{"message 2 static", false, 0, keyLength + 1},
+		{"message 3 static", true, 1, 0},
+		{"message 3 tag", true, 1, keyLength + 16 + 1},
+	}
+
+	for _, test := range tests {
+		clientConfig, serverConfig := newConfigs(t)
+		clientConn, serverConn := pipe(t)
+
+		if test.initiator {
+			clientConn = &tamperConn{Conn: clientConn, message: test.message, offset: test.offset}
+		} else {
+			serverConn = &tamperConn{Conn: serverConn, message: test.message, offset: test.offset}
+		}
+
+		c, s := handshake(clientConn, serverConn, clientConfig, serverConfig)
+		if c.err != ErrDecrypt && s.err != ErrDecrypt {
+			t.Errorf("%s: got client %v, server %v, expected ErrDecrypt", test.name,
+				c.err, s.err)
+		}
+		if c.err == nil && s.err == nil {
+			t.Errorf("%s: handshake succeeded", test.name)
+		}
+	}
+}
+
+func TestRekey(t *testing.T) {
+	clientConfig, serverConfig := newConfigs(t)
+
+	var notified MediaKeys
+	serverConfig.OnRekey = func(remote MediaKeys) { notified = remote }
+
+	client, server := connect(t, clientConfig, serverConfig)
+	before := client.LocalMediaKeys()
+
+	type rekeyResult struct {
+		keys MediaKeys
+		err  error
+	}
+	results := make(chan rekeyResult, 1)
+	go func() {
+		keys, err := client.Rekey()
+		if err == nil {
+			err = client.WriteMessage([]byte("after rekey"))
+		}
+		results <- rekeyResult{keys, err}
+	}()
+
+	// The rekey is handled while reading the next message.
+	msg, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "after rekey" {
+		t.Errorf("got message %q, expected \"after rekey\"", msg)
+	}
+
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	if r.keys.Epoch != 1 || server.RemoteMediaKeys().Epoch != 1 {
+		t.Errorf("got epochs %d and %d, expected 1", r.keys.Epoch,
+			server.RemoteMediaKeys().Epoch)
+	}
+	if !reflect.DeepEqual(r.keys, server.RemoteMediaKeys()) ||
+		!reflect.DeepEqual(r.keys, notified) {
+		t.Error("server's remote media keys differ from the client's after rekey")
+	}
+	if bytes.Equal(r.keys.Key, before.Key) {
+		t.Error("media key unchanged after rekey")
+	}
+
+	// The other direction is unaffected.
+	if server.LocalMediaKeys().Epoch != 0 {
+		t.Errorf("server's local epoch is %d, expected 0", server.LocalMediaKeys().Epoch)
+	}
+	exchange(t, server, client, []byte("reply"))
+}
+
+// tcpPair returns both ends of a loopback TCP connection, which unlike
+// net.Pipe buffers writes, so a rekey can complete before it's read.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	a, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+
+	b, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	return a, b
+}
+
+func rtpPacket(seq uint16) []byte {
+	packet := make([]byte, 12, 20)
+	packet[0] = 0x80
+	binary.BigEndian.PutUint16(packet[2:], seq)
+	binary.BigEndian.PutUint32(packet[8:], 0xdeadbeef)
+
+	return append(packet, "payload"...)
+}
+
+func TestMediaAcrossRekey(t *testing.T) {
+	clientConfig, serverConfig := newConfigs(t)
+	clientConn, serverConn := tcpPair(t)
+	c, s := handshake(clientConn, serverConn, clientConfig, serverConfig)
+	if c.err != nil || s.err != nil {
+		t.Fatalf("handshake failed: client %v, server %v", c.err, s.err)
+	}
+	client, server := c.session, s.session
+	receiver := server.MediaReceiver()
+
+	protect := func(keys MediaKeys, seq uint16) []byte {
+		t.Helper()
+
+		ctx, err := keys.Context()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		packet, err := ctx.ProtectRTP(rtpPacket(seq))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return packet
+	}
+
+	epoch0 := client.LocalMediaKeys()
+	if _, err := receiver.UnprotectRTP(protect(epoch0, 1)); err != nil {
+		t.Fatalf("epoch 0: %v", err)
+	}
+
+	epoch1, err := client.Rekey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Media with the new keys can arrive before the rekey message is read.
+	if _, err := receiver.UnprotectRTP(protect(epoch1, 2)); err != nil {
+		t.Errorf("epoch 1 before the rekey was read: %v", err)
+	}
+
+	if err := client.WriteMessage(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Media with the old keys can still be in flight.
+	if _, err := receiver.UnprotectRTP(protect(epoch0, 3)); err != nil {
+		t.Errorf("epoch 0 after the rekey: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Rekey(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.WriteMessage(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := receiver.UnprotectRTP(protect(epoch0, 4)); err != ErrUnknownEpoch {
+		t.Errorf("epoch 0 after three rekeys: got %v, expected ErrUnknownEpoch", err)
+	}
+}
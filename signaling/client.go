@@ -0,0 +1,386 @@
+package signaling
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrClosed is returned when the connection to the server has been closed.
+var ErrClosed = errors.New("signaling: connection closed")
+
+// Client represents a client connected and registered to a signaling
+// server.
+type Client struct {
+	conn     *websocket.Conn
+	name     string
+	incoming chan *Call
+	done     chan struct{} // closed by Close
+
+	nextID    uint64
+	pending   map[uint64]chan *Message
+	calls     map[string]*Call
+	closed    bool
+	closing   bool
+	usageLock *sync.Mutex
+	writeLock *sync.Mutex
+}
+
+// Event represents something that happened to a call.
+type Event struct {
+	// Type is one of TypeRinging, TypeAccept, TypeReject, TypeHangup or
+	// TypeError.
+	Type string
+	// Description is the callee's answer for TypeAccept events.
+	Description *Description
+	Reason      string
+	Err         error
+}
+
+// Call represents a call between the client and another user.
+type Call struct {
+	ID   string
+	Peer string
+
+	// Offer is the caller's description.
+	Offer *Description
+
+	// Events receives events from the peer, and is closed once the call
+	// has ended. Events are held until they're received, so it must be
+	// read from for the client to receive further messages.
+	Events <-chan Event
+
+	client   *Client
+	outgoing bool
+	events   chan Event
+	ended    bool
+	done     chan struct{} // closed when the call ends
+
+	// sendLock is held while an event is delivered, so that events isn't
+	// closed during a send.
+	sendLock     *sync.Mutex
+	eventsClosed bool
+}
+
+// Dial connects to the signaling server at the given WebSocket URL and
+// registers under the given name.
+func Dial(url string, name string) (*Client, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:      conn,
+		incoming:  make(chan *Call, 16),
+		done:      make(chan struct{}),
+		pending:   make(map[uint64]chan *Message),
+		calls:     make(map[string]*Call),
+		usageLock: new(sync.Mutex),
+		writeLock: new(sync.Mutex),
+	}
+
+	go c.run()
+
+	reply, err := c.request(&Message{Type: TypeRegister, User: name})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	c.name = reply.User
+	return c, nil
+}
+
+// Name returns the name the client is registered under.
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Incoming returns the channel incoming calls are received on. It is closed
+// when the connection to the server is closed. Incoming calls are held until
+// they're received, so it must be read from for the client to receive
+// further messages.
+func (c *Client) Incoming() <-chan *Call {
+	return c.incoming
+}
+
+// Close closes the connection to the server, ending all calls.
+func (c *Client) Close() error {
+	c.usageLock.Lock()
+	if !c.closing {
+		c.closing = true
+		close(c.done)
+	}
+	c.usageLock.Unlock()
+
+	return c.conn.Close()
+}
+
+func (c *Client) send(msg *Message) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.conn.WriteJSON(msg)
+}
+
+// request sends a message and waits for the server's reply.
+func (c *Client) request(msg *Message) (*Message, error) {
+	reply := make(chan *Message, 1)
+
+	c.usageLock.Lock()
+	if c.closed {
+		c.usageLock.Unlock()
+		return nil, ErrClosed
+	}
+
+	c.nextID++
+	msg.ID = c.nextID
+	c.pending[msg.ID] = reply
+	c.usageLock.Unlock()
+
+	if err := c.send(msg); err != nil {
+		return nil, err
+	}
+
+	resp, ok := <-reply
+	if !ok {
+		return nil, ErrClosed
+	}
+
+	if resp.Type == TypeError {
+		return nil, errorFromString(resp.Error)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) run() {
+	for {
+		var msg Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			break
+		}
+
+		c.dispatch(&msg)
+	}
+
+	c.usageLock.Lock()
+	c.closed = true
+	for id, reply := range c.pending {
+		close(reply)
+		delete(c.pending, id)
+	}
+	for id, call := range c.calls {
+		call.end()
+		delete(c.calls, id)
+	}
+	c.usageLock.Unlock()
+
+	close(c.incoming)
+}
+
+func (c *Client) dispatch(msg *Message) {
+	c.usageLock.Lock()
+
+	if reply, found := c.pending[msg.ID]; found && msg.ID != 0 {
+		reply <- msg
+		delete(c.pending, msg.ID)
+		c.usageLock.Unlock()
+		return
+	}
+
+	if msg.Type == TypeOffer {
+		if _, exists := c.calls[msg.CallID]; exists {
+			c.usageLock.Unlock()
+			return
+		}
+
+		call := newCall(c, msg.CallID, msg.From, msg.Description, false)
+		c.calls[msg.CallID] = call
+		c.usageLock.Unlock()
+
+		select {
+		case c.incoming <- call:
+		case <-c.done:
+		}
+
+		return
+	}
+
+	call, found := c.calls[msg.CallID]
+	c.usageLock.Unlock()
+	if !found {
+		return
+	}
+
+	event := Event{
+		Type:        msg.Type,
+		Description: msg.Description,
+		Reason:      msg.Reason,
+	}
+
+	if msg.Type == TypeError {
+		event.Err = errorFromString(msg.Error)
+	}
+
+	call.deliver(event)
+
+	if msg.Type == TypeReject || msg.Type == TypeHangup ||
+		(msg.Type == TypeError && call.outgoing) {
+		c.usageLock.Lock()
+		delete(c.calls, msg.CallID)
+		call.end()
+		c.usageLock.Unlock()
+	}
+}
+
+func newCall(c *Client, id string, peer string, offer *Description, outgoing bool) *Call {
+	events := make(chan Event, 8)
+	return &Call{
+		ID:       id,
+		Peer:     peer,
+		Offer:    offer,
+		Events:   events,
+		client:   c,
+		outgoing: outgoing,
+		events:   events,
+		done:     make(chan struct{}),
+		sendLock: new(sync.Mutex),
+	}
+}
+
+// deliver sends an event to the call's Events channel, blocking until it is
+// received, the call ends or the client is closed.
+func (call *Call) deliver(event Event) {
+	call.sendLock.Lock()
+	defer call.sendLock.Unlock()
+
+	if call.eventsClosed {
+		return
+	}
+
+	select {
+	case call.events <- event:
+	case <-call.done:
+	case <-call.client.done:
+	}
+}
+
+// end closes the events channel of the call. The client's usageLock must be
+// held.
+func (call *Call) end() {
+	if call.ended {
+		return
+	}
+
+	call.ended = true
+	close(call.done)
+
+	// Closing done releases any delivery in progress.
+	call.sendLock.Lock()
+	call.eventsClosed = true
+	close(call.events)
+	call.sendLock.Unlock()
+}
+
+// Lookup returns whether the user with the given name is online.
+func (c *Client) Lookup(name string) (bool, error) {
+	if name == "" {
+		return false, ErrBadMessage
+	}
+
+	reply, err := c.request(&Message{Type: TypeLookup, User: name})
+	if err != nil {
+		return false, err
+	}
+
+	return len(reply.Users) > 0, nil
+}
+
+// Users returns the names of all online users.
+func (c *Client) Users() ([]string, error) {
+	reply, err := c.request(&Message{Type: TypeLookup})
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Users, nil
+}
+
+// Call offers a call to the given user with the given description. The
+// callee's response is received on the call's Events channel.
+func (c *Client) Call(to string, offer Description) (*Call, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	call := newCall(c, hex.EncodeToString(id), to, &offer, true)
+
+	c.usageLock.Lock()
+	if c.closed {
+		c.usageLock.Unlock()
+		return nil, ErrClosed
+	}
+	c.calls[call.ID] = call
+	c.usageLock.Unlock()
+
+	err := c.send(&Message{
+		Type:        TypeOffer,
+		To:          to,
+		CallID:      call.ID,
+		Description: &offer,
+	})
+	if err != nil {
+		c.usageLock.Lock()
+		delete(c.calls, call.ID)
+		call.end()
+		c.usageLock.Unlock()
+		return nil, err
+	}
+
+	return call, nil
+}
+
+// Ringing notifies the caller that the user is being alerted of an incoming
+// call.
+func (call *Call) Ringing() error {
+	return call.client.send(&Message{Type: TypeRinging, CallID: call.ID})
+}
+
+// Accept accepts an incoming call with the given description as the answer.
+func (call *Call) Accept(answer Description) error {
+	return call.client.send(&Message{
+		Type:        TypeAccept,
+		CallID:      call.ID,
+		Description: &answer,
+	})
+}
+
+// Reject rejects an incoming call with an optional reason.
+func (call *Call) Reject(reason string) error {
+	call.finish()
+	return call.client.send(&Message{
+		Type:   TypeReject,
+		CallID: call.ID,
+		Reason: reason,
+	})
+}
+
+// Hangup ends the call.
+func (call *Call) Hangup() error {
+	call.finish()
+	return call.client.send(&Message{Type: TypeHangup, CallID: call.ID})
+}
+
+func (call *Call) finish() {
+	call.client.usageLock.Lock()
+	defer call.client.usageLock.Unlock()
+
+	delete(call.client.calls, call.ID)
+	call.end()
+}
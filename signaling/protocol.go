@@ -0,0 +1,103 @@
+// Package signaling implements a signaling server and client for setting up
+// calls between dissonance users. Users register with the server under a
+// name, look each other up, and exchange call offers and answers carrying
+// codec and transport parameters, using JSON messages over a WebSocket.
+package signaling
+
+import "errors"
+
+// Message types of the signaling protocol.
+const (
+	// TypeRegister registers the client under the name in User. The server
+	// replies with TypeRegistered or TypeError.
+	TypeRegister = "register"
+	// TypeRegistered confirms a registration.
+	TypeRegistered = "registered"
+	// TypeLookup looks up whether the user in User is online, or lists all
+	// online users if User is empty. The server replies with TypeUsers.
+	TypeLookup = "lookup"
+	// TypeUsers lists online users in Users.
+	TypeUsers = "users"
+	// TypeOffer offers a call to the user in To, with the caller's
+	// Description.
+	TypeOffer = "offer"
+	// TypeRinging is sent by the callee once the user is being alerted.
+	TypeRinging = "ringing"
+	// TypeAccept accepts a call, with the callee's Description as the
+	// answer.
+	TypeAccept = "accept"
+	// TypeReject rejects a call, with an optional Reason.
+	TypeReject = "reject"
+	// TypeHangup ends a call, from either side.
+	TypeHangup = "hangup"
+	// TypeError reports an error with a request in Error.
+	TypeError = "error"
+)
+
+// Errors reported by the server.
+var (
+	ErrNameTaken     = errors.New("signaling: name is already registered")
+	ErrNotRegistered = errors.New("signaling: not registered")
+	ErrUserOffline   = errors.New("signaling: user is not online")
+	ErrUnknownCall   = errors.New("signaling: unknown call")
+	ErrBadMessage    = errors.New("signaling: bad message")
+)
+
+var serverErrors = []error{ErrNameTaken, ErrNotRegistered, ErrUserOffline,
+	ErrUnknownCall, ErrBadMessage}
+
+// Message represents a message of the signaling protocol.
+type Message struct {
+	Type string `json:"type"`
+
+	// ID is set by the client on requests, and copied by the server into
+	// the reply.
+	ID uint64 `json:"id,omitempty"`
+
+	User   string   `json:"user,omitempty"`
+	Users  []string `json:"users,omitempty"`
+	From   string   `json:"from,omitempty"`
+	To     string   `json:"to,omitempty"`
+	CallID string   `json:"callId,omitempty"`
+
+	Description *Description `json:"description,omitempty"`
+	Reason      string       `json:"reason,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// Description represents the media parameters of one side of a call.
+type Description struct {
+	Codecs    []Codec   `json:"codecs"`
+	Transport Transport `json:"transport"`
+}
+
+// Codec represents an audio codec a peer is able to receive.
+type Codec struct {
+	Name        string            `json:"name"`
+	PayloadType uint8             `json:"payloadType"`
+	SampleRate  int               `json:"sampleRate"`
+	Channels    int               `json:"channels,omitempty"`
+	Parameters  map[string]string `json:"parameters,omitempty"`
+}
+
+// Transport represents where and how a peer receives media.
+type Transport struct {
+	// Protocol is the transport protocol, such as "udp".
+	Protocol string `json:"protocol"`
+	// Address is the address media should be sent to.
+	Address string `json:"address"`
+	// Candidates are additional addresses the peer may be reachable at.
+	Candidates []string `json:"candidates,omitempty"`
+	// Parameters are additional transport parameters, such as keys.
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+func errorFromString(s string) error {
+	for _, err := range serverErrors {
+		if err.Error() == s {
+			return err
+		}
+	}
+
+	return errors.New(s)
+}
@@ -0,0 +1,309 @@
+package signaling
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// call represents a call in progress, as tracked by the server.
+type call struct {
+	caller string
+	callee string
+}
+
+// Server represents a signaling server. It implements http.Handler, and
+// should be served on the path clients dial.
+type Server struct {
+	upgrader  websocket.Upgrader
+	users     map[string]*serverConn
+	calls     map[string]*call
+	usageLock *sync.Mutex
+}
+
+type serverConn struct {
+	conn      *websocket.Conn
+	name      string
+	writeLock *sync.Mutex
+}
+
+// maxMessageSize is the largest message read from a client, which is far
+// larger than any valid message.
+const maxMessageSize = 1 << 16
+
+// NewServer returns a new signaling server. Requests from web pages are only
+// accepted if their Origin header is one of the given origins, such as
+// "https://example.com". Requests without an Origin header, such as from
+// Dial, are always accepted.
+func NewServer(allowedOrigins ...string) *Server {
+	allowed := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		allowed[strings.ToLower(origin)] = true
+	}
+
+	return &Server{
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				return origin == "" || allowed[strings.ToLower(origin)]
+			},
+		},
+		users:     make(map[string]*serverConn),
+		calls:     make(map[string]*call),
+		usageLock: new(sync.Mutex),
+	}
+}
+
+func (c *serverConn) send(msg *Message) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *serverConn) sendError(id uint64, callID string, err error) error {
+	return c.send(&Message{
+		Type:   TypeError,
+		ID:     id,
+		CallID: callID,
+		Error:  err.Error(),
+	})
+}
+
+// ServeHTTP upgrades the request to a WebSocket and serves the client until
+// it disconnects.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	conn.SetReadLimit(maxMessageSize)
+	c := &serverConn{
+		conn:      conn,
+		writeLock: new(sync.Mutex),
+	}
+
+	defer s.disconnect(c)
+
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		if err := s.handle(c, &msg); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handle(c *serverConn, msg *Message) error {
+	if msg.Type == TypeRegister {
+		return s.register(c, msg)
+	}
+
+	if c.name == "" {
+		return c.sendError(msg.ID, msg.CallID, ErrNotRegistered)
+	}
+
+	switch msg.Type {
+	case TypeLookup:
+		return c.send(&Message{
+			Type:  TypeUsers,
+			ID:    msg.ID,
+			Users: s.lookup(msg.User),
+		})
+	case TypeOffer:
+		return s.offer(c, msg)
+	case TypeRinging, TypeAccept, TypeReject, TypeHangup:
+		return s.relay(c, msg)
+	default:
+		return c.sendError(msg.ID, msg.CallID, ErrBadMessage)
+	}
+}
+
+func (s *Server) register(c *serverConn, msg *Message) error {
+	name := strings.TrimSpace(msg.User)
+	if name == "" || c.name != "" {
+		return c.sendError(msg.ID, "", ErrBadMessage)
+	}
+
+	s.usageLock.Lock()
+	if _, found := s.users[name]; found {
+		s.usageLock.Unlock()
+		return c.sendError(msg.ID, "", ErrNameTaken)
+	}
+
+	c.name = name
+	s.users[name] = c
+	s.usageLock.Unlock()
+
+	return c.send(&Message{
+		Type: TypeRegistered,
+		ID:   msg.ID,
+		User: name,
+	})
+}
+
+func (s *Server) lookup(name string) []string {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+
+	if name != "" {
+		if _, found := s.users[name]; found {
+			return []string{name}
+		}
+
+		return nil
+	}
+
+	users := make([]string, 0, len(s.users))
+	for user := range s.users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	return users
+}
+
+func (s *Server) offer(c *serverConn, msg *Message) error {
+	if msg.CallID == "" || msg.Description == nil {
+		return c.sendError(msg.ID, msg.CallID, ErrBadMessage)
+	}
+
+	s.usageLock.Lock()
+	callee, found := s.users[msg.To]
+	_, exists := s.calls[msg.CallID]
+	if !found || exists || callee == c {
+		s.usageLock.Unlock()
+		if exists {
+			return c.sendError(msg.ID, msg.CallID, ErrBadMessage)
+		}
+		return c.sendError(msg.ID, msg.CallID, ErrUserOffline)
+	}
+
+	s.calls[msg.CallID] = &call{caller: c.name, callee: callee.name}
+	s.usageLock.Unlock()
+
+	err := callee.send(&Message{
+		Type:        TypeOffer,
+		From:        c.name,
+		To:          callee.name,
+		CallID:      msg.CallID,
+		Description: msg.Description,
+	})
+	if err != nil {
+		s.usageLock.Lock()
+		delete(s.calls, msg.CallID)
+		s.usageLock.Unlock()
+
+		return c.sendError(msg.ID, msg.CallID, ErrUserOffline)
+	}
+
+	return nil
+}
+
+// relay forwards a call message to the other party of the call.
+func (s *Server) relay(c *serverConn, msg *Message) error {
+	s.usageLock.Lock()
+	cl, found := s.calls[msg.CallID]
+	if !found || (cl.caller != c.name && cl.callee != c.name) ||
+		(msg.Type != TypeHangup && cl.callee != c.name) {
+		s.usageLock.Unlock()
+		return c.sendError(msg.ID, msg.CallID, ErrUnknownCall)
+	}
+
+	peerName := cl.caller
+	if peerName == c.name {
+		peerName = cl.callee
+	}
+
+	if msg.Type == TypeReject || msg.Type == TypeHangup {
+		delete(s.calls, msg.CallID)
+	}
+
+	peer := s.users[peerName]
+	s.usageLock.Unlock()
+
+	if peer == nil {
+		return nil
+	}
+
+	err := peer.send(&Message{
+		Type:        msg.Type,
+		From:        c.name,
+		To:          peerName,
+		CallID:      msg.CallID,
+		Description: msg.Description,
+		Reason:      msg.Reason,
+	})
+	if err != nil {
+		s.usageLock.Lock()
+		delete(s.calls, msg.CallID)
+		s.usageLock.Unlock()
+
+		if msg.Type == TypeReject || msg.Type == TypeHangup {
+			// The call has ended regardless.
+			return nil
+		}
+
+		return c.sendError(msg.ID, msg.CallID, ErrUserOffline)
+	}
+
+	return nil
+}
+
+// disconnect unregisters a client and hangs up any calls it was part of.
+func (s *Server) disconnect(c *serverConn) {
+	c.conn.Close()
+
+	if c.name == "" {
+		return
+	}
+
+	type hangup struct {
+		peer   *serverConn
+		callID string
+	}
+
+	var hangups []hangup
+
+	s.usageLock.Lock()
+	delete(s.users, c.name)
+	for id, cl := range s.calls {
+		if cl.caller != c.name && cl.callee != c.name {
+			continue
+		}
+
+		peerName := cl.caller
+		if peerName == c.name {
+			peerName = cl.callee
+		}
+
+		if peer, found := s.users[peerName]; found {
+			hangups = append(hangups, hangup{peer: peer, callID: id})
+		}
+
+		delete(s.calls, id)
+	}
+	s.usageLock.Unlock()
+
+	for _, h := range hangups {
+		err := h.peer.send(&Message{
+			Type:   TypeHangup,
+			From:   c.name,
+			To:     h.peer.name,
+			CallID: h.callID,
+			Reason: "disconnected",
+		})
+		if err != nil {
+			// The peer's connection is broken too, so close it to
+			// disconnect it.
+			h.peer.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package signaling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var testOffer = Description{
+	Codecs: []Codec{
+		{Name: "L16", PayloadType: 96, SampleRate: 48000, Channels: 1},
+	},
+	Transport: Transport{
+		Protocol: "udp",
+		Address:  "127.0.0.1:5004",
+	},
+}
+
+var testAnswer = Description{
+	Codecs: testOffer.Codecs,
+	Transport: Transport{
+		Protocol: "udp",
+		Address:  "127.0.0.1:5006",
+	},
+}
+
+func newTestServer(t *testing.T, allowedOrigins ...string) string {
+	t.Helper()
+
+	server := httptest.NewServer(NewServer(allowedOrigins...))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dial(t *testing.T, url string, name string) *Client {
+	t.Helper()
+
+	c, err := Dial(url, name)
+	if err != nil {
+		t.Fatalf("dial %s: %v", name, err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func nextEvent(t *testing.T, call *Call) Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-call.Events:
+		if !ok {
+			t.Fatal("events closed, expected an event")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+
+	return Event{}
+}
+
+func expectEnded(t *testing.T, call *Call) {
+	t.Helper()
+
+	select {
+	case event, ok := <-call.Events:
+		if ok {
+			t.Fatalf("got event %q, expected events to be closed", event.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the call to end")
+	}
+}
+
+func nextCall(t *testing.T, c *Client) *Call {
+	t.Helper()
+
+	select {
+	case call, ok := <-c.Incoming():
+		if !ok {
+			t.Fatal("incoming closed, expected a call")
+		}
+		return call
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an incoming call")
+	}
+
+	return nil
+}
+
+func TestRegisterLookup(t *testing.T) {
+	url := newTestServer(t)
+
+	alice := dial(t, url, "alice")
+	dial(t, url, " bob ")
+
+	if alice.Name() != "alice" {
+		t.Errorf("got name %q, expected alice", alice.Name())
+	}
+
+	users, err := alice.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(users, []string{"alice", "bob"}) {
+		t.Errorf("got users %v, expected [alice bob]", users)
+	}
+
+	if online, err := alice.Lookup("bob"); err != nil || !online {
+		t.Errorf("lookup bob: got %v, %v, expected true", online, err)
+	}
+
+	if online, err := alice.Lookup("carol"); err != nil || online {
+		t.Errorf("lookup carol: got %v, %v, expected false", online, err)
+	}
+
+	if _, err := Dial(url, "alice"); err != ErrNameTaken {
+		t.Errorf("duplicate registration: got %v, expected ErrNameTaken", err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	url := newTestServer(t)
+
+	alice := dial(t, url, "alice")
+	bob := dial(t, url, "bob")
+
+	call, err := alice.Call("bob", testOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	incoming := nextCall(t, bob)
+	if incoming.ID != call.ID || incoming.Peer != "alice" {
+		t.Errorf("got call %s from %q, expected %s from alice", incoming.ID,
+			incoming.Peer, call.ID)
+	}
+	if !reflect.DeepEqual(*incoming.Offer, testOffer) {
+		t.Errorf("got offer %+v, expected %+v", *incoming.Offer, testOffer)
+	}
+
+	if err := incoming.Ringing(); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, call); event.Type != TypeRinging {
+		t.Errorf("got event %q, expected ringing", event.Type)
+	}
+
+	if err := incoming.Accept(testAnswer); err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, call)
+	if event.Type != TypeAccept {
+		t.Fatalf("got event %q, expected accept", event.Type)
+	}
+	if !reflect.DeepEqual(*event.Description, testAnswer) {
+		t.Errorf("got answer %+v, expected %+v", *event.Description, testAnswer)
+	}
+
+	if err := call.Hangup(); err != nil {
+		t.Fatal(err)
+	}
+	expectEnded(t, call)
+
+	if event := nextEvent(t, incoming); event.Type != TypeHangup {
+		t.Errorf("got event %q, expected hangup", event.Type)
+	}
+	expectEnded(t, incoming)
+}
+
+func TestReject(t *testing.T) {
+	url := newTestServer(t)
+
+	alice := dial(t, url, "alice")
+	bob := dial(t, url, "bob")
+
+	call, err := alice.Call("bob", testOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	incoming := nextCall(t, bob)
+	if err := incoming.Reject("busy"); err != nil {
+		t.Fatal(err)
+	}
+	expectEnded(t, incoming)
+
+	event := nextEvent(t, call)
+	if event.Type != TypeReject || event.Reason != "busy" {
+		t.Errorf("got event %q with reason %q, expected reject with busy",
+			event.Type, event.Reason)
+	}
+	expectEnded(t, call)
+}
+
+func TestUserOffline(t *testing.T) {
+	url := newTestServer(t)
+
+	alice := dial(t, url, "alice")
+
+	call, err := alice.Call("carol", testOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := nextEvent(t, call)
+	if event.Type != TypeError || event.Err != ErrUserOffline {
+		t.Errorf("got event %q with error %v, expected ErrUserOffline",
+			event.Type, event.Err)
+	}
+	expectEnded(t, call)
+}
+
+func TestIncomingHeld(t *testing.T) {
+	url := newTestServer(t)
+
+	alice := dial(t, url, "alice")
+	bob := dial(t, url, "bob")
+
+	// More calls than Incoming buffers are held rather than dropped.
+	const calls = 40
+	ids := make(map[string]bool)
+	for i := 0; i < calls; i++ {
+		call, err := alice.Call("bob", testOffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[call.ID] = true
+	}
+
+	for i := 0; i < calls; i++ {
+		incoming := nextCall(t, bob)
+		if !ids[incoming.ID] {
+			t.Fatalf("got unexpected call %s", incoming.ID)
+		}
+		delete(ids, incoming.ID)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	url := newTestServer(t, "https://example.com")
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://example.com", true},
+		{"https://EXAMPLE.com", true},
+		{"https://example.org", false},
+		{"http://example.com", false},
+	}
+
+	for _, test := range tests {
+		header := http.Header{}
+		if test.origin != "" {
+			header.Set("Origin", test.origin)
+		}
+
+		ws, _, err := websocket.DefaultDialer.Dial(url, header)
+		if test.allowed && err != nil {
+			t.Errorf("origin %q: got %v, expected to be accepted", test.origin, err)
+		} else if !test.allowed && err != websocket.ErrBadHandshake {
+			t.Errorf("origin %q: got %v, expected ErrBadHandshake", test.origin, err)
+		}
+
+		if ws != nil {
+			ws.Close()
+		}
+	}
+
+	// Without allowed origins, only requests without an origin are
+	// accepted.
+	url = newTestServer(t)
+	header := http.Header{"Origin": {"https://example.com"}}
+	if _, _, err := websocket.DefaultDialer.Dial(url, header); err != websocket.ErrBadHandshake {
+		t.Errorf("no allowed origins: got %v, expected ErrBadHandshake", err)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	url := newTestServer(t)
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	msg := Message{Type: TypeRegister, User: strings.Repeat("a", maxMessageSize)}
+	if err := ws.WriteJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("got %v, expected the connection to close with message too big", err)
+	}
+}
@@ -0,0 +1,337 @@
+package srtp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"hash"
+)
+
+const (
+	srtcpIndexLength = 4
+	srtcpEncrypted   = 1 << 31
+)
+
+// srtpCipher represents the transforms of a protection profile, keyed with
+// session keys.
+type srtpCipher interface {
+	// encryptRTP returns the protected form of the RTP packet with the given
+	// header length.
+	encryptRTP(packet []byte, headerLen int, ssrc uint32, index uint64) []byte
+	// decryptRTP authenticates and returns the unprotected form of the SRTP
+	// packet with the given header length.
+	decryptRTP(packet []byte, headerLen int, ssrc uint32, index uint64) ([]byte, error)
+	// encryptRTCP returns the protected form of the RTCP packet.
+	encryptRTCP(packet []byte, ssrc uint32, index uint32) []byte
+	// rtcpIndex returns the E flag and SRTCP index field of the SRTCP packet.
+	rtcpIndex(packet []byte) (uint32, error)
+	// decryptRTCP authenticates and returns the unprotected form of the
+	// SRTCP packet.
+	decryptRTCP(packet []byte, ssrc uint32, index uint32) ([]byte, error)
+}
+
+// cmCipher implements AES counter mode encryption with HMAC-SHA1
+// authentication as described in RFC 3711.
+type cmCipher struct {
+	tagLength int
+
+	srtpBlock  cipher.Block
+	srtpSalt   []byte
+	srtpAuth   hash.Hash
+	srtcpBlock cipher.Block
+	srtcpSalt  []byte
+	srtcpAuth  hash.Hash
+}
+
+func newCMCipher(masterKey, masterSalt []byte, tagLength int) (*cmCipher, error) {
+	c := &cmCipher{tagLength: tagLength}
+
+	keys := make(map[byte][]byte)
+	for label, length := range map[byte]int{
+		labelSRTPEncryption:  len(masterKey),
+		labelSRTPAuth:        20,
+		labelSRTPSalt:        14,
+		labelSRTCPEncryption: len(masterKey),
+		labelSRTCPAuth:       20,
+		labelSRTCPSalt:       14,
+	} {
+		key, err := deriveKey(masterKey, masterSalt, label, length)
+		if err != nil {
+			return nil, err
+		}
+		keys[label] = key
+	}
+
+	var err error
+	c.srtpBlock, err = aes.NewCipher(keys[labelSRTPEncryption])
+	if err != nil {
+		return nil, err
+	}
+
+	c.srtcpBlock, err = aes.NewCipher(keys[labelSRTCPEncryption])
+	if err != nil {
+		return nil, err
+	}
+
+	c.srtpSalt = keys[labelSRTPSalt]
+	c.srtcpSalt = keys[labelSRTCPSalt]
+	c.srtpAuth = hmac.New(sha1.New, keys[labelSRTPAuth])
+	c.srtcpAuth = hmac.New(sha1.New, keys[labelSRTCPAuth])
+
+	return c, nil
+}
+
+// counter returns the initial counter block for the given SSRC and packet
+// index, as described in RFC 3711 section 4.1.1.
+func cmCounter(salt []byte, ssrc uint32, index uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, salt)
+
+	var ssrcBytes [4]byte
+	binary.BigEndian.PutUint32(ssrcBytes[:], ssrc)
+	for i := range ssrcBytes {
+		iv[4+i] ^= ssrcBytes[i]
+	}
+
+	var indexBytes [8]byte
+	binary.BigEndian.PutUint64(indexBytes[:], index<<16)
+	for i := range indexBytes {
+		iv[8+i] ^= indexBytes[i]
+	}
+
+	return iv
+}
+
+func (c *cmCipher) rtpTag(packet []byte, roc uint32) []byte {
+	var rocBytes [4]byte
+	binary.BigEndian.PutUint32(rocBytes[:], roc)
+
+	c.srtpAuth.Reset()
+	c.srtpAuth.Write(packet)
+	c.srtpAuth.Write(rocBytes[:])
+	return c.srtpAuth.Sum(nil)[:c.tagLength]
+}
+
+func (c *cmCipher) encryptRTP(packet []byte, headerLen int, ssrc uint32, index uint64) []byte {
+	out := make([]byte, len(packet), len(packet)+c.tagLength)
+	copy(out, packet[:headerLen])
+	cipher.NewCTR(c.srtpBlock, cmCounter(c.srtpSalt, ssrc, index)).
+		XORKeyStream(out[headerLen:], packet[headerLen:])
+
+	return append(out, c.rtpTag(out, uint32(index>>16))...)
+}
+
+func (c *cmCipher) decryptRTP(packet []byte, headerLen int, ssrc uint32, index uint64) ([]byte, error) {
+	if len(packet) < headerLen+c.tagLength {
+		return nil, ErrShortPacket
+	}
+
+	body := packet[:len(packet)-c.tagLength]
+	tag := packet[len(packet)-c.tagLength:]
+	if subtle.ConstantTimeCompare(tag, c.rtpTag(body, uint32(index>>16))) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	out := make([]byte, len(body))
+	copy(out, body[:headerLen])
+	cipher.NewCTR(c.srtpBlock, cmCounter(c.srtpSalt, ssrc, index)).
+		XORKeyStream(out[headerLen:], body[headerLen:])
+
+	return out, nil
+}
+
+func (c *cmCipher) rtcpTag(packet []byte) []byte {
+	c.srtcpAuth.Reset()
+	c.srtcpAuth.Write(packet)
+	return c.srtcpAuth.Sum(nil)[:c.tagLength]
+}
+
+func (c *cmCipher) encryptRTCP(packet []byte, ssrc uint32, index uint32) []byte {
+	out := make([]byte, len(packet), len(packet)+srtcpIndexLength+c.tagLength)
+	copy(out, packet[:rtcpHeaderLength])
+	cipher.NewCTR(c.srtcpBlock, cmCounter(c.srtcpSalt, ssrc, uint64(index))).
+		XORKeyStream(out[rtcpHeaderLength:], packet[rtcpHeaderLength:])
+
+	var indexBytes [4]byte
+	binary.BigEndian.PutUint32(indexBytes[:], srtcpEncrypted|index)
+	out = append(out, indexBytes[:]...)
+
+	return append(out, c.rtcpTag(out)...)
+}
+
+func (c *cmCipher) rtcpIndex(packet []byte) (uint32, error) {
+	if len(packet) < rtcpHeaderLength+srtcpIndexLength+c.tagLength {
+		return 0, ErrShortPacket
+	}
+
+	return binary.BigEndian.Uint32(packet[len(packet)-c.tagLength-srtcpIndexLength:]), nil
+}
+
+func (c *cmCipher) decryptRTCP(packet []byte, ssrc uint32, index uint32) ([]byte, error) {
+	body := packet[:len(packet)-c.tagLength]
+	tag := packet[len(packet)-c.tagLength:]
+	if subtle.ConstantTimeCompare(tag, c.rtcpTag(body)) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	body = body[:len(body)-srtcpIndexLength]
+	out := make([]byte, len(body))
+	copy(out, body)
+	if index&srtcpEncrypted != 0 {
+		cipher.NewCTR(c.srtcpBlock, cmCounter(c.srtcpSalt, ssrc,
+			uint64(index&^srtcpEncrypted))).
+			XORKeyStream(out[rtcpHeaderLength:], body[rtcpHeaderLength:])
+	}
+
+	return out, nil
+}
+
+// gcmCipher implements AES-GCM authenticated encryption as described in
+// RFC 7714.
+type gcmCipher struct {
+	srtpAEAD  cipher.AEAD
+	srtpSalt  []byte
+	srtcpAEAD cipher.AEAD
+	srtcpSalt []byte
+}
+
+func newGCMCipher(masterKey, masterSalt []byte) (*gcmCipher, error) {
+	c := new(gcmCipher)
+
+	for _, keys := range []struct {
+		encryption, salt byte
+		aead             *cipher.AEAD
+		saltDst          *[]byte
+	}{
+		{labelSRTPEncryption, labelSRTPSalt, &c.srtpAEAD, &c.srtpSalt},
+		{labelSRTCPEncryption, labelSRTCPSalt, &c.srtcpAEAD, &c.srtcpSalt},
+	} {
+		key, err := deriveKey(masterKey, masterSalt, keys.encryption, len(masterKey))
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		*keys.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		*keys.saltDst, err = deriveKey(masterKey, masterSalt, keys.salt, len(masterSalt))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// gcmNonce returns the initialization vector for the given SSRC, rollover
+// counter and sequence number (or SRTCP index), as described in RFC 7714
+// sections 8.1 and 9.1.
+func gcmNonce(salt []byte, ssrc uint32, high uint32, low uint16) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce[2:], ssrc)
+	binary.BigEndian.PutUint32(nonce[6:], high)
+	binary.BigEndian.PutUint16(nonce[10:], low)
+
+	for i := range nonce {
+		nonce[i] ^= salt[i]
+	}
+
+	return nonce
+}
+
+func (c *gcmCipher) encryptRTP(packet []byte, headerLen int, ssrc uint32, index uint64) []byte {
+	nonce := gcmNonce(c.srtpSalt, ssrc, uint32(index>>16), uint16(index))
+	out := make([]byte, headerLen, len(packet)+c.srtpAEAD.Overhead())
+	copy(out, packet[:headerLen])
+
+	return c.srtpAEAD.Seal(out, nonce, packet[headerLen:], packet[:headerLen])
+}
+
+func (c *gcmCipher) decryptRTP(packet []byte, headerLen int, ssrc uint32, index uint64) ([]byte, error) {
+	if len(packet) < headerLen+c.srtpAEAD.Overhead() {
+		return nil, ErrShortPacket
+	}
+
+	nonce := gcmNonce(c.srtpSalt, ssrc, uint32(index>>16), uint16(index))
+	out := make([]byte, headerLen, len(packet))
+	copy(out, packet[:headerLen])
+
+	out, err := c.srtpAEAD.Open(out, nonce, packet[headerLen:], packet[:headerLen])
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+
+	return out, nil
+}
+
+func (c *gcmCipher) rtcpNonce(ssrc uint32, index uint32) []byte {
+	return gcmNonce(c.srtcpSalt, ssrc, uint32(index>>16), uint16(index))
+}
+
+func (c *gcmCipher) encryptRTCP(packet []byte, ssrc uint32, index uint32) []byte {
+	var indexBytes [4]byte
+	binary.BigEndian.PutUint32(indexBytes[:], srtcpEncrypted|index)
+
+	aad := make([]byte, 0, rtcpHeaderLength+srtcpIndexLength)
+	aad = append(aad, packet[:rtcpHeaderLength]...)
+	aad = append(aad, indexBytes[:]...)
+
+	out := make([]byte, rtcpHeaderLength,
+		len(packet)+c.srtcpAEAD.Overhead()+srtcpIndexLength)
+	copy(out, packet[:rtcpHeaderLength])
+	out = c.srtcpAEAD.Seal(out, c.rtcpNonce(ssrc, index),
+		packet[rtcpHeaderLength:], aad)
+
+	return append(out, indexBytes[:]...)
+}
+
+func (c *gcmCipher) rtcpIndex(packet []byte) (uint32, error) {
+	if len(packet) < rtcpHeaderLength+c.srtcpAEAD.Overhead()+srtcpIndexLength {
+		return 0, ErrShortPacket
+	}
+
+	return binary.BigEndian.Uint32(packet[len(packet)-srtcpIndexLength:]), nil
+}
+
+func (c *gcmCipher) decryptRTCP(packet []byte, ssrc uint32, index uint32) ([]byte, error) {
+	body := packet[:len(packet)-srtcpIndexLength]
+	nonce := c.rtcpNonce(ssrc, index&^srtcpEncrypted)
+
+	aad := make([]byte, 0, len(body))
+	aad = append(aad, body[:rtcpHeaderLength]...)
+	if index&srtcpEncrypted == 0 {
+		// Unencrypted SRTCP packets are authenticated in their entirety.
+		aad = append(aad, body[rtcpHeaderLength:len(body)-c.srtcpAEAD.Overhead()]...)
+	}
+	aad = append(aad, packet[len(packet)-srtcpIndexLength:]...)
+
+	out := make([]byte, rtcpHeaderLength, len(body))
+	copy(out, body[:rtcpHeaderLength])
+
+	if index&srtcpEncrypted == 0 {
+		_, err := c.srtcpAEAD.Open(nil, nonce,
+			body[len(body)-c.srtcpAEAD.Overhead():], aad)
+		if err != nil {
+			return nil, ErrAuthFailed
+		}
+
+		return append(out, body[rtcpHeaderLength:len(body)-c.srtcpAEAD.Overhead()]...), nil
+	}
+
+	out, err := c.srtcpAEAD.Open(out, nonce, body[rtcpHeaderLength:], aad)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+
+	return out, nil
+}
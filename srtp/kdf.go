@@ -0,0 +1,35 @@
+package srtp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// Key derivation labels from RFC 3711 section 4.3.2.
+const (
+	labelSRTPEncryption  = 0x00
+	labelSRTPAuth        = 0x01
+	labelSRTPSalt        = 0x02
+	labelSRTCPEncryption = 0x03
+	labelSRTCPAuth       = 0x04
+	labelSRTCPSalt       = 0x05
+)
+
+// deriveKey derives a session key of the given length from the master key
+// and master salt using the AES-CM pseudo-random function with a key
+// derivation rate of zero.
+func deriveKey(masterKey, masterSalt []byte, label byte, length int) ([]byte, error) {
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, masterSalt)
+	iv[7] ^= label
+
+	out := make([]byte, length)
+	cipher.NewCTR(block, iv).XORKeyStream(out, out)
+
+	return out, nil
+}
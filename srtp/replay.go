@@ -0,0 +1,54 @@
+package srtp
+
+// replayWindowSize is the number of packet indices behind the highest
+// received index for which replays can be detected.
+const replayWindowSize = 64
+
+// replayWindow implements the sliding window replay protection described in
+// RFC 3711 section 3.3.2.
+type replayWindow struct {
+	started bool
+	highest uint64
+	mask    uint64
+}
+
+// check returns whether the given index has not already been seen and is
+// not too old to be checked.
+func (w *replayWindow) check(index uint64) bool {
+	if !w.started || index > w.highest {
+		return true
+	}
+
+	diff := w.highest - index
+	if diff >= replayWindowSize {
+		return false
+	}
+
+	return w.mask&(1<<diff) == 0
+}
+
+// accept marks the given index as seen. It must only be called after the
+// packet has been authenticated.
+func (w *replayWindow) accept(index uint64) {
+	if !w.started {
+		w.started = true
+		w.highest = index
+		w.mask = 1
+		return
+	}
+
+	if index > w.highest {
+		shift := index - w.highest
+		if shift >= replayWindowSize {
+			w.mask = 0
+		} else {
+			w.mask <<= shift
+		}
+
+		w.mask |= 1
+		w.highest = index
+		return
+	}
+
+	w.mask |= 1 << (w.highest - index)
+}
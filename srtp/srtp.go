@@ -0,0 +1,411 @@
+// Package srtp implements the Secure Real-time Transport Protocol
+// (RFC 3711) for protecting RTP and RTCP packets, with AES counter mode and
+// HMAC-SHA1 (RFC 3711) or AES-GCM (RFC 7714) protection profiles.
+package srtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+)
+
+const (
+	rtpHeaderLength  = 12
+	rtcpHeaderLength = 8
+	maxSRTCPIndex    = 1<<31 - 1
+)
+
+// Errors returned when unprotecting packets.
+var (
+	ErrShortPacket = errors.New("srtp: packet is too short")
+	ErrAuthFailed  = errors.New("srtp: authentication failed")
+	ErrReplayed    = errors.New("srtp: packet replayed or too old")
+)
+
+// ErrInvalidKeyLength is returned if the master key or master salt given
+// does not match the protection profile.
+var ErrInvalidKeyLength = errors.New("srtp: invalid master key or salt length")
+
+// ErrIndexExhausted is returned if the packet index has run out and the
+// master key must be changed.
+var ErrIndexExhausted = errors.New("srtp: packet index exhausted, rekey required")
+
+// ErrUnknownMKI is returned if a packet's master key identifier doesn't
+// match the context's.
+var ErrUnknownMKI = errors.New("srtp: unknown master key identifier")
+
+// ProtectionProfile represents an SRTP protection profile.
+type ProtectionProfile int
+
+// Possible ProtectionProfiles, named after their DTLS-SRTP registry entries.
+const (
+	ProfileAES128CMHMACSHA1_80 ProtectionProfile = iota + 1
+	ProfileAES128CMHMACSHA1_32
+	ProfileAEADAES128GCM
+	ProfileAEADAES256GCM
+)
+
+// KeyLength returns the length of the master key used by the profile.
+func (p ProtectionProfile) KeyLength() int {
+	switch p {
+	case ProfileAES128CMHMACSHA1_80, ProfileAES128CMHMACSHA1_32,
+		ProfileAEADAES128GCM:
+		return 16
+	case ProfileAEADAES256GCM:
+		return 32
+	default:
+		return 0
+	}
+}
+
+// SaltLength returns the length of the master salt used by the profile.
+func (p ProtectionProfile) SaltLength() int {
+	switch p {
+	case ProfileAES128CMHMACSHA1_80, ProfileAES128CMHMACSHA1_32:
+		return 14
+	case ProfileAEADAES128GCM, ProfileAEADAES256GCM:
+		return 12
+	default:
+		return 0
+	}
+}
+
+// mkiTrailer returns the number of bytes which follow the MKI in packets
+// protected with the profile. The MKI comes before the authentication tag
+// with AES counter mode (RFC 3711 section 3.1), and after it with AES-GCM
+// (RFC 7714 section 8.2).
+func (p ProtectionProfile) mkiTrailer() int {
+	switch p {
+	case ProfileAES128CMHMACSHA1_80:
+		return 10
+	case ProfileAES128CMHMACSHA1_32:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// PacketMKI returns the master key identifier of the given length from an
+// SRTP or SRTCP packet protected with the profile, such as to choose the
+// context to unprotect it with.
+func PacketMKI(profile ProtectionProfile, packet []byte, length int) ([]byte, error) {
+	end := len(packet) - profile.mkiTrailer()
+	if end-length < rtcpHeaderLength {
+		return nil, ErrShortPacket
+	}
+
+	return packet[end-length : end], nil
+}
+
+// ssrcState represents the state kept for each synchronization source.
+type ssrcState struct {
+	started    bool
+	roc        uint32
+	highestSeq uint16
+	window     replayWindow
+	rtcpIndex  uint32
+	rtcpWindow replayWindow
+}
+
+// Context represents the cryptographic context of an SRTP session in one
+// direction. A context should be used either only for protecting packets or
+// only for unprotecting packets.
+type Context struct {
+	profile   ProtectionProfile
+	cipher    srtpCipher
+	mki       []byte
+	ssrcs     map[uint32]*ssrcState
+	usageLock *sync.Mutex
+}
+
+// NewContext returns a new SRTP context for the given protection profile,
+// master key and master salt.
+func NewContext(profile ProtectionProfile, masterKey, masterSalt []byte) (*Context, error) {
+	if profile.KeyLength() == 0 {
+		return nil, errors.New("srtp: unknown protection profile")
+	}
+
+	if len(masterKey) != profile.KeyLength() ||
+		len(masterSalt) != profile.SaltLength() {
+		return nil, ErrInvalidKeyLength
+	}
+
+	var c srtpCipher
+	var err error
+
+	switch profile {
+	case ProfileAES128CMHMACSHA1_80:
+		c, err = newCMCipher(masterKey, masterSalt, 10)
+	case ProfileAES128CMHMACSHA1_32:
+		c, err = newCMCipher(masterKey, masterSalt, 4)
+	case ProfileAEADAES128GCM, ProfileAEADAES256GCM:
+		c, err = newGCMCipher(masterKey, masterSalt)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Context{
+		profile:   profile,
+		cipher:    c,
+		ssrcs:     make(map[uint32]*ssrcState),
+		usageLock: new(sync.Mutex),
+	}, nil
+}
+
+// SetMKI sets the master key identifier (MKI) which the context adds to the
+// packets it protects, and requires of the packets it unprotects. The MKI
+// lets a receiver holding several master keys, such as while they're being
+// changed, tell which one a packet was protected with. It must be set before
+// the context is used.
+func (c *Context) SetMKI(mki []byte) {
+	c.mki = append([]byte(nil), mki...)
+}
+
+// addMKI inserts the context's MKI into a protected packet.
+func (c *Context) addMKI(packet []byte) []byte {
+	if len(c.mki) == 0 {
+		return packet
+	}
+
+	end := len(packet) - c.profile.mkiTrailer()
+	out := make([]byte, 0, len(packet)+len(c.mki))
+	out = append(out, packet[:end]...)
+	out = append(out, c.mki...)
+	return append(out, packet[end:]...)
+}
+
+// removeMKI checks and removes the context's MKI from a protected packet.
+func (c *Context) removeMKI(packet []byte) ([]byte, error) {
+	if len(c.mki) == 0 {
+		return packet, nil
+	}
+
+	mki, err := PacketMKI(c.profile, packet, len(c.mki))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(mki, c.mki) {
+		return nil, ErrUnknownMKI
+	}
+
+	end := len(packet) - c.profile.mkiTrailer()
+	out := make([]byte, 0, len(packet)-len(c.mki))
+	out = append(out, packet[:end-len(c.mki)]...)
+	return append(out, packet[end:]...), nil
+}
+
+func (c *Context) state(ssrc uint32) *ssrcState {
+	s, found := c.ssrcs[ssrc]
+	if !found {
+		s = new(ssrcState)
+		c.ssrcs[ssrc] = s
+	}
+
+	return s
+}
+
+// SetROC sets the rollover counter for the given SSRC, such as when
+// joining a session that is already in progress.
+func (c *Context) SetROC(ssrc uint32, roc uint32) {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	s := c.state(ssrc)
+	s.roc = roc
+}
+
+// ROC returns the current rollover counter for the given SSRC.
+func (c *Context) ROC(ssrc uint32) uint32 {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	return c.state(ssrc).roc
+}
+
+// estimateROC guesses the rollover counter of a packet from its sequence
+// number, as described in RFC 3711 appendix A.
+func (s *ssrcState) estimateROC(seq uint16) uint32 {
+	if !s.started {
+		return s.roc
+	}
+
+	if s.highestSeq < 1<<15 {
+		if int(seq)-int(s.highestSeq) > 1<<15 && s.roc > 0 {
+			return s.roc - 1
+		}
+	} else if int(s.highestSeq)-(1<<15) > int(seq) {
+		return s.roc + 1
+	}
+
+	return s.roc
+}
+
+// update updates the rollover counter and highest sequence number after a
+// packet with the given rollover counter and sequence number has been
+// processed.
+func (s *ssrcState) update(roc uint32, seq uint16) {
+	if !s.started {
+		s.started = true
+		s.roc = roc
+		s.highestSeq = seq
+		return
+	}
+
+	if roc == s.roc && seq > s.highestSeq {
+		s.highestSeq = seq
+	} else if roc == s.roc+1 {
+		s.roc = roc
+		s.highestSeq = seq
+	}
+}
+
+// rtpHeaderLen returns the length of the RTP header of the packet, including
+// the CSRC list and any header extension.
+func rtpHeaderLen(packet []byte) (int, error) {
+	if len(packet) < rtpHeaderLength {
+		return 0, ErrShortPacket
+	}
+
+	n := rtpHeaderLength + 4*int(packet[0]&0x0f)
+	if packet[0]&0x10 != 0 {
+		if len(packet) < n+4 {
+			return 0, ErrShortPacket
+		}
+
+		n += 4 + 4*int(binary.BigEndian.Uint16(packet[n+2:]))
+	}
+
+	if len(packet) < n {
+		return 0, ErrShortPacket
+	}
+
+	return n, nil
+}
+
+// ProtectRTP encrypts and authenticates a marshalled RTP packet, returning
+// the SRTP packet.
+func (c *Context) ProtectRTP(packet []byte) ([]byte, error) {
+	headerLen, err := rtpHeaderLen(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+	seq := binary.BigEndian.Uint16(packet[2:])
+
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	s := c.state(ssrc)
+	roc := s.estimateROC(seq)
+	if s.started && roc == 0 && s.roc == math.MaxUint32 {
+		return nil, ErrIndexExhausted
+	}
+
+	s.update(roc, seq)
+
+	protected := c.cipher.encryptRTP(packet, headerLen, ssrc, uint64(roc)<<16|uint64(seq))
+	return c.addMKI(protected), nil
+}
+
+// UnprotectRTP authenticates and decrypts an SRTP packet, returning the
+// marshalled RTP packet. Replayed packets are rejected with ErrReplayed.
+func (c *Context) UnprotectRTP(packet []byte) ([]byte, error) {
+	packet, err := c.removeMKI(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen, err := rtpHeaderLen(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+	seq := binary.BigEndian.Uint16(packet[2:])
+
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	s := c.state(ssrc)
+	roc := s.estimateROC(seq)
+	index := uint64(roc)<<16 | uint64(seq)
+
+	if !s.window.check(index) {
+		return nil, ErrReplayed
+	}
+
+	out, err := c.cipher.decryptRTP(packet, headerLen, ssrc, index)
+	if err != nil {
+		return nil, err
+	}
+
+	s.window.accept(index)
+	s.update(roc, seq)
+
+	return out, nil
+}
+
+// ProtectRTCP encrypts and authenticates a marshalled RTCP packet (or
+// compound packet), returning the SRTCP packet.
+func (c *Context) ProtectRTCP(packet []byte) ([]byte, error) {
+	if len(packet) < rtcpHeaderLength {
+		return nil, ErrShortPacket
+	}
+
+	ssrc := binary.BigEndian.Uint32(packet[4:])
+
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	s := c.state(ssrc)
+	if s.rtcpIndex > maxSRTCPIndex {
+		return nil, ErrIndexExhausted
+	}
+
+	index := s.rtcpIndex
+	s.rtcpIndex++
+
+	return c.addMKI(c.cipher.encryptRTCP(packet, ssrc, index)), nil
+}
+
+// UnprotectRTCP authenticates and decrypts an SRTCP packet, returning the
+// marshalled RTCP packet. Replayed packets are rejected with ErrReplayed.
+func (c *Context) UnprotectRTCP(packet []byte) ([]byte, error) {
+	packet, err := c.removeMKI(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(packet) < rtcpHeaderLength {
+		return nil, ErrShortPacket
+	}
+
+	index, err := c.cipher.rtcpIndex(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	ssrc := binary.BigEndian.Uint32(packet[4:])
+
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	s := c.state(ssrc)
+	if !s.rtcpWindow.check(uint64(index &^ srtcpEncrypted)) {
+		return nil, ErrReplayed
+	}
+
+	out, err := c.cipher.decryptRTCP(packet, ssrc, index)
+	if err != nil {
+		return nil, err
+	}
+
+	s.rtcpWindow.accept(uint64(index &^ srtcpEncrypted))
+
+	return out, nil
+}
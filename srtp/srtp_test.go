@@ -0,0 +1,266 @@
+package srtp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// TestKeyDerivation checks the key derivation test vectors of RFC 3711
+// appendix B.3.
+func TestKeyDerivation(t *testing.T) {
+	masterKey := decodeHex(t, "E1F97A0D3E018BE0D64FA32C06DE4139")
+	masterSalt := decodeHex(t, "0EC675AD498AFEEBB6960B3AABE6")
+
+	tests := []struct {
+		label    byte
+		expected string
+	}{
+		{labelSRTPEncryption, "C61E7A93744F39EE10734AFE3FF7A087"},
+		{labelSRTPSalt, "30CBBC08863D8C85D49DB34A9AE1"},
+		{labelSRTPAuth, "CEBE321F6FF7716B6FD4AB49AF256A156D38BAA4"},
+	}
+
+	for _, test := range tests {
+		expected := decodeHex(t, test.expected)
+		key, err := deriveKey(masterKey, masterSalt, test.label, len(expected))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(key, expected) {
+			t.Errorf("label %d: got %X, expected %X", test.label, key, expected)
+		}
+	}
+}
+
+// TestKeystream checks the AES counter mode keystream test vectors of
+// RFC 3711 appendix B.2.
+func TestKeystream(t *testing.T) {
+	block, err := aes.NewCipher(decodeHex(t, "2B7E151628AED2A6ABF7158809CF4F3C"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	salt := decodeHex(t, "F0F1F2F3F4F5F6F7F8F9FAFBFCFD")
+	iv := cmCounter(salt, 0, 0)
+	if expected := decodeHex(t, "F0F1F2F3F4F5F6F7F8F9FAFBFCFD0000"); !bytes.Equal(iv, expected) {
+		t.Fatalf("got counter %X, expected %X", iv, expected)
+	}
+
+	keystream := make([]byte, 3*aes.BlockSize)
+	cipher.NewCTR(block, iv).XORKeyStream(keystream, keystream)
+
+	expected := decodeHex(t, "E03EAD0935C95E80E166B16DD92B4EB4"+
+		"D23513162B02D0F72A43A2FE4A5F97AB"+
+		"41E95B3BB0A2E8DD477901E4FCA894C0")
+	if !bytes.Equal(keystream, expected) {
+		t.Errorf("got keystream %X, expected %X", keystream, expected)
+	}
+}
+
+// TestGCM checks the AEAD_AES_128_GCM test vector of RFC 7714 section
+// 16.1.1, which is given with session keys.
+func TestGCM(t *testing.T) {
+	block, err := aes.NewCipher(decodeHex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &gcmCipher{
+		srtpAEAD: aead,
+		srtpSalt: decodeHex(t, "517569642070726f2071756f"),
+	}
+
+	packet := decodeHex(t, "8040f17b8041f8d35501a0b2"+
+		"47616c6c696120657374206f6d6e69732064697669736120696e207061727465732074726573")
+	expected := decodeHex(t, "8040f17b8041f8d35501a0b2"+
+		"f24de3a3fb34de6cacba861c9d7e4bcabe633bd50d294e6f42a5f47a51c7d19b"+
+		"36de3adf8833899d7f27beb16a9152cf765ee4390cce")
+
+	protected := c.encryptRTP(packet, rtpHeaderLength, 0x5501a0b2, 0xf17b)
+	if !bytes.Equal(protected, expected) {
+		t.Fatalf("got %x, expected %x", protected, expected)
+	}
+
+	unprotected, err := c.decryptRTP(protected, rtpHeaderLength, 0x5501a0b2, 0xf17b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(unprotected, packet) {
+		t.Errorf("got %x, expected %x", unprotected, packet)
+	}
+}
+
+func newContexts(t *testing.T, profile ProtectionProfile) (*Context, *Context) {
+	t.Helper()
+
+	key := bytes.Repeat([]byte{0x42}, profile.KeyLength())
+	salt := bytes.Repeat([]byte{0x24}, profile.SaltLength())
+
+	sender, err := NewContext(profile, key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver, err := NewContext(profile, key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sender, receiver
+}
+
+func rtpPacket(seq uint16, payload []byte) []byte {
+	packet := make([]byte, rtpHeaderLength, rtpHeaderLength+len(payload))
+	packet[0] = 0x80
+	packet[1] = 0x60
+	binary.BigEndian.PutUint16(packet[2:], seq)
+	binary.BigEndian.PutUint32(packet[4:], uint32(seq)*160)
+	binary.BigEndian.PutUint32(packet[8:], 0xdeadbeef)
+
+	return append(packet, payload...)
+}
+
+func TestReplay(t *testing.T) {
+	sender, receiver := newContexts(t, ProfileAES128CMHMACSHA1_80)
+
+	var protected [][]byte
+	for seq := uint16(1); seq <= 100; seq++ {
+		p, err := sender.ProtectRTP(rtpPacket(seq, []byte("payload")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		protected = append(protected, p)
+	}
+
+	for _, p := range protected {
+		if _, err := receiver.UnprotectRTP(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := receiver.UnprotectRTP(protected[99]); err != ErrReplayed {
+		t.Errorf("replay of newest packet: got %v, expected ErrReplayed", err)
+	}
+
+	if _, err := receiver.UnprotectRTP(protected[80]); err != ErrReplayed {
+		t.Errorf("replay within window: got %v, expected ErrReplayed", err)
+	}
+
+	if _, err := receiver.UnprotectRTP(protected[0]); err != ErrReplayed {
+		t.Errorf("packet behind window: got %v, expected ErrReplayed", err)
+	}
+
+	fresh, err := sender.ProtectRTP(rtpPacket(101, []byte("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh[rtpHeaderLength] ^= 1
+	if _, err := receiver.UnprotectRTP(fresh); err != ErrAuthFailed {
+		t.Errorf("tampered packet: got %v, expected ErrAuthFailed", err)
+	}
+}
+
+func TestRollover(t *testing.T) {
+	for _, profile := range []ProtectionProfile{ProfileAES128CMHMACSHA1_80, ProfileAEADAES128GCM} {
+		sender, receiver := newContexts(t, profile)
+
+		seq := uint16(0xfff0)
+		for i := 0; i < 0x20; i++ {
+			payload := []byte{byte(i), byte(seq)}
+			protected, err := sender.ProtectRTP(rtpPacket(seq, payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := receiver.UnprotectRTP(protected)
+			if err != nil {
+				t.Fatalf("profile %d seq %#x: %v", profile, seq, err)
+			}
+
+			if !bytes.Equal(out[rtpHeaderLength:], payload) {
+				t.Fatalf("profile %d seq %#x: got payload %x", profile, seq, out[rtpHeaderLength:])
+			}
+
+			seq++
+		}
+
+		if roc := sender.ROC(0xdeadbeef); roc != 1 {
+			t.Errorf("profile %d: sender ROC is %d, expected 1", profile, roc)
+		}
+		if roc := receiver.ROC(0xdeadbeef); roc != 1 {
+			t.Errorf("profile %d: receiver ROC is %d, expected 1", profile, roc)
+		}
+	}
+}
+
+func TestMKI(t *testing.T) {
+	mki := []byte{0, 0, 0, 7}
+	rtcp := []byte{0x80, 0xc8, 0, 1, 0xde, 0xad, 0xbe, 0xef, 1, 2, 3, 4}
+
+	for _, profile := range []ProtectionProfile{ProfileAES128CMHMACSHA1_80,
+		ProfileAES128CMHMACSHA1_32, ProfileAEADAES128GCM} {
+		sender, receiver := newContexts(t, profile)
+		sender.SetMKI(mki)
+		receiver.SetMKI(mki)
+
+		packet := rtpPacket(1, []byte("payload"))
+		protected, err := sender.ProtectRTP(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := PacketMKI(profile, protected, len(mki)); err != nil || !bytes.Equal(got, mki) {
+			t.Errorf("profile %d: got MKI %x, %v, expected %x", profile, got, err, mki)
+		}
+
+		if out, err := receiver.UnprotectRTP(protected); err != nil || !bytes.Equal(out, packet) {
+			t.Errorf("profile %d: got %x, %v, expected %x", profile, out, err, packet)
+		}
+
+		protected, err = sender.ProtectRTCP(rtcp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := PacketMKI(profile, protected, len(mki)); err != nil || !bytes.Equal(got, mki) {
+			t.Errorf("profile %d: got RTCP MKI %x, %v, expected %x", profile, got, err, mki)
+		}
+
+		if out, err := receiver.UnprotectRTCP(protected); err != nil || !bytes.Equal(out, rtcp) {
+			t.Errorf("profile %d: got RTCP %x, %v, expected %x", profile, out, err, rtcp)
+		}
+
+		other, _ := newContexts(t, profile)
+		other.SetMKI([]byte{0, 0, 0, 8})
+		protected, err = other.ProtectRTP(rtpPacket(2, []byte("payload")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := receiver.UnprotectRTP(protected); err != ErrUnknownMKI {
+			t.Errorf("profile %d: other MKI: got %v, expected ErrUnknownMKI", profile, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// AttrType represents the type of an attribute.
+type AttrType uint16
+
+// Possible AttrTypes from RFC 5389.
+const (
+	AttrMappedAddress     AttrType = 0x0001
+	AttrUsername          AttrType = 0x0006
+	AttrMessageIntegrity  AttrType = 0x0008
+	AttrErrorCode         AttrType = 0x0009
+	AttrUnknownAttributes AttrType = 0x000a
+	AttrRealm             AttrType = 0x0014
+	AttrNonce             AttrType = 0x0015
+	AttrXORMappedAddress  AttrType = 0x0020
+	AttrSoftware          AttrType = 0x8022
+	AttrAlternateServer   AttrType = 0x8023
+	AttrFingerprint       AttrType = 0x8028
+)
+
+// Possible AttrTypes from RFC 8445, used by ICE connectivity checks.
+const (
+	AttrPriority       AttrType = 0x0024
+	AttrUseCandidate   AttrType = 0x0025
+	AttrICEControlled  AttrType = 0x8029
+	AttrICEControlling AttrType = 0x802a
+)
+
+// Possible AttrTypes from RFC 5766, used by TURN.
+const (
+	AttrChannelNumber      AttrType = 0x000c
+	AttrLifetime           AttrType = 0x000d
+	AttrXORPeerAddress     AttrType = 0x0012
+	AttrData               AttrType = 0x0013
+	AttrXORRelayedAddress  AttrType = 0x0016
+	AttrEvenPort           AttrType = 0x0018
+	AttrRequestedTransport AttrType = 0x0019
+	AttrDontFragment       AttrType = 0x001a
+	AttrReservationToken   AttrType = 0x0022
+)
+
+// Address families used in address attributes.
+const (
+	familyIPv4 = 0x01
+	familyIPv6 = 0x02
+)
+
+// Error codes from RFC 5389, RFC 5766 and RFC 8445.
+const (
+	CodeTryAlternate           = 300
+	CodeBadRequest             = 400
+	CodeUnauthorized           = 401
+	CodeForbidden              = 403
+	CodeUnknownAttribute       = 420
+	CodeAllocationMismatch     = 437
+	CodeStaleNonce             = 438
+	CodeWrongCredentials       = 441
+	CodeUnsupportedTransport   = 442
+	CodeAllocationQuotaReached = 486
+	CodeRoleConflict           = 487
+	CodeServerError            = 500
+	CodeInsufficientCapacity   = 508
+)
+
+// Error represents an ERROR-CODE attribute.
+type Error struct {
+	Code   int
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stun: error %d: %s", e.Code, e.Reason)
+}
+
+func encodeAddress(addr *net.UDPAddr, xor bool, id TransactionID) []byte {
+	ip := addr.IP.To4()
+	family := byte(familyIPv4)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = familyIPv6
+	}
+
+	b := make([]byte, 4+len(ip))
+	b[1] = family
+	binary.BigEndian.PutUint16(b[2:], uint16(addr.Port))
+	copy(b[4:], ip)
+
+	if xor {
+		xorAddress(b, id)
+	}
+
+	return b
+}
+
+// xorAddress applies the XOR-MAPPED-ADDRESS obfuscation to an encoded
+// address in place.
+func xorAddress(b []byte, id TransactionID) {
+	var key [16]byte
+	binary.BigEndian.PutUint32(key[:], MagicCookie)
+	copy(key[4:], id[:])
+
+	b[2] ^= key[0]
+	b[3] ^= key[1]
+	for i := 4; i < len(b); i++ {
+		b[i] ^= key[i-4]
+	}
+}
+
+func decodeAddress(value []byte, xor bool, id TransactionID) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, ErrInvalidMessage
+	}
+
+	b := append([]byte(nil), value...)
+	switch b[1] {
+	case familyIPv4:
+		if len(b) != 4+net.IPv4len {
+			return nil, ErrInvalidMessage
+		}
+	case familyIPv6:
+		if len(b) != 4+net.IPv6len {
+			return nil, ErrInvalidMessage
+		}
+	default:
+		return nil, ErrInvalidMessage
+	}
+
+	if xor {
+		xorAddress(b, id)
+	}
+
+	return &net.UDPAddr{
+		IP:   net.IP(b[4:]),
+		Port: int(binary.BigEndian.Uint16(b[2:])),
+	}, nil
+}
+
+// AddAddress appends an unobfuscated address attribute, such as
+// MAPPED-ADDRESS.
+func (m *Message) AddAddress(t AttrType, addr *net.UDPAddr) {
+	m.Add(t, encodeAddress(addr, false, m.TransactionID))
+}
+
+// Address returns the value of an unobfuscated address attribute.
+func (m *Message) Address(t AttrType) (*net.UDPAddr, error) {
+	value, found := m.Get(t)
+	if !found {
+		return nil, ErrAttributeNotFound
+	}
+
+	return decodeAddress(value, false, m.TransactionID)
+}
+
+// AddXORAddress appends an XOR obfuscated address attribute, such as
+// XOR-MAPPED-ADDRESS.
+func (m *Message) AddXORAddress(t AttrType, addr *net.UDPAddr) {
+	m.Add(t, encodeAddress(addr, true, m.TransactionID))
+}
+
+// XORAddress returns the value of an XOR obfuscated address attribute.
+func (m *Message) XORAddress(t AttrType) (*net.UDPAddr, error) {
+	value, found := m.Get(t)
+	if !found {
+		return nil, ErrAttributeNotFound
+	}
+
+	return decodeAddress(value, true, m.TransactionID)
+}
+
+// AddErrorCode appends an ERROR-CODE attribute.
+func (m *Message) AddErrorCode(code int, reason string) {
+	b := make([]byte, 4, 4+len(reason))
+	b[2] = byte(code / 100)
+	b[3] = byte(code % 100)
+	m.Add(AttrErrorCode, append(b, reason...))
+}
+
+// ErrorCode returns the value of the ERROR-CODE attribute.
+func (m *Message) ErrorCode() (*Error, error) {
+	value, found := m.Get(AttrErrorCode)
+	if !found {
+		return nil, ErrAttributeNotFound
+	}
+
+	if len(value) < 4 {
+		return nil, ErrInvalidMessage
+	}
+
+	return &Error{
+		Code:   int(value[2]&0x07)*100 + int(value[3]),
+		Reason: string(value[4:]),
+	}, nil
+}
+
+// AddString appends an attribute with a string value, such as USERNAME,
+// REALM, NONCE or SOFTWARE.
+func (m *Message) AddString(t AttrType, s string) {
+	m.Add(t, []byte(s))
+}
+
+// GetString returns the value of an attribute with a string value.
+func (m *Message) GetString(t AttrType) (string, error) {
+	value, found := m.Get(t)
+	if !found {
+		return "", ErrAttributeNotFound
+	}
+
+	return string(value), nil
+}
@@ -0,0 +1,130 @@
+package stun
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Default retransmission parameters from RFC 5389 section 7.2.1.
+const (
+	DefaultRTO         = 500 * time.Millisecond
+	DefaultRetransmits = 7
+	lastTimeoutFactor  = 16
+)
+
+// ErrTimeout is returned when a request receives no response.
+var ErrTimeout = errors.New("stun: request timed out")
+
+// Client sends requests to a STUN server over a packet connection.
+type Client struct {
+	conn net.PacketConn
+
+	// RTO is the initial retransmission timeout, which doubles after each
+	// retransmission.
+	RTO time.Duration
+
+	// Retransmits is the number of times a request is sent before giving
+	// up.
+	Retransmits int
+
+	// Software, if set, is sent in the SOFTWARE attribute of requests.
+	Software string
+}
+
+// NewClient returns a new client that sends requests over the given
+// connection. While a request is in progress, the client reads from the
+// connection and discards any packets that are not its response.
+func NewClient(conn net.PacketConn) *Client {
+	return &Client{
+		conn:        conn,
+		RTO:         DefaultRTO,
+		Retransmits: DefaultRetransmits,
+	}
+}
+
+// Request sends a request to the server and waits for its response,
+// retransmitting the request over unreliable transports. Error responses
+// are returned as a *Error.
+func (c *Client) Request(server net.Addr, req *Message) (*Message, error) {
+	raw := req.Marshal()
+	buf := make([]byte, 1500)
+	rto := c.RTO
+
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	for attempt := 0; attempt < c.Retransmits; attempt++ {
+		if _, err := c.conn.WriteTo(raw, server); err != nil {
+			return nil, err
+		}
+
+		timeout := rto
+		if attempt == c.Retransmits-1 {
+			timeout = c.RTO * lastTimeoutFactor
+		}
+		deadline := time.Now().Add(timeout)
+		c.conn.SetReadDeadline(deadline)
+
+		for {
+			n, _, err := c.conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+
+				return nil, err
+			}
+
+			resp, err := Parse(buf[:n])
+			if err != nil || resp.TransactionID != req.TransactionID ||
+				(resp.Class != ClassSuccessResponse &&
+					resp.Class != ClassErrorResponse) {
+				continue
+			}
+
+			if err := resp.CheckFingerprint(); err != nil {
+				continue
+			}
+
+			if resp.Class == ClassErrorResponse {
+				stunErr, err := resp.ErrorCode()
+				if err != nil {
+					return nil, err
+				}
+
+				return resp, stunErr
+			}
+
+			return resp, nil
+		}
+
+		rto *= 2
+	}
+
+	return nil, ErrTimeout
+}
+
+// Discover sends a binding request to the server and returns the address
+// the server saw the request come from, which is the server-reflexive
+// address of the connection if it is behind a NAT.
+func (c *Client) Discover(server net.Addr) (*net.UDPAddr, error) {
+	req := NewMessage(MethodBinding, ClassRequest)
+	if c.Software != "" {
+		req.AddString(AttrSoftware, c.Software)
+	}
+	req.AddFingerprint()
+
+	resp, err := c.Request(server, req)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := resp.XORAddress(AttrXORMappedAddress)
+	if err == ErrAttributeNotFound {
+		// Servers implementing RFC 3489 only return MAPPED-ADDRESS.
+		return resp.Address(AttrMappedAddress)
+	}
+
+	return addr, err
+}
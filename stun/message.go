@@ -0,0 +1,320 @@
+// Package stun implements Session Traversal Utilities for NAT (RFC 5389),
+// used by peers to discover the address a NAT maps them to. It includes a
+// client for sending binding requests and a small binding server.
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+// MagicCookie is the fixed value in every STUN message header.
+const MagicCookie = 0x2112A442
+
+const (
+	headerLength      = 20
+	integrityLength   = 20
+	fingerprintLength = 4
+	fingerprintXOR    = 0x5354554e
+)
+
+// Errors returned when parsing and verifying messages.
+var (
+	ErrInvalidMessage    = errors.New("stun: invalid message")
+	ErrIntegrityMismatch = errors.New("stun: message integrity check failed")
+	ErrNoIntegrity       = errors.New("stun: message has no integrity")
+	ErrFingerprint       = errors.New("stun: fingerprint mismatch")
+	ErrAttributeNotFound = errors.New("stun: attribute not found")
+)
+
+// Method represents a STUN method.
+type Method uint16
+
+// Possible Methods, including those from RFC 5766 used by TURN.
+const (
+	MethodBinding          Method = 0x001
+	MethodAllocate         Method = 0x003
+	MethodRefresh          Method = 0x004
+	MethodSend             Method = 0x006
+	MethodData             Method = 0x007
+	MethodCreatePermission Method = 0x008
+	MethodChannelBind      Method = 0x009
+)
+
+// Class represents a STUN message class.
+type Class uint8
+
+// Possible Classes.
+const (
+	ClassRequest Class = iota
+	ClassIndication
+	ClassSuccessResponse
+	ClassErrorResponse
+)
+
+// TransactionID represents the transaction ID of a message.
+type TransactionID [12]byte
+
+// NewTransactionID returns a new random transaction ID.
+func NewTransactionID() TransactionID {
+	var id TransactionID
+	if _, err := rand.Read(id[:]); err != nil {
+		panic("stun: failed to generate transaction ID: " + err.Error())
+	}
+
+	return id
+}
+
+// Attribute represents an attribute of a message.
+type Attribute struct {
+	Type  AttrType
+	Value []byte
+
+	// offset is the offset of the attribute in the raw message it was
+	// parsed from.
+	offset int
+}
+
+// Message represents a STUN message.
+type Message struct {
+	Method        Method
+	Class         Class
+	TransactionID TransactionID
+	Attributes    []Attribute
+
+	// raw is the message as it was parsed, for verifying integrity.
+	raw []byte
+}
+
+// NewMessage returns a new message with a random transaction ID.
+func NewMessage(method Method, class Class) *Message {
+	return &Message{
+		Method:        method,
+		Class:         class,
+		TransactionID: NewTransactionID(),
+	}
+}
+
+// NewResponse returns a new response to the given request, with the same
+// method and transaction ID.
+func NewResponse(req *Message, class Class) *Message {
+	return &Message{
+		Method:        req.Method,
+		Class:         class,
+		TransactionID: req.TransactionID,
+	}
+}
+
+func (m *Message) messageType() uint16 {
+	method := uint16(m.Method)
+	class := uint16(m.Class)
+	return method&0x000f | (method&0x0070)<<1 | (method&0x0f80)<<2 |
+		(class&1)<<4 | (class&2)<<7
+}
+
+// Add appends an attribute to the message.
+func (m *Message) Add(t AttrType, value []byte) {
+	m.Attributes = append(m.Attributes, Attribute{Type: t, Value: value})
+}
+
+// Get returns the value of the first attribute of the given type.
+func (m *Message) Get(t AttrType) ([]byte, bool) {
+	for _, attr := range m.Attributes {
+		if attr.Type == t {
+			return attr.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Contains returns whether the message has an attribute of the given type.
+func (m *Message) Contains(t AttrType) bool {
+	_, found := m.Get(t)
+	return found
+}
+
+func padding(n int) int {
+	return (4 - n%4) % 4
+}
+
+// marshal encodes the message header and attributes, setting the length
+// field to the given length regardless of the attributes' length.
+func (m *Message) marshal(length int) []byte {
+	b := make([]byte, headerLength, headerLength+length)
+	binary.BigEndian.PutUint16(b, m.messageType())
+	binary.BigEndian.PutUint16(b[2:], uint16(length))
+	binary.BigEndian.PutUint32(b[4:], MagicCookie)
+	copy(b[8:], m.TransactionID[:])
+
+	for _, attr := range m.Attributes {
+		var header [4]byte
+		binary.BigEndian.PutUint16(header[:], uint16(attr.Type))
+		binary.BigEndian.PutUint16(header[2:], uint16(len(attr.Value)))
+		b = append(b, header[:]...)
+		b = append(b, attr.Value...)
+		b = append(b, make([]byte, padding(len(attr.Value)))...)
+	}
+
+	return b
+}
+
+func (m *Message) attributesLength() int {
+	n := 0
+	for _, attr := range m.Attributes {
+		n += 4 + len(attr.Value) + padding(len(attr.Value))
+	}
+
+	return n
+}
+
+// Marshal encodes the message into its wire format.
+func (m *Message) Marshal() []byte {
+	return m.marshal(m.attributesLength())
+}
+
+// IsMessage returns whether the given packet looks like a STUN message,
+// for demultiplexing STUN from other protocols on the same socket.
+func IsMessage(b []byte) bool {
+	return len(b) >= headerLength && b[0]&0xc0 == 0 &&
+		binary.BigEndian.Uint32(b[4:]) == MagicCookie
+}
+
+// Parse decodes a message from its wire format.
+func Parse(b []byte) (*Message, error) {
+	if !IsMessage(b) {
+		return nil, ErrInvalidMessage
+	}
+
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length%4 != 0 || len(b) < headerLength+length {
+		return nil, ErrInvalidMessage
+	}
+	b = b[:headerLength+length]
+
+	t := binary.BigEndian.Uint16(b)
+	m := &Message{
+		Method: Method(t&0x000f | (t&0x00e0)>>1 | (t&0x3e00)>>2),
+		Class:  Class((t>>4)&1 | (t>>7)&2),
+		raw:    append([]byte(nil), b...),
+	}
+	copy(m.TransactionID[:], b[8:])
+
+	n := headerLength
+	for n < len(b) {
+		if n+4 > len(b) {
+			return nil, ErrInvalidMessage
+		}
+
+		attrLength := int(binary.BigEndian.Uint16(b[n+2:]))
+		if n+4+attrLength > len(b) {
+			return nil, ErrInvalidMessage
+		}
+
+		m.Attributes = append(m.Attributes, Attribute{
+			Type:   AttrType(binary.BigEndian.Uint16(b[n:])),
+			Value:  m.raw[n+4 : n+4+attrLength],
+			offset: n,
+		})
+
+		n += 4 + attrLength + padding(attrLength)
+	}
+
+	return m, nil
+}
+
+// ShortTermKey returns the key used for MESSAGE-INTEGRITY with short-term
+// credentials.
+func ShortTermKey(password string) []byte {
+	return []byte(password)
+}
+
+// LongTermKey returns the key used for MESSAGE-INTEGRITY with long-term
+// credentials.
+func LongTermKey(username, realm, password string) []byte {
+	sum := md5.Sum([]byte(strings.Join([]string{username, realm, password}, ":")))
+	return sum[:]
+}
+
+// AddIntegrity appends a MESSAGE-INTEGRITY attribute computed with the given
+// key. It must be added after all other attributes except FINGERPRINT.
+func (m *Message) AddIntegrity(key []byte) {
+	length := m.attributesLength() + 4 + integrityLength
+	mac := hmac.New(sha1.New, key)
+	mac.Write(m.marshal(length))
+	m.Add(AttrMessageIntegrity, mac.Sum(nil))
+}
+
+// CheckIntegrity verifies the MESSAGE-INTEGRITY attribute of a parsed
+// message with the given key.
+func (m *Message) CheckIntegrity(key []byte) error {
+	for _, attr := range m.Attributes {
+		if attr.Type != AttrMessageIntegrity {
+			continue
+		}
+
+		if m.raw == nil || len(attr.Value) != integrityLength {
+			return ErrIntegrityMismatch
+		}
+
+		// The length field covers the message up to and including the
+		// MESSAGE-INTEGRITY attribute.
+		prefix := append([]byte(nil), m.raw[:attr.offset]...)
+		binary.BigEndian.PutUint16(prefix[2:],
+			uint16(attr.offset+4+integrityLength-headerLength))
+
+		mac := hmac.New(sha1.New, key)
+		mac.Write(prefix)
+		if !hmac.Equal(mac.Sum(nil), attr.Value) {
+			return ErrIntegrityMismatch
+		}
+
+		return nil
+	}
+
+	return ErrNoIntegrity
+}
+
+// AddFingerprint appends a FINGERPRINT attribute. It must be the last
+// attribute added.
+func (m *Message) AddFingerprint() {
+	length := m.attributesLength() + 4 + fingerprintLength
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value,
+		crc32.ChecksumIEEE(m.marshal(length))^fingerprintXOR)
+	m.Add(AttrFingerprint, value)
+}
+
+// CheckFingerprint verifies the FINGERPRINT attribute of a parsed message,
+// if it has one.
+func (m *Message) CheckFingerprint() error {
+	if len(m.Attributes) == 0 {
+		return nil
+	}
+
+	last := m.Attributes[len(m.Attributes)-1]
+	if last.Type != AttrFingerprint {
+		if m.Contains(AttrFingerprint) {
+			return ErrFingerprint
+		}
+
+		return nil
+	}
+
+	if m.raw == nil || len(last.Value) != fingerprintLength {
+		return ErrFingerprint
+	}
+
+	if binary.BigEndian.Uint32(last.Value) !=
+		crc32.ChecksumIEEE(m.raw[:last.offset])^fingerprintXOR {
+		return ErrFingerprint
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package stun
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// Server represents a STUN server that responds to binding requests.
+type Server struct {
+	// Software, if set, is sent in the SOFTWARE attribute of responses.
+	Software string
+
+	// Password, if set, requires requests to be authenticated with
+	// short-term credentials using this password.
+	Password string
+}
+
+// knownAttributes are the comprehension-required attributes the server
+// understands.
+var knownAttributes = map[AttrType]bool{
+	AttrMappedAddress:    true,
+	AttrUsername:         true,
+	AttrMessageIntegrity: true,
+	AttrErrorCode:        true,
+	AttrRealm:            true,
+	AttrNonce:            true,
+	AttrXORMappedAddress: true,
+}
+
+// ListenAndServe listens on the given UDP address and serves binding
+// requests.
+func (s *Server) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return s.Serve(conn)
+}
+
+// Serve serves binding requests received on the given connection until it
+// is closed.
+func (s *Server) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		req, err := Parse(buf[:n])
+		if err != nil || req.Class != ClassRequest {
+			continue
+		}
+
+		resp := s.handle(req, udpAddr)
+		if resp == nil {
+			continue
+		}
+
+		conn.WriteTo(resp.Marshal(), addr)
+	}
+}
+
+// handle returns the response to a request, or nil if the request should
+// be silently discarded.
+func (s *Server) handle(req *Message, addr *net.UDPAddr) *Message {
+	if err := req.CheckFingerprint(); err != nil {
+		return nil
+	}
+
+	var unknown []byte
+	for _, attr := range req.Attributes {
+		if attr.Type < 0x8000 && !knownAttributes[attr.Type] {
+			var t [2]byte
+			binary.BigEndian.PutUint16(t[:], uint16(attr.Type))
+			unknown = append(unknown, t[:]...)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return s.errorResponse(req, CodeUnknownAttribute, "Unknown Attribute",
+			Attribute{Type: AttrUnknownAttributes, Value: unknown})
+	}
+
+	if req.Method != MethodBinding {
+		return s.errorResponse(req, CodeBadRequest, "Bad Request")
+	}
+
+	var key []byte
+	if s.Password != "" {
+		if !req.Contains(AttrMessageIntegrity) || !req.Contains(AttrUsername) {
+			return s.errorResponse(req, CodeBadRequest, "Bad Request")
+		}
+
+		key = ShortTermKey(s.Password)
+		if req.CheckIntegrity(key) != nil {
+			return s.errorResponse(req, CodeUnauthorized, "Unauthorized")
+		}
+	}
+
+	resp := NewResponse(req, ClassSuccessResponse)
+	resp.AddXORAddress(AttrXORMappedAddress, addr)
+	resp.AddAddress(AttrMappedAddress, addr)
+	if s.Software != "" {
+		resp.AddString(AttrSoftware, s.Software)
+	}
+	if key != nil {
+		resp.AddIntegrity(key)
+	}
+	resp.AddFingerprint()
+
+	return resp
+}
+
+func (s *Server) errorResponse(req *Message, code int, reason string, attrs ...Attribute) *Message {
+	resp := NewResponse(req, ClassErrorResponse)
+	resp.AddErrorCode(code, reason)
+	resp.Attributes = append(resp.Attributes, attrs...)
+	if s.Software != "" {
+		resp.AddString(AttrSoftware, s.Software)
+	}
+	resp.AddFingerprint()
+
+	return resp
+}
@@ -0,0 +1,278 @@
+package stun
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// Test vectors from RFC 5769.
+const (
+	sampleRequest = `
+		00 01 00 58 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+		80 22 00 10 53 54 55 4e 20 74 65 73 74 20 63 6c 69 65 6e 74
+		00 24 00 04 6e 00 01 ff
+		80 29 00 08 93 2f f9 b1 51 26 3b 36
+		00 06 00 09 65 76 74 6a 3a 68 36 76 59 20 20 20
+		00 08 00 14 9a ea a7 0c bf d8 cb 56 78 1e f2 b5 b2 d3 f2 49 c1 b5 71 a2
+		80 28 00 04 e5 7a 3b cf`
+
+	sampleIPv4Response = `
+		01 01 00 3c 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+		80 22 00 0b 74 65 73 74 20 76 65 63 74 6f 72 20
+		00 20 00 08 00 01 a1 47 e1 12 a6 43
+		00 08 00 14 2b 91 f5 99 fd 9e 90 c3 8c 74 89 f9 2a f9 ba 53 f0 6b e7 d7
+		80 28 00 04 c0 7d 4c 96`
+
+	sampleIPv6Response = `
+		01 01 00 48 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+		80 22 00 0b 74 65 73 74 20 76 65 63 74 6f 72 20
+		00 20 00 14 00 02 a1 47 01 13 a9 fa a5 d3 f1 79 bc 25 f4 b5 be d2 b9 d9
+		00 08 00 14 a3 82 95 4e 4b e6 7b f1 17 84 c9 7c 82 92 c2 75 bf e3 ed 41
+		80 28 00 04 c8 fb 0b 4c`
+
+	sampleLongTermRequest = `
+		00 01 00 60 21 12 a4 42 78 ad 34 33 c6 ad 72 c0 29 da 41 2e
+		00 06 00 12 e3 83 9e e3 83 88 e3 83 aa e3 83 83 e3 82 af e3 82 b9 00 00
+		00 15 00 1c 66 2f 2f 34 39 39 6b 39 35 34 64 36 4f 4c 33 34 6f 4c 39 46
+		53 54 76 79 36 34 73 41
+		00 14 00 0b 65 78 61 6d 70 6c 65 2e 6f 72 67 00
+		00 08 00 14 f6 70 24 65 6d d6 4a 3e 02 b8 e0 71 2e 85 c9 a2 8c a8 96 66`
+
+	samplePassword = "VOkJxbRl1RmTxUk/WvJxBt"
+)
+
+func parseVector(t *testing.T, vector string) (*Message, []byte) {
+	t.Helper()
+
+	raw := decodeHex(t, vector)
+	m, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, raw
+}
+
+func checkVector(t *testing.T, m *Message, key []byte) {
+	t.Helper()
+
+	if err := m.CheckIntegrity(key); err != nil {
+		t.Errorf("integrity: %v", err)
+	}
+	if err := m.CheckFingerprint(); err != nil {
+		t.Errorf("fingerprint: %v", err)
+	}
+	if err := m.CheckIntegrity([]byte("wrong")); err != ErrIntegrityMismatch {
+		t.Errorf("integrity with wrong key: got %v, expected ErrIntegrityMismatch", err)
+	}
+}
+
+func TestSampleRequest(t *testing.T) {
+	m, raw := parseVector(t, sampleRequest)
+	checkVector(t, m, ShortTermKey(samplePassword))
+
+	if m.Method != MethodBinding || m.Class != ClassRequest {
+		t.Errorf("got method %#x class %d, expected binding request", m.Method, m.Class)
+	}
+
+	if username, err := m.GetString(AttrUsername); err != nil || username != "evtj:h6vY" {
+		t.Errorf("got username %q, %v, expected evtj:h6vY", username, err)
+	}
+
+	if software, err := m.GetString(AttrSoftware); err != nil || software != "STUN test client" {
+		t.Errorf("got software %q, %v, expected STUN test client", software, err)
+	}
+
+	if priority, _ := m.Get(AttrPriority); !bytes.Equal(priority, []byte{0x6e, 0x00, 0x01, 0xff}) {
+		t.Errorf("got priority %x", priority)
+	}
+
+	raw[len(raw)-1] ^= 1
+	corrupted, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := corrupted.CheckFingerprint(); err != ErrFingerprint {
+		t.Errorf("corrupted fingerprint: got %v, expected ErrFingerprint", err)
+	}
+}
+
+func TestSampleResponses(t *testing.T) {
+	tests := []struct {
+		vector   string
+		expected *net.UDPAddr
+	}{
+		{sampleIPv4Response, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 32853}},
+		{sampleIPv6Response, &net.UDPAddr{
+			IP:   net.ParseIP("2001:db8:1234:5678:11:2233:4455:6677"),
+			Port: 32853,
+		}},
+	}
+
+	for _, test := range tests {
+		m, _ := parseVector(t, test.vector)
+		checkVector(t, m, ShortTermKey(samplePassword))
+
+		if m.Class != ClassSuccessResponse {
+			t.Errorf("got class %d, expected success response", m.Class)
+		}
+
+		addr, err := m.XORAddress(AttrXORMappedAddress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !addr.IP.Equal(test.expected.IP) || addr.Port != test.expected.Port {
+			t.Errorf("got address %v, expected %v", addr, test.expected)
+		}
+	}
+}
+
+func TestSampleLongTermRequest(t *testing.T) {
+	username := "マトリックス"
+	const (
+		nonce = "f//499k954d6OL34oL9FSTvy64sA"
+		realm = "example.org"
+	)
+
+	// The password is "The\u00adM\u00aatrIX" before SASLprep.
+	key := LongTermKey(username, realm, "TheMatrIX")
+
+	m, raw := parseVector(t, sampleLongTermRequest)
+	checkVector(t, m, key)
+
+	// Messages built with the same attributes are identical, as the vector
+	// pads with zeroes.
+	built := &Message{
+		Method:        MethodBinding,
+		Class:         ClassRequest,
+		TransactionID: m.TransactionID,
+	}
+	built.AddString(AttrUsername, username)
+	built.AddString(AttrNonce, nonce)
+	built.AddString(AttrRealm, realm)
+	built.AddIntegrity(key)
+
+	if marshalled := built.Marshal(); !bytes.Equal(marshalled, raw) {
+		t.Errorf("got %x, expected %x", marshalled, raw)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	key := ShortTermKey(samplePassword)
+	addr := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 3478}
+
+	m := NewMessage(MethodBinding, ClassSuccessResponse)
+	m.AddXORAddress(AttrXORMappedAddress, addr)
+	m.AddString(AttrSoftware, "odd length")
+	m.AddIntegrity(key)
+	m.AddFingerprint()
+
+	parsed, err := Parse(m.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVector(t, parsed, key)
+
+	if parsed.TransactionID != m.TransactionID || parsed.Method != m.Method ||
+		parsed.Class != m.Class {
+		t.Errorf("got header %+v, expected %+v", parsed, m)
+	}
+
+	decoded, err := parsed.XORAddress(AttrXORMappedAddress)
+	if err != nil || !decoded.IP.Equal(addr.IP) || decoded.Port != addr.Port {
+		t.Errorf("got address %v, %v, expected %v", decoded, err, addr)
+	}
+}
+
+func startServer(t *testing.T, s *Server) net.Addr {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go s.Serve(conn)
+
+	return conn.LocalAddr()
+}
+
+func newTestClient(t *testing.T) (*Client, net.PacketConn) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := NewClient(conn)
+	c.RTO = 50 * time.Millisecond
+	c.Retransmits = 3
+
+	return c, conn
+}
+
+func TestLoopbackBinding(t *testing.T) {
+	server := startServer(t, &Server{Software: "test server"})
+	c, conn := newTestClient(t)
+
+	addr, err := c.Discover(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := conn.LocalAddr().(*net.UDPAddr)
+	if !addr.IP.Equal(local.IP) || addr.Port != local.Port {
+		t.Errorf("got mapped address %v, expected %v", addr, local)
+	}
+}
+
+func TestLoopbackAuthenticated(t *testing.T) {
+	server := startServer(t, &Server{Password: "secret"})
+	c, _ := newTestClient(t)
+
+	request := func(password string) (*Message, error) {
+		req := NewMessage(MethodBinding, ClassRequest)
+		req.AddString(AttrUsername, "user")
+		req.AddIntegrity(ShortTermKey(password))
+		req.AddFingerprint()
+
+		return c.Request(server, req)
+	}
+
+	resp, err := request("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.CheckIntegrity(ShortTermKey("secret")); err != nil {
+		t.Errorf("response integrity: %v", err)
+	}
+
+	_, err = request("wrong")
+	if stunErr, ok := err.(*Error); !ok || stunErr.Code != CodeUnauthorized {
+		t.Errorf("wrong password: got %v, expected error 401", err)
+	}
+
+	if _, err := c.Discover(server); err == nil {
+		t.Error("unauthenticated request succeeded")
+	} else if stunErr, ok := err.(*Error); !ok || stunErr.Code != CodeBadRequest {
+		t.Errorf("unauthenticated request: got %v, expected error 400", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Package turn implements Traversal Using Relays around NAT (RFC 5766) over
+// UDP, for calls where neither peer can reach the other directly. It
+// includes a relay server with long-term credential authentication, and a
+// client which exposes an allocation's relayed address as a net.PacketConn.
+package turn
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Channel numbers that may be bound, from RFC 5766 section 11.
+const (
+	MinChannelNumber = 0x4000
+	MaxChannelNumber = 0x7fff
+)
+
+// Lifetimes from RFC 5766.
+const (
+	DefaultLifetime    = 10 * time.Minute
+	MaxLifetime        = time.Hour
+	PermissionLifetime = 5 * time.Minute
+	ChannelLifetime    = 10 * time.Minute
+)
+
+// transportUDP is the protocol number of UDP, used in the
+// REQUESTED-TRANSPORT attribute.
+const transportUDP = 17
+
+const channelDataHeaderLength = 4
+
+// ErrInvalidChannelData is returned when a ChannelData message cannot be
+// parsed.
+var ErrInvalidChannelData = errors.New("turn: invalid ChannelData message")
+
+// isChannelData returns whether the packet looks like a ChannelData message.
+func isChannelData(b []byte) bool {
+	return len(b) >= channelDataHeaderLength && b[0]&0xc0 == 0x40
+}
+
+func encodeChannelData(number uint16, data []byte) []byte {
+	b := make([]byte, channelDataHeaderLength+len(data))
+	binary.BigEndian.PutUint16(b, number)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(data)))
+	copy(b[channelDataHeaderLength:], data)
+	return b
+}
+
+func parseChannelData(b []byte) (uint16, []byte, error) {
+	if !isChannelData(b) {
+		return 0, nil, ErrInvalidChannelData
+	}
+
+	number := binary.BigEndian.Uint16(b)
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if len(b) < channelDataHeaderLength+length {
+		return 0, nil, ErrInvalidChannelData
+	}
+
+	return number, b[channelDataHeaderLength : channelDataHeaderLength+length], nil
+}
+
+func encodeLifetime(d time.Duration) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(d/time.Second))
+	return b
+}
+
+func decodeLifetime(b []byte) (time.Duration, bool) {
+	if len(b) != 4 {
+		return 0, false
+	}
+
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Second, true
+}
+
+func encodeChannelNumber(number uint16) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b, number)
+	return b
+}
@@ -0,0 +1,541 @@
+package turn
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/stun"
+)
+
+// Intervals at which the client refreshes its allocation and bindings,
+// ahead of their expiry.
+const (
+	refreshCheckInterval = 30 * time.Second
+	bindingRefresh       = PermissionLifetime - time.Minute
+)
+
+// readQueueLength is the number of received packets buffered before packets
+// are dropped.
+const readQueueLength = 256
+
+// Errors returned by the client.
+var (
+	ErrClosed           = errors.New("turn: allocation closed")
+	ErrNoChannels       = errors.New("turn: no channel numbers available")
+	ErrInvalidResponse  = errors.New("turn: invalid response from server")
+	ErrUnsupportedAddrs = errors.New("turn: only UDP addresses are supported")
+)
+
+type packet struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// peerBinding represents a channel bound to a peer.
+type peerBinding struct {
+	peer      *net.UDPAddr
+	number    uint16
+	refreshed time.Time
+
+	// ready is closed once the first ChannelBind request completes, with
+	// err set if it failed.
+	ready chan struct{}
+	err   error
+}
+
+// Conn represents an allocation on a TURN server. It implements
+// net.PacketConn, sending and receiving packets through the relayed address.
+type Conn struct {
+	conn     net.PacketConn
+	server   *net.UDPAddr
+	username string
+	password string
+
+	realm    string
+	nonce    string
+	relayed  *net.UDPAddr
+	mapped   *net.UDPAddr
+	lifetime time.Duration
+	renewed  time.Time
+
+	transactions map[stun.TransactionID]chan *stun.Message
+	bindings     map[string]*peerBinding
+	channels     map[uint16]*peerBinding
+	nextChannel  uint16
+
+	queue  chan packet
+	closed chan struct{}
+	err    error
+
+	deadline       time.Time
+	deadlineNotify chan struct{}
+	usageLock      *sync.Mutex
+}
+
+// Allocate requests an allocation from the TURN server with the given
+// long-term credentials. The returned connection takes ownership of conn,
+// which must not be read from by anything else, and closes it when closed.
+// The connection's LocalAddr is the relayed address, which may be used as an
+// ICE relay candidate.
+func Allocate(conn net.PacketConn, server *net.UDPAddr, username, password string) (*Conn, error) {
+	c := &Conn{
+		conn:           conn,
+		server:         server,
+		username:       username,
+		password:       password,
+		transactions:   make(map[stun.TransactionID]chan *stun.Message),
+		bindings:       make(map[string]*peerBinding),
+		channels:       make(map[uint16]*peerBinding),
+		nextChannel:    MinChannelNumber,
+		queue:          make(chan packet, readQueueLength),
+		closed:         make(chan struct{}),
+		deadlineNotify: make(chan struct{}),
+		usageLock:      new(sync.Mutex),
+	}
+
+	go c.readLoop()
+
+	resp, err := c.request(stun.MethodAllocate, func(req *stun.Message) {
+		transport := make([]byte, 4)
+		transport[0] = transportUDP
+		req.Add(stun.AttrRequestedTransport, transport)
+	})
+	if err != nil {
+		c.closeWithError(err)
+		return nil, err
+	}
+
+	relayed, errRelayed := resp.XORAddress(stun.AttrXORRelayedAddress)
+	mapped, errMapped := resp.XORAddress(stun.AttrXORMappedAddress)
+	value, _ := resp.Get(stun.AttrLifetime)
+	lifetime, ok := decodeLifetime(value)
+	if errRelayed != nil || errMapped != nil || !ok {
+		c.closeWithError(ErrInvalidResponse)
+		return nil, ErrInvalidResponse
+	}
+
+	c.usageLock.Lock()
+	c.relayed = relayed
+	c.mapped = mapped
+	c.lifetime = lifetime
+	c.renewed = time.Now()
+	c.usageLock.Unlock()
+
+	go c.refreshLoop()
+
+	return c, nil
+}
+
+// readLoop demultiplexes packets from the server into responses, Data
+// indications and ChannelData messages.
+func (c *Conn) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			c.closeWithError(err)
+			return
+		}
+
+		if addr.String() != c.server.String() {
+			continue
+		}
+
+		if isChannelData(buf[:n]) {
+			number, data, err := parseChannelData(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			c.usageLock.Lock()
+			b := c.channels[number]
+			c.usageLock.Unlock()
+
+			if b != nil {
+				c.deliver(data, b.peer)
+			}
+			continue
+		}
+
+		msg, err := stun.Parse(buf[:n])
+		if err != nil || msg.CheckFingerprint() != nil {
+			continue
+		}
+
+		switch msg.Class {
+		case stun.ClassIndication:
+			if msg.Method != stun.MethodData {
+				continue
+			}
+
+			peer, err := msg.XORAddress(stun.AttrXORPeerAddress)
+			data, found := msg.Get(stun.AttrData)
+			if err == nil && found {
+				c.deliver(data, peer)
+			}
+		case stun.ClassSuccessResponse, stun.ClassErrorResponse:
+			c.usageLock.Lock()
+			ch := c.transactions[msg.TransactionID]
+			delete(c.transactions, msg.TransactionID)
+			c.usageLock.Unlock()
+
+			if ch != nil {
+				ch <- msg
+			}
+		}
+	}
+}
+
+func (c *Conn) deliver(data []byte, from *net.UDPAddr) {
+	select {
+	case c.queue <- packet{data: append([]byte(nil), data...), from: from}:
+	default:
+	}
+}
+
+// transact sends a request to the server, retransmitting it until a
+// response is received.
+func (c *Conn) transact(req *stun.Message) (*stun.Message, error) {
+	ch := make(chan *stun.Message, 1)
+
+	c.usageLock.Lock()
+	c.transactions[req.TransactionID] = ch
+	c.usageLock.Unlock()
+
+	defer func() {
+		c.usageLock.Lock()
+		delete(c.transactions, req.TransactionID)
+		c.usageLock.Unlock()
+	}()
+
+	raw := req.Marshal()
+	rto := stun.DefaultRTO
+	for attempt := 0; attempt < stun.DefaultRetransmits; attempt++ {
+		if _, err := c.conn.WriteTo(raw, c.server); err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(rto)
+		select {
+		case resp := <-ch:
+			timer.Stop()
+			return resp, nil
+		case <-c.closed:
+			timer.Stop()
+			return nil, c.err
+		case <-timer.C:
+		}
+
+		rto *= 2
+	}
+
+	return nil, stun.ErrTimeout
+}
+
+// request sends an authenticated request with attributes added by the given
+// function, retrying once with the server's realm and nonce if they are
+// missing or stale. Error responses are returned as a *stun.Error.
+func (c *Conn) request(method stun.Method, attrs func(req *stun.Message)) (*stun.Message, error) {
+	for attempt := 0; ; attempt++ {
+		c.usageLock.Lock()
+		realm, nonce := c.realm, c.nonce
+		c.usageLock.Unlock()
+
+		req := stun.NewMessage(method, stun.ClassRequest)
+		if attrs != nil {
+			attrs(req)
+		}
+		if nonce != "" {
+			req.AddString(stun.AttrUsername, c.username)
+			req.AddString(stun.AttrRealm, realm)
+			req.AddString(stun.AttrNonce, nonce)
+			req.AddIntegrity(stun.LongTermKey(c.username, realm, c.password))
+		}
+		req.AddFingerprint()
+
+		resp, err := c.transact(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.Class == stun.ClassSuccessResponse {
+			return resp, nil
+		}
+
+		stunErr, err := resp.ErrorCode()
+		if err != nil {
+			return nil, err
+		}
+
+		challenged := stunErr.Code == stun.CodeStaleNonce ||
+			(stunErr.Code == stun.CodeUnauthorized && nonce == "")
+		if !challenged || attempt > 0 {
+			return nil, stunErr
+		}
+
+		realm, errRealm := resp.GetString(stun.AttrRealm)
+		nonce, errNonce := resp.GetString(stun.AttrNonce)
+		if errRealm != nil || errNonce != nil {
+			return nil, stunErr
+		}
+
+		c.usageLock.Lock()
+		c.realm, c.nonce = realm, nonce
+		c.usageLock.Unlock()
+	}
+}
+
+// refreshLoop refreshes the allocation and channel bindings before they
+// expire.
+func (c *Conn) refreshLoop() {
+	ticker := time.NewTicker(refreshCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+
+		c.usageLock.Lock()
+		renew := time.Since(c.renewed) > c.lifetime/2
+		var stale []*peerBinding
+		for _, b := range c.bindings {
+			if b.err == nil && !b.refreshed.IsZero() &&
+				time.Since(b.refreshed) > bindingRefresh {
+				stale = append(stale, b)
+			}
+		}
+		c.usageLock.Unlock()
+
+		if renew {
+			c.refresh(DefaultLifetime)
+		}
+
+		for _, b := range stale {
+			c.bind(b)
+		}
+	}
+}
+
+// refresh sends a Refresh request for the given lifetime.
+func (c *Conn) refresh(lifetime time.Duration) error {
+	resp, err := c.request(stun.MethodRefresh, func(req *stun.Message) {
+		req.Add(stun.AttrLifetime, encodeLifetime(lifetime))
+	})
+	if err != nil {
+		return err
+	}
+
+	value, _ := resp.Get(stun.AttrLifetime)
+	if granted, ok := decodeLifetime(value); ok {
+		c.usageLock.Lock()
+		c.lifetime = granted
+		c.renewed = time.Now()
+		c.usageLock.Unlock()
+	}
+
+	return nil
+}
+
+// bind sends a ChannelBind request for the binding, which also installs or
+// refreshes a permission for the peer.
+func (c *Conn) bind(b *peerBinding) error {
+	_, err := c.request(stun.MethodChannelBind, func(req *stun.Message) {
+		req.Add(stun.AttrChannelNumber, encodeChannelNumber(b.number))
+		req.AddXORAddress(stun.AttrXORPeerAddress, b.peer)
+	})
+
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	if err == nil {
+		b.refreshed = time.Now()
+	}
+
+	return err
+}
+
+// binding returns the channel binding for the peer, binding a new channel
+// if there isn't one yet.
+func (c *Conn) binding(peer *net.UDPAddr) (*peerBinding, error) {
+	key := peer.String()
+
+	c.usageLock.Lock()
+	b, found := c.bindings[key]
+	if !found {
+		if c.nextChannel > MaxChannelNumber {
+			c.usageLock.Unlock()
+			return nil, ErrNoChannels
+		}
+
+		b = &peerBinding{
+			peer:   peer,
+			number: c.nextChannel,
+			ready:  make(chan struct{}),
+		}
+		c.nextChannel++
+		c.bindings[key] = b
+		c.channels[b.number] = b
+	}
+	c.usageLock.Unlock()
+
+	if !found {
+		err := c.bind(b)
+
+		c.usageLock.Lock()
+		b.err = err
+		if err != nil {
+			// Allow binding to be retried by a later write. The channel
+			// number isn't reused, as the server may have bound it.
+			delete(c.bindings, key)
+		}
+		c.usageLock.Unlock()
+
+		close(b.ready)
+	}
+
+	<-b.ready
+	return b, b.err
+}
+
+// ReadFrom reads a packet received from a peer through the relay.
+func (c *Conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.usageLock.Lock()
+		deadline := c.deadline
+		notify := c.deadlineNotify
+		c.usageLock.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case p := <-c.queue:
+			stopTimer(timer)
+			return copy(b, p.data), p.from, nil
+		case <-c.closed:
+			stopTimer(timer)
+			return 0, nil, c.err
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-notify:
+			stopTimer(timer)
+		}
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// WriteTo sends a packet to a peer through the relay. The first write to a
+// peer blocks while a channel is bound to it.
+func (c *Conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, c.err
+	default:
+	}
+
+	peer, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, ErrUnsupportedAddrs
+	}
+
+	binding, err := c.binding(peer)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := c.conn.WriteTo(encodeChannelData(binding.number, b), c.server); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *Conn) closeWithError(err error) {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	if c.err == nil {
+		c.err = err
+		close(c.closed)
+		c.conn.Close()
+	}
+}
+
+// Close deletes the allocation on the server and closes the underlying
+// connection.
+func (c *Conn) Close() error {
+	select {
+	case <-c.closed:
+		return nil
+	default:
+	}
+
+	// Deleting the allocation is best effort, as it expires on its own.
+	done := make(chan struct{})
+	go func() {
+		c.refresh(0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(stun.DefaultRTO * 2):
+	}
+
+	c.closeWithError(ErrClosed)
+	return nil
+}
+
+// LocalAddr returns the relayed address of the allocation.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.relayed
+}
+
+// MappedAddr returns the address the server saw the allocation request come
+// from, which is a server-reflexive address of the underlying connection.
+func (c *Conn) MappedAddr() *net.UDPAddr {
+	return c.mapped
+}
+
+// SetDeadline sets the read deadline. Writes never block once a channel is
+// bound.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for pending and future reads.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	c.deadline = t
+	close(c.deadlineNotify)
+	c.deadlineNotify = make(chan struct{})
+
+	return nil
+}
+
+// SetWriteDeadline has no effect.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
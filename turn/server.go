@@ -0,0 +1,586 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/stun"
+)
+
+// nonceLifetime is how long a nonce is valid before a client must retry
+// with a new one.
+const nonceLifetime = time.Hour
+
+// Server represents a TURN server.
+type Server struct {
+	realm       string
+	credentials func(username string) (string, bool)
+
+	// RelayIP is the IP address relayed addresses are allocated on. If nil,
+	// the IP address of the listening connection is used, so it must be set
+	// when listening on an unspecified address.
+	RelayIP net.IP
+
+	// Software, if set, is sent in the SOFTWARE attribute of responses.
+	Software string
+
+	nonceSecret []byte
+	allocations map[string]*allocation
+	closed      chan struct{}
+	usageLock   *sync.Mutex
+}
+
+// allocation represents a relayed transport address allocated to a client.
+type allocation struct {
+	server   *Server
+	conn     net.PacketConn
+	client   *net.UDPAddr
+	relay    net.PacketConn
+	username string
+	key      []byte
+	expires  time.Time
+
+	// permissions maps peer IP addresses to their expiry time.
+	permissions map[string]time.Time
+	// channels maps channel numbers to bound peers.
+	channels map[uint16]*binding
+	// peerChannels maps peer addresses to channel numbers.
+	peerChannels map[string]uint16
+}
+
+type binding struct {
+	peer    *net.UDPAddr
+	expires time.Time
+}
+
+// NewServer returns a new TURN server which authenticates clients in the
+// given realm with long-term credentials. The credentials function returns
+// the password of a user, and whether the user exists.
+func NewServer(realm string, credentials func(username string) (string, bool)) *Server {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("turn: failed to generate nonce secret: " + err.Error())
+	}
+
+	return &Server{
+		realm:       realm,
+		credentials: credentials,
+		nonceSecret: secret,
+		allocations: make(map[string]*allocation),
+		closed:      make(chan struct{}),
+		usageLock:   new(sync.Mutex),
+	}
+}
+
+// ListenAndServe listens on the given UDP address and serves clients.
+func (s *Server) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return s.Serve(conn)
+}
+
+// Serve serves clients on the given connection until it is closed.
+func (s *Server) Serve(conn net.PacketConn) error {
+	go s.expire()
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		from, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		if isChannelData(buf[:n]) {
+			s.handleChannelData(conn, from, buf[:n])
+			continue
+		}
+
+		msg, err := stun.Parse(buf[:n])
+		if err != nil || msg.CheckFingerprint() != nil {
+			continue
+		}
+
+		resp := s.handle(conn, from, msg)
+		if resp != nil {
+			conn.WriteTo(resp.Marshal(), from)
+		}
+	}
+}
+
+// Close releases all allocations. The listening connections given to Serve
+// must be closed separately.
+func (s *Server) Close() error {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+
+	select {
+	case <-s.closed:
+		return nil
+	default:
+	}
+
+	close(s.closed)
+	for key, a := range s.allocations {
+		a.relay.Close()
+		delete(s.allocations, key)
+	}
+
+	return nil
+}
+
+// expire periodically removes expired allocations, permissions and channel
+// bindings.
+func (s *Server) expire() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+
+		s.usageLock.Lock()
+		for key, a := range s.allocations {
+			if now.After(a.expires) {
+				a.relay.Close()
+				delete(s.allocations, key)
+				continue
+			}
+
+			for ip, expires := range a.permissions {
+				if now.After(expires) {
+					delete(a.permissions, ip)
+				}
+			}
+
+			for number, b := range a.channels {
+				if now.After(b.expires) {
+					delete(a.peerChannels, b.peer.String())
+					delete(a.channels, number)
+				}
+			}
+		}
+		s.usageLock.Unlock()
+	}
+}
+
+// allocationKey returns the key identifying the allocation of a client's
+// 5-tuple.
+func allocationKey(conn net.PacketConn, client *net.UDPAddr) string {
+	return conn.LocalAddr().String() + "/" + client.String()
+}
+
+func (s *Server) nonce(client *net.UDPAddr) string {
+	ts := strconv.FormatInt(time.Now().Unix(), 16)
+	mac := hmac.New(sha256.New, s.nonceSecret)
+	mac.Write([]byte(ts + client.IP.String()))
+	return ts + "-" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// checkNonce returns whether the nonce was issued by this server to the
+// client, and whether it is stale.
+func (s *Server) checkNonce(nonce string, client *net.UDPAddr) (valid, stale bool) {
+	parts := strings.SplitN(nonce, "-", 2)
+	if len(parts) != 2 {
+		return false, false
+	}
+
+	mac := hmac.New(sha256.New, s.nonceSecret)
+	mac.Write([]byte(parts[0] + client.IP.String()))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil)[:16])), []byte(parts[1])) {
+		return false, false
+	}
+
+	ts, err := strconv.ParseInt(parts[0], 16, 64)
+	if err != nil {
+		return false, false
+	}
+
+	return true, time.Since(time.Unix(ts, 0)) > nonceLifetime
+}
+
+func (s *Server) errorResponse(req *stun.Message, code int, reason string, key []byte) *stun.Message {
+	resp := stun.NewResponse(req, stun.ClassErrorResponse)
+	resp.AddErrorCode(code, reason)
+	if s.Software != "" {
+		resp.AddString(stun.AttrSoftware, s.Software)
+	}
+	if key != nil {
+		resp.AddIntegrity(key)
+	}
+	resp.AddFingerprint()
+
+	return resp
+}
+
+func (s *Server) challenge(req *stun.Message, code int, reason string, client *net.UDPAddr) *stun.Message {
+	resp := stun.NewResponse(req, stun.ClassErrorResponse)
+	resp.AddErrorCode(code, reason)
+	resp.AddString(stun.AttrRealm, s.realm)
+	resp.AddString(stun.AttrNonce, s.nonce(client))
+	if s.Software != "" {
+		resp.AddString(stun.AttrSoftware, s.Software)
+	}
+	resp.AddFingerprint()
+
+	return resp
+}
+
+// authenticate verifies the long-term credentials of a request, returning
+// the username and key, or the error response to send.
+func (s *Server) authenticate(req *stun.Message, client *net.UDPAddr) (string, []byte, *stun.Message) {
+	if !req.Contains(stun.AttrMessageIntegrity) {
+		return "", nil, s.challenge(req, stun.CodeUnauthorized, "Unauthorized", client)
+	}
+
+	username, errUser := req.GetString(stun.AttrUsername)
+	realm, errRealm := req.GetString(stun.AttrRealm)
+	nonce, errNonce := req.GetString(stun.AttrNonce)
+	if errUser != nil || errRealm != nil || errNonce != nil {
+		return "", nil, s.errorResponse(req, stun.CodeBadRequest, "Bad Request", nil)
+	}
+
+	valid, stale := s.checkNonce(nonce, client)
+	if !valid || stale {
+		return "", nil, s.challenge(req, stun.CodeStaleNonce, "Stale Nonce", client)
+	}
+
+	password, found := s.credentials(username)
+	if !found || realm != s.realm {
+		return "", nil, s.challenge(req, stun.CodeUnauthorized, "Unauthorized", client)
+	}
+
+	key := stun.LongTermKey(username, s.realm, password)
+	if req.CheckIntegrity(key) != nil {
+		return "", nil, s.challenge(req, stun.CodeUnauthorized, "Unauthorized", client)
+	}
+
+	return username, key, nil
+}
+
+func (s *Server) success(req *stun.Message, key []byte, attrs func(resp *stun.Message)) *stun.Message {
+	resp := stun.NewResponse(req, stun.ClassSuccessResponse)
+	if attrs != nil {
+		attrs(resp)
+	}
+	if s.Software != "" {
+		resp.AddString(stun.AttrSoftware, s.Software)
+	}
+	resp.AddIntegrity(key)
+	resp.AddFingerprint()
+
+	return resp
+}
+
+// handle handles a STUN message from a client, returning the response to
+// send, if any.
+func (s *Server) handle(conn net.PacketConn, client *net.UDPAddr, msg *stun.Message) *stun.Message {
+	if msg.Class == stun.ClassIndication {
+		if msg.Method == stun.MethodSend {
+			s.handleSend(conn, client, msg)
+		}
+		return nil
+	}
+
+	if msg.Class != stun.ClassRequest {
+		return nil
+	}
+
+	if msg.Method == stun.MethodBinding {
+		resp := stun.NewResponse(msg, stun.ClassSuccessResponse)
+		resp.AddXORAddress(stun.AttrXORMappedAddress, client)
+		resp.AddFingerprint()
+		return resp
+	}
+
+	username, key, errResp := s.authenticate(msg, client)
+	if errResp != nil {
+		return errResp
+	}
+
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+
+	a := s.allocations[allocationKey(conn, client)]
+	if msg.Method == stun.MethodAllocate {
+		return s.allocate(conn, client, msg, username, key, a)
+	}
+
+	if a == nil {
+		return s.errorResponse(msg, stun.CodeAllocationMismatch, "Allocation Mismatch", key)
+	}
+
+	if a.username != username {
+		return s.errorResponse(msg, stun.CodeWrongCredentials, "Wrong Credentials", key)
+	}
+
+	switch msg.Method {
+	case stun.MethodRefresh:
+		return s.refresh(msg, key, a)
+	case stun.MethodCreatePermission:
+		return s.createPermission(msg, key, a)
+	case stun.MethodChannelBind:
+		return s.channelBind(msg, key, a)
+	default:
+		return s.errorResponse(msg, stun.CodeBadRequest, "Bad Request", key)
+	}
+}
+
+// requestedLifetime returns the lifetime requested by a message, bounded by
+// the maximum lifetime.
+func requestedLifetime(msg *stun.Message) time.Duration {
+	lifetime := DefaultLifetime
+	if value, found := msg.Get(stun.AttrLifetime); found {
+		if d, ok := decodeLifetime(value); ok {
+			lifetime = d
+		}
+	}
+
+	if lifetime > MaxLifetime {
+		lifetime = MaxLifetime
+	}
+
+	return lifetime
+}
+
+// allocate handles an Allocate request. The usageLock must be held.
+func (s *Server) allocate(conn net.PacketConn, client *net.UDPAddr, req *stun.Message,
+	username string, key []byte, existing *allocation) *stun.Message {
+	if existing != nil {
+		return s.errorResponse(req, stun.CodeAllocationMismatch, "Allocation Mismatch", key)
+	}
+
+	transport, found := req.Get(stun.AttrRequestedTransport)
+	if !found || len(transport) != 4 {
+		return s.errorResponse(req, stun.CodeBadRequest, "Bad Request", key)
+	}
+
+	if transport[0] != transportUDP {
+		return s.errorResponse(req, stun.CodeUnsupportedTransport,
+			"Unsupported Transport Protocol", key)
+	}
+
+	if req.Contains(stun.AttrEvenPort) || req.Contains(stun.AttrReservationToken) {
+		return s.errorResponse(req, stun.CodeBadRequest, "Bad Request", key)
+	}
+
+	relayIP := s.RelayIP
+	if relayIP == nil {
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			relayIP = addr.IP
+		}
+	}
+
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: relayIP})
+	if err != nil {
+		return s.errorResponse(req, stun.CodeInsufficientCapacity,
+			"Insufficient Capacity", key)
+	}
+
+	lifetime := requestedLifetime(req)
+	if lifetime < DefaultLifetime {
+		lifetime = DefaultLifetime
+	}
+
+	a := &allocation{
+		server:       s,
+		conn:         conn,
+		client:       client,
+		relay:        relay,
+		username:     username,
+		key:          key,
+		expires:      time.Now().Add(lifetime),
+		permissions:  make(map[string]time.Time),
+		channels:     make(map[uint16]*binding),
+		peerChannels: make(map[string]uint16),
+	}
+	s.allocations[allocationKey(conn, client)] = a
+
+	go a.run()
+
+	return s.success(req, key, func(resp *stun.Message) {
+		resp.AddXORAddress(stun.AttrXORRelayedAddress, relay.LocalAddr().(*net.UDPAddr))
+		resp.Add(stun.AttrLifetime, encodeLifetime(lifetime))
+		resp.AddXORAddress(stun.AttrXORMappedAddress, client)
+	})
+}
+
+// refresh handles a Refresh request. The usageLock must be held.
+func (s *Server) refresh(req *stun.Message, key []byte, a *allocation) *stun.Message {
+	lifetime := requestedLifetime(req)
+	if lifetime == 0 {
+		a.relay.Close()
+		delete(s.allocations, allocationKey(a.conn, a.client))
+	} else {
+		if lifetime < DefaultLifetime {
+			lifetime = DefaultLifetime
+		}
+		a.expires = time.Now().Add(lifetime)
+	}
+
+	return s.success(req, key, func(resp *stun.Message) {
+		resp.Add(stun.AttrLifetime, encodeLifetime(lifetime))
+	})
+}
+
+// createPermission handles a CreatePermission request. The usageLock must be
+// held.
+func (s *Server) createPermission(req *stun.Message, key []byte, a *allocation) *stun.Message {
+	var peers []*net.UDPAddr
+	for _, attr := range req.Attributes {
+		if attr.Type != stun.AttrXORPeerAddress {
+			continue
+		}
+
+		m := &stun.Message{TransactionID: req.TransactionID}
+		m.Add(attr.Type, attr.Value)
+		peer, err := m.XORAddress(stun.AttrXORPeerAddress)
+		if err != nil {
+			return s.errorResponse(req, stun.CodeBadRequest, "Bad Request", key)
+		}
+		peers = append(peers, peer)
+	}
+
+	if len(peers) == 0 {
+		return s.errorResponse(req, stun.CodeBadRequest, "Bad Request", key)
+	}
+
+	for _, peer := range peers {
+		a.permissions[peer.IP.String()] = time.Now().Add(PermissionLifetime)
+	}
+
+	return s.success(req, key, nil)
+}
+
+// channelBind handles a ChannelBind request. The usageLock must be held.
+func (s *Server) channelBind(req *stun.Message, key []byte, a *allocation) *stun.Message {
+	value, found := req.Get(stun.AttrChannelNumber)
+	peer, err := req.XORAddress(stun.AttrXORPeerAddress)
+	if !found || len(value) != 4 || err != nil {
+		return s.errorResponse(req, stun.CodeBadRequest, "Bad Request", key)
+	}
+
+	number := binary.BigEndian.Uint16(value)
+	if number < MinChannelNumber || number > MaxChannelNumber {
+		return s.errorResponse(req, stun.CodeBadRequest, "Bad Request", key)
+	}
+
+	// A channel can only be bound to one peer, and a peer to one channel.
+	if b, found := a.channels[number]; found && b.peer.String() != peer.String() {
+		return s.errorResponse(req, stun.CodeBadRequest, "Bad Request", key)
+	}
+	if existing, found := a.peerChannels[peer.String()]; found && existing != number {
+		return s.errorResponse(req, stun.CodeBadRequest, "Bad Request", key)
+	}
+
+	now := time.Now()
+	a.channels[number] = &binding{peer: peer, expires: now.Add(ChannelLifetime)}
+	a.peerChannels[peer.String()] = number
+	a.permissions[peer.IP.String()] = now.Add(PermissionLifetime)
+
+	return s.success(req, key, nil)
+}
+
+// handleSend relays the data of a Send indication to the peer.
+func (s *Server) handleSend(conn net.PacketConn, client *net.UDPAddr, msg *stun.Message) {
+	peer, err := msg.XORAddress(stun.AttrXORPeerAddress)
+	data, found := msg.Get(stun.AttrData)
+	if err != nil || !found {
+		return
+	}
+
+	s.usageLock.Lock()
+	a := s.allocations[allocationKey(conn, client)]
+	permitted := a != nil && a.permitted(peer)
+	s.usageLock.Unlock()
+
+	if permitted {
+		a.relay.WriteTo(data, peer)
+	}
+}
+
+// handleChannelData relays a ChannelData message to the bound peer.
+func (s *Server) handleChannelData(conn net.PacketConn, client *net.UDPAddr, b []byte) {
+	number, data, err := parseChannelData(b)
+	if err != nil {
+		return
+	}
+
+	s.usageLock.Lock()
+	a := s.allocations[allocationKey(conn, client)]
+	var peer *net.UDPAddr
+	if a != nil {
+		if b, found := a.channels[number]; found && a.permitted(b.peer) {
+			peer = b.peer
+		}
+	}
+	s.usageLock.Unlock()
+
+	if peer != nil {
+		a.relay.WriteTo(data, peer)
+	}
+}
+
+// permitted returns whether the allocation has a permission for the peer.
+// The server's usageLock must be held.
+func (a *allocation) permitted(peer *net.UDPAddr) bool {
+	expires, found := a.permissions[peer.IP.String()]
+	return found && time.Now().Before(expires)
+}
+
+// run relays data received from peers on the relayed address to the client.
+func (a *allocation) run() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := a.relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		peer, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		a.server.usageLock.Lock()
+		permitted := a.permitted(peer)
+		number, bound := a.peerChannels[peer.String()]
+		a.server.usageLock.Unlock()
+
+		if !permitted {
+			continue
+		}
+
+		if bound {
+			a.conn.WriteTo(encodeChannelData(number, buf[:n]), a.client)
+			continue
+		}
+
+		ind := stun.NewMessage(stun.MethodData, stun.ClassIndication)
+		ind.AddXORAddress(stun.AttrXORPeerAddress, peer)
+		ind.Add(stun.AttrData, buf[:n])
+		ind.AddFingerprint()
+		a.conn.WriteTo(ind.Marshal(), a.client)
+	}
+}
@@ -0,0 +1,524 @@
+package turn
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1lann/dissonance/stun"
+)
+
+const (
+	testRealm    = "example.org"
+	testUser     = "alice"
+	testPassword = "secret"
+)
+
+func startServer(t *testing.T) (*Server, net.PacketConn) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(testRealm, func(username string) (string, bool) {
+		return testPassword, username == testUser
+	})
+	go s.Serve(conn)
+
+	t.Cleanup(func() {
+		s.Close()
+		conn.Close()
+	})
+
+	return s, conn
+}
+
+func listen(t *testing.T, ip string) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(ip)})
+	if err != nil {
+		t.Skipf("can't listen on %s: %v", ip, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// testClient speaks to the server with raw messages, to exercise requests
+// and indications the client doesn't send itself.
+type testClient struct {
+	t      *testing.T
+	conn   *net.UDPConn
+	server *net.UDPAddr
+
+	realm string
+	nonce string
+
+	// received holds packets read while waiting for a response.
+	received [][]byte
+}
+
+func newTestClient(t *testing.T, server net.PacketConn) *testClient {
+	return &testClient{
+		t:      t,
+		conn:   listen(t, "127.0.0.1"),
+		server: server.LocalAddr().(*net.UDPAddr),
+	}
+}
+
+func (c *testClient) key() []byte {
+	return stun.LongTermKey(testUser, c.realm, testPassword)
+}
+
+// read returns the next packet from the server, or nil if none arrives in
+// time.
+func (c *testClient) read(timeout time.Duration) []byte {
+	if len(c.received) > 0 {
+		b := c.received[0]
+		c.received = c.received[1:]
+		return b
+	}
+
+	buf := make([]byte, 1500)
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := c.conn.Read(buf)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil
+	} else if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return buf[:n]
+}
+
+// send sends a request with the given attributes, authenticated if a nonce
+// has been received, and returns the response.
+func (c *testClient) send(method stun.Method, attrs func(req *stun.Message)) *stun.Message {
+	c.t.Helper()
+
+	req := stun.NewMessage(method, stun.ClassRequest)
+	if attrs != nil {
+		attrs(req)
+	}
+	if c.nonce != "" {
+		req.AddString(stun.AttrUsername, testUser)
+		req.AddString(stun.AttrRealm, c.realm)
+		req.AddString(stun.AttrNonce, c.nonce)
+		req.AddIntegrity(c.key())
+	}
+	req.AddFingerprint()
+
+	if _, err := c.conn.WriteTo(req.Marshal(), c.server); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var skipped [][]byte
+	defer func() { c.received = append(skipped, c.received...) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b := c.read(time.Until(deadline))
+		if b == nil {
+			break
+		}
+
+		resp, err := stun.Parse(b)
+		if err != nil || resp.TransactionID != req.TransactionID {
+			skipped = append(skipped, b)
+			continue
+		}
+
+		if err := resp.CheckFingerprint(); err != nil {
+			c.t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	c.t.Fatal("timed out waiting for a response")
+	return nil
+}
+
+// request sends a request which is expected to succeed.
+func (c *testClient) request(method stun.Method, attrs func(req *stun.Message)) *stun.Message {
+	c.t.Helper()
+
+	resp := c.send(method, attrs)
+	if resp.Class != stun.ClassSuccessResponse {
+		stunErr, _ := resp.ErrorCode()
+		c.t.Fatalf("method %#x: got error %v", method, stunErr)
+	}
+
+	if err := resp.CheckIntegrity(c.key()); err != nil {
+		c.t.Fatalf("method %#x: response integrity: %v", method, err)
+	}
+
+	return resp
+}
+
+// expectError sends a request which is expected to fail with the given code.
+func (c *testClient) expectError(code int, method stun.Method, attrs func(req *stun.Message)) *stun.Message {
+	c.t.Helper()
+
+	resp := c.send(method, attrs)
+	stunErr, err := resp.ErrorCode()
+	if resp.Class != stun.ClassErrorResponse || err != nil || stunErr.Code != code {
+		c.t.Fatalf("method %#x: got %v, expected error %d", method, stunErr, code)
+	}
+
+	return resp
+}
+
+// challenge takes the realm and nonce from an error response.
+func (c *testClient) challenge(resp *stun.Message) {
+	c.t.Helper()
+
+	realm, errRealm := resp.GetString(stun.AttrRealm)
+	nonce, errNonce := resp.GetString(stun.AttrNonce)
+	if errRealm != nil || errNonce != nil {
+		c.t.Fatal("challenge is missing realm or nonce")
+	}
+
+	c.realm, c.nonce = realm, nonce
+}
+
+func requestUDP(req *stun.Message) {
+	req.Add(stun.AttrRequestedTransport, []byte{transportUDP, 0, 0, 0})
+}
+
+// allocate allocates a relayed address, after being challenged for
+// credentials.
+func (c *testClient) allocate() *net.UDPAddr {
+	c.t.Helper()
+
+	c.challenge(c.expectError(stun.CodeUnauthorized, stun.MethodAllocate, requestUDP))
+
+	resp := c.request(stun.MethodAllocate, requestUDP)
+	relayed, err := resp.XORAddress(stun.AttrXORRelayedAddress)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return relayed
+}
+
+// nextData returns the next Data indication or ChannelData message, or nil
+// if none arrives in time.
+func (c *testClient) nextData(timeout time.Duration) []byte {
+	for {
+		b := c.read(timeout)
+		if b == nil || isChannelData(b) {
+			return b
+		}
+
+		if msg, err := stun.Parse(b); err == nil &&
+			msg.Class == stun.ClassIndication && msg.Method == stun.MethodData {
+			return b
+		}
+	}
+}
+
+func readFrom(t *testing.T, conn *net.UDPConn, timeout time.Duration) ([]byte, *net.UDPAddr) {
+	t.Helper()
+
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, addr, err := conn.ReadFromUDP(buf)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf[:n], addr
+}
+
+func lookupAllocation(s *Server, conn net.PacketConn, c *testClient) *allocation {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+
+	return s.allocations[allocationKey(conn, c.conn.LocalAddr().(*net.UDPAddr))]
+}
+
+func TestAllocate(t *testing.T) {
+	s, serverConn := startServer(t)
+	c := newTestClient(t, serverConn)
+
+	resp := c.expectError(stun.CodeUnauthorized, stun.MethodAllocate, requestUDP)
+	c.challenge(resp)
+	if c.realm != testRealm {
+		t.Errorf("got realm %q, expected %q", c.realm, testRealm)
+	}
+
+	// A nonce the server didn't issue is rejected as stale, with a new one.
+	issued := c.nonce
+	c.nonce = "0-forged"
+	c.challenge(c.expectError(stun.CodeStaleNonce, stun.MethodAllocate, requestUDP))
+	if c.nonce == "0-forged" {
+		t.Error("stale nonce response didn't include a new nonce")
+	}
+	c.nonce = issued
+
+	resp = c.request(stun.MethodAllocate, requestUDP)
+
+	relayed, err := resp.XORAddress(stun.AttrXORRelayedAddress)
+	if err != nil || !relayed.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("got relayed address %v, %v", relayed, err)
+	}
+
+	mapped, err := resp.XORAddress(stun.AttrXORMappedAddress)
+	local := c.conn.LocalAddr().(*net.UDPAddr)
+	if err != nil || !mapped.IP.Equal(local.IP) || mapped.Port != local.Port {
+		t.Errorf("got mapped address %v, %v, expected %v", mapped, err, local)
+	}
+
+	value, _ := resp.Get(stun.AttrLifetime)
+	if lifetime, ok := decodeLifetime(value); !ok || lifetime != DefaultLifetime {
+		t.Errorf("got lifetime %v, expected %v", lifetime, DefaultLifetime)
+	}
+
+	if lookupAllocation(s, serverConn, c) == nil {
+		t.Error("server has no allocation")
+	}
+
+	c.expectError(stun.CodeAllocationMismatch, stun.MethodAllocate, requestUDP)
+}
+
+func TestAllocateClient(t *testing.T) {
+	_, serverConn := startServer(t)
+	server := serverConn.LocalAddr().(*net.UDPAddr)
+
+	// The client retries once it has been challenged for credentials.
+	conn, err := Allocate(listen(t, "127.0.0.1"), server, testUser, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if relayed := conn.LocalAddr().(*net.UDPAddr); relayed.Port == 0 {
+		t.Errorf("got relayed address %v", relayed)
+	}
+
+	_, err = Allocate(listen(t, "127.0.0.1"), server, testUser, "wrong")
+	if stunErr, ok := err.(*stun.Error); !ok || stunErr.Code != stun.CodeUnauthorized {
+		t.Errorf("wrong password: got %v, expected error 401", err)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	s, serverConn := startServer(t)
+	c := newTestClient(t, serverConn)
+	relayed := c.allocate()
+
+	peer := listen(t, "127.0.0.1")
+	stranger := listen(t, "127.0.0.2")
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+	// Without a permission, packets from the peer are dropped.
+	peer.WriteTo([]byte("early"), relayed)
+	if b := c.nextData(200 * time.Millisecond); b != nil {
+		t.Fatalf("got %x before a permission was created", b)
+	}
+
+	c.request(stun.MethodCreatePermission, func(req *stun.Message) {
+		req.AddXORAddress(stun.AttrXORPeerAddress, peerAddr)
+	})
+
+	// Send indications are relayed to permitted peers only.
+	send := func(to *net.UDPAddr, data string) {
+		ind := stun.NewMessage(stun.MethodSend, stun.ClassIndication)
+		ind.AddXORAddress(stun.AttrXORPeerAddress, to)
+		ind.Add(stun.AttrData, []byte(data))
+		ind.AddFingerprint()
+		if _, err := c.conn.WriteTo(ind.Marshal(), c.server); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(stranger.LocalAddr().(*net.UDPAddr), "to stranger")
+	send(peerAddr, "to peer")
+
+	if data, from := readFrom(t, peer, 5*time.Second); string(data) != "to peer" ||
+		!sameUDPAddr(from, relayed) {
+		t.Errorf("peer got %q from %v, expected \"to peer\" from %v", data, from, relayed)
+	}
+	if data, _ := readFrom(t, stranger, 200*time.Millisecond); data != nil {
+		t.Errorf("unpermitted peer got %q", data)
+	}
+
+	// Data from unpermitted peers is dropped, so the first Data indication
+	// is the permitted peer's.
+	stranger.WriteTo([]byte("from stranger"), relayed)
+	peer.WriteTo([]byte("from peer"), relayed)
+
+	b := c.nextData(5 * time.Second)
+	ind, err := stun.Parse(b)
+	if err != nil {
+		t.Fatalf("got %x, expected a Data indication", b)
+	}
+
+	from, err := ind.XORAddress(stun.AttrXORPeerAddress)
+	data, _ := ind.Get(stun.AttrData)
+	if err != nil || !sameUDPAddr(from, peerAddr) || string(data) != "from peer" {
+		t.Errorf("got %q from %v, expected \"from peer\" from %v", data, from, peerAddr)
+	}
+
+	// Once the permission expires, the peer's packets are dropped again.
+	a := lookupAllocation(s, serverConn, c)
+	s.usageLock.Lock()
+	a.permissions[peerAddr.IP.String()] = time.Now().Add(-time.Second)
+	s.usageLock.Unlock()
+
+	peer.WriteTo([]byte("expired"), relayed)
+	if b := c.nextData(200 * time.Millisecond); b != nil {
+		t.Errorf("got %x after the permission expired", b)
+	}
+}
+
+func TestChannelBind(t *testing.T) {
+	_, serverConn := startServer(t)
+	c := newTestClient(t, serverConn)
+	relayed := c.allocate()
+
+	peer := listen(t, "127.0.0.1")
+	other := listen(t, "127.0.0.1")
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+	bind := func(number uint16, to *net.UDPAddr) func(req *stun.Message) {
+		return func(req *stun.Message) {
+			req.Add(stun.AttrChannelNumber, encodeChannelNumber(number))
+			req.AddXORAddress(stun.AttrXORPeerAddress, to)
+		}
+	}
+
+	c.expectError(stun.CodeBadRequest, stun.MethodChannelBind, bind(MinChannelNumber-1, peerAddr))
+	c.request(stun.MethodChannelBind, bind(MinChannelNumber, peerAddr))
+
+	// Channels and peers can only be bound to each other once.
+	c.expectError(stun.CodeBadRequest, stun.MethodChannelBind,
+		bind(MinChannelNumber, other.LocalAddr().(*net.UDPAddr)))
+	c.expectError(stun.CodeBadRequest, stun.MethodChannelBind, bind(MinChannelNumber+1, peerAddr))
+
+	// Rebinding refreshes the binding.
+	c.request(stun.MethodChannelBind, bind(MinChannelNumber, peerAddr))
+
+	if _, err := c.conn.WriteTo(encodeChannelData(MinChannelNumber, []byte("to peer")), c.server); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := readFrom(t, peer, 5*time.Second); string(data) != "to peer" {
+		t.Errorf("peer got %q, expected \"to peer\"", data)
+	}
+
+	// Unbound channels are dropped.
+	c.conn.WriteTo(encodeChannelData(MinChannelNumber+2, []byte("unbound")), c.server)
+	if data, _ := readFrom(t, other, 200*time.Millisecond); data != nil {
+		t.Errorf("got %q on an unbound channel", data)
+	}
+
+	peer.WriteTo([]byte("from peer"), relayed)
+	expected := append([]byte{0x40, 0x00, 0x00, 0x09}, "from peer"...)
+	if b := c.nextData(5 * time.Second); !bytes.Equal(b, expected) {
+		t.Errorf("got %x, expected ChannelData %x", b, expected)
+	}
+}
+
+func TestChannelBindClient(t *testing.T) {
+	_, serverConn := startServer(t)
+	server := serverConn.LocalAddr().(*net.UDPAddr)
+
+	conn, err := Allocate(listen(t, "127.0.0.1"), server, testUser, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	peer := listen(t, "127.0.0.1")
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+	// The first write binds a channel, which also permits the peer.
+	if _, err := conn.WriteTo([]byte("to peer"), peerAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	data, from := readFrom(t, peer, 5*time.Second)
+	if string(data) != "to peer" || !sameUDPAddr(from, conn.LocalAddr().(*net.UDPAddr)) {
+		t.Fatalf("peer got %q from %v, expected \"to peer\" from %v", data, from,
+			conn.LocalAddr())
+	}
+
+	peer.WriteTo([]byte("from peer"), from)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "from peer" || !sameUDPAddr(addr.(*net.UDPAddr), peerAddr) {
+		t.Errorf("got %q from %v, expected \"from peer\" from %v", buf[:n], addr, peerAddr)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	s, serverConn := startServer(t)
+	c := newTestClient(t, serverConn)
+	c.allocate()
+
+	lifetime := func(d time.Duration) func(req *stun.Message) {
+		return func(req *stun.Message) {
+			req.Add(stun.AttrLifetime, encodeLifetime(d))
+		}
+	}
+
+	tests := []struct {
+		requested time.Duration
+		granted   time.Duration
+	}{
+		{20 * time.Minute, 20 * time.Minute},
+		{2 * time.Hour, MaxLifetime},
+		{time.Minute, DefaultLifetime},
+	}
+
+	for _, test := range tests {
+		resp := c.request(stun.MethodRefresh, lifetime(test.requested))
+		value, _ := resp.Get(stun.AttrLifetime)
+		if granted, ok := decodeLifetime(value); !ok || granted != test.granted {
+			t.Errorf("requested %v: got %v, expected %v", test.requested, granted,
+				test.granted)
+		}
+	}
+
+	// A zero lifetime deletes the allocation.
+	c.request(stun.MethodRefresh, lifetime(0))
+	c.expectError(stun.CodeAllocationMismatch, stun.MethodRefresh, lifetime(DefaultLifetime))
+
+	// Allocations which aren't refreshed expire.
+	expiring := newTestClient(t, serverConn)
+	expiring.allocate()
+
+	a := lookupAllocation(s, serverConn, expiring)
+	s.usageLock.Lock()
+	a.expires = time.Now().Add(-time.Second)
+	s.usageLock.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for lookupAllocation(s, serverConn, expiring) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("allocation didn't expire")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	expiring.expectError(stun.CodeAllocationMismatch, stun.MethodRefresh,
+		lifetime(DefaultLifetime))
+}
+
+func sameUDPAddr(a, b *net.UDPAddr) bool {
+	return a != nil && b != nil && a.Port == b.Port && a.IP.Equal(b.IP)
+}
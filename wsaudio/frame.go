@@ -0,0 +1,130 @@
+package wsaudio
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Format represents the encoding of the audio in a frame.
+type Format uint8
+
+// Possible Formats.
+const (
+	// FormatInt16 is 16 bit signed little endian PCM, as in an Int16Array.
+	FormatInt16 Format = iota
+	// FormatFloat32 is 32 bit float little endian PCM, as in a Float32Array
+	// from the Web Audio API.
+	FormatFloat32
+	// FormatCompressed is audio compressed by a Codec.
+	FormatCompressed
+)
+
+const headerLength = 10
+
+// Errors returned when encoding and decoding frames.
+var (
+	ErrInvalidFrame  = errors.New("wsaudio: invalid frame")
+	ErrInvalidFormat = errors.New("wsaudio: invalid format")
+	ErrNoCodec       = errors.New("wsaudio: compressed frame without a codec")
+)
+
+// Frame represents a frame of audio sent in a binary WebSocket message. The
+// message starts with a header of the format, the channel count (always 1),
+// the sample rate as a big endian uint32, and the sequence number as a big
+// endian uint32, followed by the payload.
+type Frame struct {
+	Format     Format
+	SampleRate int
+	Sequence   uint32
+	Payload    []byte
+}
+
+// Marshal encodes the frame into a WebSocket message.
+func (f *Frame) Marshal() []byte {
+	b := make([]byte, headerLength+len(f.Payload))
+	b[0] = byte(f.Format)
+	b[1] = 1
+	binary.BigEndian.PutUint32(b[2:], uint32(f.SampleRate))
+	binary.BigEndian.PutUint32(b[6:], f.Sequence)
+	copy(b[headerLength:], f.Payload)
+
+	return b
+}
+
+// Unmarshal decodes a frame from a WebSocket message. The payload references
+// the message rather than being copied.
+func (f *Frame) Unmarshal(b []byte) error {
+	if len(b) < headerLength || b[1] != 1 {
+		return ErrInvalidFrame
+	}
+
+	f.Format = Format(b[0])
+	f.SampleRate = int(binary.BigEndian.Uint32(b[2:]))
+	f.Sequence = binary.BigEndian.Uint32(b[6:])
+	f.Payload = b[headerLength:]
+
+	if f.Format > FormatCompressed || f.SampleRate <= 0 {
+		return ErrInvalidFrame
+	}
+
+	return nil
+}
+
+// Codec represents an audio codec used to compress frames.
+type Codec interface {
+	Encode(samples []int32, sampleRate int) ([]byte, error)
+	Decode(payload []byte, sampleRate int) ([]int32, error)
+}
+
+// encodeSamples encodes samples into the payload of a frame.
+func encodeSamples(format Format, codec Codec, samples []int32, sampleRate int) ([]byte, error) {
+	switch format {
+	case FormatInt16:
+		b := make([]byte, 2*len(samples))
+		for i, sample := range samples {
+			binary.LittleEndian.PutUint16(b[2*i:], uint16(sample>>16))
+		}
+		return b, nil
+	case FormatFloat32:
+		b := make([]byte, 4*len(samples))
+		for i, sample := range samples {
+			binary.LittleEndian.PutUint32(b[4*i:],
+				math.Float32bits(float32(sample)/2147483648))
+		}
+		return b, nil
+	case FormatCompressed:
+		if codec == nil {
+			return nil, ErrNoCodec
+		}
+		return codec.Encode(samples, sampleRate)
+	default:
+		return nil, ErrInvalidFormat
+	}
+}
+
+// decodeSamples decodes the payload of a frame into samples.
+func decodeSamples(f *Frame, codec Codec) ([]int32, error) {
+	switch f.Format {
+	case FormatInt16:
+		samples := make([]int32, len(f.Payload)/2)
+		for i := range samples {
+			samples[i] = int32(int16(binary.LittleEndian.Uint16(f.Payload[2*i:]))) << 16
+		}
+		return samples, nil
+	case FormatFloat32:
+		samples := make([]int32, len(f.Payload)/4)
+		for i := range samples {
+			value := float64(math.Float32frombits(binary.LittleEndian.Uint32(f.Payload[4*i:])))
+			samples[i] = int32(math.Max(-1, math.Min(1-1.0/2147483648, value)) * 2147483648)
+		}
+		return samples, nil
+	case FormatCompressed:
+		if codec == nil {
+			return nil, ErrNoCodec
+		}
+		return codec.Decode(f.Payload, f.SampleRate)
+	default:
+		return nil, ErrInvalidFormat
+	}
+}
@@ -0,0 +1,103 @@
+package wsaudio
+
+import (
+	"io"
+	"sync"
+
+	"github.com/1lann/dissonance/audio"
+)
+
+// receiveStream represents the audio received from the remote end. It
+// buffers at most limit samples, dropping the oldest beyond that.
+type receiveStream struct {
+	sampleRate int
+	limit      int
+	buffer     []int32
+	closed     bool
+	data       chan struct{}
+	usageLock  *sync.Mutex
+}
+
+func newReceiveStream(sampleRate, limit int) *receiveStream {
+	if limit < 1 {
+		limit = 1
+	}
+
+	return &receiveStream{
+		sampleRate: sampleRate,
+		limit:      limit,
+		data:       make(chan struct{}, 1),
+		usageLock:  new(sync.Mutex),
+	}
+}
+
+// write appends samples to the buffer, discarding the oldest if it's full.
+func (s *receiveStream) write(samples []int32) {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.buffer = append(s.buffer, samples...)
+	if len(s.buffer) > s.limit {
+		s.buffer = append(s.buffer[:0], s.buffer[len(s.buffer)-s.limit:]...)
+	}
+
+	s.notify()
+}
+
+// close makes reads return what remains of the buffer, then io.EOF.
+func (s *receiveStream) close() {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+
+	s.closed = true
+	s.notify()
+}
+
+func (s *receiveStream) notify() {
+	select {
+	case s.data <- struct{}{}:
+	default:
+	}
+}
+
+func (s *receiveStream) SampleRate() int {
+	return s.sampleRate
+}
+
+// Read blocks until enough samples have been received to fill dst, or the
+// connection closes.
+func (s *receiveStream) Read(dst interface{}) (int, error) {
+	dstLen := audio.SliceLength(dst)
+
+	for {
+		s.usageLock.Lock()
+		if len(s.buffer) >= dstLen || (s.closed && len(s.buffer) > 0) {
+			n := dstLen
+			if n > len(s.buffer) {
+				n = len(s.buffer)
+			}
+
+			err := audio.ReadFromInt32(dst, s.buffer, n)
+			s.buffer = s.buffer[n:]
+			s.usageLock.Unlock()
+
+			if err != nil {
+				return 0, err
+			}
+			return n, nil
+		}
+
+		closed := s.closed
+		s.usageLock.Unlock()
+
+		if closed {
+			return 0, io.EOF
+		}
+
+		<-s.data
+	}
+}
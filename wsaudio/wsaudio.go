@@ -0,0 +1,313 @@
+// Package wsaudio transports audio over WebSockets, for browser clients
+// that can't use RTP. Each binary message carries one frame of PCM or
+// compressed audio with its sample rate and a sequence number, in both
+// directions.
+//
+// On the server, a Handler maps each incoming connection to a Conn, whose
+// Stream is the audio received from the browser, and which sends any
+// audio.Stream back with Send. Handlers reject connections from web pages
+// unless Config.CheckOrigin accepts their origin.
+package wsaudio
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/1lann/dissonance/audio"
+	"github.com/gorilla/websocket"
+)
+
+// Defaults for the configuration of a connection.
+const (
+	DefaultFrameDuration = 20 * time.Millisecond
+	DefaultMaxBuffered   = 500 * time.Millisecond
+)
+
+// maxMessageSize is the largest message read from the remote end, which
+// fits a second of 16 bit PCM at 32 kHz.
+const maxMessageSize = 1 << 16
+
+// maxConcealedFrames is the most frames of silence inserted for a gap in
+// sequence numbers, beyond which the gap is treated as a restart.
+const maxConcealedFrames = 50
+
+// Errors returned by connections.
+var (
+	ErrClosed            = errors.New("wsaudio: connection closed")
+	ErrSampleRateChanged = errors.New("wsaudio: sample rate changed")
+)
+
+// Config represents the configuration of a connection.
+type Config struct {
+	// Format is the format of frames sent.
+	Format Format
+
+	// Codec compresses and decompresses frames in FormatCompressed. It may
+	// be nil if compressed frames aren't used.
+	Codec Codec
+
+	// FrameDuration is the duration of audio sent in each frame.
+	FrameDuration time.Duration
+
+	// MaxBuffered is the most received audio held for Stream. Beyond it
+	// the oldest audio is dropped, so that a remote end which sends too
+	// fast or a reader which falls behind doesn't grow memory use and
+	// latency without bound.
+	MaxBuffered time.Duration
+
+	// CheckOrigin returns whether a Handler accepts a request, given its
+	// Origin header. If nil, requests with an Origin header, which web
+	// pages always send, are rejected, and only clients such as Dial that
+	// don't send one are accepted.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// Handler represents a WebSocket audio endpoint. It implements
+// http.Handler.
+type Handler struct {
+	upgrader  websocket.Upgrader
+	config    Config
+	onConnect func(c *Conn)
+}
+
+// NewHandler returns a new handler which calls onConnect with each incoming
+// connection, and closes the connection when onConnect returns.
+func NewHandler(config Config, onConnect func(c *Conn)) *Handler {
+	checkOrigin := config.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = func(r *http.Request) bool {
+			return r.Header.Get("Origin") == ""
+		}
+	}
+
+	return &Handler{
+		upgrader: websocket.Upgrader{
+			CheckOrigin: checkOrigin,
+		},
+		config:    config,
+		onConnect: onConnect,
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket and hands the connection to
+// the handler's callback.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := newConn(ws, h.config)
+	defer c.Close()
+
+	h.onConnect(c)
+}
+
+// Dial connects to a WebSocket audio endpoint.
+func Dial(url string, config Config) (*Conn, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newConn(ws, config), nil
+}
+
+// Conn represents a WebSocket audio connection.
+type Conn struct {
+	ws     *websocket.Conn
+	config Config
+
+	stream   *receiveStream
+	ready    chan struct{}
+	started  bool
+	expected uint32
+	frameLen int
+
+	sequence  uint32
+	closed    chan struct{}
+	err       error
+	writeLock *sync.Mutex
+	usageLock *sync.Mutex
+}
+
+func newConn(ws *websocket.Conn, config Config) *Conn {
+	if config.FrameDuration <= 0 {
+		config.FrameDuration = DefaultFrameDuration
+	}
+	if config.MaxBuffered <= 0 {
+		config.MaxBuffered = DefaultMaxBuffered
+	}
+
+	c := &Conn{
+		ws:        ws,
+		config:    config,
+		ready:     make(chan struct{}),
+		closed:    make(chan struct{}),
+		writeLock: new(sync.Mutex),
+		usageLock: new(sync.Mutex),
+	}
+
+	ws.SetReadLimit(maxMessageSize)
+	go c.readLoop()
+
+	return c
+}
+
+// readLoop receives frames and writes their audio to the stream.
+func (c *Conn) readLoop() {
+	for {
+		messageType, b, err := c.ws.ReadMessage()
+		if err != nil {
+			c.closeWithError(err)
+			return
+		}
+
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		var f Frame
+		if err := f.Unmarshal(b); err != nil {
+			continue
+		}
+
+		samples, err := decodeSamples(&f, c.config.Codec)
+		if err != nil {
+			continue
+		}
+
+		if err := c.receive(&f, samples); err != nil {
+			c.closeWithError(err)
+			return
+		}
+	}
+}
+
+// receive writes the samples of a frame to the stream, dropping duplicate
+// and late frames and filling gaps left by lost frames with silence. The
+// stream drops its oldest audio if it's full.
+func (c *Conn) receive(f *Frame, samples []int32) error {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	if c.err != nil {
+		return nil
+	}
+
+	if !c.started {
+		c.started = true
+		c.stream = newReceiveStream(f.SampleRate, int(int64(c.config.MaxBuffered)*
+			int64(f.SampleRate)/int64(time.Second)))
+		c.expected = f.Sequence
+		close(c.ready)
+	} else if f.SampleRate != c.stream.SampleRate() {
+		return ErrSampleRateChanged
+	}
+
+	gap := int32(f.Sequence - c.expected)
+	if gap < 0 {
+		return nil
+	}
+
+	if gap > 0 && gap <= maxConcealedFrames && c.frameLen > 0 {
+		c.stream.write(make([]int32, int(gap)*c.frameLen))
+	}
+
+	c.expected = f.Sequence + 1
+	c.frameLen = len(samples)
+	c.stream.write(samples)
+
+	return nil
+}
+
+func (c *Conn) closeWithError(err error) {
+	c.usageLock.Lock()
+	defer c.usageLock.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.closed)
+	c.ws.Close()
+
+	if c.stream != nil {
+		c.stream.close()
+	}
+}
+
+// Stream returns the audio received from the remote end. It blocks until
+// the first frame is received, which sets the stream's sample rate. The
+// stream ends with io.EOF when the connection closes.
+func (c *Conn) Stream() (audio.Stream, error) {
+	select {
+	case <-c.ready:
+		return c.stream, nil
+	case <-c.closed:
+		return nil, c.err
+	}
+}
+
+// Send reads audio from the stream and sends it to the remote end in frames,
+// until the stream returns an error or the connection closes.
+func (c *Conn) Send(stream audio.Stream) error {
+	frameLen := int(int64(c.config.FrameDuration) * int64(stream.SampleRate()) /
+		int64(time.Second))
+	buf := make([]int32, frameLen)
+
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			if sendErr := c.SendSamples(buf[:n], stream.SampleRate()); sendErr != nil {
+				return sendErr
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// SendSamples sends a single frame of samples at the given sample rate.
+func (c *Conn) SendSamples(samples []int32, sampleRate int) error {
+	payload, err := encodeSamples(c.config.Format, c.config.Codec, samples, sampleRate)
+	if err != nil {
+		return err
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+
+	f := Frame{
+		Format:     c.config.Format,
+		SampleRate: sampleRate,
+		Sequence:   c.sequence,
+		Payload:    payload,
+	}
+	c.sequence++
+
+	return c.ws.WriteMessage(websocket.BinaryMessage, f.Marshal())
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.writeLock.Lock()
+	c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	c.writeLock.Unlock()
+
+	c.closeWithError(ErrClosed)
+	return nil
+}
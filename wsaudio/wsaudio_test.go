@@ -0,0 +1,260 @@
+package wsaudio
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []Frame{
+		{Format: FormatInt16, SampleRate: 16000, Sequence: 0, Payload: []byte{1, 2, 3, 4}},
+		{Format: FormatFloat32, SampleRate: 48000, Sequence: math.MaxUint32, Payload: []byte{}},
+		{Format: FormatCompressed, SampleRate: 8000, Sequence: 1234, Payload: []byte("opus")},
+	}
+
+	for _, f := range frames {
+		var decoded Frame
+		if err := decoded.Unmarshal(f.Marshal()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, f) {
+			t.Errorf("got %+v, expected %+v", decoded, f)
+		}
+	}
+
+	valid := (&Frame{Format: FormatInt16, SampleRate: 16000}).Marshal()
+	invalid := map[string][]byte{
+		"short":    valid[:headerLength-1],
+		"channels": append([]byte{0, 2}, valid[2:]...),
+		"format":   append([]byte{byte(FormatCompressed + 1)}, valid[1:]...),
+		"rate":     {0, 1, 0, 0, 0, 0, 0, 0, 0, 0},
+	}
+
+	for name, b := range invalid {
+		var f Frame
+		if err := f.Unmarshal(b); err != ErrInvalidFrame {
+			t.Errorf("%s: got %v, expected ErrInvalidFrame", name, err)
+		}
+	}
+}
+
+func TestSamplesRoundTrip(t *testing.T) {
+	samples := []int32{0, 1 << 16, -1 << 16, math.MinInt32, math.MaxInt32, 0x12345600}
+
+	tests := []struct {
+		format   Format
+		expected []int32
+	}{
+		{FormatInt16, []int32{0, 1 << 16, -1 << 16, math.MinInt32, 0x7fff0000, 0x12340000}},
+		{FormatFloat32, samples},
+	}
+
+	for _, test := range tests {
+		payload, err := encodeSamples(test.format, nil, samples, 16000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := decodeSamples(&Frame{Format: test.format, SampleRate: 16000,
+			Payload: payload}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(decoded, test.expected) {
+			t.Errorf("format %d: got %#x, expected %#x", test.format, decoded, test.expected)
+		}
+	}
+
+	if _, err := encodeSamples(FormatCompressed, nil, samples, 16000); err != ErrNoCodec {
+		t.Errorf("compressed without a codec: got %v, expected ErrNoCodec", err)
+	}
+}
+
+// newTestConn returns a connection without a WebSocket, which frames can be
+// passed to directly with receive.
+func newTestConn(maxBuffered time.Duration) *Conn {
+	return &Conn{
+		config:    Config{MaxBuffered: maxBuffered},
+		ready:     make(chan struct{}),
+		closed:    make(chan struct{}),
+		writeLock: new(sync.Mutex),
+		usageLock: new(sync.Mutex),
+	}
+}
+
+func receive(t *testing.T, c *Conn, sampleRate int, sequence uint32, samples ...int32) {
+	t.Helper()
+
+	if err := c.receive(&Frame{SampleRate: sampleRate, Sequence: sequence}, samples); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readSamples(t *testing.T, c *Conn, n int) []int32 {
+	t.Helper()
+
+	stream, err := c.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]int32, n)
+	for read := 0; read < n; {
+		m, err := stream.Read(buf[read:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += m
+	}
+
+	return buf
+}
+
+func TestConcealment(t *testing.T) {
+	c := newTestConn(time.Second)
+
+	receive(t, c, 1000, 10, 1, 1)
+	receive(t, c, 1000, 11, 2, 2)
+	receive(t, c, 1000, 11, 9, 9) // duplicate
+	receive(t, c, 1000, 9, 9, 9)  // late
+	receive(t, c, 1000, 14, 3, 3) // two frames lost
+	receive(t, c, 1000, 15+maxConcealedFrames+1, 4, 4)
+
+	expected := []int32{1, 1, 2, 2, 0, 0, 0, 0, 3, 3, 4, 4}
+	if got := readSamples(t, c, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	err := c.receive(&Frame{SampleRate: 2000, Sequence: 100}, []int32{0})
+	if err != ErrSampleRateChanged {
+		t.Errorf("got %v, expected ErrSampleRateChanged", err)
+	}
+}
+
+func TestMaxBuffered(t *testing.T) {
+	c := newTestConn(10 * time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		receive(t, c, 1000, uint32(i), int32(i), int32(i), int32(i), int32(i))
+	}
+
+	// Only the newest 10ms at 1000 Hz is kept.
+	expected := []int32{2, 2, 3, 3, 3, 3, 4, 4, 4, 4}
+	if got := readSamples(t, c, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	c.stream.close()
+	if _, err := c.stream.Read(make([]int32, 1)); err != io.EOF {
+		t.Errorf("read after close: got %v, expected io.EOF", err)
+	}
+}
+
+func newTestServer(t *testing.T, config Config, onConnect func(c *Conn)) string {
+	t.Helper()
+
+	server := httptest.NewServer(NewHandler(config, onConnect))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestLoopback(t *testing.T) {
+	url := newTestServer(t, Config{}, func(c *Conn) {
+		stream, err := c.Stream()
+		if err != nil {
+			return
+		}
+		c.Send(stream)
+	})
+
+	c, err := Dial(url, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const (
+		sampleRate = 16000
+		frameLen   = sampleRate / 50
+		frames     = 5
+	)
+
+	var sent []int32
+	for i := 0; i < frames; i++ {
+		samples := make([]int32, frameLen)
+		for j := range samples {
+			samples[j] = int32(i*frameLen+j) << 16
+		}
+		sent = append(sent, samples...)
+
+		if err := c.SendSamples(samples, sampleRate); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if received := readSamples(t, c, len(sent)); !reflect.DeepEqual(received, sent) {
+		t.Error("received audio differs from the audio sent")
+	}
+
+	if stream, _ := c.Stream(); stream.SampleRate() != sampleRate {
+		t.Errorf("got sample rate %d, expected %d", stream.SampleRate(), sampleRate)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	onConnect := func(c *Conn) {}
+	header := http.Header{"Origin": {"https://example.com"}}
+
+	url := newTestServer(t, Config{}, onConnect)
+	if _, _, err := websocket.DefaultDialer.Dial(url, header); err != websocket.ErrBadHandshake {
+		t.Errorf("origin rejected by default: got %v, expected ErrBadHandshake", err)
+	}
+
+	url = newTestServer(t, Config{CheckOrigin: func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://example.com"
+	}}, onConnect)
+	ws, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("allowed origin: %v", err)
+	}
+	ws.Close()
+}
+
+func TestReadLimit(t *testing.T) {
+	errs := make(chan error, 1)
+	url := newTestServer(t, Config{}, func(c *Conn) {
+		_, err := c.Stream()
+		errs <- err
+	})
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	f := Frame{SampleRate: 16000, Payload: make([]byte, maxMessageSize)}
+	if err := ws.WriteMessage(websocket.BinaryMessage, f.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if err != websocket.ErrReadLimit {
+			t.Errorf("got %v, expected ErrReadLimit", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the connection to close")
+	}
+}